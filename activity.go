@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/csi"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+)
+
+// lastActivity is the unix nano time of the last processed room message.
+var lastActivity = time.Now().UnixNano()
+
+// trackActivity is a handler noting the room messages, it never consumes them.
+func trackActivity() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local == "message" && h.Type == stanza.GROUPCHAT && !h.Archived {
+			atomic.StoreInt64(&lastActivity, time.Now().UnixNano())
+		}
+		return false
+	}
+}
+
+// csiQuiet is how long nothing must have been sent to go inactive.
+const csiQuiet = 30 * time.Second
+
+// clientState is the client state of the connection, nil when the server
+// has no CSI.
+var clientState *csi.State
+
+// busy tells whether the bot has work pending or sent something lately, it
+// keeps the client state active.
+func busy() bool {
+	if p := inbound; p != nil && p.Depth() > 0 {
+		return true
+	}
+	if hookExec != nil && hookExec.Queued() > 0 {
+		return true
+	}
+	return time.Since(disp.LastSent()) < csiQuiet
+}
+
+// active marks the bot active, e.g. while running commands.
+func active() {
+	if cs := clientState; cs != nil {
+		if err := cs.Touch(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&lastActivity)))
+}
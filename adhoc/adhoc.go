@@ -0,0 +1,236 @@
+// Package adhoc implements the responder side of XEP-0050 ad-hoc commands,
+// including multi-step sessions.
+package adhoc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns = "http://jabber.org/protocol/commands"
+
+	SessionTimeout = 10 * time.Minute
+)
+
+// actions
+const (
+	EXECUTE  = "execute"
+	NEXT     = "next"
+	PREV     = "prev"
+	COMPLETE = "complete"
+	CANCEL   = "cancel"
+)
+
+// statuses
+const (
+	EXECUTING = "executing"
+	COMPLETED = "completed"
+	CANCELED  = "canceled"
+)
+
+type Note struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type actions struct {
+	Execute  string    `xml:"execute,attr,omitempty"`
+	Prev     *struct{} `xml:"prev"`
+	Next     *struct{} `xml:"next"`
+	Complete *struct{} `xml:"complete"`
+}
+
+type command struct {
+	XMLName   xml.Name `xml:"http://jabber.org/protocol/commands command"`
+	Node      string   `xml:"node,attr"`
+	SessionID string   `xml:"sessionid,attr,omitempty"`
+	Action    string   `xml:"action,attr,omitempty"`
+	Status    string   `xml:"status,attr,omitempty"`
+	Actions   *actions `xml:"actions"`
+	Notes     []Note   `xml:"note"`
+	Form      *dataforms.Form
+}
+
+// Session is the state of a command execution across its steps.
+type Session struct {
+	ID   string
+	Node string
+	From string
+	// Step and Data are left to the command.
+	Step    int
+	Data    map[string][]string
+	expires time.Time
+}
+
+// Response is what a command step answers. Without actions the session is
+// completed, otherwise the first action is the default one.
+type Response struct {
+	Form    *dataforms.Form
+	Notes   []Note
+	Actions []string
+}
+
+type Command struct {
+	Node string
+	Name string
+	// Allowed decides who may see and run the command, nil allows everyone.
+	Allowed func(jid string) bool
+	// Run runs a step, form is the submitted one and nil on the first step.
+	Run func(s *Session, action string, form *dataforms.Form) (*Response, error)
+}
+
+func (c *Command) allowed(jid string) bool {
+	return c.Allowed == nil || c.Allowed(jid)
+}
+
+type Commands struct {
+	sync.Mutex
+	cmds     map[string]*Command
+	sessions map[string]*Session
+}
+
+func New() *Commands {
+	return &Commands{cmds: make(map[string]*Command), sessions: make(map[string]*Session)}
+}
+
+func (c *Commands) Add(cmd *Command) {
+	c.Lock()
+	c.cmds[cmd.Node] = cmd
+	c.Unlock()
+}
+
+// Items lists the commands jid may run, for disco#items on the commands node.
+func (c *Commands) Items(from, to, node string) (ret []disco.Item) {
+	if node != Ns {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, cmd := range c.cmds {
+		if cmd.allowed(from) {
+			ret = append(ret, disco.Item{JID: to, Node: cmd.Node, Name: cmd.Name})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Node < ret[j].Node })
+	return
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// session returns the session of the request, creating one for a first step.
+func (c *Commands) session(req *command, from string) (*Session, bool) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for id, s := range c.sessions {
+		if now.After(s.expires) {
+			delete(c.sessions, id)
+		}
+	}
+	if req.SessionID == "" {
+		s := &Session{ID: newSessionID(), Node: req.Node, From: from, Data: make(map[string][]string)}
+		s.expires = now.Add(SessionTimeout)
+		c.sessions[s.ID] = s
+		return s, true
+	}
+	s, ok := c.sessions[req.SessionID]
+	if !ok || s.From != from || s.Node != req.Node {
+		return nil, false
+	}
+	s.expires = now.Add(SessionTimeout)
+	return s, true
+}
+
+func (c *Commands) forget(s *Session) {
+	c.Lock()
+	delete(c.sessions, s.ID)
+	c.Unlock()
+}
+
+// Handler runs the command requests.
+func (c *Commands) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.SET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		req := &command{}
+		if xml.Unmarshal(raw, iq) != nil || iq.PayloadName().Space != Ns || iq.Decode(req) != nil {
+			return false
+		}
+		c.Lock()
+		cmd, ok := c.cmds[req.Node]
+		c.Unlock()
+		switch {
+		case !ok:
+			d.Send(iq.ErrorReply("cancel", "item-not-found"))
+			return true
+		case !cmd.allowed(h.From.String()):
+			d.Send(iq.ErrorReply("auth", "forbidden"))
+			return true
+		}
+		// steps may make requests of their own, which can't be waited for in a handler
+		go c.run(d, iq, req, cmd)
+		return true
+	}
+}
+
+func (c *Commands) run(d *dispatch.Dispatcher, iq *stanza.IQ, req *command, cmd *Command) {
+	s, ok := c.session(req, iq.From.String())
+	if !ok {
+		d.Send(iq.ErrorReply("modify", "bad-request"))
+		return
+	}
+	action := req.Action
+	if action == "" {
+		action = EXECUTE
+	}
+	ret := &command{Node: req.Node, SessionID: s.ID}
+	if action == CANCEL {
+		c.forget(s)
+		ret.Status = CANCELED
+	} else if resp, err := cmd.Run(s, action, req.Form); err != nil {
+		c.forget(s)
+		ret.Status = COMPLETED
+		ret.Notes = []Note{{Type: "error", Text: err.Error()}}
+	} else {
+		ret.Form, ret.Notes = resp.Form, resp.Notes
+		if len(resp.Actions) == 0 {
+			c.forget(s)
+			ret.Status = COMPLETED
+		} else {
+			ret.Status = EXECUTING
+			ret.Actions = &actions{Execute: resp.Actions[0]}
+			for _, a := range resp.Actions {
+				switch a {
+				case PREV:
+					ret.Actions.Prev = &struct{}{}
+				case NEXT:
+					ret.Actions.Next = &struct{}{}
+				case COMPLETE:
+					ret.Actions.Complete = &struct{}{}
+				}
+			}
+		}
+	}
+	res := iq.Result()
+	if res.Set(ret) != nil {
+		d.Send(iq.ErrorReply("wait", "internal-server-error"))
+		return
+	}
+	d.Send(res)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kpmy/xep/adhoc"
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/modules"
+	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/stanza"
+)
+
+// single runs a command of one form, answered by done with the note to show.
+func single(form func() *dataforms.Form, done func(f *dataforms.Form) (string, error)) func(*adhoc.Session, string, *dataforms.Form) (*adhoc.Response, error) {
+	return func(s *adhoc.Session, action string, f *dataforms.Form) (*adhoc.Response, error) {
+		if f == nil {
+			return &adhoc.Response{Form: form(), Actions: []string{adhoc.COMPLETE}}, nil
+		}
+		if err := form().Validate(f); err != nil {
+			return nil, err
+		}
+		note, err := done(f)
+		if err != nil {
+			return nil, err
+		}
+		return &adhoc.Response{Notes: []adhoc.Note{{Type: "info", Text: note}}}, nil
+	}
+}
+
+func joinForm() *dataforms.Form {
+	return &dataforms.Form{Type: dataforms.FORM, Title: "Join a room", Fields: []dataforms.Field{
+		{Var: "room", Type: dataforms.JID_SINGLE, Label: "Room", Required: dataforms.Required},
+		{Var: "nick", Type: dataforms.TEXT_SINGLE, Label: "Nick", Values: []string{ME}},
+		{Var: "password", Type: dataforms.TEXT_PRIVATE, Label: "Password"},
+	}}
+}
+
+func leaveForm() *dataforms.Form {
+	rf := dataforms.Field{Var: "room", Type: dataforms.LIST_SINGLE, Label: "Room", Required: dataforms.Required}
+	for _, r := range rooms.List() {
+		rf.Options = append(rf.Options, dataforms.Option{Value: r.JID})
+	}
+	return &dataforms.Form{Type: dataforms.FORM, Title: "Leave a room", Fields: []dataforms.Field{rf}}
+}
+
+func shutdownForm() *dataforms.Form {
+	return &dataforms.Form{Type: dataforms.FORM, Title: "Shut the bot down", Fields: []dataforms.Field{
+		{Var: "confirm", Type: dataforms.BOOLEAN, Label: "Really shut down?", Values: []string{"0"}},
+	}}
+}
+
+// reload reloads the modules and forgets the cached room settings.
+func reload() string {
+	mods.Stop()
+	mods = modules.Load(modulesDir)
+	mods.Start(newModuleHost())
+	prefixes.Lock()
+	prefixes.m = make(map[string]string)
+	prefixes.Unlock()
+	return fmt.Sprintf("reloaded, modules: %s", strings.Join(mods.Names(), ", "))
+}
+
+// shutdown leaves the rooms and exits, a moment later so the reply to the
+// command goes out first.
+func shutdown(by string) {
+	time.Sleep(time.Second)
+	audit(&cmd{sender: by, user: jid.Bare(by)}, "adhoc", "shutdown")
+	for _, r := range rooms.List() {
+		if err := muc.Leave(r.JID, r.Nick, "shutting down")(disp.Stream()); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := disp.Send(stanza.NewPresence(stanza.UNAVAILABLE, jid.JID{})); err != nil {
+		log.Println(err)
+	}
+	emit("stopped", map[string]string{"condition": "shutdown", "text": by})
+	stopHooks()
+	shipper.Close()
+	os.Exit(0)
+}
+
+func init() {
+	adhocCmds.Add(&adhoc.Command{Node: "join", Name: "Join a room", Allowed: adminJID, Run: single(joinForm, func(f *dataforms.Form) (string, error) {
+		room := strings.TrimSpace(f.Value("room"))
+		if !strings.Contains(room, "@") || strings.Contains(room, "/") {
+			return "", errors.New("bad room " + room)
+		}
+		nick := strings.TrimSpace(f.Value("nick"))
+		if nick == "" {
+			nick = ME
+		}
+		if err := joinRoom(room, nick, f.Value("password"), true); err != nil {
+			return "", err
+		}
+		return "joined " + room, nil
+	})})
+	adhocCmds.Add(&adhoc.Command{Node: "leave", Name: "Leave a room", Allowed: adminJID, Run: single(leaveForm, func(f *dataforms.Form) (string, error) {
+		room := f.Value("room")
+		if err := leaveRoom(room); err != nil {
+			return "", err
+		}
+		return "left " + room, nil
+	})})
+	adhocCmds.Add(&adhoc.Command{Node: "reload", Name: "Reload modules and settings", Allowed: adminJID, Run: func(*adhoc.Session, string, *dataforms.Form) (*adhoc.Response, error) {
+		return &adhoc.Response{Notes: []adhoc.Note{{Type: "info", Text: reload()}}}, nil
+	}})
+	adhocCmds.Add(&adhoc.Command{Node: "status", Name: "Show status", Allowed: adminJID, Run: func(*adhoc.Session, string, *dataforms.Form) (*adhoc.Response, error) {
+		text := summary()
+		for _, q := range botStatus().Queues {
+			text += fmt.Sprintf(", %d queued %s", q.Depth, q.Name)
+		}
+		return &adhoc.Response{Notes: []adhoc.Note{{Type: "info", Text: text}}}, nil
+	}})
+	adhocCmds.Add(&adhoc.Command{Node: "shutdown", Name: "Shut down", Allowed: adminJID, Run: func(s *adhoc.Session, action string, f *dataforms.Form) (*adhoc.Response, error) {
+		if f == nil {
+			return &adhoc.Response{Form: shutdownForm(), Actions: []string{adhoc.COMPLETE}}, nil
+		}
+		if !f.Bool("confirm") {
+			return &adhoc.Response{Notes: []adhoc.Note{{Type: "info", Text: "not shutting down"}}}, nil
+		}
+		go shutdown(s.From)
+		return &adhoc.Response{Notes: []adhoc.Note{{Type: "warn", Text: "shutting down"}}}, nil
+	}})
+}
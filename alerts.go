@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fjl/go-couchdb"
+	"github.com/ivpusic/neo"
+	"github.com/kpmy/xep/pubsub"
+)
+
+const alertsDocId = "alerts"
+
+// CAlertSubs maps the bare JIDs of contacts to the alert categories they follow.
+type CAlertSubs struct {
+	Subs map[string][]string
+}
+
+var alertsMu sync.Mutex
+
+func getAlertSubs() (ret *CAlertSubs, err error) {
+	ret = &CAlertSubs{}
+	if err = db.Get(alertsDocId, ret, nil); couchdb.NotFound(err) {
+		err = nil
+	}
+	if ret.Subs == nil {
+		ret.Subs = make(map[string][]string)
+	}
+	return
+}
+
+func setAlertSubs(s *CAlertSubs) error {
+	rev, err := db.Rev(alertsDocId)
+	if err != nil && !couchdb.NotFound(err) {
+		return err
+	}
+	_, err = db.Put(alertsDocId, s, rev)
+	return err
+}
+
+// updateAlertSubs changes the categories of jid under the lock.
+func updateAlertSubs(jid string, fn func([]string) []string) ([]string, error) {
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	s, err := getAlertSubs()
+	if err != nil {
+		return nil, err
+	}
+	cats := fn(s.Subs[jid])
+	if len(cats) == 0 {
+		delete(s.Subs, jid)
+	} else {
+		sort.Strings(cats)
+		s.Subs[jid] = cats
+	}
+	return cats, setAlertSubs(s)
+}
+
+// alertMatches tells whether the category falls under the subscribed one,
+// "alerts" covers "alerts:critical".
+func alertMatches(category, sub string) bool {
+	return category == sub || strings.HasPrefix(category, sub+":")
+}
+
+// subscribed reports whether the contact still shares presence with the bot.
+func subscribed(jid string) bool {
+	i, ok := contacts.Get(jid)
+	return ok && (i.Subscription == "both" || i.Subscription == "from")
+}
+
+type alert struct {
+	Category string `json:"category"`
+	Text     string `json:"text"`
+	// Room gets the alert posted unless Direct is "only", empty means ROOM.
+	Room   string `json:"room"`
+	Direct string `json:"direct"`
+	// Update corrects the last room post of the category instead of adding one.
+	Update bool `json:"update"`
+}
+
+// alertPosts holds the ids of the last room posts by room and category.
+var alertPosts = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// atomEntry is the item alerts are published as.
+type atomEntry struct {
+	XMLName  xml.Name `xml:"http://www.w3.org/2005/Atom entry"`
+	Title    string   `xml:"title"`
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Updated time.Time `xml:"updated"`
+}
+
+// publishAlert publishes the alert to the -alerts-node, if any.
+func publishAlert(a *alert) {
+	if alertsNode == "" {
+		return
+	}
+	e := &atomEntry{Title: a.Text, Updated: time.Now().UTC()}
+	e.Category.Term = a.Category
+	if _, err := pubsub.Publish(disp, pubsubJID, alertsNode, "", e, nil); err != nil {
+		log.Println("failed to publish the alert:", err)
+	}
+}
+
+// routeAlert sends the alert to its subscribers and, unless they are the only
+// target, to the room; it returns the number of contacts reached.
+func routeAlert(a *alert) (n int, err error) {
+	if a.Category == "" || a.Text == "" {
+		return 0, errors.New("alert needs a category and a text")
+	}
+	text := "[" + a.Category + "] " + a.Text
+	alertsMu.Lock()
+	s, err := getAlertSubs()
+	alertsMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	for jid, cats := range s.Subs {
+		for _, c := range cats {
+			if alertMatches(a.Category, c) && subscribed(jid) {
+				m, err := announcement(jid, text)
+				if err == nil {
+					err = disp.Send(m)
+				}
+				if err != nil {
+					log.Println(err)
+				} else {
+					n++
+				}
+				break
+			}
+		}
+	}
+	publishAlert(a)
+	if a.Direct != "only" {
+		room := a.Room
+		if room == "" {
+			room = ROOM
+		}
+		if _, ok := rooms.Get(room); !ok {
+			return n, errors.New("not in " + room)
+		}
+		key := room + " " + a.Category
+		alertPosts.Lock()
+		defer alertPosts.Unlock()
+		last := ""
+		if a.Update {
+			last = alertPosts.m[key]
+		}
+		if id := post(room, text, last); last == "" {
+			alertPosts.m[key] = id
+		}
+	}
+	return n, nil
+}
+
+func alertRoutes(app *neo.Application) {
+	app.Post("/api/alerts", apiHandler(func(ctx *neo.Ctx) (interface{}, error) {
+		a := &alert{}
+		if err := decodeJSON(ctx, a); err != nil {
+			return nil, err
+		}
+		n, err := routeAlert(a)
+		return map[string]int{"contacts": n}, err
+	}))
+}
+
+func init() {
+	commands["alerts"] = &command{usage: "list | subscribe <category> | unsubscribe <category>", run: func(c *cmd) (string, error) {
+		if !c.direct {
+			return c.sender + ": alerts are managed in a private chat", nil
+		}
+		var cats []string
+		var err error
+		switch {
+		case len(c.args) == 1 && c.args[0] == "list":
+			alertsMu.Lock()
+			s, e := getAlertSubs()
+			alertsMu.Unlock()
+			cats, err = s.Subs[c.user], e
+		case len(c.args) == 2 && c.args[0] == "subscribe":
+			cats, err = updateAlertSubs(c.user, func(old []string) []string {
+				for _, o := range old {
+					if o == c.args[1] {
+						return old
+					}
+				}
+				return append(old, c.args[1])
+			})
+		case len(c.args) == 2 && c.args[0] == "unsubscribe":
+			cats, err = updateAlertSubs(c.user, func(old []string) (ret []string) {
+				for _, o := range old {
+					if o != c.args[1] {
+						ret = append(ret, o)
+					}
+				}
+				return
+			})
+		default:
+			return "", errUsage
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(cats) == 0 {
+			return "no alert subscriptions", nil
+		}
+		return "subscribed to " + strings.Join(cats, ", "), nil
+	}}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/ivpusic/neo"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+type roomInfo struct {
+	Room      string   `json:"room"`
+	Nick      string   `json:"nick"`
+	Occupants []string `json:"occupants"`
+}
+
+type roomRequest struct {
+	Room     string `json:"room"`
+	Nick     string `json:"nick"`
+	Password string `json:"password"`
+}
+
+// authorized checks the bearer token, the API is disabled without one configured.
+func authorized(ctx *neo.Ctx) bool {
+	got := []byte(ctx.Req.Header.Get("Authorization"))
+	return apiToken != "" && subtle.ConstantTimeCompare(got, []byte("Bearer "+apiToken)) == 1
+}
+
+func apiHandler(fn func(ctx *neo.Ctx) (interface{}, error)) func(ctx *neo.Ctx) (int, error) {
+	return func(ctx *neo.Ctx) (int, error) {
+		if !authorized(ctx) {
+			return 401, errUnauthorized
+		}
+		if disp == nil {
+			return 503, errors.New("not connected")
+		}
+		ret, err := fn(ctx)
+		if err != nil {
+			return 400, err
+		}
+		return 200, json.NewEncoder(ctx.Res).Encode(ret)
+	}
+}
+
+func decodeJSON(ctx *neo.Ctx, v interface{}) error {
+	return json.NewDecoder(ctx.Req.Body).Decode(v)
+}
+
+func decodeRoom(ctx *neo.Ctx) (req *roomRequest, err error) {
+	req = &roomRequest{}
+	if err = decodeJSON(ctx, req); err == nil && !strings.Contains(req.Room, "@") {
+		err = errors.New("bad room " + req.Room)
+	}
+	if req.Nick == "" {
+		req.Nick = ME
+	}
+	return
+}
+
+func apiRoutes(app *neo.Application) {
+	app.Get("/api/rooms", apiHandler(func(ctx *neo.Ctx) (interface{}, error) {
+		ret := []roomInfo{}
+		for _, r := range rooms.List() {
+			info := roomInfo{Room: r.JID, Nick: r.Nick, Occupants: []string{}}
+			for _, o := range rooms.Occupants(r.JID) {
+				info.Occupants = append(info.Occupants, o.Nick)
+			}
+			ret = append(ret, info)
+		}
+		return ret, nil
+	}))
+	app.Post("/api/rooms/join", apiHandler(func(ctx *neo.Ctx) (interface{}, error) {
+		req, err := decodeRoom(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return req.Room, joinRoom(req.Room, req.Nick, req.Password, true)
+	}))
+	app.Post("/api/rooms/leave", apiHandler(func(ctx *neo.Ctx) (interface{}, error) {
+		req, err := decodeRoom(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return req.Room, leaveRoom(req.Room)
+	}))
+	alertRoutes(app)
+}
@@ -0,0 +1,44 @@
+// Package attention implements XEP-0224 attention.
+package attention
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:attention:0"
+
+type attention struct {
+	XMLName xml.Name `xml:"urn:xmpp:attention:0 attention"`
+}
+
+func Has(m *stanza.Message) bool {
+	return m.Ext(Ns, "attention") != nil
+}
+
+// Send asks for the attention of to, body says why and may be empty.
+func Send(d *dispatch.Dispatcher, to jid.JID, body string) error {
+	m := stanza.NewMessage(stanza.CHAT, to, body)
+	if err := m.With(&attention{}); err != nil {
+		return err
+	}
+	return d.Send(m)
+}
+
+// Handler passes the attention requests to fn, consuming those without a body.
+func Handler(fn func(from, body string)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.GROUPCHAT || h.Type == stanza.ERROR || h.Archived {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil || !Has(m) {
+			return false
+		}
+		fn(h.From.String(), m.Body)
+		return m.Body == ""
+	}
+}
@@ -0,0 +1,114 @@
+// Package avatar publishes and fetches XEP-0084 user avatars, falling back to
+// the XEP-0054 vCard photo where PEP isn't available.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/vcard"
+)
+
+const (
+	NsData     = "urn:xmpp:avatar:data"
+	NsMetadata = "urn:xmpp:avatar:metadata"
+)
+
+var ErrNoAvatar = errors.New("avatar: no avatar")
+
+type data struct {
+	XMLName xml.Name `xml:"urn:xmpp:avatar:data data"`
+	Data    string   `xml:",chardata"`
+}
+
+// Info describes a published avatar.
+type Info struct {
+	ID     string `xml:"id,attr"`
+	Bytes  int    `xml:"bytes,attr"`
+	Type   string `xml:"type,attr"`
+	Width  int    `xml:"width,attr,omitempty"`
+	Height int    `xml:"height,attr,omitempty"`
+}
+
+type metadata struct {
+	XMLName xml.Name `xml:"urn:xmpp:avatar:metadata metadata"`
+	Info    []Info   `xml:"info"`
+}
+
+// Publish publishes the image as the avatar of the account, the vCard photo
+// is set instead when the server refuses the PEP nodes.
+func Publish(d *dispatch.Dispatcher, img []byte) error {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(img)
+	info := Info{ID: hex.EncodeToString(sum[:]), Bytes: len(img), Type: "image/" + format, Width: cfg.Width, Height: cfg.Height}
+	enc := base64.StdEncoding.EncodeToString(img)
+	if _, err = pubsub.Publish(d, "", NsData, info.ID, &data{Data: enc}, nil); err == nil {
+		_, err = pubsub.Publish(d, "", NsMetadata, info.ID, &metadata{Info: []Info{info}}, nil)
+	}
+	if _, ok := err.(*stanza.IQError); !ok {
+		return err
+	}
+	v, err := vcard.Get(d, jid.JID{})
+	if err != nil {
+		return err
+	}
+	v.Photo = &vcard.Photo{Type: info.Type, BinVal: enc}
+	return vcard.Set(d, v)
+}
+
+// Fetch returns the avatar of addr with its type, the vCard photo is tried
+// when no avatar is published.
+func Fetch(d *dispatch.Dispatcher, addr string) ([]byte, string, error) {
+	to, err := jid.Parse(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	if img, typ, err := fetchPEP(d, addr); err == nil {
+		return img, typ, nil
+	}
+	v, err := vcard.Get(d, to)
+	if err != nil {
+		return nil, "", err
+	}
+	if v.Photo == nil || v.Photo.BinVal == "" {
+		return nil, "", ErrNoAvatar
+	}
+	img, err := v.Photo.Data()
+	return img, v.Photo.Type, err
+}
+
+func fetchPEP(d *dispatch.Dispatcher, addr string) ([]byte, string, error) {
+	items, err := pubsub.Items(d, addr, NsMetadata, 1)
+	if err != nil {
+		return nil, "", err
+	}
+	m := &metadata{}
+	if len(items) == 0 || items[0].Decode(m) != nil || len(m.Info) == 0 {
+		return nil, "", ErrNoAvatar
+	}
+	info := m.Info[0]
+	if items, err = pubsub.Items(d, addr, NsData, 0, info.ID); err != nil {
+		return nil, "", err
+	}
+	x := &data{}
+	if len(items) == 0 || items[0].Decode(x) != nil {
+		return nil, "", ErrNoAvatar
+	}
+	img, err := base64.StdEncoding.DecodeString(x.Data)
+	return img, info.Type, err
+}
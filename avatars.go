@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivpusic/neo"
+	"github.com/kpmy/xep/avatar"
+)
+
+// avatarTTL is how long fetched avatars are served from the cache.
+const avatarTTL = time.Hour
+
+type cachedAvatar struct {
+	img []byte
+	typ string
+	at  time.Time
+}
+
+var avatars = struct {
+	sync.Mutex
+	m map[string]*cachedAvatar
+}{m: make(map[string]*cachedAvatar)}
+
+// publishAvatar publishes the -avatar image of the bot.
+func publishAvatar() {
+	if avatarFile == "" {
+		return
+	}
+	img, err := ioutil.ReadFile(avatarFile)
+	if err == nil {
+		err = avatar.Publish(disp, img)
+	}
+	if err != nil {
+		log.Println("failed to publish the avatar:", err)
+	}
+}
+
+func fetchAvatar(jid string) (*cachedAvatar, error) {
+	avatars.Lock()
+	a, ok := avatars.m[jid]
+	avatars.Unlock()
+	if ok && time.Since(a.at) < avatarTTL {
+		return a, nil
+	}
+	img, typ, err := avatar.Fetch(disp, jid)
+	if err != nil {
+		return nil, err
+	}
+	a = &cachedAvatar{img: img, typ: typ, at: time.Now()}
+	avatars.Lock()
+	avatars.m[jid] = a
+	avatars.Unlock()
+	return a, nil
+}
+
+// avatarRoutes serves the avatars of users to the log viewer.
+func avatarRoutes(app *neo.Application) {
+	app.Get("/avatar", func(ctx *neo.Ctx) (int, error) {
+		jid := ctx.Req.URL.Query().Get("jid")
+		if !strings.Contains(jid, "@") {
+			return 400, errors.New("bad jid " + jid)
+		}
+		if disp == nil {
+			return 503, errors.New("not connected")
+		}
+		a, err := fetchAvatar(jid)
+		if err == avatar.ErrNoAvatar {
+			return 404, err
+		} else if err != nil {
+			return 502, err
+		}
+		ctx.Res.Header().Set("Content-Type", a.typ)
+		ctx.Res.Header().Set("Cache-Control", "max-age=3600")
+		_, err = ctx.Res.Write(a.img)
+		return 200, err
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/mam"
+	"github.com/kpmy/xep/stanza"
+)
+
+var archive = mam.New()
+
+// countStats is a handler counting the live and archived messages of the
+// joined rooms, the history sent on join is left to !backfill.
+func countStats() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type != stanza.GROUPCHAT {
+			return false
+		}
+		room, nick := splitJID(h.From)
+		if _, ok := rooms.Get(room); !ok || nick == "" {
+			return false
+		}
+		m := &stanza.Message{}
+		// corrections change a counted message rather than add one
+		if xml.Unmarshal(raw, m) == nil && (m.Delay == nil || h.Archived) && m.Replaces() == "" {
+			IncStat(room, statUser(nick), m.StanzaID(room), m.Sent())
+		}
+		return false
+	}
+}
+
+func splitJID(addr jid.JID) (bare, resource string) {
+	return addr.Bare().String(), addr.Resource
+}
+
+// newMessage is stanza.NewMessage for the addresses kept as strings.
+func newMessage(typ, to, body string) (*stanza.Message, error) {
+	addr, err := jid.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	return stanza.NewMessage(typ, addr, body), nil
+}
+
+// sameJID compares addresses the way servers do, ignoring the case of the
+// localpart and the domain.
+func sameJID(a, b string) bool {
+	ja, errA := jid.Parse(a)
+	jb, errB := jid.Parse(b)
+	return errA == nil && errB == nil && ja == jb
+}
+
+// backfill counts the archived messages of the room the bot missed.
+func backfill(room string, window time.Duration) (recovered, total int, err error) {
+	res, err := archive.Query(disp, room, mam.Filter{Start: time.Now().Add(-window)})
+	if err != nil {
+		return
+	}
+	me := rooms.Nick(room)
+	for _, r := range res {
+		m := &stanza.Message{}
+		if xml.Unmarshal(r.Stanza, m) != nil || m.Body == "" || m.Replaces() != "" {
+			continue
+		}
+		_, nick := splitJID(m.From)
+		if nick == "" || nick == me {
+			continue
+		}
+		total++
+		at := time.Now()
+		if r.Delay != nil {
+			at = r.Delay.Stamp
+		}
+		if IncStat(room, statUser(nick), r.ID, at) {
+			recovered++
+			if statsSalt == "" {
+				log.Println("BACKFILL", room, at.Format(time.RFC3339), nick+":", m.Body)
+			}
+		}
+	}
+	return
+}
+
+// catchUp replays the archives of the joined rooms since the given time, so
+// the messages said while the bot was away reach the handlers.
+func catchUp(since time.Time) {
+	if limit := time.Now().Add(-seenTTL); since.Before(limit) {
+		since = limit
+	}
+	for _, r := range rooms.List() {
+		n, err := archive.Replay(disp, r.JID, mam.Filter{Start: since})
+		if err != nil {
+			log.Println("failed to catch up with", r.JID, err)
+			continue
+		}
+		log.Println("CATCHUP", r.JID, n, "archived messages")
+	}
+}
+
+func init() {
+	commands["backfill"] = &command{admin: true, usage: "<duration, e.g. 2h>", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		window, err := time.ParseDuration(c.args[0])
+		if err != nil || window <= 0 {
+			return "", errUsage
+		}
+		if window > seenTTL {
+			return "", fmt.Errorf("can't backfill more than %s", seenTTL)
+		}
+		recovered, total, err := backfill(c.room, window)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("recovered %d of %d archived messages", recovered, total), nil
+	}}
+}
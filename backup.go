@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fjl/go-couchdb"
+	"golang.org/x/crypto/scrypt"
+)
+
+// backups are a gzipped tar sealed with AES-GCM under a scrypt derived key:
+// magic, salt, nonce, ciphertext
+const (
+	backupMagic  = "XEPBAK1\n"
+	backupKeyEnv = "XEP_BACKUP_PASSPHRASE"
+	saltSize     = 16
+)
+
+var errBadBackup = errors.New("not a backup or wrong passphrase")
+
+func backupKey(salt []byte) ([]byte, error) {
+	pass := os.Getenv(backupKeyEnv)
+	if pass == "" {
+		return nil, errors.New(backupKeyEnv + " is not set")
+	}
+	return scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+}
+
+func backupCipher(salt []byte) (cipher.AEAD, error) {
+	key, err := backupKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type allDocs struct {
+	Rows []struct {
+		ID  string          `json:"id"`
+		Doc json.RawMessage `json:"doc"`
+	} `json:"rows"`
+}
+
+// backup writes the couchdb documents, stats and room configs alike, and the
+// module state and manifests into an encrypted archive.
+func backup(path string) error {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	docs := &allDocs{}
+	if err := db.AllDocs(docs, couchdb.Options{"include_docs": true}); err != nil {
+		return err
+	}
+	for _, r := range docs.Rows {
+		if strings.HasPrefix(r.ID, "_design/") {
+			continue
+		}
+		if err := add("couchdb/"+r.ID+".json", r.Doc); err != nil {
+			return err
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(modulesDir, "*.json"))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if err = add("modules/"+filepath.Base(f), data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := backupCipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append([]byte(backupMagic), salt...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, buf.Bytes(), []byte(backupMagic))
+	log.Println("backed up", len(docs.Rows), "documents and", len(files), "module files to", path)
+	return os.WriteFile(path, out, 0600)
+}
+
+// restore puts the documents and module files of a backup back, overwriting
+// the current ones.
+func restore(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(backupMagic)) || len(data) < len(backupMagic)+saltSize {
+		return errBadBackup
+	}
+	data = data[len(backupMagic):]
+	aead, err := backupCipher(data[:saltSize])
+	if err != nil {
+		return err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return errBadBackup
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return errBadBackup
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		switch dir, name := filepath.Split(h.Name); dir {
+		case "couchdb/":
+			err = restoreDoc(strings.TrimSuffix(name, ".json"), body)
+		case "modules/":
+			if err = os.MkdirAll(modulesDir, 0755); err == nil {
+				err = os.WriteFile(filepath.Join(modulesDir, name), body, 0600)
+			}
+		default:
+			log.Println("skipping", h.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	log.Println("restored", n, "entries from", path)
+	return nil
+}
+
+func restoreDoc(id string, data []byte) error {
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	delete(doc, "_id")
+	delete(doc, "_rev")
+	rev, err := db.Rev(id)
+	if err != nil && !couchdb.NotFound(err) {
+		return err
+	}
+	_, err = db.Put(id, doc, rev)
+	return err
+}
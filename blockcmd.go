@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kpmy/xep/blocking"
+)
+
+func init() {
+	commands["block"] = &command{admin: true, usage: "<jid>...", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		return "blocked " + strings.Join(c.args, ", "), blocking.Block(disp, c.args...)
+	}}
+	commands["unblock"] = &command{admin: true, usage: "<jid>... | all", run: func(c *cmd) (string, error) {
+		switch {
+		case len(c.args) == 0:
+			return "", errUsage
+		case len(c.args) == 1 && c.args[0] == "all":
+			return "unblocked everyone", blocking.Unblock(disp)
+		}
+		return "unblocked " + strings.Join(c.args, ", "), blocking.Unblock(disp, c.args...)
+	}}
+	commands["blocked"] = &command{admin: true, run: func(c *cmd) (string, error) {
+		l, err := blocking.ListBlocked(disp)
+		if err != nil {
+			return "", err
+		}
+		if len(l) == 0 {
+			return "nobody is blocked", nil
+		}
+		return strings.Join(l, ", "), nil
+	}}
+}
@@ -0,0 +1,74 @@
+// Package blocking implements XEP-0191 blocking command, which makes the
+// server drop everything from the blocked JIDs.
+package blocking
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:blocking"
+
+type item struct {
+	JID string `xml:"jid,attr"`
+}
+
+type blocklist struct {
+	XMLName xml.Name `xml:"urn:xmpp:blocking blocklist"`
+	Items   []item   `xml:"item"`
+}
+
+type block struct {
+	XMLName xml.Name `xml:"urn:xmpp:blocking block"`
+	Items   []item   `xml:"item"`
+}
+
+type unblock struct {
+	XMLName xml.Name `xml:"urn:xmpp:blocking unblock"`
+	Items   []item   `xml:"item"`
+}
+
+func items(jids []string) (ret []item) {
+	for _, j := range jids {
+		ret = append(ret, item{JID: j})
+	}
+	return
+}
+
+func request(d *dispatch.Dispatcher, typ string, payload interface{}) (*stanza.IQ, error) {
+	iq, err := stanza.NewIQ(typ, jid.JID{}, payload)
+	if err != nil {
+		return nil, err
+	}
+	return d.Request(iq)
+}
+
+// Block asks the server to block the JIDs.
+func Block(d *dispatch.Dispatcher, jids ...string) error {
+	_, err := request(d, stanza.SET, &block{Items: items(jids)})
+	return err
+}
+
+// Unblock lifts the block of the JIDs, or of everyone when none are given.
+func Unblock(d *dispatch.Dispatcher, jids ...string) error {
+	_, err := request(d, stanza.SET, &unblock{Items: items(jids)})
+	return err
+}
+
+// ListBlocked returns the blocked JIDs.
+func ListBlocked(d *dispatch.Dispatcher) (ret []string, err error) {
+	res, err := request(d, stanza.GET, &blocklist{})
+	if err != nil {
+		return nil, err
+	}
+	l := &blocklist{}
+	if err = res.Decode(l); err == nil {
+		for _, i := range l.Items {
+			ret = append(ret, i.JID)
+		}
+	}
+	return
+}
@@ -0,0 +1,193 @@
+// Package bookmarks manages room bookmarks stored in PEP (XEP-0402), falling
+// back to private XML storage (XEP-0048) on servers without it.
+package bookmarks
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns       = "urn:xmpp:bookmarks:1"
+	NsLegacy = "storage:bookmarks"
+)
+
+// Conference is a bookmarked room.
+type Conference struct {
+	JID      string
+	Name     string
+	Nick     string
+	Password string
+	Autojoin bool
+}
+
+type conference struct {
+	XMLName  xml.Name `xml:"urn:xmpp:bookmarks:1 conference"`
+	Name     string   `xml:"name,attr,omitempty"`
+	Autojoin bool     `xml:"autojoin,attr,omitempty"`
+	Nick     string   `xml:"nick,omitempty"`
+	Password string   `xml:"password,omitempty"`
+}
+
+type item struct {
+	ID         string      `xml:"id,attr"`
+	Conference *conference `xml:"conference"`
+}
+
+type items struct {
+	Node  string `xml:"node,attr"`
+	Items []item `xml:"item"`
+}
+
+type publish struct {
+	Node string `xml:"node,attr"`
+	Item item   `xml:"item"`
+}
+
+type options struct {
+	Form *dataforms.Form
+}
+
+type retract struct {
+	Node   string `xml:"node,attr"`
+	Notify bool   `xml:"notify,attr,omitempty"`
+	Item   item   `xml:"item"`
+}
+
+type pubsub struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+	Items   *items   `xml:"items"`
+	Publish *publish `xml:"publish"`
+	Options *options `xml:"publish-options"`
+	Retract *retract `xml:"retract"`
+}
+
+type legacyConference struct {
+	JID      string `xml:"jid,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+	Nick     string `xml:"nick,omitempty"`
+	Password string `xml:"password,omitempty"`
+	Autojoin bool   `xml:"autojoin,attr,omitempty"`
+}
+
+type private struct {
+	XMLName xml.Name `xml:"jabber:iq:private query"`
+	Storage struct {
+		XMLName     xml.Name           `xml:"storage:bookmarks storage"`
+		Conferences []legacyConference `xml:"conference"`
+		// urls and other children are dropped, the bot doesn't use them
+	}
+}
+
+// Fetch returns the bookmarks of the account.
+func Fetch(d *dispatch.Dispatcher) ([]Conference, error) {
+	q := &pubsub{Items: &items{Node: Ns}}
+	iq, _ := stanza.NewIQ(stanza.GET, jid.JID{}, q)
+	res, err := d.Request(iq)
+	if err != nil {
+		if _, ok := err.(*stanza.IQError); ok {
+			// no PEP bookmarks, e.g. item-not-found or no PEP at all
+			return fetchLegacy(d)
+		}
+		return nil, err
+	}
+	q = &pubsub{}
+	if err = res.Decode(q); err != nil {
+		return nil, err
+	}
+	ret := []Conference{}
+	if q.Items != nil {
+		for _, i := range q.Items.Items {
+			if c := i.Conference; c != nil {
+				ret = append(ret, Conference{JID: i.ID, Name: c.Name, Nick: c.Nick, Password: c.Password, Autojoin: c.Autojoin})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func fetchLegacy(d *dispatch.Dispatcher) ([]Conference, error) {
+	p, err := getPrivate(d)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Conference{}
+	for _, c := range p.Storage.Conferences {
+		ret = append(ret, Conference(c))
+	}
+	return ret, nil
+}
+
+func getPrivate(d *dispatch.Dispatcher) (*private, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, jid.JID{}, &private{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	p := &private{}
+	return p, res.Decode(p)
+}
+
+func setPrivate(d *dispatch.Dispatcher, p *private) error {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, p)
+	_, err := d.Request(iq)
+	return err
+}
+
+// Add adds or updates a bookmark.
+func Add(d *dispatch.Dispatcher, c Conference) error {
+	q := &pubsub{Options: &options{Form: dataforms.NewSubmit("http://jabber.org/protocol/pubsub#publish-options", dataforms.Single(map[string]string{
+		"pubsub#persist_items":            "true",
+		"pubsub#max_items":                "max",
+		"pubsub#send_last_published_item": "never",
+		"pubsub#access_model":             "whitelist",
+	}))}}
+	q.Publish = &publish{Node: Ns, Item: item{ID: c.JID, Conference: &conference{Name: c.Name, Autojoin: c.Autojoin, Nick: c.Nick, Password: c.Password}}}
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, q)
+	if _, err := d.Request(iq); err == nil {
+		return nil
+	} else if _, ok := err.(*stanza.IQError); !ok {
+		return err
+	}
+	p, err := getPrivate(d)
+	if err != nil {
+		return err
+	}
+	updated := false
+	for i, lc := range p.Storage.Conferences {
+		if lc.JID == c.JID {
+			p.Storage.Conferences[i], updated = legacyConference(c), true
+		}
+	}
+	if !updated {
+		p.Storage.Conferences = append(p.Storage.Conferences, legacyConference(c))
+	}
+	return setPrivate(d, p)
+}
+
+// Remove deletes the bookmark of a room.
+func Remove(d *dispatch.Dispatcher, room string) error {
+	q := &pubsub{Retract: &retract{Node: Ns, Notify: true, Item: item{ID: room}}}
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, q)
+	if _, err := d.Request(iq); err == nil {
+		return nil
+	} else if _, ok := err.(*stanza.IQError); !ok {
+		return err
+	}
+	p, err := getPrivate(d)
+	if err != nil {
+		return err
+	}
+	kept := p.Storage.Conferences[:0]
+	for _, lc := range p.Storage.Conferences {
+		if lc.JID != room {
+			kept = append(kept, lc)
+		}
+	}
+	p.Storage.Conferences = kept
+	return setPrivate(d, p)
+}
@@ -0,0 +1,52 @@
+// Package botstatus publishes a machine-readable status document of the bot to
+// a PEP node, so monitoring tools can subscribe to it over XMPP.
+package botstatus
+
+import (
+	"encoding/xml"
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/pep"
+)
+
+const Ns = "urn:xmpp:xep:bot:status:0"
+
+// Queue is the depth of one of the bot queues.
+type Queue struct {
+	Name  string `xml:"name,attr"`
+	Depth int    `xml:"depth,attr"`
+}
+
+// Doc is the published status.
+type Doc struct {
+	XMLName xml.Name  `xml:"urn:xmpp:xep:bot:status:0 status"`
+	Version string    `xml:"version,omitempty"`
+	Started time.Time `xml:"started"`
+	Uptime  int64     `xml:"uptime"`
+	Rooms   []string  `xml:"rooms>room"`
+	Queues  []Queue   `xml:"queues>queue"`
+}
+
+// Publish replaces the status on the node of the account, only the current
+// item is kept and it is open to anyone, the subscribers are notified.
+func Publish(d *dispatch.Dispatcher, doc *Doc) error {
+	return pep.PublishOpen(d, Ns, doc)
+}
+
+// Every publishes the status made by fn at the interval until stop is closed.
+func Every(d *dispatch.Dispatcher, interval time.Duration, fn func() *Doc, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := Publish(d, fn()); err != nil {
+			log.Println("failed to publish the status:", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kpmy/xep/attention"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/units"
+)
+
+var started = time.Now()
+
+// summary is the bot status sent back to those asking for its attention.
+func summary() string {
+	ret := fmt.Sprintf("%s up %s, in %d rooms, idle %s", ME, time.Since(started).Truncate(time.Second), len(rooms.List()), idle().Truncate(time.Second))
+	if sess != nil {
+		if missing := sess.Missing(); len(missing) > 0 {
+			ret += ", server lacks " + strings.Join(missing, ", ")
+		}
+	}
+	return ret
+}
+
+// onAttention answers a buzz from a contact or an occupant with the summary.
+func onAttention(from, body string) {
+	room, _ := jid.Split(from)
+	if _, ok := rooms.Get(room); !ok && !contacts.Contains(from) {
+		return
+	}
+	m, err := newMessage(stanza.CHAT, from, summary())
+	if err == nil {
+		err = disp.Send(m)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+var errNoAttention = errors.New("attention is not allowed here")
+
+// buzz asks for the attention of addr, occupants only when their room allows it.
+func buzz(addr, body string) error {
+	to, err := jid.Parse(addr)
+	if err != nil {
+		return err
+	}
+	room, nick := splitJID(to)
+	if _, ok := rooms.Get(room); ok {
+		if nick == "" {
+			return errNoAttention
+		}
+		if c, err := GetRoomConfig(room); err != nil || !c.Attention {
+			return errNoAttention
+		}
+	} else if !contacts.Contains(addr) {
+		return errNoAttention
+	}
+	return attention.Send(disp, to, body)
+}
+
+func init() {
+	commands["buzz"] = &command{admin: true, usage: "<nick> [text]", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		return "", buzz(units.Bare2Full(c.room, c.args[0]), strings.Join(c.args[1:], " "))
+	}}
+}
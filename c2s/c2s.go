@@ -0,0 +1,201 @@
+// Package c2s connects to a server as a client. The stanzas are read off the
+// connection with stanza.Reader, so they come whole however the server
+// splits them, and a malformed one is skipped rather than breaking the
+// stream.
+package c2s
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+const (
+	Ns = "jabber:client"
+
+	// DefaultPort is the client port of servers without SRV records.
+	DefaultPort = 5222
+	// Timeout bounds the dialing and the opening of the stream.
+	Timeout = 30 * time.Second
+)
+
+var (
+	errNotOpened = errors.New("c2s: the server didn't open the stream")
+	errBroken    = errors.New("c2s: the stream broke while opening")
+)
+
+var _ stream.Stream = (*Stream)(nil)
+
+// Stream is the connection of the client. Like the streams of xippo it is
+// plain TCP, STARTTLS is not negotiated.
+type Stream struct {
+	// OnParseError is told about the malformed stanzas skipped, on the
+	// goroutine calling Ring.
+	OnParseError func(*stanza.ParseError)
+	server       *units.Server
+	conn         net.Conn
+	in           chan *bytes.Buffer
+	skipped      chan *stanza.ParseError
+	opened       chan xml.StartElement
+	broken       chan struct{}
+	err          error
+	fallback     func(error)
+	wmu          sync.Mutex
+	id           atomic.Value
+	ids          int64
+}
+
+// Dial connects to the client port of the server, looked up by SRV. fallback
+// is called when the stream breaks, like the one of stream.New.
+func Dial(server *units.Server, fallback func(error)) (*Stream, error) {
+	conn, err := net.DialTimeout("tcp", addr(server.Name), Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newStream(server, conn, fallback), nil
+}
+
+func newStream(server *units.Server, conn net.Conn, fallback func(error)) *Stream {
+	s := &Stream{server: server, conn: conn, in: make(chan *bytes.Buffer), skipped: make(chan *stanza.ParseError, 16), opened: make(chan xml.StartElement, 1), broken: make(chan struct{}), fallback: fallback}
+	s.id.Store("")
+	r := stanza.NewReader(conn)
+	r.Recover = true
+	go s.read(r)
+	return s
+}
+
+// addr is the first SRV target of the domain, or the domain itself.
+func addr(domain string) string {
+	if _, srv, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(srv) > 0 {
+		return net.JoinHostPort(srv[0].Target, strconv.Itoa(int(srv[0].Port)))
+	}
+	return net.JoinHostPort(domain, strconv.Itoa(DefaultPort))
+}
+
+// Start opens the stream, or restarts it after authentication, and waits for
+// the server to open its own; it is a step in place of steps.Starter.
+func (s *Stream) Start(stream.Stream) error {
+	// a header left from a restart nobody waited for is stale
+	select {
+	case <-s.opened:
+	default:
+	}
+	header := fmt.Sprintf(`<?xml version='1.0'?><stream:stream xmlns='%s' xmlns:stream='%s' to='%s' version='1.0'>`, Ns, stanza.NsStream, s.server.Name)
+	s.wmu.Lock()
+	_, err := io.WriteString(s.conn, header)
+	s.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case se := <-s.opened:
+		for _, a := range se.Attr {
+			if a.Name.Local == "id" {
+				s.id.Store(a.Value)
+			}
+		}
+		return nil
+	case <-s.broken:
+		return errBroken
+	case <-time.After(Timeout):
+		return errNotOpened
+	}
+}
+
+func (s *Stream) read(r *stanza.Reader) {
+	for {
+		raw, err := r.Next()
+		if err == stanza.ErrRestart {
+			select {
+			case s.opened <- r.Header():
+			default:
+			}
+			continue
+		}
+		if pe, ok := err.(*stanza.ParseError); ok {
+			select {
+			case s.skipped <- pe:
+			default:
+				// nobody rings, the stream goes on without telling
+			}
+			continue
+		}
+		if err != nil {
+			s.conn.Close()
+			s.err = err
+			close(s.broken)
+			close(s.in)
+			if s.fallback != nil {
+				s.fallback(err)
+			}
+			return
+		}
+		s.in <- bytes.NewBuffer(raw)
+	}
+}
+
+func (s *Stream) Server() *units.Server { return s.server }
+
+func (s *Stream) Write(b *bytes.Buffer) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+// Ring delivers the received stanzas until fn is done with one or for the
+// timeout, forever if zero; it returns once the stream breaks, Err tells why.
+func (s *Stream) Ring(fn func(*bytes.Buffer) bool, timeout time.Duration) {
+	var after <-chan time.Time
+	if timeout > 0 {
+		after = time.After(timeout)
+	}
+	for {
+		select {
+		case b, ok := <-s.in:
+			if !ok || fn(b) {
+				return
+			}
+		case pe := <-s.skipped:
+			if s.OnParseError != nil {
+				s.OnParseError(pe)
+			}
+		case <-after:
+			return
+		}
+	}
+}
+
+// Err returns the error the stream broke with, nil while it is up.
+func (s *Stream) Err() error {
+	select {
+	case <-s.broken:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Id returns a fresh id, prefixed with the stream id.
+func (s *Stream) Id(...string) string {
+	return s.id.Load().(string) + "-" + strconv.FormatInt(atomic.AddInt64(&s.ids, 1), 10)
+}
+
+// Close ends the stream.
+func (s *Stream) Close() error {
+	s.wmu.Lock()
+	io.WriteString(s.conn, "</stream:stream>")
+	s.wmu.Unlock()
+	return s.conn.Close()
+}
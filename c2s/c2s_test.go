@@ -0,0 +1,112 @@
+package c2s
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/units"
+)
+
+const (
+	header   = `<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='%s' from='xmpp.ru' version='1.0'>`
+	features = `<stream:features><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/></stream:features>`
+)
+
+// server answers every stream header of the client with its own and the
+// features, then sends the stanzas.
+func server(t *testing.T, conn net.Conn, stanzas ...string) {
+	r := bufio.NewReader(conn)
+	for _, id := range []string{"s1", "s2"} {
+		if _, err := r.ReadString('>'); err != nil {
+			t.Error(err)
+			return
+		}
+		if tag, err := r.ReadString('>'); err != nil || !strings.HasPrefix(tag, "<stream:stream") {
+			t.Errorf("client opened with %q, %v", tag, err)
+			return
+		}
+		io.WriteString(conn, strings.Replace(header, "%s", id, 1)+features)
+	}
+	for _, s := range stanzas {
+		io.WriteString(conn, s)
+	}
+	io.Copy(io.Discard, r)
+}
+
+func TestStream(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+	chat := `<message from="a@xmpp.ru/x" type="chat"><body>hi</body></message>`
+	go server(t, srv, `<message><body>a < b</body></message>`, chat)
+	s := newStream(&units.Server{Name: "xmpp.ru"}, client, nil)
+	var skipped []*stanza.ParseError
+	s.OnParseError = func(pe *stanza.ParseError) { skipped = append(skipped, pe) }
+	next := func() (ret string) {
+		s.Ring(func(b *bytes.Buffer) bool {
+			ret = b.String()
+			return true
+		}, time.Second)
+		return
+	}
+	for _, id := range []string{"s1", "s2"} {
+		if err := s.Start(s); err != nil {
+			t.Fatal(err)
+		}
+		if got := next(); got != features {
+			t.Fatalf("got %q, want the features", got)
+		}
+		if got := s.Id(); !strings.HasPrefix(got, id+"-") {
+			t.Fatalf("id %q of stream %s", got, id)
+		}
+	}
+	if got := next(); got != chat {
+		t.Fatalf("got %q, want %q", got, chat)
+	}
+	if len(skipped) != 1 {
+		t.Fatalf("skipped %d stanzas, want one", len(skipped))
+	}
+}
+
+func TestStartBroken(t *testing.T) {
+	client, srv := net.Pipe()
+	go func() {
+		bufio.NewReader(srv).ReadString('>')
+		srv.Close()
+	}()
+	broke := make(chan error, 1)
+	s := newStream(&units.Server{Name: "xmpp.ru"}, client, func(err error) { broke <- err })
+	if err := s.Start(s); err != errBroken {
+		t.Fatalf("started with %v", err)
+	}
+	if err := <-broke; err == nil {
+		t.Fatal("fallback told nothing")
+	}
+}
+
+func TestRingBroken(t *testing.T) {
+	client, srv := net.Pipe()
+	s := newStream(&units.Server{Name: "xmpp.ru"}, client, nil)
+	if s.Err() != nil {
+		t.Fatal("a live stream has an error")
+	}
+	srv.Close()
+	done := make(chan struct{})
+	go func() {
+		s.Ring(func(*bytes.Buffer) bool { return false }, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ring didn't return once the stream broke")
+	}
+	if s.Err() == nil {
+		t.Fatal("the broken stream tells no error")
+	}
+}
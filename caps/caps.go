@@ -0,0 +1,119 @@
+// Package caps implements XEP-0115 entity capabilities: the hash advertised
+// in our presence and a cache of the capabilities of others.
+package caps
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns   = "http://jabber.org/protocol/caps"
+	Node = "https://github.com/kpmy/xep"
+)
+
+type C struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/caps c"`
+	Hash    string   `xml:"hash,attr"`
+	Node    string   `xml:"node,attr"`
+	Ver     string   `xml:"ver,attr"`
+}
+
+// Ver computes the sha-1 verification string of the disco#info.
+func Ver(info *disco.InfoQuery) string {
+	ids := []string{}
+	for _, i := range info.Identities {
+		ids = append(ids, i.Category+"/"+i.Type+"//"+i.Name+"<")
+	}
+	sort.Strings(ids)
+	features := []string{}
+	for _, f := range info.Features {
+		features = append(features, f.Var+"<")
+	}
+	sort.Strings(features)
+	sum := sha1.Sum([]byte(strings.Join(ids, "") + strings.Join(features, "")))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Own returns the caps element for the identities and the features registered
+// with the dispatcher, as the disco responder advertises them.
+func Own(d *dispatch.Dispatcher, ids ...disco.Identity) *C {
+	info := &disco.InfoQuery{Identities: ids}
+	for _, f := range d.Features() {
+		info.Features = append(info.Features, disco.Feature{Var: f})
+	}
+	return &C{Hash: "sha-1", Node: Node, Ver: Ver(info)}
+}
+
+// Cache remembers the caps others advertise and the disco#info behind each
+// verification string, so a room full of the same client costs one query.
+type Cache struct {
+	sync.Mutex
+	jids map[jid.JID]*C
+	vers map[string]*disco.InfoQuery
+}
+
+func NewCache() *Cache {
+	return &Cache{jids: make(map[jid.JID]*C), vers: make(map[string]*disco.InfoQuery)}
+}
+
+// Handler records the caps of received presence, it never consumes it.
+func (c *Cache) Handler() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "presence" || h.From.IsZero() {
+			return false
+		}
+		p := &stanza.Presence{}
+		if xml.Unmarshal(raw, p) != nil {
+			return false
+		}
+		c.Lock()
+		defer c.Unlock()
+		if h.Type == stanza.UNAVAILABLE {
+			delete(c.jids, h.From)
+		} else if e := p.Ext(Ns, "c"); e != nil {
+			x := &C{}
+			if e.Decode(x) == nil {
+				c.jids[h.From] = x
+			}
+		}
+		return false
+	}
+}
+
+// Info returns the disco#info of to, from the cache when its caps are known.
+func (c *Cache) Info(d *dispatch.Dispatcher, to jid.JID) (*disco.InfoQuery, error) {
+	c.Lock()
+	x, ok := c.jids[to]
+	var info *disco.InfoQuery
+	if ok {
+		info = c.vers[x.Ver]
+	}
+	c.Unlock()
+	if info != nil {
+		return info, nil
+	}
+	if !ok {
+		return disco.Info(d, to)
+	}
+	info, err := disco.InfoNode(d, to, x.Node+"#"+x.Ver)
+	if err != nil {
+		return nil, err
+	}
+	// only answers matching the hash are shared with the other entities
+	if x.Hash == "sha-1" && Ver(info) == x.Ver {
+		c.Lock()
+		c.vers[x.Ver] = info
+		c.Unlock()
+	}
+	return info, nil
+}
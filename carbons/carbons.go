@@ -0,0 +1,61 @@
+// Package carbons implements XEP-0280 message carbons, the copies of the
+// messages sent and received by the other resources of the account.
+package carbons
+
+import (
+	"encoding/xml"
+	"log"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:carbons:2"
+
+type enable struct {
+	XMLName xml.Name `xml:"urn:xmpp:carbons:2 enable"`
+}
+
+type disable struct {
+	XMLName xml.Name `xml:"urn:xmpp:carbons:2 disable"`
+}
+
+// Enable asks the server to send carbons to this resource.
+func Enable(d *dispatch.Dispatcher) error {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &enable{})
+	_, err := d.Request(iq)
+	return err
+}
+
+// Disable stops the carbons.
+func Disable(d *dispatch.Dispatcher) error {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &disable{})
+	_, err := d.Request(iq)
+	return err
+}
+
+// Handler unwraps the carbons sent by the account to the given bare JID and
+// feeds the copied messages to the handlers as if they came directly, it
+// consumes the carbons.
+func Handler(d *dispatch.Dispatcher, account jid.JID) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" {
+			return false
+		}
+		env, err := stanza.Unwrap(raw)
+		if err != nil || env.Wrapper.Space != Ns {
+			return false
+		}
+		if from := env.From; !from.IsZero() && from != account {
+			// only our own account may send carbons, anything else is forged
+			log.Println("dropping carbon from", from)
+			return true
+		}
+		switch env.Wrapper.Local {
+		case "received", "sent":
+			d.Feed(env.Stanza)
+		}
+		return true
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/mix"
+	"github.com/kpmy/xep/stanza"
+)
+
+var channels = mix.NewChannels()
+
+// joinChannels joins the -mix channels.
+func joinChannels() {
+	for _, ch := range strings.Split(mixChannels, ",") {
+		if ch = strings.TrimSpace(ch); ch == "" {
+			continue
+		}
+		if _, err := channels.Join(disp, ch, ME); err != nil {
+			log.Println("failed to join channel", ch+":", err)
+		}
+	}
+}
+
+// onChannelMessage runs the commands said in the channels, as in the rooms,
+// and passes the messages on as events.
+func onChannelMessage(channel string, from mix.Info, m *stanza.Message) {
+	if m.Delay != nil || m.Replaces() != "" {
+		return
+	}
+	text := m.Text()
+	emit("mix", map[string]string{"channel": channel, "nick": from.Nick, "jid": from.JID, "body": text})
+	if body, ok := isCommand(channel, text); ok {
+		user := from.Nick
+		if from.JID != "" {
+			user = jid.Bare(from.JID)
+		}
+		go execCommand(&cmd{room: channel, sender: from.Nick, user: user}, body)
+	}
+}
+
+func init() {
+	commands["mix"] = &command{admin: true, usage: "list | join <channel> [nick] | leave <channel> | who <channel>", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		switch {
+		case c.args[0] == "list" && len(c.args) == 1:
+			var ret []string
+			for _, ch := range channels.List() {
+				ret = append(ret, ch.JID+" as "+ch.Nick)
+			}
+			if len(ret) == 0 {
+				return "no channels", nil
+			}
+			return strings.Join(ret, "\n"), nil
+		case c.args[0] == "join" && len(c.args) >= 2:
+			nick := ME
+			if len(c.args) > 2 {
+				nick = strings.Join(c.args[2:], " ")
+			}
+			ch, err := channels.Join(disp, c.args[1], nick)
+			if ch == nil {
+				return "", err
+			}
+			if err != nil {
+				log.Println(err)
+			}
+			return fmt.Sprintf("joined %s as %s", ch.JID, ch.Nick), nil
+		case c.args[0] == "leave" && len(c.args) == 2:
+			if _, ok := channels.Get(c.args[1]); !ok {
+				return "", errors.New("not in " + c.args[1])
+			}
+			if err := channels.Leave(disp, c.args[1]); err != nil {
+				return "", err
+			}
+			return "left " + c.args[1], nil
+		case c.args[0] == "who" && len(c.args) == 2:
+			var nicks []string
+			for _, p := range channels.Participants(c.args[1]) {
+				nicks = append(nicks, p.Nick)
+			}
+			if len(nicks) == 0 {
+				return "nobody known in " + c.args[1], nil
+			}
+			return strings.Join(nicks, ", "), nil
+		}
+		return "", errUsage
+	}}
+}
@@ -0,0 +1,97 @@
+// Package chatstates implements XEP-0085 chat state notifications.
+package chatstates
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "http://jabber.org/protocol/chatstates"
+
+const (
+	ACTIVE    = "active"
+	COMPOSING = "composing"
+	PAUSED    = "paused"
+	INACTIVE  = "inactive"
+	GONE      = "gone"
+)
+
+// Delay is how long a command may run before the bot shows it is typing.
+const Delay = 500 * time.Millisecond
+
+// Parse returns the chat state of a raw message, if it has one.
+func Parse(raw []byte) (string, bool) {
+	m := &stanza.Message{}
+	if xml.Unmarshal(raw, m) != nil {
+		return "", false
+	}
+	return Of(m)
+}
+
+func Of(m *stanza.Message) (string, bool) {
+	for _, e := range m.Extensions {
+		if e.XMLName.Space == Ns {
+			return e.XMLName.Local, true
+		}
+	}
+	return "", false
+}
+
+type state struct {
+	XMLName xml.Name
+}
+
+// Send sends a standalone chat state notification.
+func Send(d *dispatch.Dispatcher, typ string, to jid.JID, st string) error {
+	m := stanza.NewMessage(typ, to, "")
+	if err := m.With(&state{XMLName: xml.Name{Space: Ns, Local: st}}); err != nil {
+		return err
+	}
+	// standalone notifications are of no use in the archives
+	m.Hint(stanza.NoStore)
+	return d.Send(m)
+}
+
+// Typing shows the bot composing if the work takes longer than Delay, the
+// returned func ends the work and goes back to active.
+func Typing(d *dispatch.Dispatcher, typ string, to jid.JID) (done func()) {
+	var mu sync.Mutex
+	composing := false
+	t := time.AfterFunc(Delay, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		composing = Send(d, typ, to, COMPOSING) == nil
+	})
+	return func() {
+		t.Stop()
+		mu.Lock()
+		defer mu.Unlock()
+		if composing {
+			Send(d, typ, to, ACTIVE)
+		}
+	}
+}
+
+// Handler passes the chat states received to fn, it only consumes standalone
+// notifications, so the messages with a body go on to the bot.
+func Handler(fn func(from, state string)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Archived {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil {
+			return false
+		}
+		st, ok := Of(m)
+		if ok {
+			fn(h.From.String(), st)
+		}
+		return ok && m.Body == "" && len(m.Extensions) == 1
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/kpmy/xep/chatstates"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/msg"
+	"github.com/kpmy/xep/reactions"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/xhtmlim"
+	"github.com/kpmy/xippo/units"
+)
+
+// cmd is a bang command received in a room or, when direct is set, in a
+// chat with a roster contact; commands act on room either way.
+type cmd struct {
+	room   string
+	sender string
+	user   string
+	args   []string
+	direct bool
+	// secure is set for commands sent OMEMO encrypted, they are answered so
+	secure bool
+	// signed is set for commands with a verified OpenPGP signature
+	signed bool
+	// id is the stanza-id the room gave the command message and body its
+	// text, replies in the room refer to them
+	id   string
+	body string
+	// re is the stanza-id of the message the command replies to
+	re string
+}
+
+func (c *cmd) reply(text string) {
+	if c.secure {
+		if err := sendSecure(c.sender, text); err != nil {
+			log.Println("failed to send encrypted reply:", err)
+		}
+	} else if c.direct {
+		m, err := newMessage(stanza.CHAT, c.sender, text)
+		if err == nil {
+			err = disp.Send(m)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	} else if c.id != "" {
+		m, err := msg.ToAddr(c.room).Type(msg.Groupchat).ID(disp.NextID()).Body(text).ReplyTo(c.id).Quote(units.Bare2Full(c.room, c.sender), c.body).Build()
+		if err == nil {
+			err = disp.Send(m)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	} else {
+		reply(c.room, text)
+	}
+}
+
+// replyRich answers with formatted text, sent as XHTML-IM as well when the
+// room has opted in to it.
+func (c *cmd) replyRich(d xhtmlim.Doc) {
+	if c.secure {
+		c.reply(d.Plain())
+		return
+	}
+	if cfg, err := GetRoomConfig(c.room); err != nil || !cfg.Rich {
+		c.reply(d.Plain())
+		return
+	}
+	typ, to := stanza.GROUPCHAT, c.room
+	if c.direct {
+		typ, to = stanza.CHAT, c.sender
+	}
+	addr, err := jid.Parse(to)
+	if err != nil {
+		log.Println(err)
+		c.reply(d.Plain())
+		return
+	}
+	m, err := xhtmlim.NewMessage(typ, addr, d)
+	if err != nil {
+		log.Println(err)
+		c.reply(d.Plain())
+		return
+	}
+	m.ID = disp.NextID()
+	if !c.direct && c.id != "" {
+		m.InReplyTo(units.Bare2Full(c.room, c.sender), c.id, c.body)
+	}
+	if err := disp.Send(m); err != nil {
+		log.Println(err)
+	}
+}
+
+// typing shows the bot composing in the chat or room of the command while it
+// runs long, the returned func ends it.
+func (c *cmd) typing() func() {
+	typ, to := stanza.GROUPCHAT, c.room
+	if c.direct {
+		typ, to = stanza.CHAT, c.sender
+	}
+	addr, err := jid.Parse(to)
+	if err != nil {
+		return func() {}
+	}
+	return chatstates.Typing(disp, typ, addr)
+}
+
+type command struct {
+	admin bool
+	// confirm makes a destructive command wait for !confirm with a token
+	confirm bool
+	usage   string
+	run     func(c *cmd) (string, error)
+}
+
+var commands = map[string]*command{}
+
+var errUsage = errors.New("usage")
+
+func reply(room, text string) {
+	post(room, text, "")
+}
+
+// post sends text to the room, as a correction of the message with the id
+// unless it is empty, and returns the id of the message sent.
+func post(room, text, correct string) string {
+	b := msg.ToAddr(room).Type(msg.Groupchat).ID(disp.NextID()).Origin().Body(text)
+	if correct != "" {
+		b.Correct(correct)
+	}
+	m, err := b.Build()
+	if err == nil {
+		err = disp.Send(m)
+	}
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return m.ID
+}
+
+func isAdmin(room, sender, user string) bool {
+	ids := []string{user}
+	if o, ok := rooms.Occupant(room, sender); ok && o.JID != "" {
+		ids = append(ids, jid.Bare(o.JID))
+	}
+	for _, a := range strings.Split(admins, ",") {
+		for _, id := range ids {
+			if a = strings.TrimSpace(a); a != "" && strings.EqualFold(a, id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commandHandler runs the commands said in the joined rooms, it never
+// consumes the messages.
+func commandHandler() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type != stanza.GROUPCHAT {
+			return false
+		}
+		room, nick := splitJID(h.From)
+		if _, ok := rooms.Get(room); !ok || nick == "" || nick == rooms.Nick(room) {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil || m.Delay != nil || m.Replaces() != "" {
+			return false
+		}
+		text := m.Text()
+		if body, ok := isCommand(room, text); ok {
+			c := &cmd{room: room, sender: nick, user: statUser(nick), id: m.StanzaID(room), body: text}
+			if r := m.Reply(); r != nil {
+				c.re = r.ID
+			}
+			go execCommand(c, body)
+		}
+		return false
+	}
+}
+
+// directCommandHandler runs the commands said in chats, it never consumes
+// the messages.
+func directCommandHandler() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type != stanza.CHAT || h.Archived {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil || m.Delay != nil || m.Replaces() != "" {
+			return false
+		}
+		if text := m.Text(); strings.HasPrefix(text, "!") {
+			go runDirectCommand(h.From.String(), text)
+		}
+		return false
+	}
+}
+
+// runDirectCommand runs a command sent in a chat, only roster contacts may do so.
+func runDirectCommand(from, body string) {
+	if !contacts.Contains(from) {
+		log.Println("ignoring command from", from, "not on the roster")
+		return
+	}
+	bare := jid.Bare(from)
+	execCommand(&cmd{room: ROOM, sender: from, user: bare, direct: true}, body)
+}
+
+func execCommand(ctx *cmd, body string) {
+	f := strings.Fields(strings.TrimPrefix(body, "!"))
+	if len(f) == 0 {
+		return
+	}
+	c, ok := commands[f[0]]
+	if !ok {
+		return
+	}
+	active()
+	if c.admin && !isAdmin(ctx.room, ctx.sender, ctx.user) {
+		ctx.reply(ctx.sender + ": access denied")
+		return
+	}
+	if c.admin && pgpRequire && !ctx.signed {
+		ctx.reply(ctx.sender + ": admin commands must be OpenPGP signed")
+		return
+	}
+	ctx.args = f[1:]
+	if c.confirm {
+		askConfirm(ctx, f[0], c)
+		return
+	}
+	finish(ctx, f[0], c)
+}
+
+func finish(ctx *cmd, name string, c *command) {
+	done := ctx.typing()
+	out, err := c.run(ctx)
+	done()
+	if err == nil && ctx.id != "" {
+		// acknowledge the command right on it
+		if err := reactions.React(disp, stanza.GROUPCHAT, ctx.room, ctx.id, "👍"); err != nil {
+			log.Println(err)
+		}
+	}
+	switch {
+	case err == errUsage:
+		ctx.reply("usage: !" + name + " " + c.usage)
+	case err != nil:
+		ctx.reply(ctx.sender + ": " + err.Error())
+	case out != "":
+		ctx.reply(out)
+	}
+}
@@ -0,0 +1,189 @@
+// Package component connects to a server as a XEP-0114 external component.
+// The connection is a stream.Stream, so the dispatcher and everything on top
+// of it run on a component the way they run on a client stream.
+package component
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+const (
+	Ns       = "jabber:component:accept"
+	NsStream = "http://etherx.jabber.org/streams"
+
+	// DefaultPort is the usual component port of servers.
+	DefaultPort = 5347
+	// Timeout bounds the dialing and the handshake.
+	Timeout = 30 * time.Second
+)
+
+var _ stream.Stream = (*Stream)(nil)
+
+// Stream is the connection of the component, the stanzas written to it get
+// From as their from unless they have one.
+type Stream struct {
+	From string
+	// OnParseError is told about the malformed stanzas skipped, on the
+	// goroutine calling Ring.
+	OnParseError func(*stanza.ParseError)
+	server       *units.Server
+	conn         net.Conn
+	id           string
+	in           chan *bytes.Buffer
+	skipped      chan *stanza.ParseError
+	fallback     func(error)
+	wmu          sync.Mutex
+	ids          int64
+}
+
+// Dial connects to the component port of the server at addr as the domain
+// and makes the handshake with the secret. fallback is called when the
+// stream breaks, like the one of stream.New.
+func Dial(addr, domain, secret string, fallback func(error)) (*Stream, error) {
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{From: domain, server: &units.Server{Name: domain}, conn: conn, in: make(chan *bytes.Buffer), skipped: make(chan *stanza.ParseError, 16), fallback: fallback}
+	r := stanza.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(Timeout))
+	if err := s.handshake(r, secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	r.Recover = true
+	go s.read(r)
+	return s, nil
+}
+
+func (s *Stream) handshake(r *stanza.Reader, secret string) error {
+	header := fmt.Sprintf(`<?xml version='1.0'?><stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>`, Ns, NsStream, s.server.Name)
+	if _, err := io.WriteString(s.conn, header); err != nil {
+		return err
+	}
+	se, err := r.Open()
+	if err != nil {
+		return err
+	}
+	for _, a := range se.Attr {
+		if a.Name.Local == "id" {
+			s.id = a.Value
+		}
+	}
+	if s.id == "" {
+		return errors.New("component: stream without id")
+	}
+	h := sha1.Sum([]byte(s.id + secret))
+	if _, err := io.WriteString(s.conn, "<handshake>"+hex.EncodeToString(h[:])+"</handshake>"); err != nil {
+		return err
+	}
+	raw, err := r.Next()
+	if err != nil {
+		return err
+	}
+	if name, _, err := stanza.Peek(raw); err != nil || name.Local != "handshake" {
+		return fmt.Errorf("component: handshake refused: %s", raw)
+	}
+	return nil
+}
+
+func (s *Stream) read(r *stanza.Reader) {
+	for {
+		raw, err := r.Next()
+		if pe, ok := err.(*stanza.ParseError); ok {
+			select {
+			case s.skipped <- pe:
+			default:
+				// nobody rings, the stream goes on without telling
+			}
+			continue
+		}
+		if err != nil {
+			s.conn.Close()
+			close(s.in)
+			if s.fallback != nil {
+				s.fallback(err)
+			}
+			return
+		}
+		s.in <- bytes.NewBuffer(raw)
+	}
+}
+
+func (s *Stream) Server() *units.Server { return s.server }
+
+// Write sends a stanza, stamping it with From when it has no from.
+func (s *Stream) Write(b *bytes.Buffer) error {
+	raw := b.Bytes()
+	if _, h, err := stanza.Peek(raw); err == nil && h.From.IsZero() && s.From != "" {
+		if i := bytes.IndexAny(raw, " />"); i > 0 {
+			raw = append(append(append([]byte(nil), raw[:i]...), ` from="`+escape(s.From)+`"`...), raw[i:]...)
+		}
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.conn.Write(raw)
+	return err
+}
+
+func escape(s string) string {
+	b := new(bytes.Buffer)
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// Ring delivers the received stanzas until fn is done with one or for the
+// timeout, forever if zero; a broken stream delivers nothing.
+func (s *Stream) Ring(fn func(*bytes.Buffer) bool, timeout time.Duration) {
+	var after <-chan time.Time
+	if timeout > 0 {
+		after = time.After(timeout)
+	}
+	in := s.in
+	for {
+		select {
+		case b, ok := <-in:
+			if !ok {
+				// the fallback takes over, block like a quiet stream
+				in = nil
+			} else if fn(b) {
+				return
+			}
+		case pe := <-s.skipped:
+			if s.OnParseError != nil {
+				s.OnParseError(pe)
+			}
+		case <-after:
+			return
+		}
+	}
+}
+
+// Id returns a fresh id, prefixed with the stream id.
+func (s *Stream) Id(...string) string {
+	return s.id + "-" + strconv.FormatInt(atomic.AddInt64(&s.ids, 1), 10)
+}
+
+// Close ends the stream.
+func (s *Stream) Close() error {
+	s.wmu.Lock()
+	io.WriteString(s.conn, "</stream:stream>")
+	s.wmu.Unlock()
+	return s.conn.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/component"
+	"github.com/kpmy/xep/privilege"
+	"github.com/kpmy/xep/session"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/actors"
+)
+
+// privs holds what the server lets the bot do as a component.
+var privs = privilege.New()
+
+// runComponent runs the bot as a component of the -component server, the
+// domain is -s and the bot speaks as user@domain/resource in it.
+func runComponent(wg *sync.WaitGroup) {
+	var redial func(error)
+	redial = func(err error) {
+		for {
+			if err != nil {
+				log.Println(err)
+				if lastDown.IsZero() {
+					lastDown = time.Now()
+				}
+			}
+			delay, stop := reconnectDelay()
+			if stop {
+				giveUp()
+			}
+			<-time.After(delay)
+			var st *component.Stream
+			if st, err = component.Dial(compAddr, server, compSecret, redial); err == nil {
+				log.Println("connected as component", server)
+				st.From = user + "@" + server + "/" + resource
+				st.OnParseError = func(pe *stanza.ParseError) { parseError(pe.Err, pe.Raw) }
+				fullJID = st.From
+				sess = session.New(user + "@" + server)
+				actors.With().Do(actors.C(bot)).Run(st)
+				wg.Done()
+				return
+			}
+		}
+	}
+	redial(nil)
+}
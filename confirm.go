@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const confirmTimeout = 30 * time.Second
+
+type pendingCmd struct {
+	ctx     *cmd
+	name    string
+	c       *command
+	expires time.Time
+}
+
+var confirms = struct {
+	sync.Mutex
+	m map[string]*pendingCmd
+}{m: make(map[string]*pendingCmd)}
+
+// audit logs an admin action, also passing it on as an event so log sinks and
+// hooks see it.
+func audit(ctx *cmd, action, name string) {
+	line := strings.Join(append([]string{name}, ctx.args...), " ")
+	if ctx.secure {
+		// the arguments of encrypted commands may be secrets
+		line = name + " [redacted]"
+	}
+	log.Println("AUDIT", ctx.user, "("+ctx.sender+")", action, "!"+line, "in", ctx.room)
+	emit("audit", map[string]string{"user": ctx.user, "sender": ctx.sender, "room": ctx.room, "action": action, "command": line})
+}
+
+// askConfirm holds the command back until its sender confirms it with the token.
+func askConfirm(ctx *cmd, name string, c *command) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		ctx.reply(ctx.sender + ": " + err.Error())
+		return
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	confirms.Lock()
+	for t, p := range confirms.m {
+		if now.After(p.expires) {
+			delete(confirms.m, t)
+		}
+	}
+	confirms.m[token] = &pendingCmd{ctx: ctx, name: name, c: c, expires: now.Add(confirmTimeout)}
+	confirms.Unlock()
+	audit(ctx, "requested", name)
+	ctx.reply(ctx.sender + ": confirm with !confirm " + token + " within " + confirmTimeout.String())
+}
+
+func init() {
+	commands["confirm"] = &command{admin: true, usage: "<token>", run: func(c *cmd) (string, error) {
+		if len(c.args) != 1 {
+			return "", errUsage
+		}
+		confirms.Lock()
+		p, ok := confirms.m[c.args[0]]
+		// only the one who asked may confirm, from where they asked
+		if ok && (p.ctx.sender != c.sender || p.ctx.user != c.user || p.ctx.room != c.room || p.ctx.direct != c.direct) {
+			ok = false
+		}
+		if ok {
+			delete(confirms.m, c.args[0])
+		}
+		confirms.Unlock()
+		if !ok || time.Now().After(p.expires) {
+			return c.sender + ": no such command to confirm", nil
+		}
+		audit(p.ctx, "confirmed", p.name)
+		finish(p.ctx, p.name, p.c)
+		return "", nil
+	}}
+}
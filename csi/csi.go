@@ -0,0 +1,87 @@
+// Package csi implements XEP-0352 client state indication, the bot tells
+// the server it is inactive while it has nothing to do so the server can
+// hold back the unimportant traffic.
+package csi
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const Ns = "urn:xmpp:csi:0"
+
+type active struct {
+	XMLName xml.Name `xml:"urn:xmpp:csi:0 active"`
+}
+
+type inactive struct {
+	XMLName xml.Name `xml:"urn:xmpp:csi:0 inactive"`
+}
+
+// State tracks the state told to the server, a new stream starts active.
+type State struct {
+	sync.Mutex
+	st     stream.Stream
+	active bool
+	last   time.Time
+}
+
+func New(st stream.Stream) *State {
+	return &State{st: st, active: true, last: time.Now()}
+}
+
+func (s *State) send(v interface{}) error {
+	buf, err := stanza.Buffer(v)
+	if err != nil {
+		return err
+	}
+	return s.st.Write(buf)
+}
+
+// Touch records activity, going active at once if the bot was inactive.
+func (s *State) Touch() error {
+	s.Lock()
+	defer s.Unlock()
+	s.last = time.Now()
+	if s.active {
+		return nil
+	}
+	s.active = true
+	return s.send(&active{})
+}
+
+// Active reports the state told to the server.
+func (s *State) Active() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.active
+}
+
+// Run goes inactive once there was no activity for idle and busy reports
+// nothing pending, it checks until stop is closed.
+func (s *State) Run(idle time.Duration, busy func() bool, stop <-chan struct{}) {
+	t := time.NewTicker(idle / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		if busy() {
+			s.Touch()
+			continue
+		}
+		s.Lock()
+		if s.active && time.Since(s.last) >= idle {
+			if s.send(&inactive{}) == nil {
+				s.active = false
+			}
+		}
+		s.Unlock()
+	}
+}
@@ -0,0 +1,213 @@
+// Package dataforms implements XEP-0004 data forms, shared by the room
+// configuration, ad-hoc commands, archive queries, pubsub and registration.
+package dataforms
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const Ns = "jabber:x:data"
+
+// form types
+const (
+	FORM   = "form"
+	SUBMIT = "submit"
+	CANCEL = "cancel"
+	RESULT = "result"
+)
+
+// field types
+const (
+	BOOLEAN      = "boolean"
+	FIXED        = "fixed"
+	HIDDEN       = "hidden"
+	JID_MULTI    = "jid-multi"
+	JID_SINGLE   = "jid-single"
+	LIST_MULTI   = "list-multi"
+	LIST_SINGLE  = "list-single"
+	TEXT_MULTI   = "text-multi"
+	TEXT_PRIVATE = "text-private"
+	TEXT_SINGLE  = "text-single"
+)
+
+type Option struct {
+	Label string `xml:"label,attr,omitempty"`
+	Value string `xml:"value"`
+}
+
+type Field struct {
+	Var      string    `xml:"var,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	Label    string    `xml:"label,attr,omitempty"`
+	Desc     string    `xml:"desc,omitempty"`
+	Required *struct{} `xml:"required"`
+	Values   []string  `xml:"value"`
+	Options  []Option  `xml:"option"`
+	// Media holds the raw XEP-0221 media element, e.g. of a captcha.
+	Media *struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"urn:xmpp:media-element media"`
+}
+
+// Required marks a field as required, for use in literals.
+var Required = &struct{}{}
+
+type Form struct {
+	XMLName      xml.Name `xml:"jabber:x:data x"`
+	Type         string   `xml:"type,attr"`
+	Title        string   `xml:"title,omitempty"`
+	Instructions string   `xml:"instructions,omitempty"`
+	Fields       []Field  `xml:"field"`
+}
+
+// Field returns the field with the name or nil.
+func (f *Form) Field(name string) *Field {
+	for i := range f.Fields {
+		if f.Fields[i].Var == name {
+			return &f.Fields[i]
+		}
+	}
+	return nil
+}
+
+func (f *Form) Get(name string) []string {
+	if fld := f.Field(name); fld != nil {
+		return fld.Values
+	}
+	return nil
+}
+
+// Value returns the first value of a field or an empty string.
+func (f *Form) Value(name string) string {
+	if v := f.Get(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Bool returns the value of a boolean field.
+func (f *Form) Bool(name string) bool {
+	v := f.Value(name)
+	return v == "1" || v == "true"
+}
+
+// Set sets the values of a field, adding it when missing.
+func (f *Form) Set(name string, values ...string) {
+	if fld := f.Field(name); fld != nil {
+		fld.Values = values
+		return
+	}
+	f.Fields = append(f.Fields, Field{Var: name, Values: values})
+}
+
+// FormType returns the value of the hidden FORM_TYPE field.
+func (f *Form) FormType() string {
+	return f.Value("FORM_TYPE")
+}
+
+// Submit returns the values of the form as a submitted form, with the
+// values given overriding those of the form; fixed fields are left out.
+func (f *Form) Submit(values map[string][]string) *Form {
+	ret := &Form{Type: SUBMIT}
+	for _, fld := range f.Fields {
+		if fld.Var != "" && fld.Type != FIXED {
+			ret.Fields = append(ret.Fields, Field{Var: fld.Var, Values: fld.Values})
+		}
+	}
+	for _, k := range sortedKeys(values) {
+		ret.Set(k, values[k]...)
+	}
+	return ret
+}
+
+func sortedKeys(values map[string][]string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NewSubmit makes a submitted form of the type with the values, the fields
+// are ordered by name.
+func NewSubmit(formType string, values map[string][]string) *Form {
+	ret := &Form{Type: SUBMIT}
+	if formType != "" {
+		ret.Fields = append(ret.Fields, Field{Var: "FORM_TYPE", Type: HIDDEN, Values: []string{formType}})
+	}
+	for _, k := range sortedKeys(values) {
+		ret.Fields = append(ret.Fields, Field{Var: k, Values: values[k]})
+	}
+	return ret
+}
+
+// Single makes the values of NewSubmit out of single values.
+func Single(values map[string]string) map[string][]string {
+	ret := make(map[string][]string, len(values))
+	for k, v := range values {
+		ret[k] = []string{v}
+	}
+	return ret
+}
+
+// Validate checks the submitted values against the fields of the form
+// they answer: the required fields must have a value, single fields one
+// at most, booleans and JIDs must be well formed and list values must be
+// among the options.
+func (f *Form) Validate(submitted *Form) error {
+	for _, fld := range f.Fields {
+		if fld.Var == "" || fld.Type == FIXED {
+			continue
+		}
+		values := submitted.Get(fld.Var)
+		if fld.Required != nil && (len(values) == 0 || strings.TrimSpace(strings.Join(values, "")) == "") {
+			return errors.New("dataforms: " + label(fld) + " is required")
+		}
+		switch fld.Type {
+		case JID_MULTI, LIST_MULTI, TEXT_MULTI:
+		default:
+			if len(values) > 1 {
+				return errors.New("dataforms: " + label(fld) + " takes a single value")
+			}
+		}
+		for _, v := range values {
+			switch fld.Type {
+			case BOOLEAN:
+				switch v {
+				case "0", "1", "false", "true":
+				default:
+					return errors.New("dataforms: " + label(fld) + " must be a boolean")
+				}
+			case JID_SINGLE, JID_MULTI:
+				if v == "" || strings.ContainsAny(v, " \t\r\n") || strings.HasPrefix(v, "@") || strings.HasPrefix(v, "/") {
+					return errors.New("dataforms: " + label(fld) + " must be a JID")
+				}
+			case LIST_SINGLE, LIST_MULTI:
+				if len(fld.Options) > 0 && !hasOption(fld, v) {
+					return errors.New("dataforms: " + v + " isn't an option of " + label(fld))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func label(fld Field) string {
+	if fld.Label != "" {
+		return fld.Label
+	}
+	return fld.Var
+}
+
+func hasOption(fld Field, v string) bool {
+	for _, o := range fld.Options {
+		if o.Value == v {
+			return true
+		}
+	}
+	return false
+}
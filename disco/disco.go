@@ -0,0 +1,90 @@
+// Package disco implements XEP-0030 service discovery.
+package disco
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	NsInfo  = "http://jabber.org/protocol/disco#info"
+	NsItems = "http://jabber.org/protocol/disco#items"
+)
+
+type Identity struct {
+	Category string `xml:"category,attr"`
+	Type     string `xml:"type,attr"`
+	Name     string `xml:"name,attr,omitempty"`
+}
+
+type Feature struct {
+	Var string `xml:"var,attr"`
+}
+
+type InfoQuery struct {
+	XMLName    xml.Name   `xml:"http://jabber.org/protocol/disco#info query"`
+	Node       string     `xml:"node,attr,omitempty"`
+	Identities []Identity `xml:"identity"`
+	Features   []Feature  `xml:"feature"`
+}
+
+// Has reports whether the feature is advertised.
+func (q *InfoQuery) Has(feature string) bool {
+	for _, f := range q.Features {
+		if f.Var == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether an identity of the category and type is advertised.
+func (q *InfoQuery) Is(category, typ string) bool {
+	for _, i := range q.Identities {
+		if i.Category == category && i.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+type Item struct {
+	JID  string `xml:"jid,attr"`
+	Node string `xml:"node,attr,omitempty"`
+	Name string `xml:"name,attr,omitempty"`
+}
+
+type ItemsQuery struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/disco#items query"`
+	Node    string   `xml:"node,attr,omitempty"`
+	Items   []Item   `xml:"item"`
+}
+
+// Info queries the identities and features of an entity.
+func Info(d *dispatch.Dispatcher, to jid.JID) (*InfoQuery, error) {
+	return InfoNode(d, to, "")
+}
+
+func InfoNode(d *dispatch.Dispatcher, to jid.JID, node string) (*InfoQuery, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &InfoQuery{Node: node})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	ret := &InfoQuery{}
+	return ret, res.Decode(ret)
+}
+
+// Items queries the items of an entity, e.g. the services of a server.
+func Items(d *dispatch.Dispatcher, to jid.JID) (*ItemsQuery, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &ItemsQuery{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	ret := &ItemsQuery{}
+	return ret, res.Decode(ret)
+}
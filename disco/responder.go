@@ -0,0 +1,57 @@
+package disco
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+)
+
+// ItemsFunc lists the items of a node of to for the asking entity.
+type ItemsFunc func(from, to, node string) []Item
+
+// Handler answers disco#info queries with the identities and the features
+// registered with the dispatcher, and disco#items queries with the items
+// listed by items, which may be nil.
+func Handler(d *dispatch.Dispatcher, items ItemsFunc, ids ...Identity) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.GET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil {
+			return false
+		}
+		var payload interface{}
+		switch iq.PayloadName().Space {
+		case NsInfo:
+			q := &InfoQuery{}
+			if iq.Decode(q) != nil {
+				return false
+			}
+			q.Identities = ids
+			q.Features = nil
+			for _, f := range d.Features() {
+				q.Features = append(q.Features, Feature{Var: f})
+			}
+			payload = q
+		case NsItems:
+			q := &ItemsQuery{}
+			if iq.Decode(q) != nil {
+				return false
+			}
+			if items != nil {
+				q.Items = items(h.From.String(), h.To.String(), q.Node)
+			}
+			payload = q
+		default:
+			return false
+		}
+		res := iq.Result()
+		if res.Set(payload) != nil {
+			return false
+		}
+		d.Send(res)
+		return true
+	}
+}
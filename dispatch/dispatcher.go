@@ -0,0 +1,210 @@
+// Package dispatch routes raw stanzas to handlers before they reach the
+// entity-based bot loop, and correlates IQ requests with their replies.
+package dispatch
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const DefaultTimeout = 30 * time.Second
+
+var ErrTimeout = errors.New("dispatch: request timed out")
+
+// Handler inspects a raw stanza and reports whether it has consumed it. raw
+// isn't reused once the handler returns, it may keep slices of it.
+type Handler func(name xml.Name, h stanza.Header, raw []byte) bool
+
+type Dispatcher struct {
+	st       stream.Stream
+	lastSent int64
+	mu       sync.Mutex
+	handlers []Handler
+	features []string
+	pending  map[string]pending
+	counter  int
+}
+
+// pending is a request waiting for its reply, to is where it was sent.
+type pending struct {
+	to jid.JID
+	ch chan *stanza.IQ
+}
+
+func New(st stream.Stream) *Dispatcher {
+	return &Dispatcher{st: st, pending: make(map[string]pending)}
+}
+
+func (d *Dispatcher) Stream() stream.Stream {
+	return d.st
+}
+
+// Handle registers a handler, handlers are tried in registration order. The
+// features are the namespaces the handler implements, they are advertised
+// through service discovery.
+func (d *Dispatcher) Handle(h Handler, features ...string) {
+	d.mu.Lock()
+	d.handlers = append(d.handlers, h)
+	d.features = append(d.features, features...)
+	d.mu.Unlock()
+}
+
+// Features returns the sorted features of the registered handlers.
+func (d *Dispatcher) Features() (ret []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, f := range d.features {
+		if !seen[f] {
+			seen[f] = true
+			ret = append(ret, f)
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Feed passes a received stanza to the handlers, it returns true if one of them consumed it.
+func (d *Dispatcher) Feed(raw []byte) bool {
+	name, h, err := stanza.Peek(raw)
+	if err != nil {
+		return false
+	}
+	if name.Local == "iq" && (h.Type == stanza.RESULT || h.Type == stanza.ERROR) && d.reply(h, raw) {
+		return true
+	}
+	return d.handle(name, h, raw)
+}
+
+// Replay passes a stanza recovered from an archive to the handlers flagged as
+// archived, so they can tell it from a live one.
+func (d *Dispatcher) Replay(raw []byte) bool {
+	name, h, err := stanza.Peek(raw)
+	if err != nil {
+		return false
+	}
+	h.Archived = true
+	return d.handle(name, h, raw)
+}
+
+func (d *Dispatcher) handle(name xml.Name, h stanza.Header, raw []byte) bool {
+	d.mu.Lock()
+	handlers := d.handlers
+	d.mu.Unlock()
+	for _, fn := range handlers {
+		if fn(name, h, raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// reply delivers a result or an error to the request it answers. The id
+// alone is guessable, a reply must come from where the request went.
+func (d *Dispatcher) reply(h stanza.Header, raw []byte) bool {
+	d.mu.Lock()
+	p, ok := d.pending[h.ID]
+	if ok = ok && d.answers(p.to, h); ok {
+		delete(d.pending, h.ID)
+	}
+	d.mu.Unlock()
+	if ok {
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err == nil {
+			p.ch <- iq
+		} else {
+			close(p.ch)
+		}
+	}
+	return ok
+}
+
+// answers tells whether the reply comes from to. The server answers the
+// requests without an address or to our own account, its reply may come
+// from nowhere, from the account or from the server.
+func (d *Dispatcher) answers(to jid.JID, h stanza.Header) bool {
+	if h.From.Equal(to) {
+		return true
+	}
+	self := h.To.Bare()
+	if !to.IsZero() && !to.Equal(self) {
+		return false
+	}
+	if h.From.IsZero() || h.From.Equal(self) {
+		return true
+	}
+	if srv := d.st.Server(); srv != nil {
+		domain, err := jid.Parse(srv.Name)
+		return err == nil && h.From.Equal(domain)
+	}
+	return false
+}
+
+// Send validates and marshals v and writes it to the stream.
+func (d *Dispatcher) Send(v interface{}) error {
+	buf, err := stanza.Produce(v)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&d.lastSent, time.Now().UnixNano())
+	// the stream is done with the buffer once Write returns
+	defer stanza.PutBuffer(buf)
+	return d.st.Write(buf)
+}
+
+// LastSent returns when the last stanza was sent.
+func (d *Dispatcher) LastSent() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&d.lastSent))
+}
+
+// NextID returns a new stanza id unique for this dispatcher.
+func (d *Dispatcher) NextID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counter++
+	return "xep" + strconv.Itoa(d.counter)
+}
+
+// Request sends an IQ get or set and waits for the reply. Replies are
+// delivered by Feed, so it must not be called from a handler.
+func (d *Dispatcher) Request(iq *stanza.IQ) (*stanza.IQ, error) {
+	if iq.ID == "" {
+		iq.ID = d.NextID()
+	}
+	ch := make(chan *stanza.IQ, 1)
+	d.mu.Lock()
+	d.pending[iq.ID] = pending{iq.To, ch}
+	d.mu.Unlock()
+	if err := d.Send(iq); err != nil {
+		d.forget(iq.ID)
+		return nil, err
+	}
+	select {
+	case ret, ok := <-ch:
+		if !ok {
+			return nil, errors.New("dispatch: malformed reply to " + iq.ID)
+		}
+		if ret.Type == stanza.ERROR {
+			return ret, &stanza.IQError{IQ: ret}
+		}
+		return ret, nil
+	case <-time.After(DefaultTimeout):
+		d.forget(iq.ID)
+		return nil, ErrTimeout
+	}
+}
+
+func (d *Dispatcher) forget(id string) {
+	d.mu.Lock()
+	delete(d.pending, id)
+	d.mu.Unlock()
+}
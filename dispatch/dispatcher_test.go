@@ -0,0 +1,79 @@
+package dispatch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestReplyFrom(t *testing.T) {
+	for _, tc := range []struct {
+		to, from string
+		ok       bool
+	}{
+		{"pubsub.example.org", "pubsub.example.org", true},
+		{"Room@Conference.example.org/Nick", "room@conference.example.org/Nick", true},
+		{"pubsub.example.org", "mallory@example.org/x", false},
+		{"pubsub.example.org", "", false},
+		{"room@conference.example.org/nick", "room@conference.example.org", false},
+		{"", "", true},
+		{"", "example.org", true},
+		{"", "bot@example.org", true},
+		{"", "mallory@example.org/x", false},
+		{"", "bot@example.org/other", false},
+		{"bot@example.org", "", true},
+		{"bot@example.org", "example.org", true},
+		{"bot@example.org", "mallory@example.org", false},
+	} {
+		d := New(streamtest.New("example.org"))
+		iq := &stanza.IQ{Header: stanza.Header{ID: "q1", Type: stanza.GET}}
+		if tc.to != "" {
+			iq.To = jid.MustParse(tc.to)
+		}
+		ch := make(chan *stanza.IQ, 1)
+		d.pending[iq.ID] = pending{iq.To, ch}
+		from := ""
+		if tc.from != "" {
+			from = fmt.Sprintf(` from="%s"`, tc.from)
+		}
+		raw := fmt.Sprintf(`<iq type="result" id="q1" to="bot@example.org/home"%s/>`, from)
+		if got := d.Feed([]byte(raw)); got != tc.ok {
+			t.Errorf("reply from %q to a request to %q taken %v", tc.from, tc.to, got)
+			continue
+		}
+		if _, waiting := d.pending["q1"]; waiting == tc.ok {
+			t.Errorf("reply from %q to a request to %q: pending %v", tc.from, tc.to, waiting)
+		}
+	}
+}
+
+func TestReplySpoofed(t *testing.T) {
+	d := New(streamtest.New("example.org"))
+	to := jid.MustParse("pubsub.example.org")
+	replied := make(chan *stanza.IQ, 1)
+	go func() {
+		ret, err := d.Request(&stanza.IQ{Header: stanza.Header{ID: "q1", To: to, Type: stanza.GET}})
+		if err != nil {
+			t.Error(err)
+		}
+		replied <- ret
+	}()
+	for {
+		d.mu.Lock()
+		_, sent := d.pending["q1"]
+		d.mu.Unlock()
+		if sent {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.Feed([]byte(`<iq type="result" id="q1" from="mallory@example.org/x"/>`))
+	d.Feed([]byte(`<iq type="result" id="q1" from="pubsub.example.org"/>`))
+	if ret := <-replied; ret == nil || !ret.From.Equal(to) {
+		t.Fatalf("took the reply %+v", ret)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/fjl/go-couchdb"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/omemo"
+	"github.com/kpmy/xep/stanza"
+)
+
+const omemoDocId = "omemo"
+
+// omemoStore keeps the OMEMO keys and sessions of the bot in a document.
+type omemoStore struct{}
+
+func (omemoStore) Load() (*omemo.State, error) {
+	st := &omemo.State{}
+	if err := db.Get(omemoDocId, st, nil); couchdb.NotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (omemoStore) Save(st *omemo.State) error {
+	rev, err := db.Rev(omemoDocId)
+	if err != nil && !couchdb.NotFound(err) {
+		return err
+	}
+	_, err = db.Put(omemoDocId, st, rev)
+	return err
+}
+
+// e2e is set when -omemo is, it outlives the reconnects.
+var e2e *omemo.Manager
+
+func publishOmemo() {
+	if e2e == nil {
+		return
+	}
+	if err := e2e.Publish(disp); err != nil {
+		log.Println("failed to publish the OMEMO bundle:", err)
+	}
+}
+
+// runSecureCommand runs a command sent OMEMO encrypted, the replies are
+// encrypted too.
+func runSecureCommand(from, body string) {
+	if !contacts.Contains(from) {
+		log.Println("ignoring encrypted command from", from, "not on the roster")
+		return
+	}
+	go execCommand(&cmd{room: ROOM, sender: from, user: jid.Bare(from), direct: true, secure: true}, body)
+}
+
+// sendSecure sends text encrypted to the devices of the JID.
+func sendSecure(to, text string) error {
+	m, err := e2e.Message(disp, stanza.CHAT, to, text)
+	if err != nil {
+		return err
+	}
+	m.ID = disp.NextID()
+	return disp.Send(m)
+}
+
+func init() {
+	commands["omemo"] = &command{run: func(c *cmd) (string, error) {
+		if e2e == nil {
+			return "OMEMO is off", nil
+		}
+		return fmt.Sprintf("OMEMO device %d, fingerprint %s", e2e.DeviceID(), e2e.Fingerprint()), nil
+	}}
+}
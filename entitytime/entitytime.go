@@ -0,0 +1,59 @@
+// Package entitytime implements XEP-0202 entity time.
+package entitytime
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:time"
+
+type query struct {
+	XMLName xml.Name `xml:"urn:xmpp:time time"`
+	TZO     string   `xml:"tzo,omitempty"`
+	UTC     string   `xml:"utc,omitempty"`
+}
+
+// Get asks to for its time, the result carries the entity's time zone.
+func Get(d *dispatch.Dispatcher, to jid.JID) (time.Time, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &query{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return time.Time{}, err
+	}
+	q := &query{}
+	if err = res.Decode(q); err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, q.UTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if zone, err := time.Parse("-07:00", q.TZO); err == nil {
+		_, off := zone.Zone()
+		t = t.In(time.FixedZone(q.TZO, off))
+	}
+	return t, nil
+}
+
+// Handler answers time queries with the local time.
+func Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.GET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		now := time.Now()
+		res := iq.Result()
+		res.Set(&query{TZO: now.Format("-07:00"), UTC: now.UTC().Format("2006-01-02T15:04:05.000Z")})
+		d.Send(res)
+		return true
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kpmy/xep/oob"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/upload"
+)
+
+const excerptTimeout = time.Minute
+
+// share uploads data to the upload service of the server and returns its URL.
+func share(name string, data []byte, mime string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), excerptTimeout)
+	defer cancel()
+	return upload.Bytes(ctx, disp, server, name, data, mime)
+}
+
+// attach posts a link to a shared file where the command was given.
+func (c *cmd) attach(url, desc string) error {
+	if c.direct {
+		return oob.Send(disp, stanza.CHAT, c.sender, url, desc)
+	}
+	return oob.Send(disp, stanza.GROUPCHAT, c.room, url, desc)
+}
+
+func init() {
+	commands["excerpt"] = &command{usage: "[lines, 50 by default]", run: func(c *cmd) (string, error) {
+		n := 50
+		if len(c.args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(c.args[0]); err != nil || n <= 0 {
+				return "", errUsage
+			}
+		}
+		buf := new(bytes.Buffer)
+		posts.Lock()
+		from := len(posts.data) - n
+		if from < 0 {
+			from = 0
+		}
+		for _, p := range posts.data[from:] {
+			if p.Retracted {
+				continue
+			}
+			fmt.Fprintf(buf, "%s: %s\n", p.Nick, p.Msg)
+		}
+		posts.Unlock()
+		if buf.Len() == 0 {
+			return "nothing to share", nil
+		}
+		name := "excerpt-" + time.Now().UTC().Format("20060102-150405") + ".txt"
+		url, err := share(name, buf.Bytes(), "text/plain; charset=utf-8")
+		if err != nil {
+			return "", err
+		}
+		return "", c.attach(url, name)
+	}}
+}
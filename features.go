@@ -0,0 +1,16 @@
+package main
+
+import "strings"
+
+func init() {
+	commands["caps"] = &command{run: func(c *cmd) (string, error) {
+		if sess == nil {
+			return "not connected", nil
+		}
+		ret := "supported: " + strings.Join(sess.Supported(), ", ")
+		if missing := sess.Missing(); len(missing) > 0 {
+			ret += "; missing: " + strings.Join(missing, ", ")
+		}
+		return ret, nil
+	}}
+}
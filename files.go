@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kpmy/xep/ibb"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/oob"
+	"github.com/kpmy/xep/s5b"
+	"github.com/kpmy/xep/stanza"
+)
+
+// maxReceived bounds the files contacts send the bot in band.
+const maxReceived = 10 * 1024 * 1024
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// fullJID is the JID the bot is bound to.
+var fullJID string
+
+// sendFile forwards a file from a hook: as a bytestream to a full JID, over
+// the proxy of the server and in band if the peer can't connect to it;
+// otherwise as an upload linked in a chat or, for a joined room, in the room.
+func sendFile(to, name, mime string, data []byte) error {
+	if strings.Contains(to, "/") {
+		if _, ok := rooms.Get(jid.Bare(to)); !ok {
+			b := make([]byte, 8)
+			rand.Read(b)
+			sid := hex.EncodeToString(b)
+			proxy, err := s5b.Proxy(disp, server)
+			if err == nil {
+				if err = s5b.Send(disp, fullJID, to, sid, bytes.NewReader(data), *proxy); err == nil {
+					return nil
+				}
+			}
+			log.Println("sending in band to", to+":", err)
+			addr, err := jid.Parse(to)
+			if err != nil {
+				return err
+			}
+			return ibb.Send(disp, addr, sid, bytes.NewReader(data), ibb.DefaultBlockSize)
+		}
+	}
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	url, err := share(name, data, mime)
+	if err != nil {
+		return err
+	}
+	if _, ok := rooms.Get(to); ok {
+		return oob.Send(disp, stanza.GROUPCHAT, to, url, name)
+	}
+	return oob.Send(disp, stanza.CHAT, to, url, name)
+}
+
+// acceptFile keeps the bytestreams of roster contacts in the -files
+// directory.
+func acceptFile(from, sid string) io.WriteCloser {
+	if !contacts.Contains(from) {
+		log.Println("declining bytestream from", from, "not on the roster")
+		return nil
+	}
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		log.Println(err)
+		return nil
+	}
+	f, err := os.Create(receivedPath(from, sid))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return f
+}
+
+func fileDone(from, sid string, n int64, err error) {
+	path := receivedPath(from, sid)
+	if err != nil {
+		log.Println("bytestream from", from, "failed:", err)
+		os.Remove(path)
+		return
+	}
+	emit("file", map[string]string{"from": from, "sid": sid, "path": path, "size": strconv.FormatInt(n, 10)})
+}
+
+func receivedPath(from, sid string) string {
+	return filepath.Join(filesDir, unsafeName.ReplaceAllString(jid.Bare(from)+"-"+sid, "_"))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivpusic/neo"
+	"github.com/kpmy/xep/hookexecutor"
+)
+
+func init() {
+	commands["hooks"] = &command{admin: true, usage: hookexecutor.CommandUsage, run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		return hookExec.Run(strings.Join(c.args, " ")), nil
+	}}
+}
+
+// hookOptions configures the hook listener of the flags.
+func hookOptions() (hookexecutor.Options, error) {
+	opts := hookexecutor.Options{Addr: hookAddr, Socket: hookSocket, WebSocket: hookWS, QueueDepth: hookQueue, SpillDir: hookSpill}
+	switch o := hookexecutor.Overflow(hookOverflow); o {
+	case hookexecutor.OverflowDrop, hookexecutor.OverflowBlock, hookexecutor.OverflowSpill:
+		opts.Overflow = o
+	default:
+		return opts, fmt.Errorf("unknown hook overflow %q", hookOverflow)
+	}
+	webhooks, err := hookexecutor.ParseWebhooks(hookWebhooks)
+	if err != nil {
+		return opts, err
+	}
+	opts.Webhooks = webhooks
+	if hookCert == "" {
+		return opts, nil
+	}
+	cfg, err := hookexecutor.TLSConfig(hookCert, hookKey, hookClientCA)
+	opts.TLS = cfg
+	return opts, err
+}
+
+func hookPolicy() hookexecutor.Policy {
+	p := hookexecutor.Policy{MaxLength: hookMaxLen}
+	if hookDests != "" {
+		p.Destinations = strings.Split(hookDests, ",")
+	}
+	if hookTypes != "" {
+		p.Types = strings.Split(hookTypes, ",")
+	}
+	return p
+}
+
+// hookOccupants answers the hooks asking who is in a room.
+func hookOccupants(room string) (ret []string) {
+	for _, o := range rooms.Occupants(room) {
+		ret = append(ret, o.Nick)
+	}
+	return
+}
+
+// hookStats answers the hooks asking for the stats of a user, like the stats
+// command.
+func hookStats(room, nick string) (map[string]string, error) {
+	s, err := GetRoomStat(room)
+	if err != nil {
+		return nil, err
+	}
+	user := statKey(statUser(nick))
+	u, ok := s.Users[user]
+	if !ok {
+		return map[string]string{"user": nick}, nil
+	}
+	today := 0
+	if u.Day == time.Now().Format(dayLayout) {
+		today = u.Today
+	}
+	return map[string]string{
+		"user":  nick,
+		"count": strconv.Itoa(u.Count),
+		"today": strconv.Itoa(today),
+		"rank":  strconv.Itoa(s.Rank(user)),
+		"users": strconv.Itoa(len(s.Users)),
+		"first": u.First.Format(dayLayout),
+	}, nil
+}
+
+// startHooks starts the hook listener, it outlives the connections so the
+// clients stay and resume where they were; bot gives it the stream.
+func startHooks(opts hookexecutor.Options) {
+	hookExec = hookexecutor.NewExecutor(nil, opts)
+	hookExec.Attention = buzz
+	hookExec.React = react
+	hookExec.File = sendFile
+	hookExec.Rooms = strings.Split(hookRooms, ",")
+	hookExec.Contacts = strings.Split(hookContacts, ",")
+	hookExec.Policy = hookPolicy()
+	hookExec.Occupants = hookOccupants
+	hookExec.Stats = hookStats
+	if tokens, err := hookexecutor.ParseTokens(hookTokens); err == nil {
+		hookExec.Tokens = tokens
+	} else {
+		log.Println(err)
+	}
+	hookExec.Start()
+}
+
+// stopHooks flushes the hook clients and disconnects them.
+func stopHooks() {
+	if hookExec == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookexecutor.DefaultStopTimeout)
+	defer cancel()
+	if err := hookExec.Stop(ctx); err != nil {
+		log.Println("hooks stopped:", err)
+	}
+}
+
+// hookRoutes takes the events POSTed for the hooks, see Executor.Inject.
+func hookRoutes(app *neo.Application) {
+	app.Post(hookexecutor.DefaultEventsPath, func(ctx *neo.Ctx) (int, error) {
+		if hookExec == nil {
+			return 503, errors.New("not connected")
+		}
+		return hookExec.Inject(ctx.Req.Request)
+	})
+}
@@ -0,0 +1,121 @@
+package hookexecutor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAuthTimeout is how long a client has to authenticate after connect.
+const DefaultAuthTimeout = 5 * time.Second
+
+// CloseAuthFailed is the reason a client that failed to authenticate is
+// dropped with.
+const CloseAuthFailed = "auth-failed"
+
+// Permission is what the clients of a token may do.
+type Permission string
+
+const (
+	// PermRead clients get the events, their messages are dropped.
+	PermRead Permission = "read"
+	// PermSend clients may speak for the bot as well.
+	PermSend Permission = "send"
+)
+
+var errAuth = errors.New("authentication failed")
+
+// ParseTokens reads tokens like "secret:send,other:read", a token without
+// a permission may read only.
+func ParseTokens(s string) (map[string]Permission, error) {
+	ret := make(map[string]Permission)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		perm := PermRead
+		if i := strings.LastIndexByte(t, ':'); i >= 0 {
+			t, perm = t[:i], Permission(t[i+1:])
+		}
+		if perm != PermRead && perm != PermSend {
+			return nil, fmt.Errorf("unknown hook permission %q", perm)
+		}
+		ret[t] = perm
+	}
+	return ret, nil
+}
+
+// Sign answers the challenge of the executor with the token.
+func Sign(token, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// terms are what a client agreed on in authenticating.
+type terms struct {
+	perm     Permission
+	framing  Framing
+	overflow Overflow
+	// resume is the id of the last event the client had, -1 for none
+	resume int
+}
+
+// authenticate challenges a new client: the executor sends a "challenge" with
+// a nonce, the client answers with an "auth" carrying the HMAC-SHA256 of the
+// nonce keyed with its token and is told its permission in "auth-ok". The
+// token itself never goes over the connection. The framing the client asks
+// for in "auth" is agreed on in "auth-ok" when known, a client saying hello
+// in FramingJSON stays with it; so is the Overflow. A client resuming tells
+// the last event it had in FieldResume.
+func (exc *Executor) authenticate(conn net.Conn, framing Framing) (*terms, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b[:])
+	challenge := &Message{&IncomingEvent{"challenge", map[string]string{"nonce": nonce}}, -1}
+	if err := framing.Write(conn, DefaultAuthTimeout, challenge); err != nil {
+		return nil, err
+	}
+	msg, err := framing.Read(conn, DefaultAuthTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if msg.IncomingEvent == nil || msg.Type != "auth" {
+		return nil, errAuth
+	}
+	sum, err := hex.DecodeString(msg.Data["hmac"])
+	if err != nil {
+		return nil, errAuth
+	}
+	t := &terms{framing: framing, overflow: exc.overflow(Overflow(msg.Data[FieldOverflow])), resume: -1}
+	if framing == Framing1 {
+		t.framing = ParseFraming(msg.Data[FieldFraming])
+	}
+	if v, ok := msg.Data[FieldResume]; ok {
+		if t.resume, err = strconv.Atoi(v); err != nil || t.resume < 0 {
+			return nil, errAuth
+		}
+	}
+	for token, perm := range exc.Tokens {
+		want, _ := hex.DecodeString(Sign(token, nonce))
+		if hmac.Equal(sum, want) {
+			t.perm = perm
+			ok := &Message{&IncomingEvent{"auth-ok", map[string]string{
+				"permission":  string(perm),
+				FieldFraming:  t.framing.String(),
+				FieldOverflow: string(t.overflow),
+			}}, -1}
+			return t, framing.Write(conn, DefaultAuthTimeout, ok)
+		}
+	}
+	return nil, errAuth
+}
@@ -0,0 +1,95 @@
+package hookexecutor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CommandUsage lists the commands Run takes.
+const CommandUsage = "status | clients | webhooks | drop <id> | pause | resume | send <json>"
+
+// runCommand runs a command in the event loop, the state is all its own there:
+//
+//	status       the clients, the events sent and whether paused
+//	clients      the connected clients with their ids
+//	webhooks     the webhooks with the events queued, delivered and failed
+//	drop <id>    disconnects a client
+//	pause        holds the events and drops the messages of the hooks
+//	resume       sends the events held
+//	send <json>  acts on a message as if a hook sent it, like
+//	             {"type": "message", "data": {"body": "hi"}}
+func (exc *Executor) runCommand(line string) string {
+	line = strings.TrimSpace(line)
+	name, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch name {
+	case "status":
+		state := "running"
+		if exc.paused {
+			state = fmt.Sprintf("paused, %d events held", len(exc.held))
+		}
+		return fmt.Sprintf("%s, %d clients, %d events in all, %d queued", state, len(exc.clients), exc.counter, len(exc.inbox))
+	case "clients":
+		if len(exc.clients) == 0 {
+			return "no clients"
+		}
+		ret := make([]string, len(exc.clients))
+		for i, c := range exc.clients {
+			ret[i] = fmt.Sprintf("%d %s (%s) for %s, %d buffered", c.id, c.addr, c.perm, time.Since(c.since).Truncate(time.Second), len(c.inbox))
+			if c.spill != nil && c.spill.n > 0 {
+				ret[i] += fmt.Sprintf(", %d spilled", c.spill.n)
+			}
+		}
+		return strings.Join(ret, "; ")
+	case "webhooks":
+		if len(exc.webhooks) == 0 {
+			return "no webhooks"
+		}
+		ret := make([]string, len(exc.webhooks))
+		for i, h := range exc.webhooks {
+			ret[i] = fmt.Sprintf("%s %d queued, %d delivered, %d failed", h.URL, len(h.queue), atomic.LoadInt64(&h.delivered), atomic.LoadInt64(&h.failed))
+		}
+		return strings.Join(ret, "; ")
+	case "drop":
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return "usage: drop <id>"
+		}
+		for i, c := range exc.clients {
+			if c.id == id {
+				exc.dropClients([]int{i})
+				return fmt.Sprintf("dropped %d %s", id, c.addr)
+			}
+		}
+		return fmt.Sprintf("no client %d", id)
+	case "pause":
+		exc.paused = true
+		return "paused"
+	case "resume":
+		held := exc.held
+		exc.paused, exc.held = false, nil
+		for _, msg := range held {
+			exc.sendMessage(msg)
+		}
+		return fmt.Sprintf("resumed, %d events sent", len(held))
+	case "send":
+		e := &IncomingEvent{}
+		if err := json.Unmarshal([]byte(arg), e); err != nil {
+			return "bad message: " + err.Error()
+		}
+		if e.Type == "" {
+			return "bad message: no type"
+		}
+		if err := exc.SendMessageToBot(&Message{e, -1}); err != nil {
+			return "not sent: " + err.Error()
+		}
+		return "sent"
+	}
+	return "usage: " + CommandUsage
+}
@@ -0,0 +1,125 @@
+package hookexecutor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultEventsPath is where the events are POSTed to the executor.
+const DefaultEventsPath = "/events"
+
+// reservedTypes are those of the protocol, which can't be POSTed.
+var reservedTypes = []string{"hello", "challenge", "auth", "auth-ok", "ping", "pong", "close",
+	ErrorFrame, Subscribe, Subscribed, Request, Response, Resumed}
+
+var errNoType = errors.New("no event type")
+
+// Inject passes an event POSTed to the hooks like NewEvent, returning the
+// HTTP status to answer with. The request is authenticated with a token
+// that may send, either as "Authorization: Bearer <token>" or as the
+// signature of the body keyed with it in X-Hub-Signature-256 like GitHub
+// signs its webhooks. The body is JSON like {"type": "build", "data":
+// {"status": "ok"}}; when the type is in the "type" parameter of the URL
+// or the X-GitHub-Event header ("github-push" and so on) the whole body is
+// the data instead. Nested objects are flattened into dotted keys.
+func (exc *Executor) Inject(r *http.Request) (int, error) {
+	if r.Method != "POST" {
+		return http.StatusMethodNotAllowed, errors.New("POST only")
+	}
+	if len(exc.Tokens) == 0 {
+		return http.StatusServiceUnavailable, errors.New("hooks are disabled")
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, DefaultFileSizeCap))
+	if err != nil {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if !exc.authorized(r, body) {
+		return http.StatusUnauthorized, errAuth
+	}
+	// numbers are kept as sent, ids don't become floats
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return http.StatusBadRequest, err
+	}
+	typ, payload := r.URL.Query().Get("type"), interface{}(m)
+	if ev := r.Header.Get("X-GitHub-Event"); typ == "" && ev != "" {
+		typ = "github-" + ev
+	}
+	if typ == "" {
+		typ, _ = m["type"].(string)
+		payload = m["data"]
+	}
+	if typ == "" {
+		return http.StatusBadRequest, errNoType
+	}
+	if contains(reservedTypes, typ) {
+		return http.StatusBadRequest, fmt.Errorf("type %q is reserved", typ)
+	}
+	data := make(map[string]string)
+	flatten("", payload, data)
+	exc.NewEvent(IncomingEvent{typ, data})
+	return http.StatusAccepted, nil
+}
+
+// ServeEvents is Inject for mounting on an HTTP server.
+func (exc *Executor) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	code, err := exc.Inject(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+// authorized tells whether the request carries a token that may send.
+func (exc *Executor) authorized(r *http.Request, body []byte) bool {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sig, _ := hex.DecodeString(strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="))
+	for token, perm := range exc.Tokens {
+		if perm != PermSend {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return true
+		}
+		if want, _ := hex.DecodeString(Sign(token, string(body))); len(sig) > 0 && hmac.Equal(sig, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func flatten(prefix string, v interface{}, data map[string]string) {
+	key := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			flatten(key(k), e, data)
+		}
+	case []interface{}:
+		for i, e := range v {
+			flatten(key(strconv.Itoa(i)), e, data)
+		}
+	case string:
+		data[prefix] = v
+	case nil:
+	default:
+		data[prefix] = fmt.Sprint(v)
+	}
+}
@@ -1,19 +1,20 @@
 package hookexecutor
 
 import (
-	"bytes"
-	"encoding/binary"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
 	"github.com/kpmy/xippo/c2s/stream"
-	"github.com/kpmy/xippo/entity"
-	"github.com/ugorji/go/codec"
 )
 
 const (
@@ -23,7 +24,18 @@ const (
 	DefaultClientBufferSize = 8
 	DefaultHeartbeatTrigger = 5 * time.Second
 	DefaultHeartbeatTimeout = 10 * time.Second
+	DefaultLivenessTimeout  = 3 * DefaultHeartbeatTrigger
 	DefaultMessageLengthCap = 4 * 1024
+	DefaultFileSizeCap      = 1024 * 1024
+	DefaultFileTransfers    = 4
+	DefaultHeldCap          = 256
+	DefaultSocketMode       = 0600
+)
+
+// Reasons sent in the "close" message before the executor drops a client.
+const (
+	CloseLivenessTimeout = "liveness-timeout"
+	CloseShutdown        = "shutdown"
 )
 
 type IncomingEvent struct {
@@ -37,59 +49,236 @@ type Message struct {
 }
 
 type clientReply struct {
-	outbox chan *Message
+	outbox chan *clientMessage
 	info   *clientInfo
 }
 
+// clientMessage is a message of a hook along with the client it came from,
+// which is told when it is rejected.
+type clientMessage struct {
+	*Message
+	from *clientInfo
+}
+
 type clientInfo struct {
-	inbox chan *Message
-	stop  chan struct{}
+	id   int
+	addr string
+	perm Permission
+	// framing and version are the ones agreed on with the client
+	framing Framing
+	version int
+	// filter is what the client subscribed to
+	filter Filter
+	since  time.Time
+	inbox  chan *Message
+	stop   chan struct{}
+	// closed is set once the inbox is closed
+	closed bool
+	// overflow is what is done once the inbox is full, spill holds the
+	// events spilled
+	overflow Overflow
+	spill    *spill
+}
+
+// close closes the inbox, the writer closes the connection once it is.
+func (c *clientInfo) close() {
+	close(c.inbox)
+	c.closed = true
+	if c.spill != nil {
+		c.spill.close()
+	}
+}
+
+type clientRequest struct {
+	addr  string
+	terms *terms
+	reply chan clientReply
+}
+
+type command struct {
+	line  string
+	reply chan string
+}
+
+// Options configure the listener of an Executor.
+type Options struct {
+	// Addr is the TCP address to listen on. It is DefaultAddr when both it
+	// and Socket are empty, with Socket only the unix socket is listened on.
+	Addr string
+	// Socket is the path of a unix socket to listen on as well.
+	Socket string
+	// SocketMode are the permissions of the socket, DefaultSocketMode when
+	// zero.
+	SocketMode os.FileMode
+	// WebSocket is the address to serve WebSocket clients on as well, see
+	// ListenAndServeWebSocket.
+	WebSocket string
+	// TLS serves the hooks over TLS when set, with ClientAuth and ClientCAs
+	// the clients must present certificates. See TLSConfig.
+	TLS *tls.Config
+	// QueueDepth is how many events a client may fall behind before its
+	// Overflow is applied, DefaultClientBufferSize when zero. Overflow is
+	// the one of the clients not asking, OverflowDrop when empty, and
+	// SpillDir where OverflowSpill keeps the events, without it clients
+	// can't spill.
+	QueueDepth int
+	Overflow   Overflow
+	SpillDir   string
+	// Webhooks are POSTed the events as well.
+	Webhooks []Webhook
 }
 
 type Executor struct {
-	listener   net.Listener
+	life *lifecycle
+	// xmppStream is the one of the connection, swapped on reconnecting
+	streamMu   sync.Mutex
 	xmppStream stream.Stream
 	logger     *log.Logger
+	opts       Options
 
 	inbox          chan *IncomingEvent
-	outbox         chan *Message
-	cmdInbox       chan string
-	clientRequests chan chan clientReply
+	outbox         chan *clientMessage
+	cmdInbox       chan *command
+	clientRequests chan clientRequest
+	responses      chan *response
+	// written is signalled by the writers as they take events, for the
+	// spilled ones to follow
+	written chan struct{}
+
+	clients   []*clientInfo
+	counter   int
+	clientIDs int
+
+	// paused holds the events rather than sending them to the hooks, and
+	// drops the messages of the hooks
+	paused bool
+	held   []*Message
+	// recent are the last events sent, for the clients resuming
+	recent []*Message
+	// webhooks are delivered the events apart from the clients
+	webhooks []*webhook
+
+	// files holds the files hooks are sending in "file-data" chunks, by the
+	// client and the sid
+	files map[fileKey]*hookFile
+
+	// Attention sends the "attention" messages of hooks, they are dropped
+	// when it is nil.
+	Attention func(to, body string) error
+	// React sends the "reaction" messages of hooks, they are dropped when it
+	// is nil.
+	React func(to, id, reaction string) error
+	// File forwards the files hooks send with "file-open", "file-data" and
+	// "file-close", they are dropped when it is nil.
+	File func(to, name, mime string, data []byte) error
+	// Tokens are the tokens clients authenticate with and what they may do,
+	// the listener doesn't start without them.
+	Tokens map[string]Permission
+	// Rooms are where hooks may post, the first one unless they tell, and
+	// Contacts whom they may message directly.
+	Rooms    []string
+	Contacts []string
+	// Policy limits what hooks may send further.
+	Policy Policy
+	// Occupants and Stats answer the requests of hooks about the rooms, they
+	// are refused when nil. Stats may take its time.
+	Occupants func(room string) []string
+	Stats     func(room, user string) (map[string]string, error)
+}
+
+// fileKey tells the files of the clients apart, the client is zero for the
+// files sent by commands.
+type fileKey struct {
+	client int
+	sid    string
+}
 
-	clients []*clientInfo
-	counter int
+type hookFile struct {
+	to, name, mime string
+	data           []byte
 }
 
-func NewExecutor(s stream.Stream) *Executor {
+func NewExecutor(s stream.Stream, opts Options) *Executor {
+	if opts.Addr == "" && opts.Socket == "" && opts.WebSocket == "" {
+		opts.Addr = DefaultAddr
+	}
+	if opts.SocketMode == 0 {
+		opts.SocketMode = DefaultSocketMode
+	}
+	if opts.QueueDepth == 0 {
+		opts.QueueDepth = DefaultClientBufferSize
+	}
 	return &Executor{
-		nil,
-		s,
-		log.New(os.Stderr, "[hookexecutor] ", log.LstdFlags),
-		make(chan *IncomingEvent, DefaultInboxBufferSize),
-		make(chan *Message, DefaultOutboxBufferSize),
-		make(chan string, DefaultInboxBufferSize),
-		make(chan chan clientReply, DefaultInboxBufferSize),
-		nil,
-		0,
+		life:           newLifecycle(),
+		xmppStream:     s,
+		logger:         log.New(os.Stderr, "[hookexecutor] ", log.LstdFlags),
+		opts:           opts,
+		inbox:          make(chan *IncomingEvent, DefaultInboxBufferSize),
+		outbox:         make(chan *clientMessage, DefaultOutboxBufferSize),
+		cmdInbox:       make(chan *command, DefaultInboxBufferSize),
+		clientRequests: make(chan clientRequest, DefaultInboxBufferSize),
+		responses:      make(chan *response, DefaultInboxBufferSize),
+		written:        make(chan struct{}, 1),
+		files:          make(map[fileKey]*hookFile),
 	}
 }
 
+// SetStream moves the executor to the stream of a new connection, the clients
+// stay connected and the events go on numbered as before.
+func (exc *Executor) SetStream(s stream.Stream) {
+	exc.streamMu.Lock()
+	exc.xmppStream = s
+	exc.streamMu.Unlock()
+}
+
+func (exc *Executor) xmpp() stream.Stream {
+	exc.streamMu.Lock()
+	defer exc.streamMu.Unlock()
+	return exc.xmppStream
+}
+
 func (exc *Executor) Start() {
-	go exc.ListenAndServe(DefaultAddr)
+	if exc.opts.Addr != "" {
+		go exc.ListenAndServe(exc.opts.Addr)
+	}
+	if exc.opts.Socket != "" {
+		go exc.ListenAndServeUnix(exc.opts.Socket, exc.opts.SocketMode)
+	}
+	if exc.opts.WebSocket != "" {
+		go exc.ListenAndServeWebSocket(exc.opts.WebSocket)
+	}
+	exc.startWebhooks()
 	go exc.processEvents()
 }
 
-func (exc *Executor) Stop() {
-	close(exc.inbox)
-	close(exc.cmdInbox)
+// Run runs a command of the hook subsystem and returns the reply, see
+// runCommand for the commands.
+func (exc *Executor) Run(cmd string) string {
+	c := &command{cmd, make(chan string, 1)}
+	select {
+	case exc.cmdInbox <- c:
+	case <-exc.life.done:
+		return "stopped"
+	}
+	select {
+	case reply := <-c.reply:
+		return reply
+	case <-exc.life.finished:
+		return "stopped"
+	}
 }
 
-func (exc *Executor) Run(cmd string) {
-	exc.cmdInbox <- cmd
+// NewEvent passes an event to the hooks, once stopped it is dropped.
+func (exc *Executor) NewEvent(e IncomingEvent) {
+	select {
+	case exc.inbox <- &e:
+	case <-exc.life.done:
+	}
 }
 
-func (exc *Executor) NewEvent(e IncomingEvent) {
-	exc.inbox <- &e
+// Queued returns the number of events waiting to be sent to the hooks.
+func (exc *Executor) Queued() int {
+	return len(exc.inbox)
 }
 
 func stopPanic(exc *Executor, where string, callback func(err error)) {
@@ -108,36 +297,124 @@ func stopPanic(exc *Executor, where string, callback func(err error)) {
 func (exc *Executor) ListenAndServe(addr string) {
 	defer stopPanic(exc, "listener", func(_ error) { exc.ListenAndServe(addr) })
 
+	if len(exc.Tokens) == 0 {
+		exc.logger.Printf("no tokens, hooker disabled")
+		return
+	}
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		exc.logger.Printf("failed to start listener, hooker disabled: %v", err)
 		return
 	}
+	if exc.opts.TLS != nil {
+		listener = tls.NewListener(listener, exc.opts.TLS)
+	}
+	if !exc.life.listen(listener) {
+		listener.Close()
+		return
+	}
+	exc.acceptLoop(listener)
+}
+
+// ListenAndServeUnix serves the hooks on a unix socket at path, made with
+// the permissions of mode. A socket left at path by a previous run is
+// removed first.
+func (exc *Executor) ListenAndServeUnix(path string, mode os.FileMode) {
+	defer stopPanic(exc, "unix listener", func(_ error) { exc.ListenAndServeUnix(path, mode) })
+
+	if len(exc.Tokens) == 0 {
+		exc.logger.Printf("no tokens, hooker disabled")
+		return
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		exc.logger.Printf("failed to start unix listener, hooker disabled: %v", err)
+		return
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		exc.logger.Printf("failed to set socket permissions, hooker disabled: %v", err)
+		listener.Close()
+		return
+	}
+	if !exc.life.listen(listener) {
+		listener.Close()
+		return
+	}
+	exc.acceptLoop(listener)
+}
+
+func (exc *Executor) acceptLoop(listener net.Listener) {
 	defer listener.Close()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			exc.logger.Printf("failed to accept new connection: %v", err)
+			select {
+			case <-exc.life.done:
+			default:
+				exc.logger.Printf("failed to accept new connection: %v", err)
+			}
 			return
 		}
+		go exc.serve(conn)
+	}
+}
 
-		inbox, outbox := exc.createClient()
-		stop := make(chan struct{})
-		errors := make(chan error, 2)
-		go exc.clientWriter(inbox, conn, errors, stop)
-		go exc.clientReader(outbox, conn, errors, stop)
-		go exc.stopOnError(stop, errors)
+func (exc *Executor) serve(conn net.Conn) {
+	defer stopPanic(exc, "serve", nil)
+
+	bc := newBufConn(conn)
+	conn = bc
+	framing, version, err := hello(bc)
+	if err != nil {
+		exc.logger.Printf("dropping %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	t, err := exc.authenticate(conn, framing)
+	if err != nil {
+		exc.logger.Printf("dropping %s: %v", conn.RemoteAddr(), err)
+		bye := &Message{&IncomingEvent{"close", map[string]string{"reason": CloseAuthFailed}}, -1}
+		framing.Write(conn, DefaultHeartbeatTimeout, bye)
+		conn.Close()
+		return
 	}
+
+	addr := conn.RemoteAddr().String()
+	if addr == "" || addr == "@" {
+		addr = "unix"
+	}
+	if !exc.life.connect(conn) {
+		conn.Close()
+		return
+	}
+	info, outbox := exc.createClient(addr, t)
+	if info == nil {
+		exc.life.disconnect(conn)
+		conn.Close()
+		return
+	}
+	info.framing, info.version = t.framing, version
+	stop := info.stop
+	errors := make(chan error, 2)
+	alive := new(int64)
+	*alive = time.Now().UnixNano()
+	go exc.clientWriter(info.inbox, conn, t.framing, alive, errors, stop)
+	go exc.clientReader(outbox, info, conn, alive, errors, stop)
+	go exc.stopOnError(stop, errors)
 }
 
-func (exc *Executor) clientWriter(inbox chan *Message, conn net.Conn, errors chan error, stop chan struct{}) {
+func (exc *Executor) clientWriter(inbox chan *Message, conn net.Conn, framing Framing, alive *int64, errors chan error, stop chan struct{}) {
 	defer stopPanic(exc, "clientWriter",
 		func(err error) {
 			exc.logger.Printf("catched panic in writer: %v", err)
 			errors <- err
 		})
 
+	defer exc.life.disconnect(conn)
 	defer conn.Close()
 
 	heartbeatTicker := time.NewTicker(DefaultHeartbeatTrigger)
@@ -151,15 +428,26 @@ func (exc *Executor) clientWriter(inbox chan *Message, conn net.Conn, errors cha
 				return
 			}
 
-			err := WriteMessage(conn, DefaultHeartbeatTimeout, msg)
+			select {
+			case exc.written <- struct{}{}:
+			default:
+			}
+			err := framing.Write(conn, DefaultHeartbeatTimeout, msg)
 			if err != nil {
 				exc.logger.Printf("failed to write message: %v", err)
 				errors <- err
 				return
 			}
 		case <-heartbeatTicker.C:
+			if idle := time.Since(time.Unix(0, atomic.LoadInt64(alive))); idle > DefaultLivenessTimeout {
+				exc.logger.Printf("dropping client silent for %v", idle)
+				bye := &Message{&IncomingEvent{"close", map[string]string{"reason": CloseLivenessTimeout}}, -1}
+				framing.Write(conn, DefaultHeartbeatTimeout, bye)
+				errors <- fmt.Errorf("client is silent for %v", idle)
+				return
+			}
 			ping := &Message{&IncomingEvent{"ping", nil}, -1}
-			err := WriteMessage(conn, DefaultHeartbeatTimeout, ping)
+			err := framing.Write(conn, DefaultHeartbeatTimeout, ping)
 			if err != nil {
 				exc.logger.Printf("failed to write ping message: %v", err)
 				errors <- err
@@ -171,7 +459,7 @@ func (exc *Executor) clientWriter(inbox chan *Message, conn net.Conn, errors cha
 	}
 }
 
-func (exc *Executor) clientReader(outbox chan *Message, conn net.Conn, errors chan error, stop chan struct{}) {
+func (exc *Executor) clientReader(outbox chan *clientMessage, info *clientInfo, conn net.Conn, alive *int64, errors chan error, stop chan struct{}) {
 	defer stopPanic(exc, "clientReader",
 		func(err error) {
 			exc.logger.Printf("catched panic in reader: %v", err)
@@ -180,20 +468,20 @@ func (exc *Executor) clientReader(outbox chan *Message, conn net.Conn, errors ch
 	defer conn.Close()
 
 	for {
-		msg, err := ReadMessage(conn, DefaultHeartbeatTimeout)
+		msg, err := info.framing.Read(conn, DefaultLivenessTimeout)
 		if err != nil {
 			exc.logger.Printf("failed to read message: %v", err)
 			errors <- err
 			return
 		}
+		atomic.StoreInt64(alive, time.Now().UnixNano())
 
-		if msg.Type == "pong" {
-			// ignore pongs, they are for resetting timeouts
+		if msg.IncomingEvent != nil && msg.Type == "pong" {
+			// pongs only prove the client is alive
 			continue
 		}
-
 		select {
-		case outbox <- msg:
+		case outbox <- &clientMessage{msg, info}:
 		case <-stop:
 			return
 		}
@@ -206,109 +494,134 @@ func (exc *Executor) stopOnError(stop chan struct{}, errors chan error) {
 	close(stop)
 }
 
-func ReadMessage(conn net.Conn, timeout time.Duration) (*Message, error) {
-	conn.SetReadDeadline(time.Now().Add(DefaultHeartbeatTimeout))
-	var lengthBuf [2]byte
-	_, err := conn.Read(lengthBuf[:])
-	if err != nil {
-		return nil, err
-	}
-
-	length := int(binary.BigEndian.Uint16(lengthBuf[:]))
-	if length > DefaultMessageLengthCap {
-		return nil, errors.New("message is too long")
-	}
-
-	buf := bytes.NewBuffer(make([]byte, 0, length))
-	_, err = io.CopyN(buf, conn, int64(length))
-	if err != nil {
-		return nil, err
-	}
-
-	var handle = &codec.MsgpackHandle{}
-	var decoder = codec.NewDecoder(buf, handle)
-	var result = &Message{}
-	err = decoder.Decode(result)
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
-}
-
-func WriteMessage(conn net.Conn, timeout time.Duration, msg *Message) error {
-	var handle = &codec.MsgpackHandle{}
-	var buf []byte
-	var encoder = codec.NewEncoderBytes(&buf, handle)
-	err := encoder.Encode(msg)
-	if err != nil {
-		return err
-	}
-
-	length := len(buf)
-	if length > DefaultMessageLengthCap {
-		return errors.New("message is too long")
+func (exc *Executor) createClient(addr string, t *terms) (*clientInfo, chan *clientMessage) {
+	reply := make(chan clientReply, 1)
+	select {
+	case exc.clientRequests <- clientRequest{addr, t, reply}:
+	case <-exc.life.done:
+		return nil, nil
 	}
-
-	var lengthBuf [2]byte
-	binary.BigEndian.PutUint16(lengthBuf[:], uint16(length))
-
-	conn.SetWriteDeadline(time.Now().Add(timeout))
-	_, err = conn.Write(lengthBuf[:])
-	if err != nil {
-		return err
+	select {
+	case r := <-reply:
+		return r.info, r.outbox
+	case <-exc.life.finished:
+		return nil, nil
 	}
-
-	_, err = io.Copy(conn, bytes.NewBuffer(buf))
-	return err
-}
-
-func (exc *Executor) createClient() (inbox, outbox chan *Message) {
-	reply := make(chan clientReply, 1)
-	exc.clientRequests <- reply
-	r := <-reply
-	return r.info.inbox, r.outbox
 }
 
 func (exc *Executor) processEvents() {
 	defer stopPanic(exc, "processEvents", func(_ error) { exc.processEvents() })
 
+	// once stopping the clients are sent what is queued and spilled for
+	// them before they are closed, or the deadline comes
+	done := exc.life.done
+	var deadline <-chan struct{}
+	stopping := false
 	for {
+		if stopping && exc.flushed() {
+			exc.finish(deadline)
+			return
+		}
 		select {
 		case msg := <-exc.inbox:
-			message := &Message{msg, exc.counter}
-			exc.sendMessage(message)
-			exc.counter++
+			exc.event(msg)
+		case <-done:
+			done, stopping = nil, true
+			deadline = exc.life.ctx.Done()
+			for queued := true; queued; {
+				select {
+				case msg := <-exc.inbox:
+					exc.event(msg)
+				default:
+					queued = false
+				}
+			}
+		case <-deadline:
+			exc.finish(deadline)
+			return
 		case cmd := <-exc.cmdInbox:
-			// TODO(mechmind): handle cmds
-			exc.logger.Printf("ignoring cmd: '%s'", cmd)
+			cmd.reply <- exc.runCommand(cmd.line)
 		case req := <-exc.clientRequests:
 			outbox := exc.outbox
 
+			exc.clientIDs++
 			info := &clientInfo{
-				inbox: make(chan *Message, DefaultClientBufferSize),
-				stop:  make(chan struct{}),
+				id:       exc.clientIDs,
+				addr:     req.addr,
+				perm:     req.terms.perm,
+				since:    time.Now(),
+				inbox:    make(chan *Message, exc.opts.QueueDepth),
+				stop:     make(chan struct{}),
+				overflow: req.terms.overflow,
 			}
 
 			exc.clients = append(exc.clients, info)
-			req <- clientReply{outbox, info}
+			req.reply <- clientReply{outbox, info}
+			if req.terms.resume >= 0 {
+				exc.replay(info, req.terms.resume)
+			}
 		case msg := <-exc.outbox:
-			exc.SendMessageToBot(msg)
+			if msg.IncomingEvent != nil && msg.Type == Subscribe {
+				exc.subscribe(msg)
+				continue
+			}
+			if msg.IncomingEvent != nil && msg.Type == Request {
+				exc.request(msg)
+				continue
+			}
+			var err error
+			switch {
+			case msg.from.perm != PermSend:
+				err = errReadOnly
+			case exc.paused:
+				err = errPaused
+			default:
+				err = exc.act(msg.from.id, msg.Message)
+			}
+			if err != nil {
+				exc.reject(msg, err)
+			}
+		case r := <-exc.responses:
+			exc.respond(r)
+		case <-exc.written:
+			exc.drainSpills()
 		}
 	}
 }
 
+// event numbers an event and sends it, or holds it while paused.
+func (exc *Executor) event(e *IncomingEvent) {
+	message := &Message{e, exc.counter}
+	exc.counter++
+	if exc.paused {
+		if len(exc.held) == DefaultHeldCap {
+			exc.held = exc.held[1:]
+		}
+		exc.held = append(exc.held, message)
+		return
+	}
+	exc.sendMessage(message)
+}
+
 func (exc *Executor) sendMessage(msg *Message) {
+	exc.remember(msg)
 	deadClientIDs := []int{}
 
 	for idx, ch := range exc.clients {
-		select {
-		case ch.inbox <- msg:
-		default:
+		if !ch.filter.match(msg.IncomingEvent) {
+			continue
+		}
+		if !exc.enqueue(ch, msg) {
 			deadClientIDs = append(deadClientIDs, idx)
 		}
 	}
 
+	exc.dropClients(deadClientIDs)
+	exc.push(msg)
+}
+
+// dropClients drops the clients at the indices given in order.
+func (exc *Executor) dropClients(deadClientIDs []int) {
 	if len(deadClientIDs) == 0 {
 		return
 	}
@@ -319,7 +632,8 @@ func (exc *Executor) sendMessage(msg *Message) {
 	for idx, client := range exc.clients {
 		if currentID < len(deadClientIDs) && idx == deadClientIDs[currentID] {
 			// client is dead, drop him
-			close(client.inbox)
+			client.close()
+			exc.dropFiles(client.id)
 			currentID++
 		} else {
 			// client alive, take him
@@ -330,12 +644,185 @@ func (exc *Executor) sendMessage(msg *Message) {
 	exc.clients = aliveClients
 }
 
-func (exc *Executor) SendMessageToBot(msg *Message) {
-	m := entity.MSG(entity.GROUPCHAT)
-	m.To = "golang@conference.jabber.ru"
-	m.Body = msg.IncomingEvent.Data["body"]
-	err := exc.xmppStream.Write(entity.ProduceStatic(m))
+// SendMessageToBot acts on a message of a hook, the error tells why it was
+// refused or failed.
+func (exc *Executor) SendMessageToBot(msg *Message) error {
+	return exc.act(0, msg)
+}
+
+// act acts on a message of the client, zero for the commands.
+func (exc *Executor) act(client int, msg *Message) error {
+	if msg.IncomingEvent == nil {
+		return errNoEvent
+	}
+	if err := exc.Policy.check(msg); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case "file-data", "file-close":
+		// routed once opened
+		return exc.receiveFile(client, msg, jid.JID{})
+	}
+	to, _, err := exc.destination(msg.Type, msg.Data)
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case "reaction":
+		if exc.React == nil {
+			return errUnsupported
+		}
+		return exc.React(to.String(), msg.Data["id"], msg.Data["reaction"])
+	case "attention":
+		if exc.Attention == nil {
+			return errUnsupported
+		}
+		return exc.Attention(to.String(), msg.Data["body"])
+	case "file-open":
+		return exc.receiveFile(client, msg, to)
+	}
+	_, err = exc.send(msg.Data)
+	return err
+}
+
+// destination routes a message of the type and applies the policy to where
+// it goes. Attention and files may go to an occupant or a resource, they are
+// routed by its bare JID and the policy may allow either.
+func (exc *Executor) destination(typ string, data map[string]string) (jid.JID, string, error) {
+	full, err := jid.Parse(data[FieldTo])
+	if err == nil && !full.IsBare() && (typ == "attention" || typ == "file-open") {
+		data = map[string]string{FieldTo: full.Bare().String(), FieldKind: data[FieldKind]}
+	} else {
+		full = jid.JID{}
+	}
+	to, kind, err := exc.route(data)
+	if err != nil {
+		return jid.JID{}, "", err
+	}
+	if err := exc.Policy.allowTo(to.String()); err != nil {
+		if full.IsZero() || exc.Policy.allowTo(full.String()) != nil {
+			return jid.JID{}, "", err
+		}
+	}
+	if !full.IsZero() {
+		to = full
+	}
+	return to, kind, nil
+}
+
+// send routes the body of a message and sends it, returning the id of the
+// stanza.
+func (exc *Executor) send(data map[string]string) (string, error) {
+	to, kind, err := exc.destination("message", data)
+	if err != nil {
+		return "", err
+	}
+	st := exc.xmpp()
+	if st == nil {
+		return "", errOffline
+	}
+	m := stanza.NewMessage(kind, to, data["body"])
+	m.ID = st.Id()
+	// hook bodies are arbitrary text, Produce escapes them
+	buf, err := stanza.Produce(m)
+	if err == nil {
+		err = st.Write(buf)
+	}
 	if err != nil {
 		exc.logger.Printf("failed to write message to xmpp stream: %v", err)
 	}
+	return m.ID, err
+}
+
+// tell sends a message to a client unless it is gone or can't take more.
+func (exc *Executor) tell(c *clientInfo, msg *Message) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.inbox <- msg:
+	default:
+	}
+}
+
+// fileChunk is the size of the file data in a "file-data" message, it fits
+// the length cap base64 encoded.
+const fileChunk = 2 * 1024
+
+// FileMessages splits a file into the messages sending it to the bot, which
+// forwards it to the JID.
+func FileMessages(sid, to, name, mime string, data []byte) []*Message {
+	ret := []*Message{{&IncomingEvent{"file-open", map[string]string{"sid": sid, "to": to, "name": name, "mime": mime}}, -1}}
+	for len(data) > 0 {
+		n := fileChunk
+		if n > len(data) {
+			n = len(data)
+		}
+		ret = append(ret, &Message{&IncomingEvent{"file-data", map[string]string{"sid": sid, "data": base64.StdEncoding.EncodeToString(data[:n])}}, -1})
+		data = data[n:]
+	}
+	return append(ret, &Message{&IncomingEvent{"file-close", map[string]string{"sid": sid}}, -1})
+}
+
+// receiveFile assembles the chunks of a file sent by a hook to the routed
+// JID and passes the file on once it is closed, a client may send up to
+// DefaultFileTransfers files at once.
+func (exc *Executor) receiveFile(client int, msg *Message, to jid.JID) error {
+	key := fileKey{client, msg.Data["sid"]}
+	switch msg.Type {
+	case "file-open":
+		if exc.File == nil {
+			return errUnsupported
+		}
+		if _, ok := exc.files[key]; !ok && exc.transfers(client) >= DefaultFileTransfers {
+			return errTooManyFiles
+		}
+		exc.files[key] = &hookFile{to: to.String(), name: msg.Data["name"], mime: msg.Data["mime"]}
+	case "file-data":
+		f, ok := exc.files[key]
+		if !ok {
+			return errNoFile
+		}
+		chunk, err := base64.StdEncoding.DecodeString(msg.Data["data"])
+		if err == nil && len(f.data)+len(chunk) > DefaultFileSizeCap {
+			err = errors.New("file is too large")
+		}
+		if err != nil {
+			exc.logger.Printf("dropping file %s of client %d: %v", key.sid, client, err)
+			delete(exc.files, key)
+			return err
+		}
+		f.data = append(f.data, chunk...)
+	case "file-close":
+		f, ok := exc.files[key]
+		if !ok {
+			return errNoFile
+		}
+		delete(exc.files, key)
+		go func() {
+			if err := exc.File(f.to, f.name, f.mime, f.data); err != nil {
+				exc.logger.Printf("failed to send file %s to %s: %v", f.name, f.to, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// transfers counts the files the client is sending.
+func (exc *Executor) transfers(client int) (n int) {
+	for k := range exc.files {
+		if k.client == client {
+			n++
+		}
+	}
+	return
+}
+
+// dropFiles forgets the files the client was sending.
+func (exc *Executor) dropFiles(client int) {
+	for k := range exc.files {
+		if k.client == client {
+			delete(exc.files, k)
+		}
+	}
 }
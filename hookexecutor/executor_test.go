@@ -0,0 +1,86 @@
+package hookexecutor
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func fileExecutor() *Executor {
+	exc := NewExecutor(streamtest.New("example.org"), Options{})
+	exc.Contacts = []string{"admin@example.org"}
+	exc.File = func(to, name, mime string, data []byte) error { return nil }
+	return exc
+}
+
+func open(exc *Executor, client int, sid string) error {
+	return exc.act(client, &Message{&IncomingEvent{"file-open", map[string]string{"sid": sid, "to": "admin@example.org", "name": "f"}}, -1})
+}
+
+func TestFileTransfers(t *testing.T) {
+	exc := fileExecutor()
+	for i := 0; i < DefaultFileTransfers; i++ {
+		if err := open(exc, 1, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := open(exc, 1, "more"); err != errTooManyFiles {
+		t.Fatalf("got %v, want errTooManyFiles", err)
+	}
+	if err := open(exc, 1, "0"); err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	// the sids of the clients don't clash
+	if err := open(exc, 2, "0"); err != nil {
+		t.Fatal(err)
+	}
+	closing := &Message{&IncomingEvent{"file-close", map[string]string{"sid": "0"}}, -1}
+	if err := exc.act(1, closing); err != nil {
+		t.Fatal(err)
+	}
+	if err := open(exc, 1, "more"); err != nil {
+		t.Fatalf("after a close: %v", err)
+	}
+	if _, ok := exc.files[fileKey{2, "0"}]; !ok {
+		t.Fatal("the file of the other client was closed")
+	}
+}
+
+func TestFilesDroppedWithClient(t *testing.T) {
+	exc := fileExecutor()
+	for id := 1; id <= 2; id++ {
+		exc.clients = append(exc.clients, &clientInfo{id: id, inbox: make(chan *Message, 1), stop: make(chan struct{})})
+		if err := open(exc, id, "s"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exc.dropClients([]int{0})
+	if _, ok := exc.files[fileKey{1, "s"}]; ok {
+		t.Fatal("the file of the dropped client is kept")
+	}
+	if _, ok := exc.files[fileKey{2, "s"}]; !ok {
+		t.Fatal("the file of the live client is dropped")
+	}
+	if got := exc.runCommand("drop 2"); !strings.HasPrefix(got, "dropped 2") || len(exc.clients) != 0 {
+		t.Fatalf("drop 2: %s", got)
+	}
+	if _, ok := exc.files[fileKey{2, "s"}]; ok {
+		t.Fatal("the file of the client dropped by the command is kept")
+	}
+}
+
+func TestSetStream(t *testing.T) {
+	exc := NewExecutor(nil, Options{})
+	exc.Rooms = []string{"room@conference.example.org"}
+	if _, err := exc.send(map[string]string{"body": "hi"}); err != errOffline {
+		t.Fatalf("sent with no stream: %v", err)
+	}
+	for i, st := range []*streamtest.Stream{streamtest.New("example.org"), streamtest.New("example.org")} {
+		exc.SetStream(st)
+		if _, err := exc.send(map[string]string{"body": "hi"}); err != nil || len(st.Written()) != 1 {
+			t.Fatalf("stream %d: %v, %d written", i, err, len(st.Written()))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package hookexecutor
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+)
+
+// Subscribe is the type of the message a client tells the events it wants
+// with, in the comma separated "types", "rooms" and "senders" fields. It is
+// answered with Subscribed, a subscribe without fields takes everything again.
+const (
+	Subscribe  = "subscribe"
+	Subscribed = "subscribed"
+)
+
+// SubscribeVersion is the protocol version clients may subscribe from.
+const SubscribeVersion = 2
+
+var errSubscribe = errors.New("subscribe needs protocol version 2")
+
+// senderFields are the fields events tell who caused them in, by preference.
+var senderFields = []string{"sender", "from", "jid", "user", "by"}
+
+// Filter is what a client subscribed to, an event must match all the lists
+// given. Senders are patterns like *@example.org, matched against full and
+// bare JIDs; an event not telling its room or sender doesn't match the lists
+// of those.
+type Filter struct {
+	Types   []string
+	Rooms   []string
+	Senders []string
+}
+
+func split(s string) (ret []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return
+}
+
+// ParseFilter reads the fields of a subscribe.
+func ParseFilter(data map[string]string) Filter {
+	return Filter{split(data["types"]), split(data["rooms"]), split(data["senders"])}
+}
+
+func (f *Filter) fields() map[string]string {
+	return map[string]string{
+		"types":   strings.Join(f.Types, ","),
+		"rooms":   strings.Join(f.Rooms, ","),
+		"senders": strings.Join(f.Senders, ","),
+	}
+}
+
+func (f *Filter) match(e *IncomingEvent) bool {
+	if e == nil {
+		return true
+	}
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Rooms) > 0 {
+		room := e.Data["room"]
+		if room == "" {
+			// the events of a room come from the JIDs of its occupants
+			room = jid.Bare(e.Data["from"])
+		}
+		if room == "" || !contains(f.Rooms, room) {
+			return false
+		}
+	}
+	if len(f.Senders) > 0 {
+		var sender string
+		for _, k := range senderFields {
+			if sender = e.Data[k]; sender != "" {
+				break
+			}
+		}
+		if sender == "" {
+			return false
+		}
+		bare := jid.Bare(sender)
+		for _, p := range f.Senders {
+			if ok, _ := path.Match(p, sender); ok {
+				return true
+			}
+			if ok, _ := path.Match(p, bare); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribe sets the filter of the client and tells it the one set.
+func (exc *Executor) subscribe(msg *clientMessage) {
+	if msg.from.version < SubscribeVersion {
+		exc.reject(msg, errSubscribe)
+		return
+	}
+	msg.from.filter = ParseFilter(msg.Data)
+	exc.tell(msg.from, &Message{&IncomingEvent{Subscribed, msg.from.filter.fields()}, msg.ID})
+}
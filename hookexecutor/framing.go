@@ -0,0 +1,271 @@
+package hookexecutor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// DefaultFrameCap is the most payload a frame of Framing2 carries.
+	DefaultFrameCap = 64 * 1024
+	// DefaultLargeMessageCap is the largest message Framing2 takes in all
+	// the frames continuing it, and the longest line of FramingJSON.
+	DefaultLargeMessageCap = 4 * 1024 * 1024
+)
+
+// Framing is how messages are laid out on the connection. Clients ask for
+// Framing2 in their "auth" message and use it once "auth-ok" agrees, until
+// then and with the clients not asking Framing1 is used. FramingJSON is asked
+// for before that, see there.
+type Framing int
+
+const (
+	// Framing1 puts a 2-byte length before each message of at most
+	// DefaultMessageLengthCap.
+	Framing1 Framing = 1
+	// Framing2 puts a frame header before each piece of a message: a 4-byte
+	// length, a flags byte and the CRC-32 of the piece. A message larger
+	// than a frame is continued in the frames after it.
+	Framing2 Framing = 2
+	// FramingJSON sends each message as a line of JSON instead of msgpack,
+	// like {"type": "message", "data": {"body": "hi"}, "id": 1}, for the
+	// clients that are scripts. It is asked for in the "hello" with the line
+	// {"type": "hello", "data": {"encoding": "json"}}, see hello, and can't be
+	// switched to later.
+	FramingJSON Framing = 3
+)
+
+// FieldFraming is the field of "auth" and "auth-ok" the framing goes in.
+const FieldFraming = "framing"
+
+// flagMore marks a frame continued by the next one.
+const flagMore = 1
+
+// frameHeader is the length, the flags and the CRC.
+const frameHeader = 4 + 1 + 4
+
+var (
+	errTooLong  = errors.New("message is too long")
+	errChecksum = errors.New("frame checksum mismatch")
+)
+
+// ParseFraming reads the framing of an "auth" or "auth-ok", Framing1 unless
+// it is one known.
+func ParseFraming(s string) Framing {
+	if n, err := strconv.Atoi(s); err == nil && Framing(n) == Framing2 {
+		return Framing2
+	}
+	return Framing1
+}
+
+func (f Framing) String() string {
+	if f == FramingJSON {
+		return "json"
+	}
+	return strconv.Itoa(int(f))
+}
+
+// jsonMessage is Message in FramingJSON with the lower case names scripts
+// expect, Message keeps the names msgpack clients know.
+type jsonMessage struct {
+	Type string            `json:"type"`
+	Data map[string]string `json:"data,omitempty"`
+	ID   int               `json:"id"`
+}
+
+// bufConn buffers the reads of a connection, so the first byte can be
+// peeked at and the lines of FramingJSON read.
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newBufConn(conn net.Conn) *bufConn {
+	return &bufConn{conn, bufio.NewReader(conn)}
+}
+
+func (c *bufConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+var errNotBuffered = errors.New("json framing needs a buffered connection")
+
+// readLine reads a line of at most DefaultLargeMessageCap bytes.
+func (c *bufConn) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := c.r.ReadSlice('\n')
+		if len(line)+len(chunk) > DefaultLargeMessageCap {
+			return nil, errTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func readJSON(conn net.Conn) (*Message, error) {
+	bc, ok := conn.(*bufConn)
+	if !ok {
+		return nil, errNotBuffered
+	}
+	for {
+		line, err := bc.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var m jsonMessage
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, err
+		}
+		return &Message{&IncomingEvent{m.Type, m.Data}, m.ID}, nil
+	}
+}
+
+func encode(msg *Message) ([]byte, error) {
+	var buf []byte
+	err := codec.NewEncoderBytes(&buf, &codec.MsgpackHandle{}).Encode(msg)
+	return buf, err
+}
+
+// decode reads a message of msgpack, garbage must not panic the reader.
+func decode(buf []byte) (result *Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("bad message: %v", r)
+		}
+	}()
+	result = &Message{}
+	if err := codec.NewDecoderBytes(buf, &codec.MsgpackHandle{}).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Read reads a message, a partial read waits for the rest up to the timeout.
+func (f Framing) Read(conn net.Conn, timeout time.Duration) (*Message, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if f == FramingJSON {
+		return readJSON(conn)
+	}
+	if f != Framing2 {
+		var lengthBuf [2]byte
+		if _, err := io.ReadFull(conn, lengthBuf[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(lengthBuf[:]))
+		if length > DefaultMessageLengthCap {
+			return nil, errTooLong
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return decode(buf)
+	}
+	var payload []byte
+	for {
+		var header [frameHeader]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(header[:4]))
+		if length > DefaultFrameCap || len(payload)+length > DefaultLargeMessageCap {
+			return nil, errTooLong
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(frame) != binary.BigEndian.Uint32(header[5:]) {
+			return nil, errChecksum
+		}
+		payload = append(payload, frame...)
+		if header[4]&flagMore == 0 {
+			return decode(payload)
+		}
+	}
+}
+
+// Write writes a message in one go, so the frames of a message can't be
+// interleaved with others written to the connection from the same goroutine.
+func (f Framing) Write(conn net.Conn, timeout time.Duration, msg *Message) error {
+	var payload []byte
+	var err error
+	if f == FramingJSON {
+		m := jsonMessage{ID: msg.ID}
+		if msg.IncomingEvent != nil {
+			m.Type, m.Data = msg.Type, msg.Data
+		}
+		payload, err = json.Marshal(m)
+	} else {
+		payload, err = encode(msg)
+	}
+	if err != nil {
+		return err
+	}
+	out := new(bytes.Buffer)
+	switch f {
+	case FramingJSON:
+		if len(payload) > DefaultLargeMessageCap {
+			return errTooLong
+		}
+		out.Write(payload)
+		out.WriteByte('\n')
+	case Framing2:
+		if len(payload) > DefaultLargeMessageCap {
+			return errTooLong
+		}
+		for first := true; first || len(payload) > 0; first = false {
+			n := len(payload)
+			if n > DefaultFrameCap {
+				n = DefaultFrameCap
+			}
+			var header [frameHeader]byte
+			binary.BigEndian.PutUint32(header[:4], uint32(n))
+			if n < len(payload) {
+				header[4] = flagMore
+			}
+			binary.BigEndian.PutUint32(header[5:], crc32.ChecksumIEEE(payload[:n]))
+			out.Write(header[:])
+			out.Write(payload[:n])
+			payload = payload[n:]
+		}
+	default:
+		if len(payload) > DefaultMessageLengthCap {
+			return errTooLong
+		}
+		var lengthBuf [2]byte
+		binary.BigEndian.PutUint16(lengthBuf[:], uint16(len(payload)))
+		out.Write(lengthBuf[:])
+		out.Write(payload)
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = out.WriteTo(conn)
+	return err
+}
+
+// ReadMessage reads a message laid out with Framing1.
+func ReadMessage(conn net.Conn, timeout time.Duration) (*Message, error) {
+	return Framing1.Read(conn, timeout)
+}
+
+// WriteMessage writes a message laid out with Framing1.
+func WriteMessage(conn net.Conn, timeout time.Duration, msg *Message) error {
+	return Framing1.Write(conn, timeout, msg)
+}
@@ -0,0 +1,176 @@
+package hookexecutor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// wireConn is a connection reading the bytes given and keeping the ones
+// written.
+type wireConn struct {
+	net.Conn
+	r io.Reader
+	w bytes.Buffer
+}
+
+func (c *wireConn) Read(p []byte) (int, error)       { return c.r.Read(p) }
+func (c *wireConn) Write(p []byte) (int, error)      { return c.w.Write(p) }
+func (c *wireConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *wireConn) SetWriteDeadline(time.Time) error { return nil }
+
+func wire(t *testing.T, f Framing, msgs ...*Message) []byte {
+	c := &wireConn{}
+	for _, msg := range msgs {
+		if err := f.Write(c, time.Second, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c.w.Bytes()
+}
+
+// frame lays out a frame of Framing2 by hand.
+func frame(payload []byte, flags byte, crc uint32) []byte {
+	var header [frameHeader]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	header[4] = flags
+	binary.BigEndian.PutUint32(header[5:], crc)
+	return append(header[:], payload...)
+}
+
+func TestFraming(t *testing.T) {
+	small := &Message{&IncomingEvent{"message", map[string]string{"body": "hi"}}, 1}
+	large := &Message{&IncomingEvent{"message", map[string]string{"body": strings.Repeat("x", 3*DefaultFrameCap)}}, 2}
+	whole := func(r io.Reader) io.Reader { return r }
+	tests := []struct {
+		name    string
+		framing Framing
+		wire    func(t *testing.T) []byte
+		reader  func(io.Reader) io.Reader
+		want    *Message
+		err     error
+	}{
+		{"framing1", Framing1, func(t *testing.T) []byte { return wire(t, Framing1, small) }, whole, small, nil},
+		{"framing1 byte by byte", Framing1, func(t *testing.T) []byte { return wire(t, Framing1, small) }, iotest.OneByteReader, small, nil},
+		{"framing1 half reads", Framing1, func(t *testing.T) []byte { return wire(t, Framing1, small) }, iotest.HalfReader, small, nil},
+		{"framing1 torn length", Framing1, func(t *testing.T) []byte { return wire(t, Framing1, small)[:1] }, whole, nil, io.ErrUnexpectedEOF},
+		{"framing1 torn payload", Framing1, func(t *testing.T) []byte {
+			w := wire(t, Framing1, small)
+			return w[:len(w)-1]
+		}, iotest.OneByteReader, nil, io.ErrUnexpectedEOF},
+		{"framing1 nothing", Framing1, func(t *testing.T) []byte { return nil }, whole, nil, io.EOF},
+		{"framing1 oversize", Framing1, func(t *testing.T) []byte { return []byte{0xff, 0xff} }, whole, nil, errTooLong},
+
+		{"framing2", Framing2, func(t *testing.T) []byte { return wire(t, Framing2, small) }, whole, small, nil},
+		{"framing2 byte by byte", Framing2, func(t *testing.T) []byte { return wire(t, Framing2, small) }, iotest.OneByteReader, small, nil},
+		{"framing2 continued", Framing2, func(t *testing.T) []byte { return wire(t, Framing2, large) }, whole, large, nil},
+		{"framing2 continued half reads", Framing2, func(t *testing.T) []byte { return wire(t, Framing2, large) }, iotest.HalfReader, large, nil},
+		{"framing2 torn header", Framing2, func(t *testing.T) []byte { return wire(t, Framing2, small)[:frameHeader-1] }, whole, nil, io.ErrUnexpectedEOF},
+		{"framing2 torn payload", Framing2, func(t *testing.T) []byte {
+			w := wire(t, Framing2, small)
+			return w[:len(w)-1]
+		}, iotest.OneByteReader, nil, io.ErrUnexpectedEOF},
+		{"framing2 torn between frames", Framing2, func(t *testing.T) []byte {
+			return wire(t, Framing2, large)[:frameHeader+DefaultFrameCap]
+		}, whole, nil, io.EOF},
+		{"framing2 bad crc", Framing2, func(t *testing.T) []byte {
+			w := wire(t, Framing2, small)
+			w[len(w)-1] ^= 0xff
+			return w
+		}, whole, nil, errChecksum},
+		{"framing2 bad crc of a continuation", Framing2, func(t *testing.T) []byte {
+			w := wire(t, Framing2, large)
+			w[2*(frameHeader+DefaultFrameCap)-1] ^= 0xff
+			return w
+		}, whole, nil, errChecksum},
+		{"framing2 oversize frame", Framing2, func(t *testing.T) []byte {
+			return frame(make([]byte, DefaultFrameCap+1), 0, 0)
+		}, whole, nil, errTooLong},
+		{"framing2 oversize message", Framing2, func(t *testing.T) []byte {
+			chunk := make([]byte, DefaultFrameCap)
+			f := frame(chunk, flagMore, crc32.ChecksumIEEE(chunk))
+			return bytes.Repeat(f, DefaultLargeMessageCap/DefaultFrameCap+1)
+		}, whole, nil, errTooLong},
+
+		{"json", FramingJSON, func(t *testing.T) []byte { return wire(t, FramingJSON, small) }, whole, small, nil},
+		{"json byte by byte", FramingJSON, func(t *testing.T) []byte { return append([]byte("\n\n"), wire(t, FramingJSON, small)...) }, iotest.OneByteReader, small, nil},
+		{"json torn", FramingJSON, func(t *testing.T) []byte {
+			w := wire(t, FramingJSON, small)
+			return w[:len(w)-2]
+		}, whole, nil, io.EOF},
+		{"json oversize", FramingJSON, func(t *testing.T) []byte {
+			return bytes.Repeat([]byte("x"), DefaultLargeMessageCap+1)
+		}, whole, nil, errTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conn net.Conn = &wireConn{r: tt.reader(bytes.NewReader(tt.wire(t)))}
+			if tt.framing == FramingJSON {
+				conn = newBufConn(conn)
+			}
+			got, err := tt.framing.Read(conn, time.Second)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramingSequence(t *testing.T) {
+	msgs := []*Message{
+		{&IncomingEvent{"message", map[string]string{"body": "one"}}, 1},
+		{&IncomingEvent{"message", map[string]string{"body": strings.Repeat("two", DefaultFrameCap)}}, 2},
+		{&IncomingEvent{"ping", nil}, -1},
+	}
+	for _, f := range []Framing{Framing1, Framing2, FramingJSON} {
+		send := msgs
+		if f == Framing1 {
+			// too long for it
+			send = []*Message{msgs[0], msgs[2]}
+		}
+		var conn net.Conn = &wireConn{r: iotest.HalfReader(bytes.NewReader(wire(t, f, send...)))}
+		if f == FramingJSON {
+			conn = newBufConn(conn)
+		}
+		for _, want := range send {
+			got, err := f.Read(conn, time.Second)
+			if err != nil {
+				t.Fatalf("framing %s: %v", f, err)
+			}
+			if got.Type != want.Type || got.ID != want.ID || got.Data["body"] != want.Data["body"] {
+				t.Fatalf("framing %s: got %+v, want %+v", f, got, want)
+			}
+		}
+	}
+}
+
+func TestFramingWriteOversize(t *testing.T) {
+	long := &Message{&IncomingEvent{"message", map[string]string{"body": strings.Repeat("x", DefaultMessageLengthCap)}}, 1}
+	if err := Framing1.Write(&wireConn{}, time.Second, long); err != errTooLong {
+		t.Fatalf("framing 1 wrote %v", err)
+	}
+	huge := &Message{&IncomingEvent{"message", map[string]string{"body": strings.Repeat("x", DefaultLargeMessageCap)}}, 1}
+	for _, f := range []Framing{Framing2, FramingJSON} {
+		if err := f.Write(&wireConn{}, time.Second, huge); err != errTooLong {
+			t.Fatalf("framing %s wrote %v", f, err)
+		}
+	}
+}
+
+func TestParseFraming(t *testing.T) {
+	for s, want := range map[string]Framing{"": Framing1, "1": Framing1, "2": Framing2, "3": Framing1, "x": Framing1} {
+		if got := ParseFraming(s); got != want {
+			t.Errorf("ParseFraming(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package hookclient
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kpmy/xep/hookexecutor"
 )
@@ -14,15 +20,36 @@ const (
 	DefaultClientOutboxSize = 4
 )
 
+var errStopped = errors.New("client stopped")
+
 type Client struct {
-	addr string
-	conn net.Conn
+	addr  string
+	token string
+	conn  net.Conn
+	// Permission is what the executor lets the client do, known once
+	// started.
+	Permission hookexecutor.Permission
+	// TLS connects over TLS when set, see hookexecutor.ClientTLSConfig.
+	TLS *tls.Config
+	// Overflow asks the executor what to do once the client falls behind,
+	// the default of the executor when empty.
+	Overflow hookexecutor.Overflow
+	framing  hookexecutor.Framing
 
 	prefixHandlers []stringMatchHandler
 	substrHandlers []stringMatchHandler
 
 	logger *log.Logger
 	stop   chan struct{}
+	outbox chan *hookexecutor.Message
+
+	// pending are the requests waiting for a response, by id
+	mu      sync.Mutex
+	pending map[int]chan *hookexecutor.Message
+	ids     int
+
+	// last is the id of the last event had, Start resumes after it
+	last int
 }
 
 type Handler interface {
@@ -40,32 +67,176 @@ type stringMatchHandler struct {
 	handler Handler
 }
 
-func NewClient(addr string) *Client {
+// NewClient makes a client of the executor at addr, a TCP address or the path
+// of its unix socket like unix:/run/xep/hooks.sock.
+func NewClient(addr, token string) *Client {
 	return &Client{
-		addr,
-		nil,
-		nil,
-		nil,
-		log.New(os.Stderr, "[hookclient] ", log.LstdFlags),
-		nil,
+		addr:    addr,
+		token:   token,
+		framing: hookexecutor.Framing1,
+		logger:  log.New(os.Stderr, "[hookclient] ", log.LstdFlags),
+		pending: make(map[int]chan *hookexecutor.Message),
+		last:    -1,
 	}
 }
 
 func (c *Client) Start() error {
-	conn, err := net.Dial("tcp", c.addr)
+	var conn net.Conn
+	var err error
+	if strings.HasPrefix(c.addr, "unix:") {
+		conn, err = net.Dial("unix", strings.TrimPrefix(c.addr, "unix:"))
+	} else if c.TLS != nil {
+		conn, err = tls.Dial("tcp", c.addr, c.TLS)
+	} else {
+		conn, err = net.Dial("tcp", c.addr)
+	}
 	if err != nil {
 		return err
 	}
 
+	if c.Permission, c.framing, err = c.authenticate(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
 	c.conn = conn
 	c.stop = make(chan struct{})
+	c.outbox = make(chan *hookexecutor.Message, DefaultClientOutboxSize)
 
 	go c.run()
 	return nil
 }
 
+// authenticate says hello to the executor and answers its challenge with the
+// token, asking for the large message framing and resuming after the last
+// event had.
+func (c *Client) authenticate(conn net.Conn) (hookexecutor.Permission, hookexecutor.Framing, error) {
+	hello := &hookexecutor.Message{&hookexecutor.IncomingEvent{"hello", map[string]string{
+		hookexecutor.FieldVersion: strconv.Itoa(hookexecutor.ProtocolVersion),
+	}}, -1}
+	if err := hookexecutor.WriteMessage(conn, hookexecutor.DefaultAuthTimeout, hello); err != nil {
+		return "", 0, err
+	}
+	msg, err := hookexecutor.ReadMessage(conn, hookexecutor.DefaultAuthTimeout)
+	if err != nil {
+		return "", 0, err
+	}
+	if msg.IncomingEvent != nil && msg.Type == hookexecutor.ErrorFrame {
+		return "", 0, fmt.Errorf("executor refused hello: %s", msg.Data["reason"])
+	}
+	if msg.IncomingEvent == nil || msg.Type != "hello" {
+		return "", 0, errors.New("no hello from executor")
+	}
+	if msg, err = hookexecutor.ReadMessage(conn, hookexecutor.DefaultAuthTimeout); err != nil {
+		return "", 0, err
+	}
+	if msg.IncomingEvent == nil || msg.Type != "challenge" {
+		return "", 0, errors.New("no challenge from executor")
+	}
+	auth := &hookexecutor.Message{&hookexecutor.IncomingEvent{"auth", map[string]string{
+		"hmac":                     hookexecutor.Sign(c.token, msg.Data["nonce"]),
+		hookexecutor.FieldFraming:  hookexecutor.Framing2.String(),
+		hookexecutor.FieldOverflow: string(c.Overflow),
+	}}, -1}
+	if c.last >= 0 {
+		auth.Data[hookexecutor.FieldResume] = strconv.Itoa(c.last)
+	}
+	if err := hookexecutor.WriteMessage(conn, hookexecutor.DefaultAuthTimeout, auth); err != nil {
+		return "", 0, err
+	}
+	if msg, err = hookexecutor.ReadMessage(conn, hookexecutor.DefaultAuthTimeout); err != nil {
+		return "", 0, err
+	}
+	if msg.IncomingEvent == nil || msg.Type != "auth-ok" {
+		return "", 0, errors.New("authentication refused")
+	}
+	return hookexecutor.Permission(msg.Data["permission"]), hookexecutor.ParseFraming(msg.Data[hookexecutor.FieldFraming]), nil
+}
+
+// Stop disconnects, Start connects again resuming where it stopped.
 func (c *Client) Stop() {
 	close(c.stop)
+	c.conn.Close()
+}
+
+// SendFile sends a file to the bot, which forwards it to the JID.
+func (c *Client) SendFile(sid, to, name, mime string, data []byte) {
+	for _, msg := range hookexecutor.FileMessages(sid, to, name, mime, data) {
+		select {
+		case c.outbox <- msg:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Subscribe asks the executor for the events of the types, in the rooms and
+// from the senders given only, empty lists take them all.
+func (c *Client) Subscribe(filter hookexecutor.Filter) {
+	msg := &hookexecutor.Message{&hookexecutor.IncomingEvent{hookexecutor.Subscribe, map[string]string{
+		"types":   strings.Join(filter.Types, ","),
+		"rooms":   strings.Join(filter.Rooms, ","),
+		"senders": strings.Join(filter.Senders, ","),
+	}}, -1}
+	select {
+	case c.outbox <- msg:
+	case <-c.stop:
+	}
+}
+
+// Request asks the executor, see hookexecutor.Request for the methods, and
+// returns the data of the response.
+func (c *Client) Request(method string, data map[string]string, timeout time.Duration) (map[string]string, error) {
+	c.mu.Lock()
+	c.ids++
+	id := c.ids
+	reply := make(chan *hookexecutor.Message, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	req := map[string]string{hookexecutor.FieldMethod: method}
+	for k, v := range data {
+		req[k] = v
+	}
+	select {
+	case c.outbox <- &hookexecutor.Message{&hookexecutor.IncomingEvent{hookexecutor.Request, req}, id}:
+	case <-c.stop:
+		return nil, errStopped
+	}
+	select {
+	case msg := <-reply:
+		if msg.Type == hookexecutor.ErrorFrame {
+			return nil, errors.New(msg.Data["reason"])
+		}
+		return msg.Data, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no response to %s request in %v", method, timeout)
+	case <-c.stop:
+		return nil, errStopped
+	}
+}
+
+// respond passes a response, or the error frame refusing a request, to the
+// request waiting for it.
+func (c *Client) respond(msg *hookexecutor.Message) {
+	id := msg.ID
+	if msg.Type == hookexecutor.ErrorFrame {
+		id, _ = strconv.Atoi(msg.Data["ref"])
+	}
+	c.mu.Lock()
+	reply, ok := c.pending[id]
+	c.mu.Unlock()
+	if ok {
+		select {
+		case reply <- msg:
+		default:
+		}
+	}
 }
 
 func (c *Client) Wait() {
@@ -80,10 +251,10 @@ func (c *Client) run() {
 	}()
 
 	inbox := make(chan *hookexecutor.Message, DefaultClientInboxSize)
-	outbox := make(chan *hookexecutor.Message, DefaultClientOutboxSize)
+	outbox := c.outbox
 	errors := make(chan error, 2)
-	go c.reader(inbox, errors, c.stop)
-	go c.writer(outbox, errors, c.stop)
+	go c.reader(c.conn, inbox, errors, c.stop)
+	go c.writer(c.conn, outbox, errors, c.stop)
 	go c.stopOnError(c.stop, errors)
 
 	for {
@@ -99,6 +270,34 @@ func (c *Client) run() {
 				continue
 			}
 
+			if msg.Type == hookexecutor.Response || msg.Type == hookexecutor.ErrorFrame && msg.Data["type"] == hookexecutor.Request {
+				c.respond(msg)
+				continue
+			}
+
+			if msg.Type == hookexecutor.ErrorFrame {
+				c.logger.Printf("%s message %s rejected: %s", msg.Data["type"], msg.Data["ref"], msg.Data["reason"])
+				continue
+			}
+
+			if msg.Type == hookexecutor.Subscribed {
+				c.logger.Printf("subscribed to types %q, rooms %q, senders %q", msg.Data["types"], msg.Data["rooms"], msg.Data["senders"])
+				continue
+			}
+
+			if msg.Type == "close" {
+				c.logger.Printf("closed by executor: %s", msg.Data["reason"])
+				return
+			}
+
+			if msg.Type == hookexecutor.Resumed {
+				c.logger.Printf("resumed after %d, %s events replayed, complete: %s", c.last, msg.Data["replayed"], msg.Data["complete"])
+				continue
+			}
+
+			if msg.ID >= 0 {
+				c.last = msg.ID
+			}
 			handlers := c.selectHandlers(msg)
 			if len(handlers) > 0 {
 				go c.executeHandlers(handlers, msg, outbox)
@@ -149,7 +348,7 @@ func (c *Client) executeHandlers(handlers []Handler, msg *hookexecutor.Message,
 	}
 }
 
-func (c *Client) reader(inbox chan *hookexecutor.Message, errors chan error, stop chan struct{}) {
+func (c *Client) reader(conn net.Conn, inbox chan *hookexecutor.Message, errors chan error, stop chan struct{}) {
 	defer func() {
 		if err := recover(); err != nil {
 			c.logger.Println("panic recovered in reader: %v", err)
@@ -158,7 +357,7 @@ func (c *Client) reader(inbox chan *hookexecutor.Message, errors chan error, sto
 	defer close(inbox)
 
 	for {
-		msg, err := hookexecutor.ReadMessage(c.conn, hookexecutor.DefaultHeartbeatTimeout)
+		msg, err := c.framing.Read(conn, hookexecutor.DefaultLivenessTimeout)
 		if err != nil {
 			c.logger.Printf("reader failed to read message: %v", err)
 			errors <- err
@@ -169,7 +368,7 @@ func (c *Client) reader(inbox chan *hookexecutor.Message, errors chan error, sto
 	}
 }
 
-func (c *Client) writer(outbox chan *hookexecutor.Message, errors chan error, stop chan struct{}) {
+func (c *Client) writer(conn net.Conn, outbox chan *hookexecutor.Message, errors chan error, stop chan struct{}) {
 	defer func() {
 		if err := recover(); err != nil {
 			c.logger.Println("panic recovered in writer: %v", err)
@@ -179,7 +378,7 @@ func (c *Client) writer(outbox chan *hookexecutor.Message, errors chan error, st
 	for {
 		select {
 		case msg := <-outbox:
-			err := hookexecutor.WriteMessage(c.conn, hookexecutor.DefaultHeartbeatTimeout, msg)
+			err := c.framing.Write(conn, hookexecutor.DefaultHeartbeatTimeout, msg)
 			if err != nil {
 				c.logger.Printf("writer failed to write message: %v", err)
 				errors <- err
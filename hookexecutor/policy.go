@@ -0,0 +1,97 @@
+package hookexecutor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ErrorFrame is the type of the message telling a client its message was
+// rejected, with the "reason", the "type" of the message and its id as "ref".
+const ErrorFrame = "error"
+
+var (
+	errReadOnly     = errors.New("the client may not send")
+	errPaused       = errors.New("hooks are paused")
+	errNoEvent      = errors.New("message without event")
+	errUnsupported  = errors.New("not supported by the bot")
+	errNoFile       = errors.New("no such file open")
+	errTooManyFiles = errors.New("too many files open")
+	errOffline      = errors.New("not connected")
+)
+
+// Policy limits what hooks may send, the zero Policy lets everything the
+// routing does through.
+type Policy struct {
+	// Destinations are the JIDs, or patterns like *@conference.example.org,
+	// anything sent must go to.
+	Destinations []string
+	// MaxLength is the longest a body may be in characters.
+	MaxLength int
+	// Types are the message types hooks may send, "file" stands for all the
+	// file ones.
+	Types []string
+}
+
+// PolicyError is why a message was refused by the policy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "policy: " + e.Reason
+}
+
+func (p *Policy) allowTo(to string) error {
+	if len(p.Destinations) == 0 {
+		return nil
+	}
+	for _, d := range p.Destinations {
+		if ok, _ := path.Match(d, to); ok {
+			return nil
+		}
+	}
+	return &PolicyError{fmt.Sprintf("messages to %q are not allowed", to)}
+}
+
+func (p *Policy) allowType(typ string) error {
+	if len(p.Types) == 0 {
+		return nil
+	}
+	switch typ {
+	case "file-open", "file-data", "file-close":
+		typ = "file"
+	}
+	for _, t := range p.Types {
+		if t == typ {
+			return nil
+		}
+	}
+	return &PolicyError{fmt.Sprintf("%q messages are not allowed", typ)}
+}
+
+// check applies what the policy can tell of the message alone, the
+// destinations are checked once routed.
+func (p *Policy) check(msg *Message) error {
+	if err := p.allowType(msg.Type); err != nil {
+		return err
+	}
+	if body := msg.Data["body"]; p.MaxLength > 0 && utf8.RuneCountInString(body) > p.MaxLength {
+		return &PolicyError{fmt.Sprintf("body longer than %d characters", p.MaxLength)}
+	}
+	return nil
+}
+
+// reject tells the client its message was refused, unless it is gone or
+// can't take more.
+func (exc *Executor) reject(msg *clientMessage, err error) {
+	var typ string
+	if msg.IncomingEvent != nil {
+		typ = msg.Type
+	}
+	exc.logger.Printf("rejecting %s message of client %d: %v", typ, msg.from.id, err)
+	frame := &Message{&IncomingEvent{ErrorFrame, map[string]string{"reason": err.Error(), "type": typ, "ref": strconv.Itoa(msg.ID)}}, -1}
+	exc.tell(msg.from, frame)
+}
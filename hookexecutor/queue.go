@@ -0,0 +1,203 @@
+package hookexecutor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Overflow is what the executor does with the events for a client whose
+// queue is full. Clients ask for one in FieldOverflow of their "auth", the
+// one agreed on is told in "auth-ok".
+type Overflow string
+
+const (
+	// OverflowDrop disconnects the client.
+	OverflowDrop Overflow = "drop"
+	// OverflowBlock waits up to DefaultBlockTimeout for the client to catch
+	// up, holding up the events of all the clients, then disconnects it.
+	OverflowBlock Overflow = "block"
+	// OverflowSpill writes the events to a file in Options.SpillDir and
+	// sends them once the client catches up, up to DefaultSpillCap.
+	OverflowSpill Overflow = "spill"
+)
+
+const (
+	FieldOverflow       = "overflow"
+	DefaultBlockTimeout = 2 * time.Second
+	DefaultSpillCap     = 100000
+)
+
+// overflow is the one of a client asking for ask, OverflowDrop unless known
+// and possible.
+func (exc *Executor) overflow(ask Overflow) Overflow {
+	if ask == "" {
+		ask = exc.opts.Overflow
+	}
+	switch ask {
+	case OverflowBlock:
+		return ask
+	case OverflowSpill:
+		if exc.opts.SpillDir != "" {
+			return ask
+		}
+	}
+	return OverflowDrop
+}
+
+// spill keeps the events of a client in a file of JSON lines until sent.
+type spill struct {
+	path string
+	w    *os.File
+	rf   *os.File
+	r    *bufio.Reader
+	// n is how many events are in the file, next the one read but not sent
+	n    int
+	next *Message
+}
+
+func openSpill(dir string, id int) (*spill, error) {
+	path := filepath.Join(dir, fmt.Sprintf("hook-%d-%d.spill", os.Getpid(), id))
+	w, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &spill{path: path, w: w, rf: rf, r: bufio.NewReader(rf)}, nil
+}
+
+func (s *spill) push(msg *Message) error {
+	m := jsonMessage{ID: msg.ID}
+	if msg.IncomingEvent != nil {
+		m.Type, m.Data = msg.Type, msg.Data
+	}
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(line, '\n')); err == nil {
+		s.n++
+	}
+	return err
+}
+
+// drain sends the events spilled to the inbox while it has room, the file is
+// emptied once they are all sent.
+func (s *spill) drain(inbox chan *Message) error {
+	for s.n > 0 {
+		if s.next == nil {
+			line, err := s.r.ReadBytes('\n')
+			if err != nil {
+				return err
+			}
+			var m jsonMessage
+			if err := json.Unmarshal(line, &m); err != nil {
+				return err
+			}
+			s.next = &Message{&IncomingEvent{m.Type, m.Data}, m.ID}
+		}
+		select {
+		case inbox <- s.next:
+			s.next = nil
+			s.n--
+		default:
+			return nil
+		}
+	}
+	if err := s.w.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.w.Seek(0, 0); err != nil {
+		return err
+	}
+	_, err := s.rf.Seek(0, 0)
+	s.r.Reset(s.rf)
+	return err
+}
+
+func (s *spill) close() {
+	s.w.Close()
+	s.rf.Close()
+	os.Remove(s.path)
+}
+
+// enqueue passes an event to a client by its overflow, false when the client
+// is to be dropped.
+func (exc *Executor) enqueue(c *clientInfo, msg *Message) bool {
+	select {
+	case <-c.stop:
+		return false
+	default:
+	}
+	if c.spill != nil && c.spill.n > 0 {
+		if err := c.spill.drain(c.inbox); err != nil {
+			exc.logger.Printf("failed to read spilled events of client %d: %v", c.id, err)
+			return false
+		}
+		if c.spill.n > 0 {
+			return exc.spillTo(c, msg)
+		}
+	}
+	select {
+	case c.inbox <- msg:
+		return true
+	default:
+	}
+	switch c.overflow {
+	case OverflowBlock:
+		t := time.NewTimer(DefaultBlockTimeout)
+		defer t.Stop()
+		select {
+		case c.inbox <- msg:
+			return true
+		case <-c.stop:
+		case <-t.C:
+		}
+	case OverflowSpill:
+		return exc.spillTo(c, msg)
+	}
+	return false
+}
+
+func (exc *Executor) spillTo(c *clientInfo, msg *Message) bool {
+	if c.spill == nil {
+		s, err := openSpill(exc.opts.SpillDir, c.id)
+		if err != nil {
+			exc.logger.Printf("failed to spill events of client %d: %v", c.id, err)
+			return false
+		}
+		c.spill = s
+	}
+	if c.spill.n >= DefaultSpillCap {
+		exc.logger.Printf("client %d has %d events spilled", c.id, c.spill.n)
+		return false
+	}
+	if err := c.spill.push(msg); err != nil {
+		exc.logger.Printf("failed to spill events of client %d: %v", c.id, err)
+		return false
+	}
+	return true
+}
+
+// drainSpills sends on the events spilled as the clients take events.
+func (exc *Executor) drainSpills() {
+	var dead []int
+	for idx, c := range exc.clients {
+		if c.spill == nil || c.spill.n == 0 {
+			continue
+		}
+		if err := c.spill.drain(c.inbox); err != nil {
+			exc.logger.Printf("failed to read spilled events of client %d: %v", c.id, err)
+			dead = append(dead, idx)
+		}
+	}
+	exc.dropClients(dead)
+}
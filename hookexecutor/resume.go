@@ -0,0 +1,47 @@
+package hookexecutor
+
+import "strconv"
+
+// FieldResume is the field of "auth" a reconnecting client tells the id of
+// the last event it had in. The events after it still kept are sent again,
+// after a Resumed telling how many and whether any are missing.
+const (
+	FieldResume = "resume"
+	Resumed     = "resumed"
+)
+
+// DefaultReplayCap is how many of the last events are kept for the clients
+// resuming.
+const DefaultReplayCap = 1024
+
+// remember keeps an event sent for the clients resuming.
+func (exc *Executor) remember(msg *Message) {
+	if len(exc.recent) == DefaultReplayCap {
+		exc.recent = exc.recent[1:]
+	}
+	exc.recent = append(exc.recent, msg)
+}
+
+// replay sends a client resuming the events after last. Ids count from zero
+// again when the bot restarts, a last not sent yet tells nothing.
+func (exc *Executor) replay(c *clientInfo, last int) {
+	var events []*Message
+	for _, msg := range exc.recent {
+		if msg.ID > last {
+			events = append(events, msg)
+		}
+	}
+	complete := last < exc.counter && (len(exc.recent) == 0 || exc.recent[0].ID <= last+1)
+	ok := exc.enqueue(c, &Message{&IncomingEvent{Resumed, map[string]string{
+		"replayed": strconv.Itoa(len(events)),
+		"complete": strconv.FormatBool(complete),
+	}}, -1})
+	for _, msg := range events {
+		if ok {
+			ok = exc.enqueue(c, msg)
+		}
+	}
+	if !ok {
+		exc.dropClients([]int{len(exc.clients) - 1})
+	}
+}
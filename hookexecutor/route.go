@@ -0,0 +1,59 @@
+package hookexecutor
+
+import (
+	"fmt"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+// The fields of a "message" routing it: "to" is a room or the bare JID of a
+// contact, the first room when empty, and "kind" is "groupchat" or "chat",
+// told by the destination when empty.
+const (
+	FieldTo   = "to"
+	FieldKind = "kind"
+)
+
+// RouteError is why a message of a hook can't go where it asks to.
+type RouteError struct {
+	To, Kind string
+}
+
+func (e *RouteError) Error() string {
+	if e.Kind != "" {
+		return fmt.Sprintf("%q messages to %s are not allowed", e.Kind, e.To)
+	}
+	return fmt.Sprintf("messages to %q are not allowed", e.To)
+}
+
+func allowed(list []string, to jid.JID) bool {
+	for _, a := range list {
+		if j, err := jid.Parse(a); err == nil && j.BareEqual(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// route tells where a message of a hook goes and how, the destinations are
+// the Rooms and Contacts only.
+func (exc *Executor) route(data map[string]string) (to jid.JID, kind string, err error) {
+	addr, kind := data[FieldTo], data[FieldKind]
+	if addr == "" {
+		if len(exc.Rooms) == 0 {
+			return jid.JID{}, "", &RouteError{}
+		}
+		addr = exc.Rooms[0]
+	}
+	if to, err = jid.Parse(addr); err != nil || !to.IsBare() {
+		return jid.JID{}, "", &RouteError{To: addr}
+	}
+	switch {
+	case allowed(exc.Rooms, to) && (kind == "" || kind == stanza.GROUPCHAT):
+		return to, stanza.GROUPCHAT, nil
+	case allowed(exc.Contacts, to) && (kind == "" || kind == stanza.CHAT):
+		return to, stanza.CHAT, nil
+	}
+	return jid.JID{}, "", &RouteError{To: addr, Kind: kind}
+}
@@ -0,0 +1,86 @@
+package hookexecutor
+
+import (
+	"testing"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestRouteAll(t *testing.T) {
+	exc := NewExecutor(streamtest.New("example.org"), Options{})
+	exc.Rooms = []string{"room@conference.example.org"}
+	exc.Contacts = []string{"admin@example.org"}
+	var sent []string
+	exc.React = func(to, id, reaction string) error {
+		sent = append(sent, "reaction "+to)
+		return nil
+	}
+	exc.Attention = func(to, body string) error {
+		sent = append(sent, "attention "+to)
+		return nil
+	}
+	exc.File = func(to, name, mime string, data []byte) error { return nil }
+	for _, tc := range []struct {
+		typ, to string
+		ok      bool
+	}{
+		{"reaction", "room@conference.example.org", true},
+		{"reaction", "", true},
+		{"reaction", "stranger@example.org", false},
+		{"reaction", "room@conference.example.org/nick", false},
+		{"attention", "room@conference.example.org/nick", true},
+		{"attention", "Admin@example.org/phone", true},
+		{"attention", "stranger@example.org/phone", false},
+		{"attention", "@example.org", false},
+		{"file-open", "admin@example.org/phone", true},
+		{"file-open", "elsewhere@conference.example.org/nick", false},
+	} {
+		data := map[string]string{"to": tc.to, "sid": "s1", "id": "m1", "reaction": "+1"}
+		if tc.to == "" {
+			delete(data, "to")
+		}
+		err := exc.SendMessageToBot(&Message{&IncomingEvent{tc.typ, data}, -1})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s to %q: %v", tc.typ, tc.to, err)
+		}
+	}
+	want := []string{"reaction room@conference.example.org", "reaction room@conference.example.org",
+		"attention room@conference.example.org/nick", "attention admin@example.org/phone"}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %q", sent)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("sent %q, want %q", sent[i], want[i])
+		}
+	}
+	if f := exc.files[fileKey{0, "s1"}]; f == nil || f.to != "admin@example.org/phone" {
+		t.Fatalf("file opened %+v", f)
+	}
+}
+
+func TestRoutePolicy(t *testing.T) {
+	exc := NewExecutor(streamtest.New("example.org"), Options{})
+	exc.Rooms = []string{"room@conference.example.org", "other@chat.example.org"}
+	exc.Contacts = []string{"admin@example.org", "friend@example.org"}
+	exc.Policy.Destinations = []string{"*@conference.example.org", "admin@example.org", "friend@example.org/laptop"}
+	exc.React = func(to, id, reaction string) error { return nil }
+	exc.Attention = func(to, body string) error { return nil }
+	for _, tc := range []struct {
+		typ, to string
+		ok      bool
+	}{
+		{"reaction", "room@conference.example.org", true},
+		{"reaction", "other@chat.example.org", false},
+		{"attention", "room@conference.example.org/nick", true},
+		{"attention", "admin@example.org/phone", true},
+		{"attention", "friend@example.org/laptop", true},
+		{"attention", "friend@example.org/phone", false},
+		{"attention", "other@chat.example.org/nick", false},
+	} {
+		err := exc.SendMessageToBot(&Message{&IncomingEvent{tc.typ, map[string]string{"to": tc.to, "id": "m1"}}, -1})
+		if _, refused := err.(*PolicyError); refused == tc.ok || (tc.ok && err != nil) {
+			t.Errorf("%s to %s: %v", tc.typ, tc.to, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package hookexecutor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+)
+
+// Request is the type of a message asking the executor something, with the
+// "method" in FieldMethod:
+//
+//	send       sends "body" like a "message" and tells the "id" it was sent with
+//	occupants  tells the "occupants" of "room", nicks comma separated
+//	stats      tells the stats of "user" in "room"
+//
+// The room is the first of Rooms when empty. A request is answered with a
+// Response carrying its id, or an error frame referring to it.
+const (
+	Request     = "request"
+	Response    = "response"
+	FieldMethod = "method"
+)
+
+// RequestVersion is the protocol version clients may make requests from.
+const RequestVersion = 3
+
+var (
+	errRequest = errors.New("requests need protocol version 3")
+	errNoRoom  = errors.New("no such room")
+)
+
+type response struct {
+	to   *clientMessage
+	data map[string]string
+	err  error
+}
+
+// request answers a request, the ones asking the bot are answered once it
+// replies through the responses.
+func (exc *Executor) request(msg *clientMessage) {
+	if msg.from.version < RequestVersion {
+		exc.reject(msg, errRequest)
+		return
+	}
+	method := msg.Data[FieldMethod]
+	switch method {
+	case "send":
+		m := &Message{&IncomingEvent{"message", msg.Data}, msg.ID}
+		var id string
+		var err error
+		switch {
+		case msg.from.perm != PermSend:
+			err = errReadOnly
+		case exc.paused:
+			err = errPaused
+		default:
+			if err = exc.Policy.check(m); err == nil {
+				id, err = exc.send(m.Data)
+			}
+		}
+		exc.respond(&response{msg, map[string]string{"id": id}, err})
+	case "occupants", "stats":
+		room, err := exc.room(msg.Data["room"])
+		if err != nil {
+			exc.respond(&response{msg, nil, err})
+			return
+		}
+		user := msg.Data["user"]
+		go func() {
+			defer stopPanic(exc, "request", nil)
+			r := &response{to: msg, err: errUnsupported}
+			switch {
+			case method == "occupants" && exc.Occupants != nil:
+				r.data, r.err = map[string]string{"occupants": strings.Join(exc.Occupants(room), ",")}, nil
+			case method == "stats" && exc.Stats != nil:
+				r.data, r.err = exc.Stats(room, user)
+			}
+			select {
+			case exc.responses <- r:
+			case <-exc.life.finished:
+			}
+		}()
+	default:
+		exc.respond(&response{msg, nil, fmt.Errorf("unknown method %q", method)})
+	}
+}
+
+// room is the one of Rooms asked about.
+func (exc *Executor) room(addr string) (string, error) {
+	if addr == "" && len(exc.Rooms) > 0 {
+		return exc.Rooms[0], nil
+	}
+	if j, err := jid.Parse(addr); err == nil {
+		for _, r := range exc.Rooms {
+			if allowed([]string{r}, j) {
+				return r, nil
+			}
+		}
+	}
+	return "", errNoRoom
+}
+
+func (exc *Executor) respond(r *response) {
+	if r.err != nil {
+		exc.reject(r.to, r.err)
+		return
+	}
+	data := map[string]string{FieldMethod: r.to.Data[FieldMethod]}
+	for k, v := range r.data {
+		data[k] = v
+	}
+	exc.tell(r.to.from, &Message{&IncomingEvent{Response, data}, r.to.ID})
+}
@@ -0,0 +1,38 @@
+package hookexecutor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestRequestSendPaused(t *testing.T) {
+	st := streamtest.New("example.org")
+	exc := NewExecutor(st, Options{})
+	exc.Rooms = []string{"room@conference.example.org"}
+	exc.paused = true
+	c := &clientInfo{id: 1, perm: PermSend, version: RequestVersion, inbox: make(chan *Message, 1), stop: make(chan struct{})}
+	exc.request(&clientMessage{&Message{&IncomingEvent{Request, map[string]string{FieldMethod: "send", "body": "hi"}}, 7}, c})
+	if len(st.Written()) != 0 {
+		t.Fatalf("sent %s while paused", st.Written()[0])
+	}
+	if m := <-c.inbox; m.Type != ErrorFrame || m.Data["reason"] != errPaused.Error() {
+		t.Fatalf("answered %+v", m.IncomingEvent)
+	}
+}
+
+func TestRequestSend(t *testing.T) {
+	st := streamtest.New("example.org")
+	exc := NewExecutor(st, Options{})
+	exc.Rooms = []string{"room@conference.example.org"}
+	c := &clientInfo{id: 1, perm: PermSend, version: RequestVersion, inbox: make(chan *Message, 1), stop: make(chan struct{})}
+	exc.request(&clientMessage{&Message{&IncomingEvent{Request, map[string]string{FieldMethod: "send", "body": "hi"}}, 7}, c})
+	m := <-c.inbox
+	if m.Type != Response || m.ID != 7 || m.Data["id"] == "" {
+		t.Fatalf("answered %+v", m)
+	}
+	if w := st.Written(); len(w) != 1 || !strings.Contains(string(w[0]), `id="`+m.Data["id"]+`"`) {
+		t.Fatalf("sent %q, answered the id %q", w, m.Data["id"])
+	}
+}
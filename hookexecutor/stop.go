@@ -0,0 +1,165 @@
+package hookexecutor
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout is how long Stop is usually given to flush the clients.
+const DefaultStopTimeout = 5 * time.Second
+
+// lifecycle is what Stop needs to shut the executor down.
+type lifecycle struct {
+	sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]bool
+	writers   sync.WaitGroup
+	stopped   bool
+	ctx       context.Context
+	// done is closed when Stop is called, finished once the event loop is
+	// through with the clients
+	done, finished chan struct{}
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{conns: make(map[net.Conn]bool), done: make(chan struct{}), finished: make(chan struct{})}
+}
+
+// listen keeps a listener for Stop to close, false when stopped already.
+func (l *lifecycle) listen(listener net.Listener) bool {
+	l.Lock()
+	defer l.Unlock()
+	if !l.stopped {
+		l.listeners = append(l.listeners, listener)
+	}
+	return !l.stopped
+}
+
+// connect keeps a connection for Stop to close when out of time, with the
+// writer of the connection done once it is closed.
+func (l *lifecycle) connect(conn net.Conn) bool {
+	l.Lock()
+	defer l.Unlock()
+	if !l.stopped {
+		l.conns[conn] = true
+		l.writers.Add(1)
+	}
+	return !l.stopped
+}
+
+// work keeps Stop waiting for a worker other than the writers until it is
+// done with them, false when stopped already.
+func (l *lifecycle) work() bool {
+	l.Lock()
+	defer l.Unlock()
+	if !l.stopped {
+		l.writers.Add(1)
+	}
+	return !l.stopped
+}
+
+// expired tells whether Stop ran out of time.
+func (l *lifecycle) expired() bool {
+	select {
+	case <-l.done:
+		return l.ctx.Err() != nil
+	default:
+		return false
+	}
+}
+
+// sleep waits for d, false when Stop runs out of time first.
+func (l *lifecycle) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-l.done:
+	}
+	select {
+	case <-t.C:
+		return true
+	case <-l.ctx.Done():
+		return false
+	}
+}
+
+func (l *lifecycle) disconnect(conn net.Conn) {
+	l.Lock()
+	delete(l.conns, conn)
+	l.Unlock()
+	l.writers.Done()
+}
+
+// Stop shuts the executor down: it stops accepting clients, sends the events
+// queued and spilled and a "close" with CloseShutdown, and returns once the
+// clients are disconnected and the webhooks have the events. When ctx is
+// done first they are disconnected at once and its error is returned.
+func (exc *Executor) Stop(ctx context.Context) error {
+	l := exc.life
+	l.Lock()
+	if l.stopped {
+		l.Unlock()
+		return nil
+	}
+	l.stopped, l.ctx = true, ctx
+	close(l.done)
+	for _, listener := range l.listeners {
+		listener.Close()
+	}
+	l.Unlock()
+
+	disconnected := make(chan struct{})
+	go func() {
+		<-l.finished
+		l.writers.Wait()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+		return nil
+	case <-ctx.Done():
+	}
+	l.Lock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.Unlock()
+	return ctx.Err()
+}
+
+// flushed tells whether the clients still there have all the events spilled
+// for them.
+func (exc *Executor) flushed() bool {
+	for _, c := range exc.clients {
+		select {
+		case <-c.stop:
+			continue
+		default:
+		}
+		if c.spill != nil && c.spill.n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// finish says goodbye to the clients, waiting for those slow to take it until
+// the deadline.
+func (exc *Executor) finish(deadline <-chan struct{}) {
+	bye := &Message{&IncomingEvent{"close", map[string]string{"reason": CloseShutdown}}, -1}
+	for _, c := range exc.clients {
+		select {
+		case c.inbox <- bye:
+		case <-c.stop:
+		case <-deadline:
+		}
+		c.close()
+	}
+	exc.clients = nil
+	exc.closeWebhooks()
+	close(exc.life.finished)
+}
@@ -0,0 +1,112 @@
+package hookexecutor
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the newest version of the hook protocol, and
+	// MinProtocolVersion the oldest one still spoken. Clients that don't say
+	// hello speak MinProtocolVersion. Version 2 adds Subscribe, 3 Request.
+	ProtocolVersion    = 3
+	MinProtocolVersion = 1
+	// DefaultHelloWait is how long the executor waits for the "hello" of a
+	// client before it challenges the client.
+	DefaultHelloWait = 200 * time.Millisecond
+)
+
+// Fields of "hello": the "version" a client speaks and the "encoding" it
+// comes in, "msgpack" or "json" for FramingJSON. The executor answers with
+// the "version" agreed on, and the "versions" it speaks in the error frame
+// refusing one.
+const (
+	FieldVersion  = "version"
+	FieldVersions = "versions"
+	FieldEncoding = "encoding"
+)
+
+var errHello = errors.New("bad hello")
+
+// VersionError is a version of the protocol the executor doesn't speak.
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported protocol version %q, speaking %s", e.Version, versions())
+}
+
+func versions() string {
+	return fmt.Sprintf("%d-%d", MinProtocolVersion, ProtocolVersion)
+}
+
+// hello opens the conversation with a new client. A client says "hello"
+// first thing, in a line of JSON or in Framing1, with the version it speaks
+// and is answered with a "hello" before the challenge; one waiting to be
+// challenged for DefaultHelloWait is taken for a client of
+// MinProtocolVersion. A hello that can't be read or has an unknown version is
+// answered with an error frame.
+func hello(c *bufConn) (Framing, int, error) {
+	// the handshake goes first, it must not be cut short by the wait below
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(DefaultAuthTimeout))
+		if err := tc.Handshake(); err != nil {
+			return Framing1, 0, err
+		}
+		tc.SetDeadline(time.Time{})
+	}
+	c.SetReadDeadline(time.Now().Add(DefaultHelloWait))
+	b, err := c.r.Peek(1)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return Framing1, MinProtocolVersion, nil
+		}
+		return Framing1, 0, err
+	}
+	framing := Framing1
+	if b[0] == '{' {
+		framing = FramingJSON
+	}
+	var version int
+	msg, err := framing.Read(c, DefaultAuthTimeout)
+	if err == nil {
+		version, err = checkHello(msg, framing)
+	}
+	if err != nil {
+		frame := &Message{&IncomingEvent{ErrorFrame, map[string]string{"reason": err.Error(), "type": "hello", "ref": "-1", FieldVersions: versions()}}, -1}
+		framing.Write(c, DefaultAuthTimeout, frame)
+		return framing, 0, err
+	}
+	ok := &Message{&IncomingEvent{"hello", map[string]string{FieldVersion: strconv.Itoa(version)}}, -1}
+	return framing, version, framing.Write(c, DefaultAuthTimeout, ok)
+}
+
+// checkHello reads the version of a hello, the encoding it tells must be the
+// one it came in.
+func checkHello(msg *Message, framing Framing) (int, error) {
+	if msg.IncomingEvent == nil || msg.Type != "hello" {
+		return 0, errHello
+	}
+	enc, want := msg.Data[FieldEncoding], "msgpack"
+	if framing == FramingJSON {
+		want = "json"
+	}
+	if enc != "" && enc != want {
+		return 0, fmt.Errorf("%s hello asking for encoding %q", want, enc)
+	}
+	v, ok := msg.Data[FieldVersion]
+	if !ok {
+		return MinProtocolVersion, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < MinProtocolVersion || n > ProtocolVersion {
+		return 0, &VersionError{v}
+	}
+	return n, nil
+}
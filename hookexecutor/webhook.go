@@ -0,0 +1,180 @@
+package hookexecutor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultWebhookQueue   = 256
+	DefaultWebhookTimeout = 10 * time.Second
+	DefaultWebhookRetries = 5
+	DefaultWebhookBackoff = time.Second
+)
+
+// The headers of the events POSTed to webhooks. The signature is
+// "sha256=" and the HMAC-SHA256 of the body keyed with the secret.
+const (
+	HeaderEvent     = "X-Xep-Event"
+	HeaderDelivery  = "X-Xep-Delivery"
+	HeaderSignature = "X-Xep-Signature"
+)
+
+// Webhook is a URL the events are POSTed to as JSON like those of
+// FramingJSON, for consumers that can't stay connected. The ones matching
+// the Filter are, signed with the Secret when set. A delivery failing is
+// retried up to DefaultWebhookRetries times, backing off from
+// DefaultWebhookBackoff; the events are dropped once DefaultWebhookQueue
+// are waiting.
+type Webhook struct {
+	URL    string
+	Secret string
+	Filter Filter
+}
+
+// ParseWebhooks reads space separated webhook URLs, the secret and the
+// filter in the fragment, which is never sent:
+//
+//	https://ci.example.org/xep#secret=s&types=message,reaction&rooms=room@service
+func ParseWebhooks(s string) ([]Webhook, error) {
+	var ret []Webhook
+	for _, f := range strings.Fields(s) {
+		u, err := url.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("webhook %s is not http", f)
+		}
+		q, err := url.ParseQuery(u.Fragment)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %v", f, err)
+		}
+		u.Fragment = ""
+		ret = append(ret, Webhook{u.String(), q.Get("secret"), ParseFilter(map[string]string{
+			"types":   q.Get("types"),
+			"rooms":   q.Get("rooms"),
+			"senders": q.Get("senders"),
+		})})
+	}
+	return ret, nil
+}
+
+type webhook struct {
+	Webhook
+	queue chan *Message
+	// delivered and failed count the events, by the deliverer
+	delivered, failed int64
+}
+
+var webhookClient = &http.Client{Timeout: DefaultWebhookTimeout}
+
+// startWebhooks starts delivering to the webhooks of the options, Stop waits
+// for the events queued to be delivered.
+func (exc *Executor) startWebhooks() {
+	for _, w := range exc.opts.Webhooks {
+		if !exc.life.work() {
+			return
+		}
+		h := &webhook{Webhook: w, queue: make(chan *Message, DefaultWebhookQueue)}
+		exc.webhooks = append(exc.webhooks, h)
+		go exc.deliver(h)
+	}
+}
+
+// push queues an event for the webhooks wanting it.
+func (exc *Executor) push(msg *Message) {
+	for _, h := range exc.webhooks {
+		if !h.Filter.match(msg.IncomingEvent) {
+			continue
+		}
+		select {
+		case h.queue <- msg:
+		default:
+			atomic.AddInt64(&h.failed, 1)
+			exc.logger.Printf("webhook %s is behind, dropping event %d", h.URL, msg.ID)
+		}
+	}
+}
+
+// deliver posts the events to a webhook until its queue is closed, once
+// Stop runs out of time those left are dropped.
+func (exc *Executor) deliver(h *webhook) {
+	defer exc.life.writers.Done()
+
+	for msg := range h.queue {
+		if exc.life.expired() {
+			atomic.AddInt64(&h.failed, 1)
+			continue
+		}
+		if err := exc.post(h, msg); err != nil {
+			atomic.AddInt64(&h.failed, 1)
+			exc.logger.Printf("failed to deliver event %d to webhook %s: %v", msg.ID, h.URL, err)
+		} else {
+			atomic.AddInt64(&h.delivered, 1)
+		}
+	}
+}
+
+func (exc *Executor) post(h *webhook, msg *Message) (err error) {
+	defer stopPanic(exc, "webhook", nil)
+
+	m := jsonMessage{ID: msg.ID}
+	if msg.IncomingEvent != nil {
+		m.Type, m.Data = msg.Type, msg.Data
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	backoff := DefaultWebhookBackoff
+	for try := 0; ; try++ {
+		var retry bool
+		if retry, err = h.send(body, m.Type, msg.ID); err == nil || !retry || try == DefaultWebhookRetries {
+			return err
+		}
+		if !exc.life.sleep(backoff) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// send posts the event once, telling whether a failure is worth a retry.
+func (h *webhook) send(body []byte, typ string, id int) (bool, error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "xep-hooks")
+	req.Header.Set(HeaderEvent, typ)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(id))
+	if h.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(h.Secret, string(body)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook answered %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// closeWebhooks lets the deliverers finish once the events are all queued.
+func (exc *Executor) closeWebhooks() {
+	for _, h := range exc.webhooks {
+		close(h.queue)
+	}
+}
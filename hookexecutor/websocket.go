@@ -0,0 +1,261 @@
+package hookexecutor
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWebSocketPath is where ListenAndServeWebSocket serves the clients.
+const DefaultWebSocketPath = "/hooks"
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMessageCap is the largest message taken, with room for the frame headers
+// of Framing2.
+const wsMessageCap = 2 * DefaultLargeMessageCap
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWebSocket = errors.New("bad websocket frame")
+
+// wsConn carries the hook protocol in WebSocket messages, a message a write:
+// the frames of msgpack in binary ones and the lines of FramingJSON in text
+// ones, as the client sends them. The messages read are joined into a stream,
+// a newline is added to the text ones lacking it.
+type wsConn struct {
+	net.Conn
+	r   *bufio.Reader
+	buf []byte
+	wmu sync.Mutex
+	// text is set once the client sends a text message
+	text int32
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		op, payload, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if op == wsText {
+			atomic.StoreInt32(&c.text, 1)
+			if len(payload) > 0 && payload[len(payload)-1] != '\n' {
+				payload = append(payload, '\n')
+			}
+		}
+		c.buf = payload
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readMessage reads the frames of a data message, answering the control
+// frames in between.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var op byte
+	var msg []byte
+	for {
+		fin, code, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch code {
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return 0, nil, io.EOF
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, errWebSocket
+			}
+			op = code
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, errWebSocket
+			}
+		default:
+			return 0, nil, errWebSocket
+		}
+		if len(msg)+len(payload) > wsMessageCap {
+			return 0, nil, errTooLong
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.r, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[1]&0x80 == 0 {
+		// clients must mask what they send
+		return false, 0, nil, errWebSocket
+	}
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > wsMessageCap {
+		return false, 0, nil, errTooLong
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		header = append(header, b[:]...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(append(header, payload...))
+	return err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	op := byte(wsBinary)
+	if atomic.LoadInt32(&c.text) != 0 {
+		op = wsText
+	}
+	if err := c.writeFrame(op, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, nil)
+	return c.Conn.Close()
+}
+
+// ServeWebSocket upgrades a request to a WebSocket and serves the hook
+// protocol on it, for mounting on an HTTP server. The tokens are all the
+// authentication there is, requests of any origin are taken.
+func (exc *Executor) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "websocket only", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	if len(exc.Tokens) == 0 {
+		http.Error(w, "hooks are disabled", http.StatusServiceUnavailable)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		exc.logger.Printf("failed to upgrade to websocket: %v", err)
+		return
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	exc.serve(&wsConn{Conn: conn, r: rw.Reader})
+}
+
+// ListenAndServeWebSocket serves the hooks over WebSocket at
+// DefaultWebSocketPath on addr, over TLS as the others, and takes the events
+// POSTed to DefaultEventsPath.
+func (exc *Executor) ListenAndServeWebSocket(addr string) {
+	defer stopPanic(exc, "websocket listener", nil)
+
+	if len(exc.Tokens) == 0 {
+		exc.logger.Printf("no tokens, hooker disabled")
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		exc.logger.Printf("failed to start websocket listener: %v", err)
+		return
+	}
+	if !exc.life.listen(listener) {
+		listener.Close()
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DefaultWebSocketPath, exc.ServeWebSocket)
+	mux.HandleFunc(DefaultEventsPath, exc.ServeEvents)
+	// the WebSocket needs HTTP/1.1 to be hijacked
+	srv := &http.Server{Handler: mux, TLSConfig: exc.opts.TLS, TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){}}
+	if exc.opts.TLS != nil {
+		err = srv.ServeTLS(listener, "", "")
+	} else {
+		err = srv.Serve(listener)
+	}
+	select {
+	case <-exc.life.done:
+	default:
+		exc.logger.Printf("websocket listener stopped: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package ibb implements XEP-0047 in-band bytestreams, the data is sent in
+// base64 blocks carried by IQs.
+package ibb
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns = "http://jabber.org/protocol/ibb"
+
+	DefaultBlockSize = 4096
+	// MaxBlockSize is the largest block size accepted.
+	MaxBlockSize = 65535
+)
+
+var ErrTooLarge = errors.New("ibb: stream too large")
+
+type open struct {
+	XMLName   xml.Name `xml:"http://jabber.org/protocol/ibb open"`
+	BlockSize int      `xml:"block-size,attr"`
+	SID       string   `xml:"sid,attr"`
+	Stanza    string   `xml:"stanza,attr,omitempty"`
+}
+
+type data struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/ibb data"`
+	Seq     uint16   `xml:"seq,attr"`
+	SID     string   `xml:"sid,attr"`
+	Data    string   `xml:",chardata"`
+}
+
+type closeStream struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/ibb close"`
+	SID     string   `xml:"sid,attr"`
+}
+
+// Send opens a bytestream with the sid to the full JID and sends r over it,
+// the stream is closed when r is drained.
+func Send(d *dispatch.Dispatcher, to jid.JID, sid string, r io.Reader, blockSize int) error {
+	if blockSize <= 0 || blockSize > MaxBlockSize {
+		blockSize = DefaultBlockSize
+	}
+	iq, _ := stanza.NewIQ(stanza.SET, to, &open{BlockSize: blockSize, SID: sid, Stanza: "iq"})
+	if _, err := d.Request(iq); err != nil {
+		return err
+	}
+	buf := make([]byte, blockSize)
+	for seq := uint16(0); ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			iq, _ := stanza.NewIQ(stanza.SET, to, &data{Seq: seq, SID: sid, Data: base64.StdEncoding.EncodeToString(buf[:n])})
+			if _, err := d.Request(iq); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			Close(d, to, sid)
+			return err
+		}
+	}
+	return Close(d, to, sid)
+}
+
+// Close closes the bytestream.
+func Close(d *dispatch.Dispatcher, to jid.JID, sid string) error {
+	iq, _ := stanza.NewIQ(stanza.SET, to, &closeStream{SID: sid})
+	_, err := d.Request(iq)
+	return err
+}
+
+type stream struct {
+	w         io.WriteCloser
+	blockSize int
+	seq       uint16
+	n         int64
+}
+
+// Receiver takes the bytestreams opened to the bot.
+type Receiver struct {
+	// Accept returns where the data of a stream being opened goes, nil
+	// declines the stream.
+	Accept func(from, sid string) io.WriteCloser
+	// Done is called once a stream is closed, err is set when it failed; it
+	// runs on the dispatching so it mustn't block.
+	Done func(from, sid string, n int64, err error)
+	// MaxSize bounds the bytes of a stream, unbounded when zero.
+	MaxSize int64
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+func key(from jid.JID, sid string) string {
+	return from.String() + " " + sid
+}
+
+// end closes the writer and returns the call of Done, made once the lock is
+// released.
+func (r *Receiver) end(from jid.JID, sid string, s *stream, err error) func() {
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	return func() {
+		if r.Done != nil {
+			r.Done(from.String(), sid, s.n, err)
+		}
+	}
+}
+
+// Handler answers the open, data and close requests.
+func (r *Receiver) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.SET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		var done func()
+		defer func() {
+			if done != nil {
+				done()
+			}
+		}()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.streams == nil {
+			r.streams = make(map[string]*stream)
+		}
+		switch iq.PayloadName().Local {
+		case "open":
+			o := &open{}
+			if err := iq.Decode(o); err != nil || o.SID == "" || o.BlockSize <= 0 {
+				d.Send(iq.ErrorReply("modify", "bad-request"))
+				return true
+			}
+			if o.Stanza == "message" {
+				d.Send(iq.ErrorReply("cancel", "feature-not-implemented"))
+				return true
+			}
+			if o.BlockSize > MaxBlockSize {
+				d.Send(iq.ErrorReply("modify", "resource-constraint"))
+				return true
+			}
+			if _, ok := r.streams[key(h.From, o.SID)]; ok {
+				d.Send(iq.ErrorReply("cancel", "conflict"))
+				return true
+			}
+			var w io.WriteCloser
+			if r.Accept != nil {
+				w = r.Accept(h.From.String(), o.SID)
+			}
+			if w == nil {
+				d.Send(iq.ErrorReply("cancel", "not-acceptable"))
+				return true
+			}
+			r.streams[key(h.From, o.SID)] = &stream{w: w, blockSize: o.BlockSize}
+		case "data":
+			x := &data{}
+			if err := iq.Decode(x); err != nil {
+				d.Send(iq.ErrorReply("modify", "bad-request"))
+				return true
+			}
+			s, ok := r.streams[key(h.From, x.SID)]
+			if !ok {
+				d.Send(iq.ErrorReply("cancel", "item-not-found"))
+				return true
+			}
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(x.Data))
+			switch {
+			case err != nil || len(b) > s.blockSize:
+				err = errors.New("ibb: bad block")
+				d.Send(iq.ErrorReply("modify", "bad-request"))
+			case x.Seq != s.seq:
+				err = errors.New("ibb: block out of sequence")
+				d.Send(iq.ErrorReply("cancel", "unexpected-request"))
+			case r.MaxSize > 0 && s.n+int64(len(b)) > r.MaxSize:
+				err = ErrTooLarge
+				d.Send(iq.ErrorReply("cancel", "not-acceptable"))
+			default:
+				if _, err = s.w.Write(b); err != nil {
+					d.Send(iq.ErrorReply("cancel", "internal-server-error"))
+				}
+			}
+			if err != nil {
+				delete(r.streams, key(h.From, x.SID))
+				done = r.end(h.From, x.SID, s, err)
+				return true
+			}
+			s.seq++
+			s.n += int64(len(b))
+		case "close":
+			c := &closeStream{}
+			iq.Decode(c)
+			s, ok := r.streams[key(h.From, c.SID)]
+			if !ok {
+				d.Send(iq.ErrorReply("cancel", "item-not-found"))
+				return true
+			}
+			delete(r.streams, key(h.From, c.SID))
+			done = r.end(h.From, c.SID, s, nil)
+		default:
+			d.Send(iq.ErrorReply("cancel", "feature-not-implemented"))
+			return true
+		}
+		d.Send(iq.Result())
+		return true
+	}
+}
@@ -0,0 +1,133 @@
+package ibb
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+const peer = "peer@example.org/res"
+
+type sink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *sink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		v   interface{}
+	}{
+		{`<open xmlns="http://jabber.org/protocol/ibb" block-size="4096" sid="s1" stanza="iq"/>`, &open{BlockSize: 4096, SID: "s1", Stanza: "iq"}},
+		{`<open xmlns="http://jabber.org/protocol/ibb" block-size="512" sid="s1"/>`, &open{BlockSize: 512, SID: "s1"}},
+		{`<data xmlns="http://jabber.org/protocol/ibb" seq="65535" sid="s1">aGk=</data>`, &data{Seq: 65535, SID: "s1", Data: "aGk="}},
+		{`<close xmlns="http://jabber.org/protocol/ibb" sid="s1"/>`, &closeStream{SID: "s1"}},
+	} {
+		got := reflect.New(reflect.TypeOf(tc.v).Elem()).Interface()
+		if err := xml.Unmarshal([]byte(tc.raw), got); err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		b, err := xml.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := reflect.New(reflect.TypeOf(tc.v).Elem()).Interface()
+		if err := xml.Unmarshal(b, again); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		// the XMLName is the only field set by parsing alone
+		reflect.ValueOf(tc.v).Elem().Field(0).Set(reflect.ValueOf(got).Elem().Field(0))
+		if !reflect.DeepEqual(got, tc.v) || !reflect.DeepEqual(again, tc.v) {
+			t.Errorf("%s parsed %+v, after a round trip %+v", tc.raw, got, again)
+		}
+	}
+}
+
+// receive passes the stanzas to the handler as sent by the peer.
+func receive(r *Receiver, d *dispatch.Dispatcher, raw ...string) {
+	h := r.Handler(d)
+	for _, s := range raw {
+		s = strings.Replace(s, "<iq ", `<iq from="`+peer+`" `, 1)
+		name, hdr, _ := stanza.Peek([]byte(s))
+		h(name, hdr, []byte(s))
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(Ns), `<iq type="result" id="{id}" from="`+peer+`"/>`)
+	d := dispatch.New(st)
+	go st.Ring(func(b *bytes.Buffer) bool {
+		d.Feed(b.Bytes())
+		return false
+	}, 5*time.Second)
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	if err := Send(d, jid.MustParse(peer), "s1", bytes.NewReader(payload), 64); err != nil {
+		t.Fatal(err)
+	}
+	sent := st.Written()
+	// open, 16 blocks and close
+	if len(sent) != 18 {
+		t.Fatalf("sent %d stanzas", len(sent))
+	}
+	w := &sink{}
+	var done []int64
+	r := &Receiver{
+		Accept: func(from, sid string) io.WriteCloser { return w },
+		Done:   func(from, sid string, n int64, err error) { done = append(done, n) },
+	}
+	back := streamtest.New("example.org")
+	var raw []string
+	for _, s := range sent {
+		raw = append(raw, string(s))
+	}
+	receive(r, dispatch.New(back), raw...)
+	if !bytes.Equal(w.Bytes(), payload) || !w.closed || len(done) != 1 || done[0] != int64(len(payload)) {
+		t.Fatalf("received %d bytes, closed %v, done %v", w.Len(), w.closed, done)
+	}
+	for _, s := range back.Written() {
+		if !bytes.Contains(s, []byte(`type="result"`)) {
+			t.Fatalf("answered %s", s)
+		}
+	}
+}
+
+func TestReceiveRefused(t *testing.T) {
+	const opened = `<iq type="set" id="o"><open xmlns="http://jabber.org/protocol/ibb" block-size="4" sid="s1"/></iq>`
+	for _, tc := range []struct {
+		name      string
+		raw       []string
+		condition string
+	}{
+		{"no block size", []string{`<iq type="set" id="x"><open xmlns="http://jabber.org/protocol/ibb" sid="s1"/></iq>`}, "bad-request"},
+		{"messages", []string{`<iq type="set" id="x"><open xmlns="http://jabber.org/protocol/ibb" block-size="4" sid="s1" stanza="message"/></iq>`}, "feature-not-implemented"},
+		{"block too large", []string{`<iq type="set" id="x"><open xmlns="http://jabber.org/protocol/ibb" block-size="65536" sid="s1"/></iq>`}, "resource-constraint"},
+		{"opened twice", []string{opened, opened}, "conflict"},
+		{"unknown stream", []string{`<iq type="set" id="x"><data xmlns="http://jabber.org/protocol/ibb" seq="0" sid="s2">aGk=</data></iq>`}, "item-not-found"},
+		{"out of sequence", []string{opened, `<iq type="set" id="x"><data xmlns="http://jabber.org/protocol/ibb" seq="1" sid="s1">aGk=</data></iq>`}, "unexpected-request"},
+		{"block larger than agreed", []string{opened, `<iq type="set" id="x"><data xmlns="http://jabber.org/protocol/ibb" seq="0" sid="s1">aGVsbG8=</data></iq>`}, "bad-request"},
+		{"too large", []string{opened, `<iq type="set" id="x"><data xmlns="http://jabber.org/protocol/ibb" seq="0" sid="s1">aGVs</data></iq>`}, "not-acceptable"},
+	} {
+		st := streamtest.New("example.org")
+		r := &Receiver{Accept: func(from, sid string) io.WriteCloser { return &sink{} }, MaxSize: 2}
+		receive(r, dispatch.New(st), tc.raw...)
+		w := st.Written()
+		if last := string(w[len(w)-1]); !strings.Contains(last, `type="error"`) || !strings.Contains(last, "<"+tc.condition) {
+			t.Errorf("%s: answered %s", tc.name, last)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"strings"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/actors"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+// onInvite turns room invitations into events and joins rooms the inviter is allowed to pull the bot into.
+func onInvite(st stream.Stream) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Archived {
+			return false
+		}
+		inv, ok := muc.ParseInvite(raw)
+		if !ok {
+			return false
+		}
+		data := map[string]string{"room": inv.Room, "inviter": inv.From, "reason": inv.Reason}
+		emit("invite", data)
+		if inv.Allowed(strings.Split(inviteFrom, ",")) {
+			log.Println("JOIN", inv.Room, "invited by", inv.From)
+			rooms.Add(&muc.Room{JID: inv.Room, Nick: ME, Password: inv.Password})
+			go actors.With().Do(actors.C(rooms.Join(inv.Room))).Run(st)
+		} else {
+			log.Println("ignoring invite to", inv.Room, "from", inv.From)
+		}
+		return true
+	}
+}
@@ -0,0 +1,183 @@
+// Package jid parses, normalizes and compares XMPP addresses (RFC 7622) and
+// escapes localparts as in XEP-0106.
+package jid
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPart is the longest a part may be in bytes.
+const maxPart = 1023
+
+var (
+	ErrEmptyDomain   = errors.New("jid: empty domainpart")
+	ErrEmptyLocal    = errors.New("jid: empty localpart")
+	ErrEmptyResource = errors.New("jid: empty resourcepart")
+	ErrTooLong       = errors.New("jid: part longer than 1023 bytes")
+	ErrInvalid       = errors.New("jid: invalid characters")
+)
+
+// JID is an address like local@domain/resource, its localpart and domainpart
+// are kept normalized so JIDs can be compared with ==.
+type JID struct {
+	Local    string
+	Domain   string
+	Resource string
+}
+
+// forbidden are the characters RFC 7622 doesn't allow in a localpart.
+const forbidden = "\"&'/:<>@"
+
+// normalize case-maps a localpart or domainpart. PRECIS maps the case with
+// Unicode default case folding, lower-casing is what it comes to in practice.
+func normalize(s string) string {
+	return strings.ToLower(s)
+}
+
+// New builds a JID of the parts, normalizing and checking them.
+func New(local, domain, resource string) (JID, error) {
+	j := JID{Local: normalize(local), Domain: normalize(strings.TrimSuffix(domain, ".")), Resource: resource}
+	switch {
+	case j.Domain == "":
+		return JID{}, ErrEmptyDomain
+	case len(j.Local) > maxPart || len(j.Domain) > maxPart || len(j.Resource) > maxPart:
+		return JID{}, ErrTooLong
+	case strings.ContainsAny(j.Local, forbidden) || strings.ContainsAny(j.Domain, "@/ "):
+		return JID{}, ErrInvalid
+	case !utf8.ValidString(j.Local) || !utf8.ValidString(j.Domain) || !utf8.ValidString(j.Resource):
+		return JID{}, ErrInvalid
+	}
+	return j, nil
+}
+
+// Parse reads an address, the resource starts at the first slash and the
+// localpart ends at the first at sign before it.
+func Parse(s string) (JID, error) {
+	var local, resource string
+	if i := strings.Index(s, "/"); i >= 0 {
+		if s, resource = s[:i], s[i+1:]; resource == "" {
+			return JID{}, ErrEmptyResource
+		}
+	}
+	if i := strings.Index(s, "@"); i >= 0 {
+		if local, s = s[:i], s[i+1:]; local == "" {
+			return JID{}, ErrEmptyLocal
+		}
+	}
+	return New(local, s, resource)
+}
+
+// MustParse is Parse for the JIDs known to be valid, it panics otherwise.
+func MustParse(s string) JID {
+	j, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return j
+}
+
+func (j JID) String() string {
+	s := j.Domain
+	if j.Local != "" {
+		s = j.Local + "@" + s
+	}
+	if j.Resource != "" {
+		s += "/" + j.Resource
+	}
+	return s
+}
+
+func (j JID) IsZero() bool {
+	return j == JID{}
+}
+
+func (j JID) IsBare() bool {
+	return j.Resource == ""
+}
+
+// Bare returns the JID without its resource.
+func (j JID) Bare() JID {
+	return JID{Local: j.Local, Domain: j.Domain}
+}
+
+// WithResource returns the full JID of the bare one with the resource.
+func (j JID) WithResource(resource string) JID {
+	return JID{Local: j.Local, Domain: j.Domain, Resource: resource}
+}
+
+// Equal compares the JIDs, it is == spelled out.
+func (j JID) Equal(o JID) bool {
+	return j == o
+}
+
+// BareEqual tells whether the JIDs are of the same account or room.
+func (j JID) BareEqual(o JID) bool {
+	return j.Local == o.Local && j.Domain == o.Domain
+}
+
+func (j JID) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	if j.IsZero() {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: j.String()}, nil
+}
+
+func (j *JID) UnmarshalXMLAttr(attr xml.Attr) (err error) {
+	*j, err = Parse(attr.Value)
+	return
+}
+
+// Split returns the bare part and the resource of an address without
+// checking it, for the JIDs taken from received stanzas as they are.
+func Split(s string) (bare, resource string) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// Bare parses an address and returns its bare JID, empty when it is
+// malformed.
+func Bare(s string) string {
+	j, err := Parse(s)
+	if err != nil {
+		return ""
+	}
+	return j.Bare().String()
+}
+
+// Domain returns the domainpart of an address without checking it.
+func Domain(s string) string {
+	bare, _ := Split(s)
+	if i := strings.Index(bare, "@"); i >= 0 {
+		bare = bare[i+1:]
+	}
+	return normalize(bare)
+}
+
+// escapes are the XEP-0106 escapes of the characters a localpart can't hold.
+var escapes = strings.NewReplacer(
+	`\20`, `\5c20`, `\22`, `\5c22`, `\26`, `\5c26`, `\27`, `\5c27`, `\2f`, `\5c2f`,
+	`\3a`, `\5c3a`, `\3c`, `\5c3c`, `\3e`, `\5c3e`, `\40`, `\5c40`, `\5c`, `\5c5c`,
+	" ", `\20`, `"`, `\22`, "&", `\26`, "'", `\27`, "/", `\2f`,
+	":", `\3a`, "<", `\3c`, ">", `\3e`, "@", `\40`,
+)
+
+var unescapes = strings.NewReplacer(
+	`\20`, " ", `\22`, `"`, `\26`, "&", `\27`, "'", `\2f`, "/",
+	`\3a`, ":", `\3c`, "<", `\3e`, ">", `\40`, "@", `\5c`, `\`,
+)
+
+// Escape turns a name like an e-mail address into a localpart, a backslash
+// is escaped only where it would start an escape.
+func Escape(local string) string {
+	return escapes.Replace(strings.Trim(local, " "))
+}
+
+// Unescape turns an escaped localpart back into the name.
+func Unescape(local string) string {
+	return unescapes.Replace(local)
+}
@@ -2,14 +2,16 @@ package jsexecutor
 
 import (
 	"fmt"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
 	"github.com/kpmy/xippo/c2s/stream"
-	"github.com/kpmy/xippo/entity"
 	"github.com/robertkrimen/otto"
 	"sync"
 	"time"
 )
 
 const sleepDuration time.Duration = 1 * time.Second
+const room = "golang@conference.jabber.ru"
 
 // An utility struct for incoming events.
 type IncomingEvent struct {
@@ -93,21 +95,25 @@ func (e *Executor) execute() {
 		_, err := e.vm.Run(script)
 		if err != nil {
 			fmt.Printf("js fucking shit error: %s\n", err)
-			m := entity.MSG(entity.GROUPCHAT)
-			m.To = "golang@conference.jabber.ru"
-			m.Body = err.Error()
-			e.xmppStream.Write(entity.ProduceStatic(m))
+			e.say(err.Error())
 		}
 		e.stateMutex.Unlock()
 	}
 }
 
+// say writes the text to the room, stanza.Produce escapes whatever the
+// scripts came up with.
+func (e *Executor) say(text string) error {
+	buf, err := stanza.Produce(stanza.NewMessage(stanza.GROUPCHAT, jid.MustParse(room), text))
+	if err != nil {
+		return err
+	}
+	return e.xmppStream.Write(buf)
+}
+
 func (e *Executor) sendingRoutine() {
 	for msg := range e.outgoingMsgs {
-		m := entity.MSG(entity.GROUPCHAT)
-		m.To = "golang@conference.jabber.ru"
-		m.Body = msg
-		err := e.xmppStream.Write(entity.ProduceStatic(m))
+		err := e.say(msg)
 		if err != nil {
 			fmt.Printf("send error: %s", err)
 		}
@@ -126,10 +132,7 @@ func (e *Executor) processIncomingEvents() {
 			_, err := handler.Call(obj.Value(), obj.Value())
 			if err != nil {
 				fmt.Printf("js fucking shit error: %s\n", err)
-				m := entity.MSG(entity.GROUPCHAT)
-				m.To = "golang@conference.jabber.ru"
-				m.Body = err.Error()
-				e.xmppStream.Write(entity.ProduceStatic(m))
+				e.say(err.Error())
 			}
 		}
 		e.stateMutex.Unlock()
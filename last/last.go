@@ -0,0 +1,51 @@
+// Package last implements XEP-0012 last activity.
+package last
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "jabber:iq:last"
+
+type query struct {
+	XMLName xml.Name `xml:"jabber:iq:last query"`
+	Seconds int64    `xml:"seconds,attr"`
+	Status  string   `xml:",chardata"`
+}
+
+// Get asks to for its idle time, or for the time since a bare JID went
+// offline; status is the last unavailable status in the latter case.
+func Get(d *dispatch.Dispatcher, to jid.JID) (idle time.Duration, status string, err error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &query{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return
+	}
+	q := &query{}
+	if err = res.Decode(q); err == nil {
+		idle, status = time.Duration(q.Seconds)*time.Second, q.Status
+	}
+	return
+}
+
+// Handler answers last activity queries with the idle time.
+func Handler(d *dispatch.Dispatcher, idle func() time.Duration) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.GET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		res := iq.Result()
+		res.Set(&query{Seconds: int64(idle() / time.Second)})
+		d.Send(res)
+		return true
+	}
+}
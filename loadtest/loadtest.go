@@ -0,0 +1,88 @@
+// Package loadtest generates synthetic room traffic and measures how the bot
+// copes with it.
+package loadtest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/record"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/units"
+)
+
+// Timer collects durations, a nil Timer ignores them.
+type Timer struct {
+	sync.Mutex
+	samples []time.Duration
+	first   time.Time
+	last    time.Time
+}
+
+func (t *Timer) Add(d time.Duration) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.Lock()
+	if t.first.IsZero() {
+		t.first = now
+	}
+	t.last = now
+	t.samples = append(t.samples, d)
+	t.Unlock()
+}
+
+// Since adds the time passed since start, for use with defer.
+func (t *Timer) Since(start time.Time) {
+	t.Add(time.Since(start))
+}
+
+// Wrap times a stream callback.
+func (t *Timer) Wrap(fn func(*bytes.Buffer) bool) func(*bytes.Buffer) bool {
+	return func(in *bytes.Buffer) bool {
+		defer t.Since(time.Now())
+		return fn(in)
+	}
+}
+
+// String reports the count, the rate over the sampled period and the percentiles.
+func (t *Timer) String() string {
+	t.Lock()
+	defer t.Unlock()
+	n := len(t.samples)
+	if n == 0 {
+		return "no samples"
+	}
+	s := append([]time.Duration(nil), t.samples...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	p := func(q float64) time.Duration { return s[int(q*float64(n-1))] }
+	rate := ""
+	if span := t.last.Sub(t.first); span > 0 {
+		rate = fmt.Sprintf(" (%.1f/s)", float64(n-1)/span.Seconds())
+	}
+	return fmt.Sprintf("%d%s p50 %s p95 %s p99 %s max %s", n, rate, p(.5), p(.95), p(.99), s[n-1])
+}
+
+// Synthetic generates messages of the simulated clients to the room, rate is
+// the total number of messages per second.
+func Synthetic(room string, clients, messages int, rate float64) (ret []record.Entry) {
+	at := time.Now()
+	step := time.Duration(float64(time.Second) / rate)
+	for i := 0; i < messages; i++ {
+		nick := "load" + strconv.Itoa(i%clients)
+		m := stanza.NewMessage(stanza.GROUPCHAT, jid.JID{}, "load test message "+strconv.Itoa(i))
+		m.From, _ = jid.Parse(units.Bare2Full(room, nick))
+		m.ID = "load" + strconv.Itoa(i)
+		raw, _ := xml.Marshal(m)
+		ret = append(ret, record.Entry{At: at, Raw: string(raw)})
+		at = at.Add(step)
+	}
+	return
+}
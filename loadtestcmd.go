@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/fjl/go-couchdb"
+	"github.com/kpmy/xep/loadtest"
+	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/record"
+)
+
+var (
+	loadClients  int
+	loadMessages int
+	loadRate     float64
+	loadTrace    string
+	loadSpeed    float64
+	loadRoom     string
+)
+
+// timers of the load test, nil otherwise
+var (
+	ringTimer *loadtest.Timer
+	statTimer *loadtest.Timer
+	hookTimer *loadtest.Timer
+)
+
+func init() {
+	flag.IntVar(&loadClients, "load-clients", 20, "-load-clients=20")
+	flag.IntVar(&loadMessages, "load-messages", 1000, "-load-messages=1000")
+	flag.Float64Var(&loadRate, "load-rate", 50, "-load-rate=50 messages per second")
+	flag.StringVar(&loadTrace, "load-trace", "", "-load-trace=stanzas.jsonl, replayed instead of simulated clients")
+	flag.Float64Var(&loadSpeed, "load-speed", 1, "-load-speed=1, trace replay speed-up")
+	flag.StringVar(&loadRoom, "load-room", ROOM, "-load-room=room@service")
+}
+
+// loadTest runs the bot against simulated clients or a recorded trace
+// instead of the server, the stats go to a separate database.
+func loadTest() error {
+	var entries []record.Entry
+	speed := 1.0
+	if loadTrace != "" {
+		var err error
+		if entries, err = record.Load(loadTrace); err != nil {
+			return err
+		}
+		speed = loadSpeed
+	} else {
+		if loadClients <= 0 || loadRate <= 0 {
+			return errors.New("load test needs clients and a rate")
+		}
+		entries = loadtest.Synthetic(loadRoom, loadClients, loadMessages, loadRate)
+	}
+	client, err := couchdb.NewClient(dbUrl, nil)
+	if err != nil {
+		return err
+	}
+	if db, err = client.CreateDB(dbName + "_loadtest"); err != nil {
+		return err
+	}
+	ringTimer, statTimer, hookTimer = new(loadtest.Timer), new(loadtest.Timer), new(loadtest.Timer)
+	rooms.Add(&muc.Room{JID: loadRoom, Nick: ME})
+	player := record.NewPlayer(server, entries)
+	player.Speed = speed
+	start := time.Now()
+	go bot(player)
+	<-player.Done
+	// let the hooks and the commands started by the last stanzas finish
+	time.Sleep(time.Second)
+	log.Println("load test of", len(entries), "stanzas took", time.Since(start))
+	log.Println("processing:", ringTimer)
+	log.Println("stat flush:", statTimer)
+	log.Println("hook fan-out:", hookTimer)
+	log.Println("stanzas written:", len(player.Written()))
+	return nil
+}
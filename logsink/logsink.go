@@ -0,0 +1,133 @@
+// Package logsink ships log lines and bot events to external systems: syslog,
+// journald, Loki or Elasticsearch.
+package logsink
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultQueueSize = 256
+
+// Entry is a log line or an event with its structured fields.
+type Entry struct {
+	Time   time.Time
+	Kind   string
+	Msg    string
+	Fields map[string]string
+}
+
+type Sink interface {
+	Send(e *Entry) error
+	Close() error
+}
+
+// Open creates a sink from an address like syslog://, syslog://host:514,
+// journald://, loki+http://host:3100 or es+http://host:9200/index.
+func Open(addr string) (Sink, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "syslog":
+		return newSyslog(u.Host)
+	case u.Scheme == "journald":
+		return newJournald()
+	case strings.HasPrefix(u.Scheme, "loki+"):
+		u.Scheme = strings.TrimPrefix(u.Scheme, "loki+")
+		return newLoki(u), nil
+	case strings.HasPrefix(u.Scheme, "es+"):
+		u.Scheme = strings.TrimPrefix(u.Scheme, "es+")
+		return newElastic(u), nil
+	}
+	return nil, errors.New("unknown log sink " + addr)
+}
+
+// Shipper queues entries for its sinks, it drops entries when they can't keep up
+// rather than slowing the bot down.
+type Shipper struct {
+	sinks []Sink
+	queue chan *Entry
+	done  chan struct{}
+	host  string
+}
+
+// New opens the sinks of the comma separated addresses.
+func New(addrs string) (*Shipper, error) {
+	s := &Shipper{queue: make(chan *Entry, DefaultQueueSize), done: make(chan struct{})}
+	s.host, _ = os.Hostname()
+	for _, a := range strings.Split(addrs, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		sink, err := Open(a)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Shipper) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		for _, sink := range s.sinks {
+			if err := sink.Send(e); err != nil {
+				// not through log, that would loop back here
+				os.Stderr.WriteString("log sink: " + err.Error() + "\n")
+			}
+		}
+	}
+}
+
+func (s *Shipper) push(e *Entry) {
+	if s == nil {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields["host"] = s.host
+	select {
+	case s.queue <- e:
+	default:
+	}
+}
+
+// Write takes the output of a log.Logger, one line per call.
+func (s *Shipper) Write(p []byte) (int, error) {
+	s.push(&Entry{Time: time.Now(), Kind: "log", Msg: strings.TrimRight(string(p), "\n")})
+	return len(p), nil
+}
+
+// Event ships a bot event with its data as fields.
+func (s *Shipper) Event(typ string, data map[string]string) {
+	fields := make(map[string]string, len(data))
+	for k, v := range data {
+		fields[k] = v
+	}
+	s.push(&Entry{Time: time.Now(), Kind: "event", Msg: typ, Fields: fields})
+}
+
+func (s *Shipper) close() {
+	for _, sink := range s.sinks {
+		sink.Close()
+	}
+}
+
+// Close flushes the queue and closes the sinks.
+func (s *Shipper) Close() {
+	if s == nil {
+		return
+	}
+	close(s.queue)
+	<-s.done
+	s.close()
+}
@@ -0,0 +1,170 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const tag = "xep"
+
+type syslogSink struct{ w *syslog.Writer }
+
+func newSyslog(host string) (Sink, error) {
+	network := ""
+	if host != "" {
+		network = "udp"
+	}
+	w, err := syslog.Dial(network, host, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w}, nil
+}
+
+// Send formats the fields as key=value pairs after the message.
+func (s *syslogSink) Send(e *Entry) error {
+	buf := bytes.NewBufferString(e.Kind + " " + e.Msg)
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, " %s=%q", k, e.Fields[k])
+	}
+	return s.w.Info(buf.String())
+}
+
+func (s *syslogSink) Close() error { return s.w.Close() }
+
+const journalSocket = "/run/systemd/journal/socket"
+
+type journaldSink struct{ conn net.Conn }
+
+func newJournald() (Sink, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn}, nil
+}
+
+// Send uses the native journal protocol, fields become upper case journal
+// fields prefixed with XEP_.
+func (s *journaldSink) Send(e *Entry) error {
+	buf := new(bytes.Buffer)
+	field := func(k, v string) {
+		if strings.ContainsRune(v, '\n') {
+			buf.WriteString(k + "\n")
+			binary.Write(buf, binary.LittleEndian, uint64(len(v)))
+			buf.WriteString(v + "\n")
+		} else {
+			buf.WriteString(k + "=" + v + "\n")
+		}
+	}
+	field("MESSAGE", e.Msg)
+	field("PRIORITY", "6")
+	field("SYSLOG_IDENTIFIER", tag)
+	field("XEP_KIND", e.Kind)
+	for k, v := range e.Fields {
+		field("XEP_"+journalKey(k), v)
+	}
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+func journalKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+}
+
+func (s *journaldSink) Close() error { return s.conn.Close() }
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func post(u string, typ string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(u, typ, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(u + ": " + resp.Status)
+	}
+	return nil
+}
+
+type lokiSink struct{ url string }
+
+func newLoki(u *url.URL) Sink {
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/loki/api/v1/push"
+	}
+	return &lokiSink{u.String()}
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Send pushes an entry as its own stream, labelled with the kind only so the
+// label cardinality stays low, the fields go into the line as JSON.
+func (s *lokiSink) Send(e *Entry) error {
+	line, err := json.Marshal(map[string]interface{}{"msg": e.Msg, "fields": e.Fields})
+	if err != nil {
+		return err
+	}
+	push := map[string][]lokiStream{"streams": {{
+		Stream: map[string]string{"app": tag, "kind": e.Kind},
+		Values: [][2]string{{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)}},
+	}}}
+	return post(s.url, "application/json", push)
+}
+
+func (s *lokiSink) Close() error { return nil }
+
+type elasticSink struct{ url string }
+
+func newElastic(u *url.URL) Sink {
+	index := strings.Trim(u.Path, "/")
+	if index == "" {
+		index = tag
+	}
+	u.Path = "/" + index + "/_doc"
+	return &elasticSink{u.String()}
+}
+
+func (s *elasticSink) Send(e *Entry) error {
+	doc := map[string]interface{}{"@timestamp": e.Time.UTC().Format(time.RFC3339Nano), "app": tag, "kind": e.Kind, "message": e.Msg}
+	for k, v := range e.Fields {
+		if _, ok := doc[k]; !ok {
+			doc[k] = v
+		}
+	}
+	return post(s.url, "application/json", doc)
+}
+
+func (s *elasticSink) Close() error { return nil }
@@ -3,14 +3,16 @@ package luaexecutor
 import (
 	"fmt"
 	"github.com/Shopify/go-lua"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
 	"github.com/kpmy/xippo/c2s/stream"
-	"github.com/kpmy/xippo/entity"
 	"path/filepath"
 	"sync"
 	"time"
 )
 
 const sleepDuration time.Duration = 1 * time.Second
+const room = "golang@conference.jabber.ru"
 const callbacksLocation string = "clbks"
 
 // An utility struct for incoming events.
@@ -129,21 +131,25 @@ func (e *Executor) execute() {
 		err := lua.DoString(e.state, script)
 		if err != nil {
 			fmt.Printf("lua fucking shit error: %s\n", err)
-			m := entity.MSG(entity.GROUPCHAT)
-			m.To = "golang@conference.jabber.ru"
-			m.Body = err.Error()
-			e.xmppStream.Write(entity.ProduceStatic(m))
+			e.say(err.Error())
 		}
 		e.stateMutex.Unlock()
 	}
 }
 
+// say writes the text to the room, stanza.Produce escapes whatever the
+// scripts came up with.
+func (e *Executor) say(text string) error {
+	buf, err := stanza.Produce(stanza.NewMessage(stanza.GROUPCHAT, jid.MustParse(room), text))
+	if err != nil {
+		return err
+	}
+	return e.xmppStream.Write(buf)
+}
+
 func (e *Executor) sendingRoutine() {
 	for msg := range e.outgoingMsgs {
-		m := entity.MSG(entity.GROUPCHAT)
-		m.To = "golang@conference.jabber.ru"
-		m.Body = msg
-		err := e.xmppStream.Write(entity.ProduceStatic(m))
+		err := e.say(msg)
 		if err != nil {
 			fmt.Printf("send error: %s", err)
 		}
@@ -175,10 +181,8 @@ func (e *Executor) processIncomingEvents() {
 					}
 					err := e.state.ProtectedCall(1, 0, 0)
 					if err != nil {
-						m := entity.MSG(entity.GROUPCHAT)
-						m.To = "golang@conference.jabber.ru"
-						m.Body, _ = e.state.ToString(-1)
-						e.xmppStream.Write(entity.ProduceStatic(m))
+						msg, _ := e.state.ToString(-1)
+						e.say(msg)
 						e.state.Pop(1)
 					}
 				} else {
@@ -1,26 +1,63 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/ivpusic/golog"
 	"reflect"
 	//	"github.com/skratchdot/open-golang/open"
+	"github.com/kpmy/xep/adhoc"
+	"github.com/kpmy/xep/attention"
+	"github.com/kpmy/xep/botstatus"
+	"github.com/kpmy/xep/c2s"
+	"github.com/kpmy/xep/caps"
+	"github.com/kpmy/xep/carbons"
+	"github.com/kpmy/xep/chatstates"
+	"github.com/kpmy/xep/csi"
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/entitytime"
 	"github.com/kpmy/xep/hookexecutor"
+	"github.com/kpmy/xep/ibb"
+	"github.com/kpmy/xep/jid"
 	"github.com/kpmy/xep/jsexecutor"
+	"github.com/kpmy/xep/last"
+	"github.com/kpmy/xep/logsink"
 	"github.com/kpmy/xep/luaexecutor"
+	"github.com/kpmy/xep/mix"
+	"github.com/kpmy/xep/modules"
 	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/omemo"
+	"github.com/kpmy/xep/oob"
+	"github.com/kpmy/xep/ox"
+	"github.com/kpmy/xep/pep"
+	"github.com/kpmy/xep/ping"
+	"github.com/kpmy/xep/privilege"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/pump"
+	"github.com/kpmy/xep/reactions"
+	"github.com/kpmy/xep/record"
+	"github.com/kpmy/xep/retract"
+	"github.com/kpmy/xep/roster"
+	"github.com/kpmy/xep/runner"
+	"github.com/kpmy/xep/s5b"
+	"github.com/kpmy/xep/session"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/version"
 	"github.com/kpmy/xippo/c2s/actors"
-	"github.com/kpmy/xippo/c2s/actors/steps"
 	"github.com/kpmy/xippo/c2s/stream"
-	"github.com/kpmy/xippo/entity"
-	"github.com/kpmy/xippo/entity/dyn"
 	"github.com/kpmy/xippo/units"
 	"html/template"
+	"io"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,14 +67,71 @@ import (
 const (
 	ROOM = "golang@conference.jabber.ru"
 	ME   = "xep"
+
+	// stepTimeout bounds each step of the login, a stalled server is redialed
+	stepTimeout = time.Minute
 )
 
 var (
-	user     string
-	pwd      string
-	server   string
-	resource string
-	neo_log  = golog.GetLogger("application")
+	user         string
+	pwd          string
+	server       string
+	resource     string
+	inviteFrom   string
+	modulesDir   string
+	selfPing     time.Duration
+	admins       string
+	apiToken     string
+	rejoinHist   bool
+	skipHist     bool
+	catchUpOn    bool
+	recordTo     string
+	nickSuffix   string
+	useBookmarks bool
+	subPolicy    string
+	show         string
+	status       string
+	priority     int
+	logSinks     string
+	swName       string
+	swVersion    string
+	statusEvery  time.Duration
+	pubsubJID    string
+	alertsNode   string
+	avatarFile   string
+	vcardDesc    string
+	vcardEmail   string
+	selfRegister bool
+	csiIdle      time.Duration
+	omemoOn      bool
+	pgpKey       string
+	pgpHome      string
+	pgpRequire   bool
+	filesDir     string
+	compAddr     string
+	compSecret   string
+	mixChannels  string
+	swOS         string
+	statsSalt    string
+	workers      int
+	queueSize    int
+	hookTokens   string
+	hookAddr     string
+	hookCert     string
+	hookKey      string
+	hookClientCA string
+	hookSocket   string
+	hookRooms    string
+	hookContacts string
+	hookDests    string
+	hookMaxLen   int
+	hookTypes    string
+	hookQueue    int
+	hookOverflow string
+	hookSpill    string
+	hookWS       string
+	hookWebhooks string
+	neo_log      = golog.GetLogger("application")
 )
 
 type (
@@ -56,6 +150,9 @@ type (
 		User string
 		Nick string
 		Msg  string
+		// ID is the stanza-id the room gave the message
+		ID        string
+		Retracted bool
 	}
 
 	Posts struct {
@@ -69,12 +166,82 @@ var posts *Posts
 var executor *luaexecutor.Executor
 var jsexec *jsexecutor.Executor
 var hookExec *hookexecutor.Executor
+var disp *dispatch.Dispatcher
+var mods *modules.Set
+var rooms = muc.NewRooms()
+var stopPing chan struct{}
+
+// lastDown is when the connection was lost, the archives are replayed from
+// there after reconnecting.
+var lastDown time.Time
+var contacts *roster.Roster
+var subscriptions *roster.Subscriptions
+var sess *session.Session
+var shipper *logsink.Shipper
+var adhocCmds = adhoc.New()
+var capsCache = caps.NewCache()
+var capsExt stanza.Extension
+var identity = disco.Identity{Category: "client", Type: "bot", Name: ME}
 
 func init() {
 	flag.StringVar(&user, "u", "goxep", "-u=user")
 	flag.StringVar(&server, "s", "xmpp.ru", "-s=server")
 	flag.StringVar(&resource, "r", "go", "-r=resource")
 	flag.StringVar(&pwd, "p", "GogogOg0", "-p=password")
+	flag.StringVar(&inviteFrom, "invite-from", "", "-invite-from=jid1,jid2")
+	flag.StringVar(&modulesDir, "modules", "modules", "-modules=dir")
+	flag.DurationVar(&selfPing, "self-ping", 5*time.Minute, "-self-ping=5m")
+	flag.StringVar(&admins, "admins", "", "-admins=user1,jid2")
+	flag.StringVar(&apiToken, "api-token", "", "-api-token=secret")
+	flag.BoolVar(&rejoinHist, "rejoin-history", false, "-rejoin-history")
+	flag.BoolVar(&skipHist, "skip-history", false, "-skip-history")
+	flag.BoolVar(&catchUpOn, "catch-up", true, "-catch-up=false")
+	flag.StringVar(&recordTo, "record", "", "-record=stanzas.jsonl")
+	flag.StringVar(&nickSuffix, "nick-suffixes", "_,2,3", "-nick-suffixes=_,2,3")
+	flag.BoolVar(&useBookmarks, "bookmarks", true, "-bookmarks=false")
+	flag.StringVar(&subPolicy, "subscriptions", roster.AskAdmin, "-subscriptions=auto-accept|accept-from-roster-domain|deny|ask-admin")
+	flag.StringVar(&show, "show", "", "-show=away|xa|dnd|chat")
+	flag.StringVar(&status, "status", "ПЩ сюды: https://github.com/kpmy/xep", "-status=text")
+	flag.IntVar(&priority, "priority", 0, "-priority=0")
+	flag.StringVar(&logSinks, "log-sink", "", "-log-sink=syslog://,journald://,loki+http://host:3100,es+http://host:9200/index")
+	flag.StringVar(&swName, "sw-name", "xep", "-sw-name=xep")
+	flag.StringVar(&swVersion, "sw-version", "0.1", "-sw-version=0.1")
+	flag.DurationVar(&statusEvery, "status-every", 0, "-status-every=1m")
+	flag.StringVar(&pubsubJID, "pubsub-service", "", "-pubsub-service=pubsub.example.org")
+	flag.StringVar(&alertsNode, "alerts-node", "", "-alerts-node=builds")
+	flag.StringVar(&avatarFile, "avatar", "", "-avatar=avatar.png")
+	flag.StringVar(&vcardDesc, "vcard-desc", "", "-vcard-desc=text")
+	flag.StringVar(&vcardEmail, "vcard-email", "", "-vcard-email=admin@example.org")
+	flag.BoolVar(&selfRegister, "register", false, "-register")
+	flag.DurationVar(&csiIdle, "csi-idle", 5*time.Minute, "-csi-idle=5m")
+	flag.BoolVar(&omemoOn, "omemo", false, "-omemo")
+	flag.StringVar(&pgpKey, "pgp-key", "", "-pgp-key=fingerprint, signs the alerts")
+	flag.StringVar(&pgpHome, "pgp-home", "", "-pgp-home=~/.gnupg")
+	flag.BoolVar(&pgpRequire, "pgp-require", false, "-pgp-require, admin commands only when signed")
+	flag.StringVar(&filesDir, "files", "files", "-files=dir, where files sent in band are kept")
+	flag.StringVar(&compAddr, "component", "", "-component=localhost:5347, runs as the component -s")
+	flag.StringVar(&compSecret, "component-secret", "", "-component-secret=secret")
+	flag.StringVar(&mixChannels, "mix", "", "-mix=channel1@mix.example.org,channel2@mix.example.org")
+	flag.StringVar(&swOS, "sw-os", runtime.GOOS, "-sw-os=linux, empty hides the OS")
+	flag.StringVar(&statsSalt, "stats-salt", "", "-stats-salt=secret, keeps only salted hashes of users in stats and no message log")
+	flag.IntVar(&workers, "workers", pump.DefaultWorkers, "-workers=4")
+	flag.IntVar(&queueSize, "queue", pump.DefaultQueueSize, "-queue=256")
+	flag.StringVar(&hookTokens, "hook-tokens", "", "-hook-tokens=secret:send,other:read, hooks are off without")
+	flag.StringVar(&hookAddr, "hook-addr", hookexecutor.DefaultAddr, "-hook-addr=127.0.0.1:1984")
+	flag.StringVar(&hookSocket, "hook-socket", "", "-hook-socket=/run/xep/hooks.sock, with -hook-addr= the only listener")
+	flag.StringVar(&hookWS, "hook-websocket", "", "-hook-websocket=127.0.0.1:1985, serves the hooks over WebSocket at /hooks as well")
+	flag.StringVar(&hookWebhooks, "hook-webhooks", "", "-hook-webhooks=https://ci.example.org/xep#secret=s&types=message, space separated, POSTed the events")
+	flag.StringVar(&hookRooms, "hook-rooms", ROOM, "-hook-rooms=room1@service,room2@service, where hooks may post, the first by default")
+	flag.StringVar(&hookContacts, "hook-contacts", "", "-hook-contacts=jid1,jid2, whom hooks may message directly")
+	flag.StringVar(&hookDests, "hook-destinations", "", "-hook-destinations=*@conference.example.org,admin@example.org, patterns of where hooks may send")
+	flag.IntVar(&hookMaxLen, "hook-max-length", 0, "-hook-max-length=1000, characters in a body")
+	flag.StringVar(&hookTypes, "hook-types", "", "-hook-types=message,reaction,attention,file")
+	flag.StringVar(&hookCert, "hook-cert", "", "-hook-cert=cert.pem, serves the hooks over TLS with -hook-key")
+	flag.StringVar(&hookKey, "hook-key", "", "-hook-key=key.pem")
+	flag.StringVar(&hookClientCA, "hook-client-ca", "", "-hook-client-ca=ca.pem, hook clients need certificates it signed")
+	flag.IntVar(&hookQueue, "hook-queue", hookexecutor.DefaultClientBufferSize, "-hook-queue=64, events a hook client may fall behind")
+	flag.StringVar(&hookOverflow, "hook-overflow", string(hookexecutor.OverflowDrop), "-hook-overflow=drop|block|spill, for the clients falling further behind")
+	flag.StringVar(&hookSpill, "hook-spill-dir", "", "-hook-spill-dir=/var/spool/xep, where hook clients may spill")
 	log.SetFlags(0)
 	posts = new(Posts)
 }
@@ -85,16 +252,17 @@ func (d *StatData) Less(i, j int) bool { return d.Stat[i].Count > d.Stat[j].Coun
 
 func (d *StatData) Swap(i, j int) { d.Stat[i], d.Stat[j] = d.Stat[j], d.Stat[i] }
 
-func doReply(sender string, typ entity.MessageType) func(stream.Stream) error {
+func doReply(sender, typ string) func(stream.Stream) error {
 	return func(s stream.Stream) error {
-		m := entity.MSG(typ)
-		if typ != entity.GROUPCHAT {
-			m.To = units.Bare2Full(ROOM, sender)
-		} else {
-			m.To = ROOM
+		m := stanza.NewMessage(typ, jid.MustParse(ROOM), "пщ")
+		if typ != stanza.GROUPCHAT {
+			m.To = m.To.WithResource(sender)
+		}
+		buf, err := stanza.Produce(m)
+		if err != nil {
+			return err
 		}
-		m.Body = "пщ"
-		return s.Write(entity.Encode(dyn.NewMessage(m.Type, m.To, m.Body)))
+		return s.Write(buf)
 	}
 }
 
@@ -124,114 +292,321 @@ func loadTpl(name string) (ret *template.Template, err error) {
 	return
 }
 
+// emit passes an event to the script executors, hooks and modules.
+func emit(typ string, data map[string]string) {
+	executor.NewEvent(luaexecutor.IncomingEvent{typ, data})
+	jsexec.NewEvent(jsexecutor.IncomingEvent{typ, data})
+	hookStart := time.Now()
+	hookExec.NewEvent(hookexecutor.IncomingEvent{typ, data})
+	hookTimer.Since(hookStart)
+	mods.Event(typ, data)
+	shipper.Event(typ, data)
+}
+
 func bot(st stream.Stream) error {
-	actors.With().Do(actors.C(steps.PresenceTo(units.Bare2Full(ROOM, ME), entity.CHAT, "ПЩ сюды: https://github.com/kpmy/xep"))).Run(st)
 	executor = luaexecutor.NewExecutor(st)
 	executor.Start()
 	jsexec = jsexecutor.NewExecutor(st)
 	jsexec.Start()
-	hookExec = hookexecutor.NewExecutor(st)
-	hookExec.Start()
-	for {
-		st.Ring(conv(func(_e entity.Entity) {
-			switch e := _e.(type) {
-			case *entity.Message:
-				if strings.HasPrefix(e.From, ROOM+"/") {
-					sender := strings.TrimPrefix(e.From, ROOM+"/")
-					um := muc.UserMapping()
-					user := sender
-					if u, ok := um[sender]; ok {
-						user, _ = u.(string)
-					}
-					if e.Type == entity.GROUPCHAT {
-						posts.Lock()
-						posts.data = append(posts.data, Post{Nick: sender, User: user, Msg: e.Body})
-						IncStat(user)
-						posts.Unlock()
-					}
-					if sender != ME {
-						executor.NewEvent(luaexecutor.IncomingEvent{"message",
-							map[string]string{"sender": sender, "body": e.Body}})
-						jsexec.NewEvent(jsexecutor.IncomingEvent{"message",
-							map[string]string{"sender": sender, "body": e.Body}})
-						hookExec.NewEvent(hookexecutor.IncomingEvent{"message",
-							map[string]string{"sender": sender, "body": e.Body}})
-						switch {
-						case strings.HasPrefix(e.Body, "lua>"):
-							go func(script string) {
-								actors.With().Do(actors.C(doLua(script))).Run(st)
-							}(strings.TrimPrefix(e.Body, "lua>"))
-						case strings.HasPrefix(e.Body, "js>"):
-							go func(script string) {
-								actors.With().Do(actors.C(doJS(script))).Run(st)
-							}(strings.TrimPrefix(e.Body, "js>"))
-						case strings.HasPrefix(e.Body, "say"):
-							go func(script string) {
-								actors.With().Do(actors.C(doLuaAndPrint(script))).Run(st)
-							}(strings.TrimSpace(strings.TrimPrefix(e.Body, "say")))
-						}
-					}
+	hookExec.SetStream(st)
+	disp = dispatch.New(st)
+	disp.Handle(onStreamError())
+	if compAddr != "" {
+		disp.Handle(privs.Handler(), privilege.Ns)
+	}
+	disp.Handle(disco.Handler(disp, adhocCmds.Items, identity), disco.NsInfo, disco.NsItems)
+	disp.Handle(ping.Handler(disp), ping.Ns)
+	disp.Handle(entitytime.Handler(disp), entitytime.Ns)
+	disp.Handle(last.Handler(disp, idle), last.Ns)
+	disp.Handle(version.Handler(disp, &version.Query{Name: swName, Version: swVersion, OS: swOS}), version.Ns)
+	disp.Handle(adhocCmds.Handler(disp), adhoc.Ns)
+	disp.Handle((&ibb.Receiver{Accept: acceptFile, Done: fileDone, MaxSize: maxReceived}).Handler(disp), ibb.Ns)
+	disp.Handle((&s5b.Receiver{Accept: acceptFile, Done: fileDone, MaxSize: maxReceived}).Handler(disp), s5b.Ns)
+	disp.Handle(rooms.Handler(disp), muc.NsMUC)
+	disp.Handle(capsCache.Handler(), caps.Ns)
+	disp.Handle(archive.Handler())
+	disp.Handle(retract.Handler(onRetract), retract.Ns)
+	disp.Handle(logPosts())
+	disp.Handle(countStats())
+	disp.Handle(trackActivity())
+	disp.Handle(carbons.Handler(disp, jid.MustParse(user+"@"+server)), carbons.Ns)
+	disp.Handle(commandHandler())
+	disp.Handle(channels.Handler(onChannelMessage), mix.Ns)
+	if omemoOn && e2e == nil {
+		var err error
+		if e2e, err = omemo.New(user+"@"+server, omemoStore{}); err != nil {
+			log.Println("OMEMO is off:", err)
+		}
+	}
+	if e2e != nil {
+		disp.Handle(e2e.Handler(disp, runSecureCommand), omemo.Ns, pep.Notify(omemo.NsDevices))
+	}
+	setupPGP()
+	disp.Handle(ox.Handler(pgp, user+"@"+server, runSignedCommand), ox.Ns)
+	disp.Handle(directCommandHandler())
+	disp.Handle(reactions.Handler(func(from string, r *reactions.Reactions) {
+		emit("reaction", map[string]string{"from": from, "id": r.ID, "reactions": strings.Join(r.Reactions, " ")})
+	}), reactions.Ns)
+	disp.Handle(attention.Handler(onAttention), attention.Ns)
+	disp.Handle(chatstates.Handler(func(from, state string) {
+		emit("chatstate", map[string]string{"from": from, "state": state})
+	}), chatstates.Ns)
+	disp.Handle(oob.Handler(func(from string, x oob.Data) {
+		emit("attachment", map[string]string{"from": from, "url": x.URL, "desc": x.Desc})
+	}), oob.Ns)
+	disp.Handle(pubsub.Handler(func(from string, e *pubsub.Event) {
+		ids := make([]string, 0, len(e.Items))
+		for _, i := range e.Items {
+			ids = append(ids, i.ID)
+		}
+		if e2e != nil {
+			e2e.Update(disp, from, e)
+		}
+		channels.Update(from, e)
+		emit("pubsub", map[string]string{"from": from, "node": e.Node, "items": strings.Join(ids, " "), "retracts": strings.Join(e.Retracts, " ")})
+	}))
+	disp.Handle(onInvite(st), muc.NsConference)
+	contacts = roster.New(user + "@" + server)
+	disp.Handle(contacts.Handler(disp))
+	subscriptions = roster.NewSubscriptions(contacts, subPolicy)
+	subscriptions.Ask = func(jid string) {
+		reply(ROOM, jid+" wants to subscribe, admins: !subscription approve|deny "+jid)
+	}
+	disp.Handle(subscriptions.Handler(disp))
+	// the modules outlive the connections, their host sends through the
+	// current dispatcher; !reload is what restarts them
+	if mods == nil {
+		mods = modules.Load(modulesDir)
+		mods.Start(newModuleHost())
+	}
+	rooms.History = rejoinHist
+	rooms.SkipHistory = skipHist
+	rooms.Suffixes = strings.Split(nickSuffix, ",")
+	rooms.Show, rooms.Status = show, status
+	// the handlers are all registered now, so the caps cover their features
+	if ext, err := stanza.NewExtension(caps.Own(disp, identity)); err == nil {
+		capsExt = ext
+		rooms.Extensions = []stanza.Extension{ext}
+	}
+	if _, ok := rooms.Get(ROOM); !ok {
+		rooms.Add(&muc.Room{JID: ROOM, Nick: ME})
+	}
+	// a component has no account, so no roster, PEP or archive of its own
+	account := compAddr == ""
+	if account {
+		actors.With().Do(actors.C(sendPresence(show, status))).Run(st)
+	}
+	actors.With().Do(actors.C(rooms.Presence(disp))).Run(st)
+	if stopPing != nil {
+		close(stopPing)
+	}
+	stopPing = make(chan struct{})
+	go rooms.KeepAlive(disp, selfPing, stopPing)
+	if statusEvery > 0 {
+		go botstatus.Every(disp, statusEvery, botStatus, stopPing)
+	}
+	clientState = nil
+	if csiIdle > 0 && sess.Supports(session.CSI) {
+		clientState = csi.New(st)
+		go clientState.Run(csiIdle, busy, stopPing)
+	}
+	if mixChannels != "" && account {
+		go joinChannels()
+	}
+	go func() {
+		sess.Probe(disp)
+		if !account {
+			return
+		}
+		// the requests are independent, a slow server answers them at once
+		quiet := func(fn func()) runner.Step {
+			return runner.Task(func() error { fn(); return nil })
+		}
+		err := runner.With().DoParallel(
+			runner.Task(func() error { return pep.PublishNick(disp, ME) }),
+			quiet(publishAvatar),
+			quiet(publishVCard),
+			quiet(publishOmemo),
+			quiet(publishPGPKey),
+			runner.Task(func() error {
+				if !sess.Supports(session.Carbons) {
+					return nil
+				}
+				return carbons.Enable(disp)
+			}),
+			runner.Task(func() error {
+				if useBookmarks {
+					autojoin()
 				}
-			case dyn.Entity:
-				switch e.Type() {
-				case dyn.PRESENCE:
-					if from := e.Model().Attr("from"); from != "" && strings.HasPrefix(from, ROOM+"/") {
-						sender := strings.TrimPrefix(from, ROOM+"/")
-						um := muc.UserMapping()
-						user := sender
-						if u, ok := um[sender]; ok {
-							user, _ = u.(string)
-						}
-						if show := firstByName(e.Model(), "show"); e.Model().Attr("type") == "" && (show == nil || show.ChildrenCount() == 0) { //онлаен тип
-							//go func() { actors.With().Do(actors.C(doLuaAndPrint(`"` + user + `, насяльника..."`))).Run(st) }()
-							executor.NewEvent(luaexecutor.IncomingEvent{"presence",
-								map[string]string{"sender": sender, "user": user}})
-							log.Println("ONLINE", user)
-						}
+				return nil
+			}),
+			runner.Task(func() error { return contacts.Fetch(disp) }),
+		).Run(st)
+		if err != nil {
+			log.Println("after login:", err)
+		}
+	}()
+	if !lastDown.IsZero() {
+		if catchUpOn && account {
+			go catchUp(lastDown)
+		}
+		lastDown = time.Time{}
+	}
+	go reportStreamError()
+	ring := func(fn func(*bytes.Buffer) bool) func(*bytes.Buffer) bool { return ringTimer.Wrap(fn) }
+	switch {
+	case recordTo == "":
+	case statsSalt != "":
+		// the stanzas carry the names and bodies privacy mode keeps out
+		log.Println("not recording the stanzas with -stats-salt")
+	default:
+		if rec, err := record.New(recordTo); err == nil {
+			defer rec.Close()
+			ring = func(fn func(*bytes.Buffer) bool) func(*bytes.Buffer) bool { return rec.Wrap(ringTimer.Wrap(fn)) }
+		} else {
+			log.Println(err)
+		}
+	}
+	inbound = pump.New(workers, queueSize)
+	return inbound.Run(st, ring(conv(func(_e interface{}) {
+		switch e := _e.(type) {
+		case *stanza.Message:
+			if room, sender := splitJID(e.From); sender != "" && sameJID(room, ROOM) {
+				if sender != rooms.Nick(ROOM) {
+					emit("message", map[string]string{"room": room, "sender": sender, "body": e.Body})
+					switch {
+					case strings.HasPrefix(e.Body, "lua>"):
+						go func(script string) {
+							actors.With().Do(actors.C(doLua(script))).Run(st)
+						}(strings.TrimPrefix(e.Body, "lua>"))
+					case strings.HasPrefix(e.Body, "js>"):
+						go func(script string) {
+							actors.With().Do(actors.C(doJS(script))).Run(st)
+						}(strings.TrimPrefix(e.Body, "js>"))
+					case strings.HasPrefix(e.Body, "say"):
+						go func(script string) {
+							actors.With().Do(actors.C(doLuaAndPrint(script))).Run(st)
+						}(strings.TrimSpace(strings.TrimPrefix(e.Body, "say")))
 					}
 				}
-			default:
-				log.Println(reflect.TypeOf(e))
 			}
-		}), 0)
-	}
+		case *stanza.Presence:
+			if room, sender := splitJID(e.From); sender != "" && sameJID(room, ROOM) {
+				um := muc.UserMapping()
+				user := sender
+				if u, ok := um[sender]; ok {
+					user, _ = u.(string)
+				}
+				if e.Type == stanza.AVAILABLE && e.Show == "" { //онлаен тип
+					//go func() { actors.With().Do(actors.C(doLuaAndPrint(`"` + user + `, насяльника..."`))).Run(st) }()
+					executor.NewEvent(luaexecutor.IncomingEvent{"presence",
+						map[string]string{"sender": sender, "user": user}})
+					log.Println("ONLINE", statKey(user))
+				}
+			}
+		default:
+			log.Println(reflect.TypeOf(e))
+		}
+	})))
 }
 
 func main() {
 	flag.Parse()
+	switch cmd := flag.Arg(0); cmd {
+	case "backup", "restore":
+		if flag.NArg() != 2 {
+			log.Fatal("usage: xep ", cmd, " <file>, the passphrase is taken from ", backupKeyEnv)
+		}
+		do := backup
+		if cmd == "restore" {
+			do = restore
+		}
+		if err := do(flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "loadtest":
+		if err := loadTest(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if logSinks != "" {
+		var err error
+		if shipper, err = logsink.New(logSinks); err != nil {
+			log.Fatal(err)
+		}
+		defer shipper.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, shipper))
+	}
+	hookOpts, err := hookOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	startHooks(hookOpts)
 	s := &units.Server{Name: server}
 	c := &units.Client{Name: user, Server: s}
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
+	if compAddr != "" {
+		go runComponent(wg)
+		go neo_server(wg)
+		wg.Wait()
+		return
+	}
+	// Ctrl-C cancels the login and the bot, a second one kills as before
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	go func() {
-		var redial func(error)
-
-		dial := func(st stream.Stream) {
+		<-ctx.Done()
+		stop()
+	}()
+	// the bot returns once the stream breaks, it is redialed then
+	go func() {
+		defer wg.Done()
+		dial := func() error {
 			log.Println("dialing ", s)
-
-			if err := stream.Dial(st); err == nil {
-				log.Println("dialed")
-				neg := &steps.Negotiation{}
-				actors.With().Do(actors.C(steps.Starter), redial).Do(actors.C(neg.Act()), redial).Run(st)
-				if neg.HasMechanism("PLAIN") {
-					auth := &steps.PlainAuth{Client: c, Pwd: pwd}
-					neg := &steps.Negotiation{}
-					bind := &steps.Bind{Rsrc: resource + strconv.Itoa(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(500))}
-					actors.With().Do(actors.C(auth.Act()), redial).Do(actors.C(steps.Starter)).Do(actors.C(neg.Act())).Do(actors.C(bind.Act())).Do(actors.C(steps.Session)).Run(st)
-					actors.With().Do(actors.C(steps.InitialPresence)).Run(st)
-					actors.With().Do(actors.C(bot)).Run(st)
-				}
-				wg.Done()
+			st, err := c2s.Dial(s, nil)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+			log.Println("dialed")
+			st.OnParseError = func(pe *stanza.ParseError) { parseError(pe.Err, pe.Raw) }
+			sess = session.New(user + "@" + server)
+			rsrc := resource + strconv.Itoa(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(500))
+			opts := runner.LoginOptions{Resource: rsrc, Starter: st.Start, Register: selfRegister, Features: sess.Features(), Timeout: stepTimeout, BindRetries: 3}
+			login := runner.With().WithContext(ctx)
+			err = login.DoState(runner.Login(c, pwd, opts)).Do(func(st stream.Stream) error {
+				// once is enough, the account is there
+				selfRegister = false
+				fullJID = login.State().JID
+				return bot(st)
+			}).Run(st)
+			if errors.Is(err, runner.ErrNoMechanism) {
+				log.Println(err)
+				return nil
 			}
+			return err
 		}
 
-		redial = func(err error) {
+		for err := error(nil); ; {
 			log.Println(err)
-			<-time.After(time.Second)
-			dial(stream.New(s, redial))
+			if err != nil && lastDown.IsZero() {
+				lastDown = time.Now()
+			}
+			delay, stop := reconnectDelay()
+			if stop {
+				giveUp()
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if err = dial(); err == nil || ctx.Err() != nil {
+				return
+			}
 		}
-
-		redial(nil)
 	}()
 	go neo_server(wg)
 	go func() {
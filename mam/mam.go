@@ -0,0 +1,184 @@
+// Package mam queries XEP-0313 message archives.
+package mam
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns    = "urn:xmpp:mam:2"
+	NsRSM = "http://jabber.org/protocol/rsm"
+
+	DefaultPageSize = 100
+)
+
+// Filter narrows a query, zero fields are left out. After resumes the query
+// past the message with that archive id.
+type Filter struct {
+	With       string
+	Start, End time.Time
+	After      string
+}
+
+// Result is an archived stanza with its archive id.
+type Result struct {
+	ID string
+	stanza.Forwarded
+}
+
+type set struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/rsm set"`
+	Max     int      `xml:"max,omitempty"`
+	After   string   `xml:"after,omitempty"`
+	Last    string   `xml:"last,omitempty"`
+}
+
+type query struct {
+	XMLName xml.Name `xml:"urn:xmpp:mam:2 query"`
+	QueryID string   `xml:"queryid,attr"`
+	Form    *dataforms.Form
+	Set     set
+}
+
+type fin struct {
+	XMLName  xml.Name `xml:"urn:xmpp:mam:2 fin"`
+	Complete bool     `xml:"complete,attr"`
+	Set      set
+}
+
+type result struct {
+	QueryID string `xml:"queryid,attr"`
+	ID      string `xml:"id,attr"`
+}
+
+// Archive collects the results of the running queries.
+type Archive struct {
+	sync.Mutex
+	queries map[string][]*Result
+}
+
+func New() *Archive {
+	return &Archive{queries: make(map[string][]*Result)}
+}
+
+// Handler consumes the result messages of the running queries.
+func (a *Archive) Handler() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" {
+			return false
+		}
+		r := &struct {
+			Result *result `xml:"urn:xmpp:mam:2 result"`
+		}{}
+		if xml.Unmarshal(raw, r) != nil || r.Result == nil {
+			return false
+		}
+		a.Lock()
+		defer a.Unlock()
+		if _, ok := a.queries[r.Result.QueryID]; !ok {
+			return false
+		}
+		if env, err := stanza.Unwrap(raw); err == nil {
+			a.queries[r.Result.QueryID] = append(a.queries[r.Result.QueryID], &Result{ID: r.Result.ID, Forwarded: env.Forwarded})
+		}
+		return true
+	}
+}
+
+// Query pages through the archive of addr, the account archive when empty, and
+// returns everything matching the filter.
+func (a *Archive) Query(d *dispatch.Dispatcher, addr string, f Filter) (ret []*Result, err error) {
+	var to jid.JID
+	if addr != "" {
+		if to, err = jid.Parse(addr); err != nil {
+			return
+		}
+	}
+	values := make(map[string]string)
+	if f.With != "" {
+		values["with"] = f.With
+	}
+	if !f.Start.IsZero() {
+		values["start"] = f.Start.UTC().Format(time.RFC3339)
+	}
+	if !f.End.IsZero() {
+		values["end"] = f.End.UTC().Format(time.RFC3339)
+	}
+	q := &query{QueryID: d.NextID(), Form: dataforms.NewSubmit(Ns, dataforms.Single(values))}
+	q.Set.Max = DefaultPageSize
+	q.Set.After = f.After
+	a.Lock()
+	a.queries[q.QueryID] = nil
+	a.Unlock()
+	defer func() {
+		a.Lock()
+		delete(a.queries, q.QueryID)
+		a.Unlock()
+	}()
+	for {
+		iq, _ := stanza.NewIQ(stanza.SET, to, q)
+		var res *stanza.IQ
+		if res, err = d.Request(iq); err != nil {
+			return
+		}
+		done := &fin{}
+		if err = res.Decode(done); err != nil {
+			return
+		}
+		a.Lock()
+		ret = append(ret, a.queries[q.QueryID]...)
+		a.queries[q.QueryID] = nil
+		a.Unlock()
+		if done.Complete || done.Set.Last == "" || done.Set.Last == q.Set.After {
+			return
+		}
+		q.Set.After = done.Set.Last
+	}
+}
+
+// Message returns the archived message stamped with its original time and
+// the archive id as stanza-id by the archive, unless it carries them already.
+func (r *Result) Message(by string) (*stanza.Message, error) {
+	m := &stanza.Message{}
+	if err := xml.Unmarshal(r.Stanza, m); err != nil {
+		return nil, err
+	}
+	if m.Delay == nil {
+		m.Delay = r.Delay
+	}
+	if by != "" && m.StanzaID(by) == "" {
+		if err := m.With(&stanza.StanzaID{ID: r.ID, By: by}); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Replay queries the archive of addr and feeds the messages found to the
+// handlers of the dispatcher as archived ones, it returns how many there were.
+func (a *Archive) Replay(d *dispatch.Dispatcher, addr string, f Filter) (n int, err error) {
+	res, err := a.Query(d, addr, f)
+	if err != nil {
+		return
+	}
+	for _, r := range res {
+		m, err := r.Message(addr)
+		if err != nil {
+			continue
+		}
+		raw, err := xml.Marshal(m)
+		if err != nil {
+			continue
+		}
+		d.Replay(raw)
+		n++
+	}
+	return
+}
@@ -2,60 +2,54 @@ package main
 
 import (
 	"bytes"
-	"github.com/kpmy/xippo/entity"
-	"github.com/kpmy/xippo/entity/dyn"
-	"github.com/kpmy/ypk/dom"
-	"gopkg.in/xmlpath.v2"
+	"github.com/kpmy/xep/stanza"
 	"log"
 )
 
-func conv(fn func(entity.Entity)) func(*bytes.Buffer) bool {
-	delayed := func(msg dom.Element) bool {
-		for _, _e := range msg.Children() {
-			if e, ok := _e.(dom.Element); ok && e.Name() == "delay" {
-				return true
-			}
-		}
-		return false
+// maxParseRaw caps the malformed input passed along with a parse-error event.
+const maxParseRaw = 512
+
+// parseError reports a stanza that was skipped as malformed, the stream goes
+// on with the next one.
+func parseError(err error, raw []byte) {
+	log.Println("skipped malformed stanza:", err)
+	if len(raw) > maxParseRaw {
+		raw = raw[:maxParseRaw]
 	}
+	// in privacy mode the stanza is only told apart by its hash, it carries
+	// the addresses and the text
+	emit("parse-error", map[string]string{"error": err.Error(), "raw": statKey(string(raw))})
+}
 
+// conv decodes the stanzas the dispatcher left for fn, a *stanza.Message or
+// a *stanza.Presence; messages delayed by the room history are left out.
+func conv(fn func(interface{})) func(*bytes.Buffer) bool {
 	return func(in *bytes.Buffer) (done bool) {
 		done = true
-		log.Println("IN")
-		log.Println(string(in.Bytes()))
-		log.Println()
-		if p, err := xmlpath.Parse(bytes.NewBuffer(in.Bytes())); err == nil {
-			log.Println("xpath", p.String())
-		} else {
-			log.Println(err)
+		if statsSalt == "" {
+			log.Println("IN")
+			log.Println(string(in.Bytes()))
+			log.Println()
+		}
+		if disp != nil && disp.Feed(in.Bytes()) {
+			return
 		}
-		if _e, err := entity.Decode(bytes.NewBuffer(in.Bytes())); err == nil {
-			e := _e.Model()
-			switch e.Name() {
-			case dyn.MESSAGE:
-				if !delayed(e) {
-					if ent, err := entity.ConsumeStatic(in); err == nil {
-						fn(ent)
-					} else {
-						log.Println(err)
-					}
-				}
-			case dyn.PRESENCE:
-				fn(_e)
+		v, err := stanza.Consume(in.Bytes())
+		switch v := v.(type) {
+		case *stanza.Message:
+			if v.Delay == nil {
+				fn(v)
+			}
+		case *stanza.Presence:
+			fn(v)
+		case *stanza.IQ:
+		default:
+			// stream level elements have their own namespace, a stanza
+			// of an unknown name is malformed
+			if name, _, _ := stanza.Peek(in.Bytes()); err != stanza.ErrUnknownElement || name.Space == "" {
+				parseError(err, in.Bytes())
 			}
-		} else {
-			log.Println(err)
 		}
 		return
 	}
 }
-
-func firstByName(root dom.Element, name string) (ret dom.Element) {
-	for _, x := range root.Children() {
-		if e, ok := x.(dom.Element); ok && e.Name() == name {
-			ret = e
-			break
-		}
-	}
-	return
-}
@@ -0,0 +1,243 @@
+// Package mix implements XEP-0369 mediated information exchange channels,
+// joined through the server of the account as XEP-0405 has it. Channels are
+// the MIX counterpart of muc.Rooms.
+package mix
+
+import (
+	"encoding/xml"
+	"sort"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns    = "urn:xmpp:mix:core:1"
+	NsPAM = "urn:xmpp:mix:pam:2"
+
+	NodeMessages     = "urn:xmpp:mix:nodes:messages"
+	NodeParticipants = "urn:xmpp:mix:nodes:participants"
+	NodeInfo         = "urn:xmpp:mix:nodes:info"
+)
+
+type subscribe struct {
+	Node string `xml:"node,attr"`
+}
+
+type join struct {
+	XMLName   xml.Name    `xml:"urn:xmpp:mix:core:1 join"`
+	ID        string      `xml:"id,attr,omitempty"`
+	Subscribe []subscribe `xml:"subscribe"`
+	Nick      string      `xml:"nick,omitempty"`
+}
+
+type clientJoin struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:pam:2 client-join"`
+	Channel string   `xml:"channel,attr,omitempty"`
+	Join    join
+}
+
+type clientLeave struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:pam:2 client-leave"`
+	Channel string   `xml:"channel,attr"`
+	Leave   struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:core:1 leave"`
+	}
+}
+
+type setNick struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:core:1 setnick"`
+	Nick    string   `xml:"nick"`
+}
+
+// Participant is an item of the participants node, ID is its item id.
+type Participant struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:core:1 participant"`
+	ID      string   `xml:"-"`
+	Nick    string   `xml:"nick"`
+	JID     string   `xml:"jid"`
+}
+
+// Info is what a channel message tells of its sender.
+type Info struct {
+	XMLName xml.Name `xml:"urn:xmpp:mix:core:1 mix"`
+	Nick    string   `xml:"nick"`
+	JID     string   `xml:"jid"`
+}
+
+// Channel is a channel the bot has joined.
+type Channel struct {
+	JID string
+	// ID is the participant id of the bot in the channel.
+	ID           string
+	Nick         string
+	participants map[string]*Participant
+}
+
+// Channels keeps track of the joined channels.
+type Channels struct {
+	sync.Mutex
+	channels map[string]*Channel
+}
+
+func NewChannels() *Channels {
+	return &Channels{channels: make(map[string]*Channel)}
+}
+
+// Join joins the channel with the nick, subscribing to its messages and
+// participants, and fetches the participants.
+func (c *Channels) Join(d *dispatch.Dispatcher, channel, nick string) (*Channel, error) {
+	channel = jid.Bare(channel)
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &clientJoin{Channel: channel, Join: join{
+		Subscribe: []subscribe{{NodeMessages}, {NodeParticipants}, {NodeInfo}},
+		Nick:      nick,
+	}})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	cj := &clientJoin{}
+	if err := res.Decode(cj); err != nil {
+		return nil, err
+	}
+	ch := &Channel{JID: channel, ID: cj.Join.ID, Nick: nick, participants: make(map[string]*Participant)}
+	if cj.Join.Nick != "" {
+		ch.Nick = cj.Join.Nick
+	}
+	c.Lock()
+	c.channels[channel] = ch
+	c.Unlock()
+	items, err := pubsub.Items(d, channel, NodeParticipants, 0)
+	if err != nil {
+		return ch, err
+	}
+	c.Update(channel, &pubsub.Event{Node: NodeParticipants, Items: items})
+	return ch, nil
+}
+
+// Leave leaves the channel.
+func (c *Channels) Leave(d *dispatch.Dispatcher, channel string) error {
+	channel = jid.Bare(channel)
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &clientLeave{Channel: channel})
+	if _, err := d.Request(iq); err != nil {
+		return err
+	}
+	c.Lock()
+	delete(c.channels, channel)
+	c.Unlock()
+	return nil
+}
+
+// SetNick changes the nick of the bot in the channel, it returns the nick
+// the channel assigned.
+func (c *Channels) SetNick(d *dispatch.Dispatcher, channel, nick string) (string, error) {
+	to, err := jid.Parse(channel)
+	if err != nil {
+		return "", err
+	}
+	channel = to.Bare().String()
+	iq, _ := stanza.NewIQ(stanza.SET, to.Bare(), &setNick{Nick: nick})
+	res, err := d.Request(iq)
+	if err != nil {
+		return "", err
+	}
+	sn := &setNick{}
+	if res.Decode(sn) == nil && sn.Nick != "" {
+		nick = sn.Nick
+	}
+	c.Lock()
+	if ch, ok := c.channels[channel]; ok {
+		ch.Nick = nick
+	}
+	c.Unlock()
+	return nick, nil
+}
+
+func (c *Channels) Get(addr string) (ret *Channel, ok bool) {
+	c.Lock()
+	ret, ok = c.channels[jid.Bare(addr)]
+	c.Unlock()
+	return
+}
+
+// List returns the joined channels by JID.
+func (c *Channels) List() (ret []*Channel) {
+	c.Lock()
+	for _, ch := range c.channels {
+		ret = append(ret, ch)
+	}
+	c.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].JID < ret[j].JID })
+	return
+}
+
+// Participants returns the participants of the channel by nick.
+func (c *Channels) Participants(channel string) (ret []Participant) {
+	c.Lock()
+	if ch, ok := c.channels[jid.Bare(channel)]; ok {
+		for _, p := range ch.participants {
+			ret = append(ret, *p)
+		}
+	}
+	c.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Nick < ret[j].Nick })
+	return
+}
+
+// Update applies the notifications of the participants node of a joined
+// channel.
+func (c *Channels) Update(from string, e *pubsub.Event) {
+	if e.Node != NodeParticipants {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	ch, ok := c.channels[jid.Bare(from)]
+	if !ok {
+		return
+	}
+	for _, i := range e.Items {
+		p := &Participant{}
+		if i.Decode(p) == nil {
+			p.ID = i.ID
+			ch.participants[i.ID] = p
+		}
+	}
+	for _, id := range e.Retracts {
+		delete(ch.participants, id)
+	}
+}
+
+// Handler passes the live messages of the joined channels to fn with what
+// they tell of their sender, leaving out the bot's own; it never consumes
+// them.
+func (c *Channels) Handler(fn func(channel string, from Info, m *stanza.Message)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type != stanza.GROUPCHAT || h.Archived {
+			return false
+		}
+		ch, ok := c.Get(h.From.String())
+		if !ok {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil {
+			return false
+		}
+		info := Info{}
+		if x := m.Ext(Ns, "mix"); x == nil || x.Decode(&info) != nil {
+			return false
+		}
+		c.Lock()
+		nick := ch.Nick
+		c.Unlock()
+		if info.Nick == nick {
+			return false
+		}
+		fn(ch.JID, info, m)
+		return false
+	}
+}
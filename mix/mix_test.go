@@ -0,0 +1,126 @@
+package mix
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+const channel = "coven@mix.example.org"
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		v   interface{}
+	}{
+		{`<client-join xmlns="urn:xmpp:mix:pam:2" channel="coven@mix.example.org"><join xmlns="urn:xmpp:mix:core:1">` +
+			`<subscribe node="urn:xmpp:mix:nodes:messages"/><subscribe node="urn:xmpp:mix:nodes:participants"/><nick>bot</nick></join></client-join>`,
+			&clientJoin{Channel: channel, Join: join{Subscribe: []subscribe{{NodeMessages}, {NodeParticipants}}, Nick: "bot"}}},
+		{`<client-join xmlns="urn:xmpp:mix:pam:2"><join xmlns="urn:xmpp:mix:core:1" id="123456"><nick>bot2</nick></join></client-join>`,
+			&clientJoin{Join: join{ID: "123456", Nick: "bot2"}}},
+		{`<client-leave xmlns="urn:xmpp:mix:pam:2" channel="coven@mix.example.org"><leave xmlns="urn:xmpp:mix:core:1"/></client-leave>`,
+			&clientLeave{Channel: channel}},
+		{`<setnick xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick></setnick>`, &setNick{Nick: "thirdwitch"}},
+		{`<participant xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hag66@shakespeare.example</jid></participant>`,
+			&Participant{Nick: "thirdwitch", JID: "hag66@shakespeare.example"}},
+		{`<mix xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hag66@shakespeare.example</jid></mix>`,
+			&Info{Nick: "thirdwitch", JID: "hag66@shakespeare.example"}},
+	} {
+		typ := reflect.TypeOf(tc.v).Elem()
+		got := reflect.New(typ).Interface()
+		if err := xml.Unmarshal([]byte(tc.raw), got); err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		b, err := xml.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := reflect.New(typ).Interface()
+		if err := xml.Unmarshal(b, again); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		// the names are the only fields set by parsing alone
+		if !reflect.DeepEqual(clearNames(got), clearNames(tc.v)) || !reflect.DeepEqual(clearNames(again), clearNames(tc.v)) {
+			t.Errorf("%s parsed %+v, after a round trip %+v", tc.raw, got, again)
+		}
+	}
+}
+
+// clearNames zeroes the XMLName fields of v and of the structs it nests.
+func clearNames(v interface{}) interface{} {
+	var clear func(reflect.Value)
+	clear = func(s reflect.Value) {
+		for i := 0; i < s.NumField(); i++ {
+			switch f := s.Field(i); {
+			case f.Type() == reflect.TypeOf(xml.Name{}):
+				f.Set(reflect.ValueOf(xml.Name{}))
+			case f.Kind() == reflect.Struct:
+				clear(f)
+			}
+		}
+	}
+	clear(reflect.ValueOf(v).Elem())
+	return v
+}
+
+func TestJoin(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains("client-join"), `<iq type="result" id="{id}"><client-join xmlns="urn:xmpp:mix:pam:2">`+
+		`<join xmlns="urn:xmpp:mix:core:1" id="123456"><nick>bot2</nick></join></client-join></iq>`)
+	st.On(streamtest.Contains(NodeParticipants+`"`), `<iq type="result" id="{id}" from="`+channel+`">`+
+		`<pubsub xmlns="http://jabber.org/protocol/pubsub"><items node="urn:xmpp:mix:nodes:participants">`+
+		`<item id="123456"><participant xmlns="urn:xmpp:mix:core:1"><nick>bot2</nick></participant></item>`+
+		`<item id="654321"><participant xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hag66@shakespeare.example</jid></participant></item>`+
+		`</items></pubsub></iq>`)
+	d := dispatch.New(st)
+	go st.Ring(func(b *bytes.Buffer) bool {
+		d.Feed(b.Bytes())
+		return false
+	}, 5*time.Second)
+	c := NewChannels()
+	ch, err := c.Join(d, channel+"/ignored", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.JID != channel || ch.ID != "123456" || ch.Nick != "bot2" {
+		t.Fatalf("joined %+v", ch)
+	}
+	if p := c.Participants(channel); len(p) != 2 || p[1].Nick != "thirdwitch" || p[1].ID != "654321" {
+		t.Fatalf("participants %+v", p)
+	}
+	c.Update(channel, &pubsub.Event{Node: NodeParticipants, Retracts: []string{"654321"}})
+	if p := c.Participants(channel); len(p) != 1 {
+		t.Fatalf("participants after the retract %+v", p)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := NewChannels()
+	c.channels[channel] = &Channel{JID: channel, Nick: "bot", participants: make(map[string]*Participant)}
+	var got []string
+	h := c.Handler(func(ch string, from Info, m *stanza.Message) {
+		got = append(got, ch+" "+from.Nick+" "+m.Body)
+	})
+	for _, raw := range []string{
+		`<message from="coven@mix.example.org" type="groupchat"><body>hi</body><mix xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick></mix></message>`,
+		`<message from="coven@mix.example.org" type="groupchat"><body>mine</body><mix xmlns="urn:xmpp:mix:core:1"><nick>bot</nick></mix></message>`,
+		`<message from="coven@mix.example.org" type="groupchat"><body>no info</body></message>`,
+		`<message from="other@mix.example.org" type="groupchat"><body>elsewhere</body><mix xmlns="urn:xmpp:mix:core:1"><nick>x</nick></mix></message>`,
+		`<message from="coven@mix.example.org" type="chat"><body>direct</body><mix xmlns="urn:xmpp:mix:core:1"><nick>x</nick></mix></message>`,
+	} {
+		name, hdr, _ := stanza.Peek([]byte(raw))
+		if h(name, hdr, []byte(raw)) {
+			t.Errorf("consumed %s", raw)
+		}
+	}
+	if len(got) != 1 || got[0] != channel+" thirdwitch hi" {
+		t.Fatalf("passed %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kpmy/xep/muc"
+)
+
+// occupantJID resolves a nick to the occupant's real JID, JIDs are returned as is.
+func occupantJID(room, who string) (string, error) {
+	if strings.Contains(who, "@") {
+		return who, nil
+	}
+	if o, ok := rooms.Occupant(room, who); ok && o.JID != "" {
+		return o.JID, nil
+	}
+	return "", fmt.Errorf("real JID of %s is unknown", who)
+}
+
+func setRole(role string) func(c *cmd) (string, error) {
+	return func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		return "", muc.SetRole(disp, c.room, c.args[0], role, strings.Join(c.args[1:], " "))
+	}
+}
+
+func init() {
+	commands["kick"] = &command{admin: true, usage: "<nick> [reason]", run: setRole(muc.NONE)}
+	commands["voice"] = &command{admin: true, usage: "<nick>", run: setRole(muc.PARTICIPANT)}
+	commands["devoice"] = &command{admin: true, usage: "<nick>", run: setRole(muc.VISITOR)}
+	commands["ban"] = &command{admin: true, confirm: true, usage: "<nick|jid> [reason]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		jid, err := occupantJID(c.room, c.args[0])
+		if err != nil {
+			return "", err
+		}
+		return "", muc.Ban(disp, c.room, jid, strings.Join(c.args[1:], " "))
+	}}
+	commands["affiliation"] = &command{admin: true, confirm: true, usage: "<nick|jid> <owner|admin|member|none|outcast>", run: func(c *cmd) (string, error) {
+		if len(c.args) != 2 {
+			return "", errUsage
+		}
+		switch c.args[1] {
+		case muc.OWNER, muc.ADMIN, muc.MEMBER, muc.NONE, muc.OUTCAST:
+		default:
+			return "", errUsage
+		}
+		jid, err := occupantJID(c.room, c.args[0])
+		if err != nil {
+			return "", err
+		}
+		return "", muc.SetAffiliation(disp, c.room, jid, c.args[1], "")
+	}}
+}
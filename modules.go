@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/kpmy/xep/dispatch"
+)
+
+type moduleHost struct {
+	logger *log.Logger
+}
+
+func (h *moduleHost) Send(typ, to, body string) error {
+	m, err := newMessage(typ, to, body)
+	if err != nil {
+		return err
+	}
+	return disp.Send(m)
+}
+
+func (h *moduleHost) Dispatcher() *dispatch.Dispatcher { return disp }
+
+func (h *moduleHost) Logger() *log.Logger { return h.logger }
+
+func newModuleHost() *moduleHost {
+	return &moduleHost{log.New(os.Stderr, "[modules] ", log.LstdFlags)}
+}
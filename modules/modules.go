@@ -0,0 +1,153 @@
+// Package modules discovers and loads third-party bot modules from a directory.
+//
+// A Go plugin module is built with -buildmode=plugin against the same xep
+// sources as the bot and exports
+//
+//	var ABI = modules.ABI
+//	func New() modules.Module
+package modules
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+)
+
+// ABI is the version of the module interface, modules built against another one are refused.
+const ABI = 1
+
+// Host is what the bot exposes to modules.
+type Host interface {
+	// Send posts a message of the given type ("chat" or "groupchat").
+	Send(typ, to, body string) error
+	Dispatcher() *dispatch.Dispatcher
+	Logger() *log.Logger
+}
+
+type Module interface {
+	Name() string
+	Start(Host) error
+	// Event receives the same events as the script executors.
+	Event(typ string, data map[string]string)
+	Stop()
+}
+
+// Loader loads a module from a file, loaders are selected by file extension.
+type Loader func(path string) (Module, error)
+
+var loaders = map[string]Loader{".so": loadPlugin}
+
+func Register(ext string, l Loader) {
+	loaders[ext] = l
+}
+
+func loadPlugin(path string) (Module, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	abi, err := p.Lookup("ABI")
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := abi.(*int); !ok || *v != ABI {
+		return nil, fmt.Errorf("incompatible module ABI, want %d", ABI)
+	}
+	sym, err := p.Lookup("New")
+	if err != nil {
+		return nil, err
+	}
+	New, ok := sym.(func() Module)
+	if !ok {
+		return nil, fmt.Errorf("New has type %T", sym)
+	}
+	return New(), nil
+}
+
+// Set is a group of loaded modules.
+type Set struct {
+	sync.Mutex
+	mods []Module
+}
+
+// Load loads every module found in dir, failing modules are logged and skipped.
+func Load(dir string) *Set {
+	ret := &Set{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("modules:", err)
+		}
+		return ret
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		l, ok := loaders[strings.ToLower(filepath.Ext(n))]
+		if !ok {
+			continue
+		}
+		if m, err := l(filepath.Join(dir, n)); err == nil {
+			log.Println("module loaded", m.Name(), "from", n)
+			ret.mods = append(ret.mods, m)
+		} else {
+			log.Println("module", n, "failed to load:", err)
+		}
+	}
+	return ret
+}
+
+// Start starts the modules, modules failing to start are dropped.
+func (s *Set) Start(h Host) {
+	s.Lock()
+	defer s.Unlock()
+	started := s.mods[:0]
+	for _, m := range s.mods {
+		if err := m.Start(h); err == nil {
+			started = append(started, m)
+		} else {
+			log.Println("module", m.Name(), "failed to start:", err)
+		}
+	}
+	s.mods = started
+}
+
+func (s *Set) Event(typ string, data map[string]string) {
+	s.Lock()
+	mods := s.mods
+	s.Unlock()
+	for _, m := range mods {
+		m.Event(typ, data)
+	}
+}
+
+func (s *Set) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	for _, m := range s.mods {
+		m.Stop()
+	}
+	s.mods = nil
+}
+
+// Names returns the names of the running modules.
+func (s *Set) Names() (ret []string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, m := range s.mods {
+		ret = append(ret, m.Name())
+	}
+	return
+}
@@ -0,0 +1,235 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WASM modules run in a sandbox and can only use the host functions of the
+// "xep" import module their capabilities allow:
+//
+//	log(ptr, len)                                  always
+//	send(ptr, len) -> i32                          {"type", "to", "body"} json, if send is granted
+//	kv_get(kptr, klen, vptr, vcap) -> i32          value length or -1, if kv is granted
+//	kv_set(kptr, klen, vptr, vlen) -> i32          0 or -1, if kv is granted
+//
+// Modules export memory, alloc(size) -> ptr and on_event(ptr, len), the
+// event is passed as {"type", "data"} json. Capabilities are read from a
+// json manifest named like the module, e.g. echo.wasm and echo.json.
+const (
+	wasmMemoryPages = 256 // 16MiB
+	wasmCallTimeout = time.Second
+	kvMaxKeys       = 1024
+	kvMaxValue      = 64 * 1024
+)
+
+// Caps are the capabilities granted to a WASM module.
+type Caps struct {
+	Send bool `json:"send"`
+	// To lists the JIDs the module may send to.
+	To []string `json:"to"`
+	KV bool     `json:"kv"`
+	// Events lists the event types delivered to the module, all if empty.
+	Events []string `json:"events"`
+}
+
+type wasmModule struct {
+	sync.Mutex
+	name    string
+	caps    Caps
+	kvPath  string
+	kv      map[string]string
+	host    Host
+	rt      wazero.Runtime
+	mod     api.Module
+	alloc   api.Function
+	onEvent api.Function
+}
+
+func init() {
+	Register(".wasm", loadWasm)
+}
+
+func loadWasm(path string) (Module, error) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	m := &wasmModule{name: filepath.Base(base), kvPath: base + ".kv.json", kv: make(map[string]string)}
+	if data, err := os.ReadFile(base + ".json"); err == nil {
+		if err = json.Unmarshal(data, &m.caps); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if data, err := os.ReadFile(m.kvPath); err == nil && m.caps.KV {
+		json.Unmarshal(data, &m.kv)
+	}
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	m.rt = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryPages).
+		WithCloseOnContextDone(true))
+	if err = m.instantiate(ctx, bin); err != nil {
+		m.rt.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *wasmModule) instantiate(ctx context.Context, bin []byte) (err error) {
+	// WASI without preopened dirs or env, so toolchains needing it still work
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, m.rt); err != nil {
+		return
+	}
+	_, err = m.rt.NewHostModuleBuilder("xep").
+		NewFunctionBuilder().WithFunc(m.log).Export("log").
+		NewFunctionBuilder().WithFunc(m.send).Export("send").
+		NewFunctionBuilder().WithFunc(m.kvGet).Export("kv_get").
+		NewFunctionBuilder().WithFunc(m.kvSet).Export("kv_set").
+		Instantiate(ctx)
+	if err != nil {
+		return
+	}
+	compiled, err := m.rt.CompileModule(ctx, bin)
+	if err != nil {
+		return
+	}
+	if m.mod, err = m.rt.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(m.name)); err != nil {
+		return
+	}
+	m.alloc, m.onEvent = m.mod.ExportedFunction("alloc"), m.mod.ExportedFunction("on_event")
+	if m.alloc == nil || m.onEvent == nil {
+		err = errors.New("module must export alloc and on_event")
+	}
+	return
+}
+
+func read(mod api.Module, ptr, size uint32) (string, bool) {
+	b, ok := mod.Memory().Read(ptr, size)
+	return string(b), ok
+}
+
+func (m *wasmModule) log(_ context.Context, mod api.Module, ptr, size uint32) {
+	if s, ok := read(mod, ptr, size); ok {
+		log.Printf("[%s] %s", m.name, s)
+	}
+}
+
+func (m *wasmModule) send(_ context.Context, mod api.Module, ptr, size uint32) int32 {
+	data, ok := read(mod, ptr, size)
+	if !ok || !m.caps.Send || m.host == nil {
+		return -1
+	}
+	msg := struct {
+		Type string `json:"type"`
+		To   string `json:"to"`
+		Body string `json:"body"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return -1
+	}
+	allowed := false
+	for _, to := range m.caps.To {
+		allowed = allowed || strings.EqualFold(to, msg.To)
+	}
+	if !allowed || (msg.Type != "chat" && msg.Type != "groupchat") {
+		return -1
+	}
+	if err := m.host.Send(msg.Type, msg.To, msg.Body); err != nil {
+		return -1
+	}
+	return 0
+}
+
+// kvGet and kvSet are only called from within Event, which holds the lock.
+func (m *wasmModule) kvGet(_ context.Context, mod api.Module, kptr, klen, vptr, vcap uint32) int32 {
+	key, ok := read(mod, kptr, klen)
+	if !ok || !m.caps.KV {
+		return -1
+	}
+	v, ok := m.kv[key]
+	if !ok {
+		return -1
+	}
+	if uint32(len(v)) <= vcap {
+		mod.Memory().Write(vptr, []byte(v))
+	}
+	return int32(len(v))
+}
+
+func (m *wasmModule) kvSet(_ context.Context, mod api.Module, kptr, klen, vptr, vlen uint32) int32 {
+	key, ok := read(mod, kptr, klen)
+	if !ok || !m.caps.KV || vlen > kvMaxValue {
+		return -1
+	}
+	v, ok := read(mod, vptr, vlen)
+	if _, exists := m.kv[key]; !ok || (!exists && len(m.kv) >= kvMaxKeys) {
+		return -1
+	}
+	m.kv[key] = v
+	if data, err := json.Marshal(m.kv); err == nil {
+		os.WriteFile(m.kvPath, data, 0600)
+	}
+	return 0
+}
+
+func (m *wasmModule) Name() string { return m.name }
+
+func (m *wasmModule) Start(h Host) error {
+	m.Lock()
+	m.host = h
+	m.Unlock()
+	return nil
+}
+
+func (m *wasmModule) Event(typ string, data map[string]string) {
+	if len(m.caps.Events) > 0 {
+		wanted := false
+		for _, e := range m.caps.Events {
+			wanted = wanted || e == typ
+		}
+		if !wanted {
+			return
+		}
+	}
+	evt, _ := json.Marshal(struct {
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}{typ, data})
+	m.Lock()
+	defer m.Unlock()
+	if m.mod == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wasmCallTimeout)
+	defer cancel()
+	res, err := m.alloc.Call(ctx, uint64(len(evt)))
+	if err == nil && len(res) == 1 && m.mod.Memory().Write(uint32(res[0]), evt) {
+		_, err = m.onEvent.Call(ctx, res[0], uint64(len(evt)))
+	}
+	if err != nil {
+		// a trap or a timeout closes the instance for good
+		log.Printf("module %s disabled: %v", m.name, err)
+		m.mod = nil
+	}
+}
+
+func (m *wasmModule) Stop() {
+	m.Lock()
+	defer m.Unlock()
+	m.rt.Close(context.Background())
+	m.mod = nil
+}
@@ -0,0 +1,178 @@
+// Package msg builds messages step by step, checking them once they are
+// complete rather than leaving the callers to fill stanza.Message by hand:
+//
+//	m, err := msg.To(room).Type(msg.Groupchat).Body(text).ReplyTo(id).OOB(url).Build()
+package msg
+
+import (
+	"errors"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/oob"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Chat      = stanza.CHAT
+	Groupchat = stanza.GROUPCHAT
+	Normal    = stanza.NORMAL
+	Headline  = stanza.HEADLINE
+)
+
+var (
+	ErrNoRecipient = errors.New("msg: no recipient")
+	ErrType        = errors.New("msg: unknown message type")
+	ErrEmpty       = errors.New("msg: neither body nor extensions")
+	ErrGroupchat   = errors.New("msg: groupchat messages go to the bare room JID")
+	ErrOrigin      = errors.New("msg: origin-id needs an id")
+	ErrReply       = errors.New("msg: reply without the id replied to")
+)
+
+// Builder collects the parts of a message, the first error stops it and is
+// returned by Build.
+type Builder struct {
+	to      jid.JID
+	typ     string
+	id      string
+	origin  bool
+	body    string
+	reply   *stanza.Reply
+	quote   string
+	correct string
+	links   []oob.Data
+	ext     []interface{}
+	hints   []string
+	err     error
+}
+
+// To starts a message to the JID.
+func To(to jid.JID) *Builder {
+	return &Builder{to: to, typ: Normal}
+}
+
+// ToAddr starts a message to the address, it fails the build when malformed.
+func ToAddr(to string) *Builder {
+	j, err := jid.Parse(to)
+	return &Builder{to: j, typ: Normal, err: err}
+}
+
+func (b *Builder) Type(typ string) *Builder {
+	b.typ = typ
+	return b
+}
+
+func (b *Builder) ID(id string) *Builder {
+	b.id = id
+	return b
+}
+
+// Origin marks the message with its id as XEP-0359 origin-id.
+func (b *Builder) Origin() *Builder {
+	b.origin = true
+	return b
+}
+
+func (b *Builder) Body(body string) *Builder {
+	b.body = body
+	return b
+}
+
+// ReplyTo makes the message a reply to the message with the id, the
+// stanza-id the room gave it for groupchat.
+func (b *Builder) ReplyTo(id string) *Builder {
+	if b.reply == nil {
+		b.reply = &stanza.Reply{}
+	}
+	b.reply.ID = id
+	return b
+}
+
+// Quote names the sender of the message replied to and quotes it for the
+// clients not supporting replies.
+func (b *Builder) Quote(from, text string) *Builder {
+	if b.reply == nil {
+		b.reply = &stanza.Reply{}
+	}
+	b.reply.To, b.quote = from, text
+	return b
+}
+
+// Correct makes the message a correction of the last one sent with the id.
+func (b *Builder) Correct(id string) *Builder {
+	b.correct = id
+	return b
+}
+
+// OOB links a file to the message.
+func (b *Builder) OOB(url string) *Builder {
+	b.links = append(b.links, oob.Data{URL: url})
+	return b
+}
+
+// With adds an extension struct.
+func (b *Builder) With(v interface{}) *Builder {
+	b.ext = append(b.ext, v)
+	return b
+}
+
+// Hint adds XEP-0334 processing hints.
+func (b *Builder) Hint(hints ...string) *Builder {
+	b.hints = append(b.hints, hints...)
+	return b
+}
+
+func (b *Builder) check() error {
+	switch {
+	case b.err != nil:
+		return b.err
+	case b.to.IsZero():
+		return ErrNoRecipient
+	case b.typ != Chat && b.typ != Groupchat && b.typ != Normal && b.typ != Headline:
+		return ErrType
+	case b.typ == Groupchat && !b.to.IsBare():
+		return ErrGroupchat
+	case b.origin && b.id == "":
+		return ErrOrigin
+	case b.reply != nil && b.reply.ID == "":
+		return ErrReply
+	case b.body == "" && b.reply == nil && b.correct == "" && len(b.links) == 0 && len(b.ext) == 0:
+		return ErrEmpty
+	}
+	return nil
+}
+
+// Build checks the message and returns it.
+func (b *Builder) Build() (*stanza.Message, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	m := stanza.NewMessage(b.typ, b.to, b.body)
+	m.ID = b.id
+	if b.origin {
+		if err := m.SetOriginID(); err != nil {
+			return nil, err
+		}
+	}
+	if b.reply != nil {
+		if err := m.InReplyTo(b.reply.To, b.reply.ID, b.quote); err != nil {
+			return nil, err
+		}
+	}
+	if b.correct != "" {
+		if err := m.CorrectLast(b.correct); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range b.links {
+		if err := oob.Attach(m, l.URL, l.Desc); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range b.ext {
+		if err := m.With(v); err != nil {
+			return nil, err
+		}
+	}
+	m.Hint(b.hints...)
+	return m, nil
+}
@@ -0,0 +1,84 @@
+package muc
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const NsAdmin = "http://jabber.org/protocol/muc#admin"
+
+const (
+	NONE        = "none"
+	VISITOR     = "visitor"
+	PARTICIPANT = "participant"
+	MODERATOR   = "moderator"
+
+	OUTCAST = "outcast"
+	MEMBER  = "member"
+	ADMIN   = "admin"
+	OWNER   = "owner"
+)
+
+type Item struct {
+	Affiliation string `xml:"affiliation,attr,omitempty"`
+	Role        string `xml:"role,attr,omitempty"`
+	JID         string `xml:"jid,attr,omitempty"`
+	Nick        string `xml:"nick,attr,omitempty"`
+	Reason      string `xml:"reason,omitempty"`
+}
+
+type adminQuery struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/muc#admin query"`
+	Items   []Item   `xml:"item"`
+}
+
+// request sends the query to the room.
+func request(d *dispatch.Dispatcher, typ, room string, q interface{}) (*stanza.IQ, error) {
+	to, err := jid.Parse(room)
+	if err != nil {
+		return nil, err
+	}
+	iq, _ := stanza.NewIQ(typ, to, q)
+	return d.Request(iq)
+}
+
+func admin(d *dispatch.Dispatcher, room string, item Item) error {
+	_, err := request(d, stanza.SET, room, &adminQuery{Items: []Item{item}})
+	return err
+}
+
+// SetRole changes the role of the occupant with the given nick.
+func SetRole(d *dispatch.Dispatcher, room, nick, role, reason string) error {
+	return admin(d, room, Item{Nick: nick, Role: role, Reason: reason})
+}
+
+// SetAffiliation changes the affiliation of a bare JID with the room.
+func SetAffiliation(d *dispatch.Dispatcher, room, addr, affiliation, reason string) error {
+	return admin(d, room, Item{JID: jid.Bare(addr), Affiliation: affiliation, Reason: reason})
+}
+
+// Kick removes the occupant from the room.
+func Kick(d *dispatch.Dispatcher, room, nick, reason string) error {
+	return SetRole(d, room, nick, NONE, reason)
+}
+
+// Ban bans a bare JID from the room.
+func Ban(d *dispatch.Dispatcher, room, addr, reason string) error {
+	return SetAffiliation(d, room, addr, OUTCAST, reason)
+}
+
+// Affiliations lists the JIDs having the given affiliation with the room.
+func Affiliations(d *dispatch.Dispatcher, room, affiliation string) ([]Item, error) {
+	res, err := request(d, stanza.GET, room, &adminQuery{Items: []Item{{Affiliation: affiliation}}})
+	if err != nil {
+		return nil, err
+	}
+	q := &adminQuery{}
+	if err = res.Decode(q); err != nil {
+		return nil, err
+	}
+	return q.Items, nil
+}
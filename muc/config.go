@@ -0,0 +1,74 @@
+package muc
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	NsOwner      = "http://jabber.org/protocol/muc#owner"
+	NsRoomConfig = "http://jabber.org/protocol/muc#roomconfig"
+)
+
+type destroy struct {
+	XMLName xml.Name `xml:"destroy"`
+	JID     string   `xml:"jid,attr,omitempty"`
+	Reason  string   `xml:"reason,omitempty"`
+}
+
+type ownerQuery struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/muc#owner query"`
+	Form    *dataforms.Form
+	Destroy *destroy
+}
+
+// ConfigForm retrieves the configuration form of a room the bot owns.
+func ConfigForm(d *dispatch.Dispatcher, room string) (*dataforms.Form, error) {
+	res, err := request(d, stanza.GET, room, &ownerQuery{})
+	if err != nil {
+		return nil, err
+	}
+	q := &ownerQuery{}
+	if err = res.Decode(q); err != nil {
+		return nil, err
+	}
+	if q.Form == nil {
+		return nil, errors.New("muc: no configuration form for " + room)
+	}
+	return q.Form, nil
+}
+
+// Configure submits the room configuration, the current form is fetched and
+// the given values override it. Without values the room keeps its defaults,
+// which also unlocks a freshly created room as an instant one.
+func Configure(d *dispatch.Dispatcher, room string, values map[string][]string) error {
+	submit := &dataforms.Form{Type: dataforms.SUBMIT}
+	if len(values) > 0 {
+		f, err := ConfigForm(d, room)
+		if err != nil {
+			return err
+		}
+		submit = f.Submit(values)
+		submit.Set("FORM_TYPE", NsRoomConfig)
+	}
+	_, err := request(d, stanza.SET, room, &ownerQuery{Form: submit})
+	return err
+}
+
+// Create enters a new room as its owner and unlocks it with the given configuration.
+func Create(d *dispatch.Dispatcher, room, nick string, values map[string][]string) error {
+	if err := Join(room, nick, "")(d.Stream()); err != nil {
+		return err
+	}
+	return Configure(d, room, values)
+}
+
+// Destroy destroys a room the bot owns.
+func Destroy(d *dispatch.Dispatcher, room, reason string) error {
+	_, err := request(d, stanza.SET, room, &ownerQuery{Destroy: &destroy{Reason: reason}})
+	return err
+}
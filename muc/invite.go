@@ -0,0 +1,69 @@
+package muc
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+)
+
+const (
+	NsMUC        = "http://jabber.org/protocol/muc"
+	NsUser       = "http://jabber.org/protocol/muc#user"
+	NsConference = "jabber:x:conference"
+)
+
+// Invite is either a mediated (XEP-0045) or a direct (XEP-0249) room invitation.
+type Invite struct {
+	Room     string
+	From     string
+	Reason   string
+	Password string
+	Direct   bool
+}
+
+type inviteMessage struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr"`
+	Type    string   `xml:"type,attr"`
+	User    *struct {
+		Invite *struct {
+			From   string `xml:"from,attr"`
+			Reason string `xml:"reason"`
+		} `xml:"invite"`
+		Password string `xml:"password"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+	Conference *struct {
+		JID      string `xml:"jid,attr"`
+		Password string `xml:"password,attr"`
+		Reason   string `xml:"reason,attr"`
+	} `xml:"jabber:x:conference x"`
+}
+
+// ParseInvite extracts an invitation from a raw message stanza.
+func ParseInvite(raw []byte) (ret *Invite, ok bool) {
+	m := &inviteMessage{}
+	if err := xml.Unmarshal(raw, m); err != nil || m.Type == "error" {
+		return
+	}
+	switch {
+	case m.User != nil && m.User.Invite != nil:
+		ret = &Invite{Room: jid.Bare(m.From), From: m.User.Invite.From, Reason: m.User.Invite.Reason, Password: m.User.Password}
+	case m.Conference != nil && m.Conference.JID != "":
+		ret = &Invite{Room: m.Conference.JID, From: m.From, Reason: m.Conference.Reason, Password: m.Conference.Password, Direct: true}
+	default:
+		return
+	}
+	return ret, true
+}
+
+// Allowed reports whether the inviter's bare JID is on the list.
+func (i *Invite) Allowed(allow []string) bool {
+	from := jid.Bare(i.From)
+	for _, a := range allow {
+		if a = jid.Bare(strings.TrimSpace(a)); a != "" && a == from {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package muc
+
+import "testing"
+
+func TestInviteAllowed(t *testing.T) {
+	allow := []string{" Admin@Example.org ", "other@example.org"}
+	for from, want := range map[string]bool{
+		"admin@example.org/phone":   true,
+		"ADMIN@EXAMPLE.ORG/Phone":   true,
+		"admin@example.org.":        true,
+		"other@example.org/desktop": true,
+		"admin@example.com/phone":   false,
+		"@example.org/phone":        false,
+	} {
+		if got := (&Invite{From: from}).Allowed(allow); got != want {
+			t.Errorf("invite from %s allowed %v", from, got)
+		}
+	}
+}
+
+func TestParseInviteRoom(t *testing.T) {
+	raw := `<message from="Room@Conference.example.org"><x xmlns="http://jabber.org/protocol/muc#user">` +
+		`<invite from="admin@example.org/phone"/></x></message>`
+	i, ok := ParseInvite([]byte(raw))
+	if !ok || i.Room != "room@conference.example.org" || i.Direct {
+		t.Fatalf("invite %+v", i)
+	}
+}
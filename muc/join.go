@@ -0,0 +1,91 @@
+package muc
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+type history struct {
+	Since      *time.Time `xml:"since,attr,omitempty"`
+	MaxStanzas *int       `xml:"maxstanzas,attr,omitempty"`
+}
+
+// sinceHistory asks for the history since the given time, a zero time leaves
+// the amount of history to the room.
+func sinceHistory(since time.Time) *history {
+	if since.IsZero() {
+		return nil
+	}
+	since = since.UTC()
+	return &history{Since: &since}
+}
+
+// noHistory asks the room not to replay any history.
+func noHistory() *history {
+	none := 0
+	return &history{MaxStanzas: &none}
+}
+
+type mucX struct {
+	XMLName  xml.Name `xml:"http://jabber.org/protocol/muc x"`
+	History  *history `xml:"history"`
+	Password string   `xml:"password,omitempty"`
+}
+
+// Join enters a room under the given nick, password may be empty.
+func Join(room, nick, password string) func(stream.Stream) error {
+	return JoinSince(room, nick, password, time.Time{})
+}
+
+// JoinSince enters a room asking for the history since the given time, a
+// zero time leaves the amount of history to the room.
+func JoinSince(room, nick, password string, since time.Time) func(stream.Stream) error {
+	return join(room, nick, password, "", "", sinceHistory(since))
+}
+
+// occupant returns the JID of the nick in the room.
+func occupant(room, nick string) (jid.JID, error) {
+	return jid.Parse(units.Bare2Full(room, nick))
+}
+
+func join(room, nick, password, show, status string, h *history, ext ...stanza.Extension) func(stream.Stream) error {
+	return func(s stream.Stream) error {
+		to, err := occupant(room, nick)
+		if err != nil {
+			return err
+		}
+		p := stanza.NewPresence(stanza.AVAILABLE, to)
+		p.Show, p.Status = show, status
+		p.Extensions = append(p.Extensions, ext...)
+		if err := p.With(&mucX{Password: password, History: h}); err != nil {
+			return err
+		}
+		buf, err := stanza.Buffer(p)
+		if err != nil {
+			return err
+		}
+		return s.Write(buf)
+	}
+}
+
+// Leave exits the room.
+func Leave(room, nick, status string) func(stream.Stream) error {
+	return func(s stream.Stream) error {
+		to, err := occupant(room, nick)
+		if err != nil {
+			return err
+		}
+		p := stanza.NewPresence(stanza.UNAVAILABLE, to)
+		p.Status = status
+		buf, err := stanza.Buffer(p)
+		if err != nil {
+			return err
+		}
+		return s.Write(buf)
+	}
+}
@@ -0,0 +1,39 @@
+package muc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestJoin(t *testing.T) {
+	st := streamtest.New("example.org")
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := JoinSince("room@conference.example.org", "bot", "pass", since)(st); err != nil {
+		t.Fatal(err)
+	}
+	w := st.Written()
+	if len(w) != 1 {
+		t.Fatalf("%d stanzas written", len(w))
+	}
+	p := string(w[0])
+	for _, want := range []string{`to="room@conference.example.org/bot"`, `xmlns="http://jabber.org/protocol/muc"`,
+		`since="2020-01-02T03:04:05Z"`, "<password>pass</password>"} {
+		if !strings.Contains(p, want) {
+			t.Errorf("join %s lacks %s", p, want)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	st := streamtest.New("example.org")
+	if err := Leave("room@conference.example.org", "bot", "bye")(st); err != nil {
+		t.Fatal(err)
+	}
+	p := string(st.Written()[0])
+	if !strings.Contains(p, `type="unavailable"`) || !strings.Contains(p, "<status>bye</status>") {
+		t.Fatalf("leave is %s", p)
+	}
+}
@@ -0,0 +1,140 @@
+package muc
+
+import (
+	"encoding/xml"
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+// Occupant is a participant of a room as seen in its presence.
+type Occupant struct {
+	Nick        string
+	JID         string
+	Role        string
+	Affiliation string
+}
+
+// User is the muc#user element rooms add to the presences of occupants.
+type User struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/muc#user x"`
+	Item    Item     `xml:"item"`
+	Status  []struct {
+		Code int `xml:"code,attr"`
+	} `xml:"status"`
+}
+
+type userPresence struct {
+	XMLName xml.Name            `xml:"presence"`
+	From    string              `xml:"from,attr"`
+	Type    string              `xml:"type,attr"`
+	X       *User               `xml:"http://jabber.org/protocol/muc#user x"`
+	Error   *stanza.StanzaError `xml:"error"`
+}
+
+func (p *userPresence) conflict() bool {
+	return p.Error != nil && p.Error.Condition == stanza.Conflict
+}
+
+// Occupant returns the occupant with the given nick.
+func (r *Rooms) Occupant(room, nick string) (ret Occupant, ok bool) {
+	r.Lock()
+	defer r.Unlock()
+	if rm, found := r.rooms[room]; found && rm.occupants != nil {
+		var o *Occupant
+		if o, ok = rm.occupants[nick]; ok {
+			ret = *o
+		}
+	}
+	return
+}
+
+// Occupants returns a snapshot of the occupants of the room.
+func (r *Rooms) Occupants(room string) (ret []Occupant) {
+	r.Lock()
+	defer r.Unlock()
+	if rm, ok := r.rooms[room]; ok {
+		for _, o := range rm.occupants {
+			ret = append(ret, *o)
+		}
+	}
+	return
+}
+
+const (
+	StatusSelf     = 110
+	StatusCreated  = 201
+	StatusBanned   = 301
+	StatusKicked   = 307
+	StatusShutdown = 332
+)
+
+func (p *userPresence) has(code int) bool {
+	for _, s := range p.X.Status {
+		if s.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler tracks occupants of the joined rooms and rejoins the rooms the bot
+// was kicked from or lost, it never consumes the stanza.
+func (r *Rooms) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local == "message" && h.Type == stanza.GROUPCHAT && !h.Archived {
+			r.Lock()
+			if rm, ok := r.rooms[h.From.Bare().String()]; ok {
+				rm.lastSeen = time.Now()
+			}
+			r.Unlock()
+			return false
+		}
+		if name.Local != "presence" || h.From.IsBare() {
+			return false
+		}
+		p := &userPresence{}
+		if err := xml.Unmarshal(raw, p); err != nil || (p.X == nil && p.Type != "error") {
+			return false
+		}
+		room, nick := jid.Split(p.From)
+		r.Lock()
+		defer r.Unlock()
+		rm, ok := r.rooms[room]
+		if !ok {
+			return false
+		}
+		if p.Type == "error" {
+			if nick == rm.Nick && p.conflict() {
+				r.nextNick(d, rm)
+			}
+			return false
+		}
+		if rm.occupants == nil {
+			rm.occupants = make(map[string]*Occupant)
+		}
+		self := nick == rm.Nick || p.has(StatusSelf)
+		if p.Type == "unavailable" {
+			delete(rm.occupants, nick)
+			if self {
+				log.Println("left", room, "with status", p.X.Status)
+				rm.joined = false
+				go r.rejoin(d, room)
+			}
+		} else {
+			rm.occupants[nick] = &Occupant{Nick: nick, JID: p.X.Item.JID, Role: p.X.Item.Role, Affiliation: p.X.Item.Affiliation}
+			if self {
+				rm.joined = true
+				rm.conflicts = 0
+			}
+		}
+		return false
+	}
+}
+
+func init() {
+	stanza.Register(NsUser, "x", func() interface{} { return &User{} })
+}
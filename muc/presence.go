@@ -0,0 +1,77 @@
+package muc
+
+import (
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+// Presence is a step sending presence to all tracked rooms at once, for the
+// initial joins as well as after a reconnect or a stream resumption, when
+// rooms may have dropped the bot. The joins are then verified by self-ping in
+// the background and the rooms failing it are rejoined.
+func (r *Rooms) Presence(d *dispatch.Dispatcher) func(stream.Stream) error {
+	return func(s stream.Stream) error {
+		r.Lock()
+		joins := []func(stream.Stream) error{}
+		list := []*Room{}
+		for _, room := range r.rooms {
+			joins = append(joins, r.join(room, true))
+			list = append(list, room)
+		}
+		r.Unlock()
+		for _, join := range joins {
+			if err := join(s); err != nil {
+				return err
+			}
+		}
+		go r.verify(d, list)
+		return nil
+	}
+}
+
+func (r *Rooms) verify(d *dispatch.Dispatcher, list []*Room) {
+	// give the rooms time to answer and the bot loop time to start
+	time.Sleep(MinRejoinDelay)
+	for _, room := range list {
+		if _, ok := r.Get(room.JID); ok && !SelfPing(d, room) {
+			log.Println("presence in", room.JID, "not confirmed")
+			r.Lock()
+			room.joined = false
+			r.Unlock()
+			go r.rejoin(d, room.JID)
+		}
+	}
+}
+
+// Update is a step changing the show and status in all joined rooms, without
+// the MUC x a rejoin would carry.
+func (r *Rooms) Update(show, status string) func(stream.Stream) error {
+	return func(s stream.Stream) error {
+		r.Lock()
+		r.Show, r.Status = show, status
+		list := []*stanza.Presence{}
+		for _, room := range r.rooms {
+			if to, err := occupant(room.JID, room.Nick); err == nil && room.joined {
+				p := stanza.NewPresence(stanza.AVAILABLE, to)
+				p.Show, p.Status = show, status
+				p.Extensions = append(p.Extensions, r.Extensions...)
+				list = append(list, p)
+			}
+		}
+		r.Unlock()
+		for _, p := range list {
+			buf, err := stanza.Buffer(p)
+			if err != nil {
+				return err
+			}
+			if err = s.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
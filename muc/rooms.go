@@ -0,0 +1,175 @@
+package muc
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const (
+	MinRejoinDelay = 5 * time.Second
+	MaxRejoinDelay = 5 * time.Minute
+)
+
+// Room is a room the bot has joined.
+type Room struct {
+	JID string
+	// Nick is the nick the bot has in the room, it differs from the wanted one
+	// when that was taken.
+	Nick     string
+	Password string
+
+	occupants map[string]*Occupant
+	joined    bool
+	rejoining bool
+	lastSeen  time.Time
+	base      string
+	conflicts int
+}
+
+// Rooms keeps track of the joined rooms.
+type Rooms struct {
+	sync.Mutex
+	// History makes rejoins request the history missed since the last seen message.
+	History bool
+	// SkipHistory asks the rooms not to replay their history on join, so old
+	// messages aren't taken for new ones. History still applies to rejoins.
+	SkipHistory bool
+	// Suffixes are appended in turn to the wanted nick when it is taken.
+	Suffixes []string
+	// Show and Status are sent with the presence to the rooms.
+	Show   string
+	Status string
+	// Extensions are added to the presence sent to the rooms, e.g. caps.
+	Extensions []stanza.Extension
+	rooms      map[string]*Room
+}
+
+func NewRooms() *Rooms {
+	return &Rooms{rooms: make(map[string]*Room), Suffixes: []string{"_", "2", "3"}}
+}
+
+func (r *Rooms) Add(room *Room) {
+	r.Lock()
+	r.rooms[room.JID] = room
+	r.Unlock()
+}
+
+// Nick returns the nick the bot has in the room.
+func (r *Rooms) Nick(jid string) string {
+	r.Lock()
+	defer r.Unlock()
+	if room, ok := r.rooms[jid]; ok {
+		return room.Nick
+	}
+	return ""
+}
+
+// nextNick retries the join with the next nick after a conflict, it is called with the lock held.
+func (r *Rooms) nextNick(d *dispatch.Dispatcher, room *Room) {
+	if room.base == "" {
+		room.base = room.Nick
+	}
+	if room.conflicts >= len(r.Suffixes) {
+		log.Println("giving up on", room.JID, "all nicks are taken")
+		return
+	}
+	room.Nick = room.base + r.Suffixes[room.conflicts]
+	room.conflicts++
+	log.Println("nick conflict in", room.JID, "retrying as", room.Nick)
+	join := r.join(room, false)
+	go func() {
+		if err := join(d.Stream()); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+func (r *Rooms) Remove(jid string) {
+	r.Lock()
+	delete(r.rooms, jid)
+	r.Unlock()
+}
+
+func (r *Rooms) Get(jid string) (ret *Room, ok bool) {
+	r.Lock()
+	ret, ok = r.rooms[jid]
+	r.Unlock()
+	return
+}
+
+// List returns the joined rooms ordered by JID.
+func (r *Rooms) List() (ret []*Room) {
+	r.Lock()
+	for _, room := range r.rooms {
+		ret = append(ret, room)
+	}
+	r.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].JID < ret[j].JID })
+	return
+}
+
+// Join builds the join step of an added room with the settings of the rooms.
+func (r *Rooms) Join(jid string) func(stream.Stream) error {
+	r.Lock()
+	defer r.Unlock()
+	room, ok := r.rooms[jid]
+	if !ok {
+		return func(stream.Stream) error { return errors.New("unknown room " + jid) }
+	}
+	return r.join(room, false)
+}
+
+// join builds the join step of a room, it is called with the lock held.
+func (r *Rooms) join(room *Room, rejoin bool) func(stream.Stream) error {
+	var h *history
+	if rejoin && r.History {
+		h = sinceHistory(room.lastSeen)
+	}
+	if h == nil && r.SkipHistory {
+		h = noHistory()
+	}
+	return join(room.JID, room.Nick, room.Password, r.Show, r.Status, h, r.Extensions...)
+}
+
+// rejoin keeps rejoining the room with a growing delay until the room
+// confirms our presence or the room is removed.
+func (r *Rooms) rejoin(d *dispatch.Dispatcher, jid string) {
+	r.Lock()
+	room, ok := r.rooms[jid]
+	if !ok || room.rejoining {
+		r.Unlock()
+		return
+	}
+	room.rejoining = true
+	r.Unlock()
+	defer func() {
+		r.Lock()
+		room.rejoining = false
+		r.Unlock()
+	}()
+	for delay := MinRejoinDelay; ; delay *= 2 {
+		if delay > MaxRejoinDelay {
+			delay = MaxRejoinDelay
+		}
+		time.Sleep(delay)
+		r.Lock()
+		cur, ok := r.rooms[jid]
+		done := !ok || cur != room || room.joined
+		join := r.join(room, true)
+		r.Unlock()
+		if done {
+			return
+		}
+		log.Println("rejoining", jid)
+		if err := join(d.Stream()); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package muc
+
+import (
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/ping"
+	"github.com/kpmy/xep/stanza"
+)
+
+// SelfPing checks whether the bot is still an occupant of the room by pinging
+// its own occupant JID, as described in XEP-0410.
+func SelfPing(d *dispatch.Dispatcher, room *Room) bool {
+	to, err := occupant(room.JID, room.Nick)
+	if err == nil {
+		err = ping.Ping(d, to)
+	}
+	if err == nil {
+		return true
+	}
+	switch stanza.ErrorCondition(err) {
+	case stanza.ServiceUnavailable, stanza.FeatureNotImplemented, stanza.ItemNotFound:
+		// routed to our client by the room, so we are still there
+		return true
+	case stanza.RemoteServerNotFound, stanza.RemoteServerTimeout:
+		// the room is unreachable, rejoining won't help
+		return true
+	}
+	return false
+}
+
+// KeepAlive self-pings every joined room each interval and rejoins the rooms
+// the bot has silently dropped out of, until stop is closed.
+func (r *Rooms) KeepAlive(d *dispatch.Dispatcher, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			for _, room := range r.List() {
+				if !SelfPing(d, room) {
+					log.Println("self-ping failed, rejoining", room.JID)
+					r.Lock()
+					join := r.join(room, true)
+					r.Unlock()
+					if err := join(d.Stream()); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
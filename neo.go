@@ -56,6 +56,9 @@ func neo_server(wg *sync.WaitGroup) {
 		}
 		return 500, err
 	})
+	apiRoutes(app)
+	avatarRoutes(app)
+	hookRoutes(app)
 	app.Start()
 	wg.Done()
 }
@@ -0,0 +1,144 @@
+package omemo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+)
+
+var (
+	errMAC     = errors.New("omemo: bad mac")
+	errPadding = errors.New("omemo: bad padding")
+	errKey     = errors.New("omemo: bad key")
+)
+
+var zeroSalt = make([]byte, 32)
+
+// hkdf is HKDF-SHA-256 (RFC 5869).
+func hkdf(ikm, salt []byte, info string, n int) []byte {
+	ext := hmac.New(sha256.New, salt)
+	ext.Write(ikm)
+	prk := ext.Sum(nil)
+	var ret, t []byte
+	for i := byte(1); len(ret) < n; i++ {
+		exp := hmac.New(sha256.New, prk)
+		exp.Write(t)
+		exp.Write([]byte(info))
+		exp.Write([]byte{i})
+		t = exp.Sum(nil)
+		ret = append(ret, t...)
+	}
+	return ret[:n]
+}
+
+func mac(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// material derives the AES-256 key, the HMAC key and the IV from a key.
+func material(key []byte, info string) (enc, auth, iv []byte) {
+	m := hkdf(key, zeroSalt, info, 80)
+	return m[:32], m[32:64], m[64:]
+}
+
+func encryptCBC(key, iv, plain []byte) []byte {
+	b, _ := aes.NewCipher(key)
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(b, iv).CryptBlocks(data, data)
+	return data
+}
+
+func decryptCBC(key, iv, data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errPadding
+	}
+	b, _ := aes.NewCipher(key)
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(b, iv).CryptBlocks(plain, data)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errPadding
+	}
+	for _, p := range plain[len(plain)-pad:] {
+		if int(p) != pad {
+			return nil, errPadding
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+var p25519, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+func reverse(b []byte) []byte {
+	ret := make([]byte, len(b))
+	for i := range b {
+		ret[len(b)-1-i] = b[i]
+	}
+	return ret
+}
+
+// montgomery converts an Ed25519 public key to the X25519 one of the same
+// secret, u = (1 + y) / (1 - y).
+func montgomery(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errKey
+	}
+	le := append([]byte{}, pub...)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, p25519)
+	if den.Sign() == 0 {
+		return nil, errKey
+	}
+	u := num.Mul(num, den.ModInverse(den, p25519))
+	u.Mod(u, p25519)
+	b := u.Bytes()
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+// montgomeryPrivate returns the X25519 key of an Ed25519 private key.
+func montgomeryPrivate(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(priv.Seed())
+	s := h[:32]
+	s[0] &= 248
+	s[31] &= 127
+	s[31] |= 64
+	return ecdh.X25519().NewPrivateKey(s)
+}
+
+func generate() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+func dh(priv *ecdh.PrivateKey, pub []byte) ([]byte, error) {
+	p, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(p)
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,616 @@
+// Package omemo is XEP-0384 OMEMO end-to-end encryption of chat messages in
+// its urn:xmpp:omemo:2 version: the device list and key bundle of the bot on
+// PEP, X3DH session establishment and the double ratchet, on the standard
+// library alone. Devices are trusted on first use and pinned after that; the
+// older eu.siacs.conversations.axolotl version isn't supported.
+package omemo
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/pep"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns        = "urn:xmpp:omemo:2"
+	NsDevices = "urn:xmpp:omemo:2:devices"
+	NsBundles = "urn:xmpp:omemo:2:bundles"
+	NsSCE     = "urn:xmpp:sce:1"
+	NsEME     = "urn:xmpp:eme:0"
+
+	// preKeys is how many one-time prekeys the bundle offers.
+	preKeys = 100
+	// Fallback is the body for clients without OMEMO.
+	Fallback = "This message is OMEMO encrypted."
+)
+
+var (
+	ErrNotForUs  = errors.New("omemo: message not encrypted for this device")
+	ErrNoSession = errors.New("omemo: no session with the device")
+	ErrNoDevices = errors.New("omemo: no device to encrypt for")
+	ErrUntrusted = errors.New("omemo: identity key of the device changed")
+)
+
+// Devices is the device list published on the devices node.
+type Devices struct {
+	XMLName xml.Name `xml:"urn:xmpp:omemo:2 devices"`
+	Devices []Device `xml:"device"`
+}
+
+type Device struct {
+	ID    uint32 `xml:"id,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type key struct {
+	ID   uint32 `xml:"id,attr"`
+	Data string `xml:",chardata"`
+}
+
+type bundle struct {
+	XMLName xml.Name `xml:"urn:xmpp:omemo:2 bundle"`
+	SPK     key      `xml:"spk"`
+	SPKS    string   `xml:"spks"`
+	IK      string   `xml:"ik"`
+	PreKeys []key    `xml:"prekeys>pk"`
+}
+
+// Encrypted is the encrypted element of a message.
+type Encrypted struct {
+	XMLName xml.Name `xml:"urn:xmpp:omemo:2 encrypted"`
+	Header  struct {
+		SID  uint32 `xml:"sid,attr"`
+		Keys []Keys `xml:"keys"`
+	} `xml:"header"`
+	Payload string `xml:"payload,omitempty"`
+}
+
+// Keys holds the message key encrypted for the devices of one JID.
+type Keys struct {
+	JID  string `xml:"jid,attr"`
+	Keys []Key  `xml:"key"`
+}
+
+type Key struct {
+	RID  uint32 `xml:"rid,attr"`
+	Kex  bool   `xml:"kex,attr,omitempty"`
+	Data string `xml:",chardata"`
+}
+
+type envelope struct {
+	XMLName xml.Name `xml:"urn:xmpp:sce:1 envelope"`
+	Content struct {
+		Body string `xml:"jabber:client body"`
+	} `xml:"content"`
+	RPad string `xml:"rpad,omitempty"`
+	From *struct {
+		JID string `xml:"jid,attr"`
+	} `xml:"from"`
+}
+
+type eme struct {
+	XMLName   xml.Name `xml:"urn:xmpp:eme:0 encryption"`
+	Namespace string   `xml:"namespace,attr"`
+	Name      string   `xml:"name,attr"`
+}
+
+// State is what the store keeps between runs, private keys included.
+type State struct {
+	DeviceID   uint32
+	Identity   []byte
+	SPKID      uint32
+	SPK        []byte
+	SPKSig     []byte
+	PreKeys    map[uint32][]byte
+	NextPreKey uint32
+	// Sessions and Trusted are keyed by bare JID and device id.
+	Sessions map[string]*Session
+	Trusted  map[string][]byte
+}
+
+// Store persists the state, Load returns nil for none.
+type Store interface {
+	Load() (*State, error)
+	Save(*State) error
+}
+
+type Manager struct {
+	// JID is the bare JID of the account.
+	JID     string
+	store   Store
+	mu      sync.Mutex
+	st      *State
+	devices map[string][]uint32
+}
+
+func sessionKey(addr string, id uint32) string {
+	return jid.Bare(addr) + "/" + strconv.FormatUint(uint64(id), 10)
+}
+
+// New loads the state of the store, generating the device id and keys the
+// first time.
+func New(addr string, s Store) (*Manager, error) {
+	st, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{JID: jid.Bare(addr), store: s, st: st, devices: make(map[string][]uint32)}
+	if st == nil {
+		if m.st, err = newState(); err != nil {
+			return nil, err
+		}
+		if err = s.Save(m.st); err != nil {
+			return nil, err
+		}
+	}
+	if m.st.Sessions == nil {
+		m.st.Sessions = make(map[string]*Session)
+	}
+	if m.st.Trusted == nil {
+		m.st.Trusted = make(map[string][]byte)
+	}
+	return m, nil
+}
+
+func newState() (*State, error) {
+	_, id, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	spk, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	st := &State{
+		DeviceID: binary.BigEndian.Uint32(random(4))&0x7fffffff | 1,
+		Identity: id,
+		SPKID:    1,
+		SPK:      spk.Bytes(),
+		SPKSig:   ed25519.Sign(id, spk.PublicKey().Bytes()),
+		PreKeys:  make(map[uint32][]byte),
+	}
+	return st, st.fill()
+}
+
+// fill tops the one-time prekeys up.
+func (st *State) fill() error {
+	for len(st.PreKeys) < preKeys {
+		pk, err := generate()
+		if err != nil {
+			return err
+		}
+		st.NextPreKey++
+		st.PreKeys[st.NextPreKey] = pk.Bytes()
+	}
+	return nil
+}
+
+func (m *Manager) identity() ed25519.PrivateKey {
+	return ed25519.PrivateKey(m.st.Identity)
+}
+
+// DeviceID is the id of the bot's device.
+func (m *Manager) DeviceID() uint32 {
+	return m.st.DeviceID
+}
+
+// Fingerprint is the identity key of the bot in hex, for comparing it with
+// the one the clients show.
+func (m *Manager) Fingerprint() string {
+	return hex.EncodeToString(m.identity().Public().(ed25519.PublicKey))
+}
+
+func (m *Manager) save() {
+	if err := m.store.Save(m.st); err != nil {
+		log.Println("omemo:", err)
+	}
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+}
+
+func public(priv []byte) []byte {
+	k, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil
+	}
+	return k.PublicKey().Bytes()
+}
+
+// Publish publishes the bundle of the bot and adds its device to the device
+// list of the account.
+func (m *Manager) Publish(d *dispatch.Dispatcher) error {
+	m.mu.Lock()
+	b := &bundle{
+		SPK:  key{ID: m.st.SPKID, Data: b64(public(m.st.SPK))},
+		SPKS: b64(m.st.SPKSig),
+		IK:   b64(m.identity().Public().(ed25519.PublicKey)),
+	}
+	for id, pk := range m.st.PreKeys {
+		b.PreKeys = append(b.PreKeys, key{ID: id, Data: b64(public(pk))})
+	}
+	m.mu.Unlock()
+	_, err := pubsub.Publish(d, "", NsBundles, strconv.FormatUint(uint64(m.DeviceID()), 10), b, map[string]string{
+		"pubsub#max_items":    "max",
+		"pubsub#access_model": "open",
+	})
+	if err != nil {
+		return err
+	}
+	list := &Devices{}
+	if i, err := pep.Last(d, "", NsDevices); err == nil && i != nil {
+		i.Decode(list)
+	}
+	for _, dev := range list.Devices {
+		if dev.ID == m.DeviceID() {
+			return nil
+		}
+	}
+	list.Devices = append(list.Devices, Device{ID: m.DeviceID(), Label: "bot"})
+	return pep.PublishOpen(d, NsDevices, list)
+}
+
+// Update takes the device lists from the notifications of the devices node,
+// putting the bot's device back when another client dropped it.
+func (m *Manager) Update(d *dispatch.Dispatcher, from string, e *pubsub.Event) {
+	if e.Node != NsDevices || len(e.Items) == 0 {
+		return
+	}
+	list := &Devices{}
+	if e.Items[len(e.Items)-1].Decode(list) != nil {
+		return
+	}
+	ids := make([]uint32, 0, len(list.Devices))
+	for _, dev := range list.Devices {
+		ids = append(ids, dev.ID)
+	}
+	addr := jid.Bare(from)
+	if addr == "" {
+		addr = m.JID
+	}
+	m.mu.Lock()
+	m.devices[addr] = ids
+	m.mu.Unlock()
+	if addr != m.JID {
+		return
+	}
+	for _, id := range ids {
+		if id == m.DeviceID() {
+			return
+		}
+	}
+	go func() {
+		if err := m.Publish(d); err != nil {
+			log.Println("omemo:", err)
+		}
+	}()
+}
+
+// Devices returns the device ids of the JID, from the notifications or
+// fetched.
+func (m *Manager) Devices(d *dispatch.Dispatcher, addr string) ([]uint32, error) {
+	addr = jid.Bare(addr)
+	m.mu.Lock()
+	ids, ok := m.devices[addr]
+	m.mu.Unlock()
+	if ok {
+		return ids, nil
+	}
+	i, err := pep.Last(d, addr, NsDevices)
+	if err != nil || i == nil {
+		return nil, err
+	}
+	list := &Devices{}
+	if err := i.Decode(list); err != nil {
+		return nil, err
+	}
+	for _, dev := range list.Devices {
+		ids = append(ids, dev.ID)
+	}
+	m.mu.Lock()
+	m.devices[addr] = ids
+	m.mu.Unlock()
+	return ids, nil
+}
+
+// FetchBundle fetches the bundle of the device and picks one of its
+// one-time prekeys.
+func FetchBundle(d *dispatch.Dispatcher, addr string, id uint32) (*Bundle, error) {
+	items, err := pubsub.Items(d, addr, NsBundles, 0, strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("omemo: no bundle for %s/%d", addr, id)
+	}
+	b := &bundle{}
+	if err := items[0].Decode(b); err != nil {
+		return nil, err
+	}
+	if len(b.PreKeys) == 0 {
+		return nil, fmt.Errorf("omemo: no prekeys for %s/%d", addr, id)
+	}
+	pk := b.PreKeys[mrand.Intn(len(b.PreKeys))]
+	ret := &Bundle{SPKID: b.SPK.ID, PKID: pk.ID}
+	for _, f := range []struct {
+		dst *[]byte
+		src string
+	}{{(*[]byte)(&ret.Identity), b.IK}, {&ret.SPK, b.SPK.Data}, {&ret.SPKSig, b.SPKS}, {&ret.PK, pk.Data}} {
+		if *f.dst, err = unb64(f.src); err != nil {
+			return nil, err
+		}
+	}
+	if len(ret.SPK) != 32 || len(ret.PK) != 32 {
+		return nil, errKey
+	}
+	return ret, nil
+}
+
+// trust pins the identity key of the device on first use, the lock is held.
+func (m *Manager) trust(k string, ik []byte) error {
+	if pinned, ok := m.st.Trusted[k]; ok && !hmac.Equal(pinned, ik) {
+		return ErrUntrusted
+	}
+	m.st.Trusted[k] = append([]byte{}, ik...)
+	return nil
+}
+
+// Encrypt encrypts the body for the devices of the JID, building sessions
+// with the ones new to the bot.
+func (m *Manager) Encrypt(d *dispatch.Dispatcher, to, body string) (*Encrypted, error) {
+	addr := jid.Bare(to)
+	ids, err := m.Devices(d, addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		k := sessionKey(addr, id)
+		m.mu.Lock()
+		_, ok := m.st.Sessions[k]
+		m.mu.Unlock()
+		if ok {
+			continue
+		}
+		s, err := m.initiate(d, addr, id)
+		if err != nil {
+			log.Println("omemo:", k, err)
+			continue
+		}
+		m.mu.Lock()
+		if err := m.trust(k, s.Identity); err != nil {
+			log.Println("omemo:", k, err)
+		} else {
+			m.st.Sessions[k] = s
+		}
+		m.mu.Unlock()
+	}
+	env := &envelope{RPad: b64(random(mrand.Intn(32)))}
+	env.Content.Body = body
+	env.From = &struct {
+		JID string `xml:"jid,attr"`
+	}{m.JID}
+	plain, err := xml.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	mk := random(32)
+	enc, auth, iv := material(mk, "OMEMO Payload")
+	ct := encryptCBC(enc, iv, plain)
+	keyMat := append(mk, mac(auth, ct)[:16]...)
+	e := &Encrypted{Payload: b64(ct)}
+	e.Header.SID = m.DeviceID()
+	keys := Keys{JID: addr}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		s, ok := m.st.Sessions[sessionKey(addr, id)]
+		if !ok {
+			continue
+		}
+		msg, kex, err := s.Encrypt(keyMat)
+		if err != nil {
+			return nil, err
+		}
+		keys.Keys = append(keys.Keys, Key{RID: id, Kex: kex, Data: b64(msg)})
+	}
+	if len(keys.Keys) == 0 {
+		return nil, ErrNoDevices
+	}
+	e.Header.Keys = []Keys{keys}
+	m.save()
+	return e, nil
+}
+
+func (m *Manager) initiate(d *dispatch.Dispatcher, addr string, id uint32) (*Session, error) {
+	b, err := FetchBundle(d, addr, id)
+	if err != nil {
+		return nil, err
+	}
+	return initiate(m.identity(), b)
+}
+
+// Message makes an encrypted message with the fallback body.
+func (m *Manager) Message(d *dispatch.Dispatcher, typ, to, body string) (*stanza.Message, error) {
+	addr, err := jid.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+	e, err := m.Encrypt(d, to, body)
+	if err != nil {
+		return nil, err
+	}
+	msg := stanza.NewMessage(typ, addr, Fallback)
+	msg.With(e)
+	msg.With(&eme{Namespace: Ns, Name: "OMEMO"})
+	msg.Hint(stanza.Store)
+	return msg, nil
+}
+
+// Decrypt decrypts the element of a message from the JID, used tells that a
+// one-time prekey went and the bundle should be published again. An empty
+// body comes with messages that only move the ratchet on.
+func (m *Manager) Decrypt(from string, e *Encrypted) (body string, used bool, err error) {
+	addr := jid.Bare(from)
+	var own *Key
+	for _, ks := range e.Header.Keys {
+		if jid.Bare(ks.JID) != m.JID {
+			continue
+		}
+		for i, k := range ks.Keys {
+			if k.RID == m.DeviceID() {
+				own = &ks.Keys[i]
+			}
+		}
+	}
+	if own == nil {
+		return "", false, ErrNotForUs
+	}
+	data, err := unb64(own.Data)
+	if err != nil {
+		return "", false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := sessionKey(addr, e.Header.SID)
+	var keyMat []byte
+	if own.Kex {
+		if keyMat, used, err = m.accept(k, data); err != nil {
+			return "", false, err
+		}
+	} else {
+		s, ok := m.st.Sessions[k]
+		if !ok {
+			return "", false, ErrNoSession
+		}
+		if keyMat, err = s.Decrypt(data); err != nil {
+			return "", false, err
+		}
+	}
+	m.save()
+	if e.Payload == "" {
+		return "", used, nil
+	}
+	if len(keyMat) != 48 {
+		return "", used, errKey
+	}
+	ct, err := unb64(e.Payload)
+	if err != nil {
+		return "", used, err
+	}
+	enc, auth, iv := material(keyMat[:32], "OMEMO Payload")
+	if !hmac.Equal(mac(auth, ct)[:16], keyMat[32:]) {
+		return "", used, errMAC
+	}
+	plain, err := decryptCBC(enc, iv, ct)
+	if err != nil {
+		return "", used, err
+	}
+	env := &envelope{}
+	if err := xml.Unmarshal(plain, env); err != nil {
+		return "", used, err
+	}
+	if env.From == nil || jid.Bare(env.From.JID) != addr {
+		return "", used, errors.New("omemo: envelope from another sender")
+	}
+	return env.Content.Body, used, nil
+}
+
+// accept takes a key exchange, the device repeats it until it hears back so
+// it may be for the session already built. The lock is held.
+func (m *Manager) accept(k string, data []byte) ([]byte, bool, error) {
+	kx, err := parseKeyExchange(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if s, ok := m.st.Sessions[k]; ok && hmac.Equal(s.Identity, kx.IK) {
+		if plain, err := s.Decrypt(kx.Message); err == nil {
+			return plain, false, nil
+		}
+	}
+	if err := m.trust(k, kx.IK); err != nil {
+		return nil, false, err
+	}
+	pkb, ok := m.st.PreKeys[kx.PKID]
+	if !ok || kx.SPKID != m.st.SPKID {
+		return nil, false, errors.New("omemo: unknown prekey")
+	}
+	spk, err := ecdh.X25519().NewPrivateKey(m.st.SPK)
+	if err != nil {
+		return nil, false, err
+	}
+	pk, err := ecdh.X25519().NewPrivateKey(pkb)
+	if err != nil {
+		return nil, false, err
+	}
+	s, err := respond(m.identity(), spk, pk, kx)
+	if err != nil {
+		return nil, false, err
+	}
+	plain, err := s.Decrypt(kx.Message)
+	if err != nil {
+		return nil, false, err
+	}
+	m.st.Sessions[k] = s
+	delete(m.st.PreKeys, kx.PKID)
+	return plain, true, m.st.fill()
+}
+
+// Handler decrypts the encrypted chat messages and passes their bodies to
+// fn, consuming the messages.
+func (m *Manager) Handler(d *dispatch.Dispatcher, fn func(from, body string)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR || h.Type == stanza.GROUPCHAT {
+			return false
+		}
+		msg := &stanza.Message{}
+		if xml.Unmarshal(raw, msg) != nil {
+			return false
+		}
+		x := msg.Ext(Ns, "encrypted")
+		if x == nil {
+			return false
+		}
+		e := &Encrypted{}
+		if err := x.Decode(e); err != nil {
+			log.Println("omemo:", err)
+			return true
+		}
+		body, used, err := m.Decrypt(h.From.String(), e)
+		if used {
+			go func() {
+				if err := m.Publish(d); err != nil {
+					log.Println("omemo:", err)
+				}
+			}()
+		}
+		switch {
+		case err != nil:
+			log.Println("omemo: message from", h.From, err)
+		case body != "" && !h.Archived && msg.Delay == nil:
+			fn(h.From.String(), body)
+		}
+		return true
+	}
+}
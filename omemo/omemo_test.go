@@ -0,0 +1,155 @@
+package omemo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestRoundTrip(t *testing.T) {
+	enc := &Encrypted{Payload: "PAYLOAD"}
+	enc.Header.SID = 27183
+	enc.Header.Keys = []Keys{{JID: "juliet@example.org", Keys: []Key{{RID: 31415, Kex: true, Data: "KEX"}, {RID: 12321, Data: "KEY"}}}}
+	env := &envelope{RPad: "PAD", From: &struct {
+		JID string `xml:"jid,attr"`
+	}{"romeo@example.net"}}
+	env.Content.Body = "Hello"
+	for _, tc := range []struct {
+		raw string
+		v   interface{}
+	}{
+		{`<devices xmlns="urn:xmpp:omemo:2"><device id="12345"/><device id="4223" label="Gajim on Ubuntu Linux"/></devices>`,
+			&Devices{Devices: []Device{{ID: 12345}, {ID: 4223, Label: "Gajim on Ubuntu Linux"}}}},
+		{`<bundle xmlns="urn:xmpp:omemo:2"><spk id="0">SPK</spk><spks>SPKS</spks><ik>IK</ik>` +
+			`<prekeys><pk id="1">PK1</pk><pk id="2">PK2</pk></prekeys></bundle>`,
+			&bundle{SPK: key{0, "SPK"}, SPKS: "SPKS", IK: "IK", PreKeys: []key{{1, "PK1"}, {2, "PK2"}}}},
+		{`<encrypted xmlns="urn:xmpp:omemo:2"><header sid="27183"><keys jid="juliet@example.org">` +
+			`<key rid="31415" kex="true">KEX</key><key rid="12321">KEY</key></keys></header><payload>PAYLOAD</payload></encrypted>`, enc},
+		{`<envelope xmlns="urn:xmpp:sce:1"><content><body xmlns="jabber:client">Hello</body></content>` +
+			`<rpad>PAD</rpad><from jid="romeo@example.net"/></envelope>`, env},
+		{`<encryption xmlns="urn:xmpp:eme:0" namespace="urn:xmpp:omemo:2" name="OMEMO"/>`, &eme{Namespace: Ns, Name: "OMEMO"}},
+	} {
+		typ := reflect.TypeOf(tc.v).Elem()
+		got := reflect.New(typ).Interface()
+		if err := xml.Unmarshal([]byte(tc.raw), got); err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		b, err := xml.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := reflect.New(typ).Interface()
+		if err := xml.Unmarshal(b, again); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		// the XMLName is the only field set by parsing alone
+		reflect.ValueOf(tc.v).Elem().Field(0).Set(reflect.ValueOf(got).Elem().Field(0))
+		if !reflect.DeepEqual(got, tc.v) || !reflect.DeepEqual(again, tc.v) {
+			t.Errorf("%s parsed %+v, after a round trip %+v", tc.raw, got, again)
+		}
+	}
+}
+
+type memStore struct{ st *State }
+
+func (s *memStore) Load() (*State, error) { return s.st, nil }
+func (s *memStore) Save(st *State) error  { s.st = st; return nil }
+
+// serve answers every iq written on st with an empty result.
+func serve(st *streamtest.Stream) *dispatch.Dispatcher {
+	st.On(streamtest.Element("iq"), `<iq type="result" id="{id}"/>`)
+	d := dispatch.New(st)
+	go st.Ring(func(b *bytes.Buffer) bool {
+		d.Feed(b.Bytes())
+		return false
+	}, 5*time.Second)
+	return d
+}
+
+func TestSession(t *testing.T) {
+	const alice, bob = "alice@example.org", "bob@example.org"
+	a, err := New(alice+"/bot", &memStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(bob, &memStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bundle bob publishes is the one alice fetches
+	bs := streamtest.New("example.org")
+	bd := serve(bs)
+	if err := b.Publish(bd); err != nil {
+		t.Fatal(err)
+	}
+	var published []byte
+	for _, w := range bs.Written() {
+		if i, j := bytes.Index(w, []byte("<bundle")), bytes.Index(w, []byte("</bundle>")); i >= 0 && j > i {
+			published = w[i : j+len("</bundle>")]
+		}
+	}
+	if published == nil {
+		t.Fatalf("no bundle published in %q", bs.Written())
+	}
+	bid := strconv.FormatUint(uint64(b.DeviceID()), 10)
+	as := streamtest.New("example.org")
+	as.On(streamtest.Contains(NsDevices), `<iq type="result" id="{id}" from="`+bob+`"><pubsub xmlns="http://jabber.org/protocol/pubsub">`+
+		`<items node="`+NsDevices+`"><item id="current"><devices xmlns="urn:xmpp:omemo:2"><device id="`+bid+`"/></devices></item></items></pubsub></iq>`)
+	as.On(streamtest.Contains(NsBundles), `<iq type="result" id="{id}" from="`+bob+`"><pubsub xmlns="http://jabber.org/protocol/pubsub">`+
+		`<items node="`+NsBundles+`"><item id="`+bid+`">`+string(published)+`</item></items></pubsub></iq>`)
+	ad := dispatch.New(as)
+	go as.Ring(func(buf *bytes.Buffer) bool {
+		ad.Feed(buf.Bytes())
+		return false
+	}, 5*time.Second)
+
+	msg, err := a.Message(ad, stanza.CHAT, bob, "hello bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := xml.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	h := b.Handler(bd, func(from, body string) { got = append(got, from+" "+body) })
+	name, hdr, _ := stanza.Peek(raw)
+	hdr.From = jid.MustParse(alice + "/bot")
+	if !h(name, hdr, raw) {
+		t.Fatalf("not consumed %s", raw)
+	}
+	if len(got) != 1 || got[0] != alice+"/bot hello bob" {
+		t.Fatalf("decrypted %q", got)
+	}
+
+	// bob answers on the session the key exchange built
+	aid := strconv.FormatUint(uint64(a.DeviceID()), 10)
+	b.Update(bd, alice, &pubsub.Event{Node: NsDevices, Items: []pubsub.Item{{ID: "current",
+		Payload: []byte(`<devices xmlns="urn:xmpp:omemo:2"><device id="` + aid + `"/></devices>`)}}})
+	e, err := b.Encrypt(bd, alice, "hello alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := e.Header.Keys[0].Keys[0]; k.Kex {
+		t.Fatalf("answered with a key exchange %+v", k)
+	}
+	body, used, err := a.Decrypt(bob, e)
+	if err != nil || used || body != "hello alice" {
+		t.Fatalf("decrypted %q %v %v", body, used, err)
+	}
+	if _, _, err := a.Decrypt(bob, e); err == nil {
+		t.Fatal("decrypted the message twice")
+	}
+	if _, _, err := b.Decrypt(alice, e); err != ErrNotForUs {
+		t.Fatalf("decrypted a message for another device: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package omemo
+
+import "errors"
+
+// The messages are the protobuf ones of the XEP, they are small enough to
+// be encoded by hand.
+
+var errProto = errors.New("omemo: malformed message")
+
+type fields map[int][]byte
+
+func putVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func varint(b []byte) (v uint64, n int) {
+	for shift := uint(0); n < len(b) && shift < 64; shift += 7 {
+		c := b[n]
+		n++
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, n
+		}
+	}
+	return 0, 0
+}
+
+func putUint(b []byte, field int, v uint32) []byte {
+	return putVarint(putVarint(b, uint64(field<<3)), uint64(v))
+}
+
+func putBytes(b []byte, field int, v []byte) []byte {
+	b = putVarint(putVarint(b, uint64(field<<3|2)), uint64(len(v)))
+	return append(b, v...)
+}
+
+// parse reads the varint and the bytes fields, varints are kept encoded.
+func parse(b []byte) (fields, error) {
+	ret := make(fields)
+	for len(b) > 0 {
+		key, n := varint(b)
+		if n == 0 {
+			return nil, errProto
+		}
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			_, n = varint(b)
+			if n == 0 {
+				return nil, errProto
+			}
+			ret[int(key>>3)], b = b[:n], b[n:]
+		case 2:
+			l, n := varint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				return nil, errProto
+			}
+			ret[int(key>>3)], b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, errProto
+		}
+	}
+	return ret, nil
+}
+
+func (f fields) uint(field int) uint32 {
+	v, _ := varint(f[field])
+	return uint32(v)
+}
+
+type message struct {
+	N, PN      uint32
+	DH         []byte
+	Ciphertext []byte
+}
+
+func (m *message) marshal() []byte {
+	b := putUint(nil, 1, m.N)
+	b = putUint(b, 2, m.PN)
+	b = putBytes(b, 3, m.DH)
+	if m.Ciphertext != nil {
+		b = putBytes(b, 4, m.Ciphertext)
+	}
+	return b
+}
+
+func parseMessage(b []byte) (*message, error) {
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if f[1] == nil || f[2] == nil || len(f[3]) != 32 {
+		return nil, errProto
+	}
+	return &message{N: f.uint(1), PN: f.uint(2), DH: f[3], Ciphertext: f[4]}, nil
+}
+
+type authMessage struct {
+	MAC     []byte
+	Message []byte
+}
+
+func (m *authMessage) marshal() []byte {
+	return putBytes(putBytes(nil, 1, m.MAC), 2, m.Message)
+}
+
+func parseAuthMessage(b []byte) (*authMessage, error) {
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(f[1]) != 16 || f[2] == nil {
+		return nil, errProto
+	}
+	return &authMessage{MAC: f[1], Message: f[2]}, nil
+}
+
+type keyExchange struct {
+	PKID, SPKID uint32
+	IK, EK      []byte
+	Message     []byte
+}
+
+func (k *keyExchange) marshal() []byte {
+	b := putUint(nil, 1, k.PKID)
+	b = putUint(b, 2, k.SPKID)
+	b = putBytes(b, 3, k.IK)
+	b = putBytes(b, 4, k.EK)
+	return putBytes(b, 5, k.Message)
+}
+
+func parseKeyExchange(b []byte) (*keyExchange, error) {
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if f[1] == nil || f[2] == nil || len(f[3]) != 32 || len(f[4]) != 32 || f[5] == nil {
+		return nil, errProto
+	}
+	return &keyExchange{PKID: f.uint(1), SPKID: f.uint(2), IK: f[3], EK: f[4], Message: f[5]}, nil
+}
@@ -0,0 +1,264 @@
+package omemo
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// maxSkip bounds the message keys kept for messages that arrive out of order.
+const maxSkip = 1000
+
+var errSkip = errors.New("omemo: too many skipped messages")
+
+// Session is the double ratchet state with one device, kept as plain bytes
+// so the store can serialize it.
+type Session struct {
+	// Identity is the Ed25519 identity key of the device.
+	Identity []byte
+	AD       []byte
+	RK       []byte
+	DHsPriv  []byte
+	DHsPub   []byte
+	DHr      []byte
+	CKs      []byte
+	CKr      []byte
+	Ns       uint32
+	Nr       uint32
+	PN       uint32
+	Skipped  map[string][]byte
+	// Kex is sent along until the device answers, so it can build the
+	// session on its end.
+	Kex *keyExchange `json:",omitempty"`
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Skipped = make(map[string][]byte, len(s.Skipped))
+	for k, v := range s.Skipped {
+		c.Skipped[k] = v
+	}
+	return &c
+}
+
+func kdfRK(rk, out []byte) (root, chain []byte) {
+	m := hkdf(out, rk, "OMEMO Root Chain", 64)
+	return m[:32], m[32:]
+}
+
+func kdfCK(ck []byte) (chain, key []byte) {
+	return mac(ck, []byte{2}), mac(ck, []byte{1})
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(dh), n)
+}
+
+// Bundle is what X3DH needs of a device's published bundle.
+type Bundle struct {
+	Identity ed25519.PublicKey
+	SPKID    uint32
+	SPK      []byte
+	SPKSig   []byte
+	PKID     uint32
+	PK       []byte
+}
+
+// initiate runs X3DH as the initiator against a bundle, the session sends
+// the key exchange with its messages.
+func initiate(own ed25519.PrivateKey, b *Bundle) (*Session, error) {
+	if len(b.Identity) != ed25519.PublicKeySize || !ed25519.Verify(b.Identity, b.SPK, b.SPKSig) {
+		return nil, errors.New("omemo: bad signed prekey signature")
+	}
+	ik, err := montgomeryPrivate(own)
+	if err != nil {
+		return nil, err
+	}
+	ikb, err := montgomery(b.Identity)
+	if err != nil {
+		return nil, err
+	}
+	ek, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	var dhs [][]byte
+	for _, p := range []struct {
+		priv *ecdh.PrivateKey
+		pub  []byte
+	}{{ik, b.SPK}, {ek, ikb.Bytes()}, {ek, b.SPK}, {ek, b.PK}} {
+		out, err := dh(p.priv, p.pub)
+		if err != nil {
+			return nil, err
+		}
+		dhs = append(dhs, out)
+	}
+	sk := hkdf(append(bytes.Repeat([]byte{0xff}, 32), bytes.Join(dhs, nil)...), zeroSalt, "OMEMO X3DH", 32)
+	ownPub := own.Public().(ed25519.PublicKey)
+	s := &Session{
+		Identity: b.Identity,
+		AD:       append(append([]byte{}, ownPub...), b.Identity...),
+		DHr:      b.SPK,
+		Skipped:  make(map[string][]byte),
+		Kex:      &keyExchange{PKID: b.PKID, SPKID: b.SPKID, IK: ownPub, EK: ek.PublicKey().Bytes()},
+	}
+	ratchet, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	s.DHsPriv, s.DHsPub = ratchet.Bytes(), ratchet.PublicKey().Bytes()
+	out, err := dh(ratchet, s.DHr)
+	if err != nil {
+		return nil, err
+	}
+	s.RK, s.CKs = kdfRK(sk, out)
+	return s, nil
+}
+
+// respond runs X3DH as the responder to a key exchange, with the signed
+// prekey and the one-time prekey it names.
+func respond(own ed25519.PrivateKey, spk, pk *ecdh.PrivateKey, k *keyExchange) (*Session, error) {
+	ik, err := montgomeryPrivate(own)
+	if err != nil {
+		return nil, err
+	}
+	ika, err := montgomery(k.IK)
+	if err != nil {
+		return nil, err
+	}
+	var dhs [][]byte
+	for _, p := range []struct {
+		priv *ecdh.PrivateKey
+		pub  []byte
+	}{{spk, ika.Bytes()}, {ik, k.EK}, {spk, k.EK}, {pk, k.EK}} {
+		out, err := dh(p.priv, p.pub)
+		if err != nil {
+			return nil, err
+		}
+		dhs = append(dhs, out)
+	}
+	sk := hkdf(append(bytes.Repeat([]byte{0xff}, 32), bytes.Join(dhs, nil)...), zeroSalt, "OMEMO X3DH", 32)
+	return &Session{
+		Identity: k.IK,
+		AD:       append(append([]byte{}, k.IK...), own.Public().(ed25519.PublicKey)...),
+		RK:       sk,
+		DHsPriv:  spk.Bytes(),
+		DHsPub:   spk.PublicKey().Bytes(),
+		Skipped:  make(map[string][]byte),
+	}, nil
+}
+
+// Encrypt encrypts the plain text for the device, it returns an
+// OMEMOAuthenticatedMessage or, while the session isn't confirmed, an
+// OMEMOKeyExchange.
+func (s *Session) Encrypt(plain []byte) (msg []byte, kex bool, err error) {
+	var mk []byte
+	s.CKs, mk = kdfCK(s.CKs)
+	enc, auth, iv := material(mk, "OMEMO Message Key Material")
+	m := (&message{N: s.Ns, PN: s.PN, DH: s.DHsPub, Ciphertext: encryptCBC(enc, iv, plain)}).marshal()
+	s.Ns++
+	msg = (&authMessage{MAC: mac(auth, s.AD, m)[:16], Message: m}).marshal()
+	if s.Kex == nil {
+		return msg, false, nil
+	}
+	k := *s.Kex
+	k.Message = msg
+	return k.marshal(), true, nil
+}
+
+// Decrypt decrypts an OMEMOAuthenticatedMessage, the session is left as it
+// was when that fails.
+func (s *Session) Decrypt(msg []byte) ([]byte, error) {
+	am, err := parseAuthMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseMessage(am.Message)
+	if err != nil {
+		return nil, err
+	}
+	if mk, ok := s.Skipped[skippedKey(m.DH, m.N)]; ok {
+		plain, err := s.open(mk, am, m)
+		if err == nil {
+			delete(s.Skipped, skippedKey(m.DH, m.N))
+		}
+		return plain, err
+	}
+	c := s.clone()
+	if !bytes.Equal(m.DH, c.DHr) {
+		if err := c.skip(m.PN); err != nil {
+			return nil, err
+		}
+		if err := c.step(m.DH); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.skip(m.N); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	c.CKr, mk = kdfCK(c.CKr)
+	c.Nr++
+	plain, err := c.open(mk, am, m)
+	if err != nil {
+		return nil, err
+	}
+	// the device has the session once it sends with it
+	c.Kex = nil
+	*s = *c
+	return plain, nil
+}
+
+func (s *Session) open(mk []byte, am *authMessage, m *message) ([]byte, error) {
+	enc, auth, iv := material(mk, "OMEMO Message Key Material")
+	if !hmac.Equal(mac(auth, s.AD, am.Message)[:16], am.MAC) {
+		return nil, errMAC
+	}
+	return decryptCBC(enc, iv, m.Ciphertext)
+}
+
+func (s *Session) skip(until uint32) error {
+	if s.CKr == nil {
+		return nil
+	}
+	if s.Nr+maxSkip < until {
+		return errSkip
+	}
+	for ; s.Nr < until; s.Nr++ {
+		var mk []byte
+		s.CKr, mk = kdfCK(s.CKr)
+		s.Skipped[skippedKey(s.DHr, s.Nr)] = mk
+	}
+	if len(s.Skipped) > maxSkip {
+		return errSkip
+	}
+	return nil
+}
+
+// step is the DH ratchet step on a new ratchet key of the device.
+func (s *Session) step(remote []byte) error {
+	s.PN, s.Ns, s.Nr = s.Ns, 0, 0
+	s.DHr = remote
+	priv, err := ecdh.X25519().NewPrivateKey(s.DHsPriv)
+	if err != nil {
+		return err
+	}
+	out, err := dh(priv, s.DHr)
+	if err != nil {
+		return err
+	}
+	s.RK, s.CKr = kdfRK(s.RK, out)
+	if priv, err = generate(); err != nil {
+		return err
+	}
+	s.DHsPriv, s.DHsPub = priv.Bytes(), priv.PublicKey().Bytes()
+	if out, err = dh(priv, s.DHr); err != nil {
+		return err
+	}
+	s.RK, s.CKs = kdfRK(s.RK, out)
+	return nil
+}
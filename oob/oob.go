@@ -0,0 +1,75 @@
+// Package oob implements XEP-0066 out of band data for messages, clients
+// show the files linked this way inline.
+package oob
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "jabber:x:oob"
+
+// Data is a link to a file.
+type Data struct {
+	XMLName xml.Name `xml:"jabber:x:oob x"`
+	URL     string   `xml:"url"`
+	Desc    string   `xml:"desc,omitempty"`
+}
+
+func init() {
+	stanza.Register(Ns, "x", func() interface{} { return &Data{} })
+}
+
+// Of returns the links attached to the message.
+func Of(m *stanza.Message) (ret []Data) {
+	for _, e := range m.Extensions {
+		if e.XMLName.Space != Ns || e.XMLName.Local != "x" {
+			continue
+		}
+		if x, ok := e.Value.(*Data); ok && x.URL != "" {
+			ret = append(ret, *x)
+		}
+	}
+	return
+}
+
+// Attach links the file to the message, clients render it inline only when
+// the body is the URL alone.
+func Attach(m *stanza.Message, url, desc string) error {
+	return m.With(&Data{URL: url, Desc: desc})
+}
+
+// Send sends the URL with the file attached.
+func Send(d *dispatch.Dispatcher, typ, to, url, desc string) error {
+	addr, err := jid.Parse(to)
+	if err != nil {
+		return err
+	}
+	m := stanza.NewMessage(typ, addr, url)
+	m.ID = d.NextID()
+	if err := Attach(m, url, desc); err != nil {
+		return err
+	}
+	return d.Send(m)
+}
+
+// Handler passes the links attached to live messages to fn, it never
+// consumes the messages.
+func Handler(fn func(from string, x Data)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR || h.Archived {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil || m.Delay != nil {
+			return false
+		}
+		for _, x := range Of(m) {
+			fn(h.From.String(), x)
+		}
+		return false
+	}
+}
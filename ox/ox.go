@@ -0,0 +1,277 @@
+// Package ox is XEP-0373 OpenPGP for XMPP signing, the sign elements of
+// XEP-0374 with the keys of a GnuPG keyring, through the gpg binary. Only
+// signing is covered, signcrypt and crypt elements aren't.
+package ox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/pep"
+	"github.com/kpmy/xep/pubsub"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns           = "urn:xmpp:openpgp:0"
+	NsPublicKeys = "urn:xmpp:openpgp:0:public-keys"
+
+	// MaxAge bounds how old a signed element may be, against replays.
+	MaxAge = 5 * time.Minute
+)
+
+var ErrNoSignature = errors.New("ox: no valid signature")
+
+// OpenPGP is the openpgp element of a message, a base64 OpenPGP message.
+type OpenPGP struct {
+	XMLName xml.Name `xml:"urn:xmpp:openpgp:0 openpgp"`
+	Data    string   `xml:",chardata"`
+}
+
+type jidRef struct {
+	JID string `xml:"jid,attr"`
+}
+
+type sign struct {
+	XMLName xml.Name `xml:"urn:xmpp:openpgp:0 sign"`
+	To      []jidRef `xml:"to"`
+	Time    struct {
+		Stamp time.Time `xml:"stamp,attr"`
+	} `xml:"time"`
+	RPad    string `xml:"rpad,omitempty"`
+	Payload struct {
+		Body string `xml:"jabber:client body"`
+	} `xml:"payload"`
+}
+
+type pubkey struct {
+	XMLName xml.Name `xml:"urn:xmpp:openpgp:0 pubkey"`
+	Data    string   `xml:"data"`
+}
+
+type keyMeta struct {
+	Fingerprint string    `xml:"v4-fingerprint,attr"`
+	Date        time.Time `xml:"date,attr"`
+}
+
+type keysList struct {
+	XMLName xml.Name  `xml:"urn:xmpp:openpgp:0 public-keys-list"`
+	Keys    []keyMeta `xml:"pubkey-metadata"`
+}
+
+// GPG runs gpg, Path defaults to the one in PATH and Home to the default
+// GnuPG home.
+type GPG struct {
+	Path string
+	Home string
+}
+
+func (g *GPG) run(in []byte, args ...string) (out []byte, status string, err error) {
+	path := g.Path
+	if path == "" {
+		path = "gpg"
+	}
+	args = append([]string{"--batch", "--no-tty", "--status-fd", "2"}, args...)
+	if g.Home != "" {
+		args = append([]string{"--homedir", g.Home}, args...)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		err = fmt.Errorf("gpg: %v: %s", err, lines[len(lines)-1])
+	}
+	return stdout.Bytes(), stderr.String(), err
+}
+
+// colons returns the fields of the --with-colons records of the given type,
+// unescaped.
+func colons(out []byte, typ string) (ret [][]string) {
+	for _, l := range strings.Split(string(out), "\n") {
+		if f := strings.Split(l, ":"); len(f) > 9 && f[0] == typ {
+			for i := range f {
+				f[i] = unescape(f[i])
+			}
+			ret = append(ret, f)
+		}
+	}
+	return
+}
+
+// unescape undoes the \xHH escapes of gpg.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Fingerprint resolves a key id or user id to the fingerprint of the
+// primary key, secret says the secret key must be in the keyring.
+func (g *GPG) Fingerprint(key string, secret bool) (string, error) {
+	list := "--list-keys"
+	if secret {
+		list = "--list-secret-keys"
+	}
+	out, _, err := g.run(nil, "--with-colons", list, key)
+	if err != nil {
+		return "", err
+	}
+	if fpr := colons(out, "fpr"); len(fpr) > 0 {
+		return fpr[0][9], nil
+	}
+	return "", fmt.Errorf("ox: no key %s", key)
+}
+
+// Sign signs the body for the recipients with the secret key.
+func (g *GPG) Sign(key string, to []string, body string) (*OpenPGP, error) {
+	s := &sign{RPad: strings.Repeat(" ", rand.Intn(16))}
+	for _, t := range to {
+		s.To = append(s.To, jidRef{t})
+	}
+	s.Time.Stamp = time.Now().UTC().Truncate(time.Second)
+	s.Payload.Body = body
+	data, err := xml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := g.run(data, "--local-user", key, "--sign")
+	if err != nil {
+		return nil, err
+	}
+	return &OpenPGP{Data: base64.StdEncoding.EncodeToString(out)}, nil
+}
+
+// Verify checks the element sent by from to the account and returns its body
+// and the fingerprint of the key that signed it. The key has to be in the
+// keyring with the xmpp: user id of the sender, as the XEP has it.
+func (g *GPG) Verify(from, account string, e *OpenPGP) (body, fpr string, err error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e.Data))
+	if err != nil {
+		return "", "", err
+	}
+	out, status, err := g.run(data, "--decrypt")
+	if err != nil {
+		return "", "", err
+	}
+	good := false
+	for _, l := range strings.Split(status, "\n") {
+		f := strings.Fields(strings.TrimPrefix(l, "[GNUPG:] "))
+		switch {
+		case len(f) > 0 && f[0] == "GOODSIG":
+			good = true
+		case len(f) > 10 && f[0] == "VALIDSIG":
+			fpr = f[10]
+		}
+	}
+	if !good || fpr == "" {
+		return "", "", ErrNoSignature
+	}
+	keys, _, err := g.run(nil, "--with-colons", "--list-keys", fpr)
+	if err != nil {
+		return "", "", err
+	}
+	owned := false
+	for _, uid := range colons(keys, "uid") {
+		owned = owned || strings.EqualFold(uid[9], "xmpp:"+jid.Bare(from))
+	}
+	if !owned {
+		return "", "", fmt.Errorf("ox: key %s isn't one of %s", fpr, jid.Bare(from))
+	}
+	s := &sign{}
+	if err := xml.Unmarshal(out, s); err != nil {
+		return "", "", err
+	}
+	to := false
+	for _, t := range s.To {
+		to = to || jid.Bare(t.JID) == jid.Bare(account)
+	}
+	if !to {
+		return "", "", errors.New("ox: signed for someone else")
+	}
+	if d := time.Since(s.Time.Stamp); d > MaxAge || d < -MaxAge {
+		return "", "", errors.New("ox: signed at " + s.Time.Stamp.String())
+	}
+	return s.Payload.Body, fpr, nil
+}
+
+// PublishKey publishes the public key with the fingerprint and lists it in
+// the metadata node, so contacts can fetch it.
+func (g *GPG) PublishKey(d *dispatch.Dispatcher, fpr string) error {
+	out, _, err := g.run(nil, "--export", fpr)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err = pubsub.Publish(d, "", NsPublicKeys+":"+fpr, now.Format(time.RFC3339), &pubkey{Data: base64.StdEncoding.EncodeToString(out)}, map[string]string{
+		"pubsub#max_items":    "1",
+		"pubsub#access_model": "open",
+	})
+	if err != nil {
+		return err
+	}
+	list := &keysList{}
+	if i, err := pep.Last(d, "", NsPublicKeys); err == nil && i != nil {
+		i.Decode(list)
+	}
+	for _, k := range list.Keys {
+		if strings.EqualFold(k.Fingerprint, fpr) {
+			return nil
+		}
+	}
+	list.Keys = append(list.Keys, keyMeta{fpr, now})
+	return pep.PublishOpen(d, NsPublicKeys, list)
+}
+
+// Handler verifies the signed chat messages to the account and passes the
+// bodies to fn with the fingerprints, consuming the messages. gpg runs
+// outside of the dispatching.
+func Handler(g *GPG, account string, fn func(from, body, fpr string)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR || h.Type == stanza.GROUPCHAT {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil {
+			return false
+		}
+		x := m.Ext(Ns, "openpgp")
+		if x == nil {
+			return false
+		}
+		e := &OpenPGP{}
+		if err := x.Decode(e); err != nil || h.Archived || m.Delay != nil {
+			return true
+		}
+		go func() {
+			body, fpr, err := g.Verify(h.From.String(), account, e)
+			if err != nil {
+				log.Println("ox: message from", h.From, err)
+				return
+			}
+			fn(h.From.String(), body, fpr)
+		}()
+		return true
+	}
+}
@@ -0,0 +1,99 @@
+package ox
+
+import (
+	"encoding/xml"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	signed := &sign{To: []jidRef{{"juliet@example.org"}, {"romeo@example.net"}}, RPad: "  "}
+	signed.Time.Stamp = stamp
+	signed.Payload.Body = "Hello"
+	for _, tc := range []struct {
+		raw string
+		v   interface{}
+	}{
+		{`<openpgp xmlns="urn:xmpp:openpgp:0">BASE64_OPENPGP_MESSAGE</openpgp>`, &OpenPGP{Data: "BASE64_OPENPGP_MESSAGE"}},
+		{`<sign xmlns="urn:xmpp:openpgp:0"><to jid="juliet@example.org"/><to jid="romeo@example.net"/><time stamp="2020-01-02T03:04:05Z"/>` +
+			`<rpad>  </rpad><payload><body xmlns="jabber:client">Hello</body></payload></sign>`, signed},
+		{`<pubkey xmlns="urn:xmpp:openpgp:0"><data>BASE64_KEY</data></pubkey>`, &pubkey{Data: "BASE64_KEY"}},
+		{`<public-keys-list xmlns="urn:xmpp:openpgp:0"><pubkey-metadata v4-fingerprint="1357B01865B2503C18453D208CAC2A9678548E35" date="2020-01-02T03:04:05Z"/></public-keys-list>`,
+			&keysList{Keys: []keyMeta{{"1357B01865B2503C18453D208CAC2A9678548E35", stamp}}}},
+	} {
+		typ := reflect.TypeOf(tc.v).Elem()
+		got := reflect.New(typ).Interface()
+		if err := xml.Unmarshal([]byte(tc.raw), got); err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		b, err := xml.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := reflect.New(typ).Interface()
+		if err := xml.Unmarshal(b, again); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		// the XMLName is the only field set by parsing alone
+		reflect.ValueOf(tc.v).Elem().Field(0).Set(reflect.ValueOf(got).Elem().Field(0))
+		if !reflect.DeepEqual(got, tc.v) || !reflect.DeepEqual(again, tc.v) {
+			t.Errorf("%s parsed %+v, after a round trip %+v", tc.raw, got, again)
+		}
+	}
+}
+
+func TestColons(t *testing.T) {
+	out := []byte("sec:u:255:22:8CAC2A9678548E35:1577934245:::u:::scESC:::+:::ed25519:::0:\n" +
+		"fpr:::::::::1357B01865B2503C18453D208CAC2A9678548E35:\n" +
+		"uid:u::::1577934245::A1B2::xmpp\\x3abot@example.org::::::::::0:\n")
+	if f := colons(out, "fpr"); len(f) != 1 || f[0][9] != "1357B01865B2503C18453D208CAC2A9678548E35" {
+		t.Fatalf("fpr %q", f)
+	}
+	if f := colons(out, "uid"); len(f) != 1 || f[0][9] != "xmpp:bot@example.org" {
+		t.Fatalf("uid %q", f)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("no gpg")
+	}
+	g := &GPG{Home: t.TempDir()}
+	t.Cleanup(func() { exec.Command("gpgconf", "--homedir", g.Home, "--kill", "gpg-agent").Run() })
+	if _, _, err := g.run(nil, "--passphrase", "", "--quick-gen-key", "xmpp:bot@example.org", "ed25519", "sign"); err != nil {
+		t.Skip(err)
+	}
+	fpr, err := g.Fingerprint("xmpp:bot@example.org", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := g.Sign(fpr, []string{"friend@example.org"}, "hi <there>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := xml.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := &OpenPGP{}
+	if err := xml.Unmarshal(b, received); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		from, account string
+		ok            bool
+	}{
+		{"bot@example.org/phone", "friend@example.org/home", true},
+		{"mallory@example.org/x", "friend@example.org", false},
+		{"bot@example.org", "other@example.org", false},
+	} {
+		body, got, err := g.Verify(tc.from, tc.account, received)
+		if (err == nil) != tc.ok || tc.ok && (body != "hi <there>" || !strings.EqualFold(got, fpr)) {
+			t.Errorf("from %s to %s: %q by %s, %v", tc.from, tc.account, body, got, err)
+		}
+	}
+}
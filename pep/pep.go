@@ -0,0 +1,102 @@
+// Package pep publishes to the XEP-0163 personal eventing service of the
+// account, with helpers for the user mood, activity and nickname nodes.
+package pep
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/pubsub"
+)
+
+const (
+	NsMood     = "http://jabber.org/protocol/mood"
+	NsActivity = "http://jabber.org/protocol/activity"
+	NsNick     = "http://jabber.org/protocol/nick"
+)
+
+// Notify returns the feature asking for the notifications of a node, it is
+// advertised through caps.
+func Notify(node string) string {
+	return node + "+notify"
+}
+
+// Publish publishes v as the only item of the node, visible to the contacts
+// sharing presence with the account as PEP defaults to.
+func Publish(d *dispatch.Dispatcher, node string, v interface{}) error {
+	_, err := pubsub.Publish(d, "", node, "current", v, map[string]string{"pubsub#max_items": "1"})
+	return err
+}
+
+// PublishOpen is Publish for nodes anyone may read.
+func PublishOpen(d *dispatch.Dispatcher, node string, v interface{}) error {
+	_, err := pubsub.Publish(d, "", node, "current", v, map[string]string{
+		"pubsub#max_items":    "1",
+		"pubsub#access_model": "open",
+	})
+	return err
+}
+
+// Last returns the current item of the node of jid, the account's when empty.
+func Last(d *dispatch.Dispatcher, jid, node string) (*pubsub.Item, error) {
+	items, err := pubsub.Items(d, jid, node, 1)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return &items[len(items)-1], nil
+}
+
+// Mood is a XEP-0107 user mood, an empty Value clears it.
+type Mood struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/mood mood"`
+	Value   *struct {
+		XMLName xml.Name
+	} `xml:",any"`
+	Text string `xml:"text,omitempty"`
+}
+
+// PublishMood publishes the mood, e.g. "happy", with an optional text.
+func PublishMood(d *dispatch.Dispatcher, mood, text string) error {
+	m := &Mood{Text: text}
+	if mood != "" {
+		m.Value = &struct{ XMLName xml.Name }{xml.Name{Space: NsMood, Local: mood}}
+	}
+	return Publish(d, NsMood, m)
+}
+
+type activity struct {
+	XMLName xml.Name  `xml:"http://jabber.org/protocol/activity activity"`
+	General *specific `xml:",any"`
+	Text    string    `xml:"text,omitempty"`
+}
+
+type specific struct {
+	XMLName  xml.Name
+	Specific *struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// PublishActivity publishes the XEP-0108 user activity, e.g. "working" and
+// "coding"; specific and text may be empty, an empty general clears it.
+func PublishActivity(d *dispatch.Dispatcher, general, detail, text string) error {
+	a := &activity{Text: text}
+	if general != "" {
+		a.General = &specific{XMLName: xml.Name{Space: NsActivity, Local: general}}
+		if detail != "" {
+			a.General.Specific = &struct{ XMLName xml.Name }{xml.Name{Space: NsActivity, Local: detail}}
+		}
+	}
+	return Publish(d, NsActivity, a)
+}
+
+type nick struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/nick nick"`
+	Nick    string   `xml:",chardata"`
+}
+
+// PublishNick publishes the XEP-0172 nickname of the account, it is open to
+// anyone so it shows in subscription requests too.
+func PublishNick(d *dispatch.Dispatcher, name string) error {
+	return PublishOpen(d, NsNick, &nick{Nick: name})
+}
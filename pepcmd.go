@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kpmy/xep/pep"
+)
+
+func init() {
+	commands["mood"] = &command{admin: true, usage: "<mood, e.g. happy | none> [text]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		mood := c.args[0]
+		if mood == "none" {
+			mood = ""
+		}
+		if err := pep.PublishMood(disp, mood, strings.Join(c.args[1:], " ")); err != nil {
+			return "", err
+		}
+		return "mood set", nil
+	}}
+	commands["activity"] = &command{admin: true, usage: "<general[/specific], e.g. working/coding | none> [text]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		f := strings.SplitN(c.args[0], "/", 2)
+		general, detail := f[0], ""
+		if len(f) > 1 {
+			detail = f[1]
+		}
+		if general == "none" {
+			general, detail = "", ""
+		}
+		if err := pep.PublishActivity(disp, general, detail, strings.Join(c.args[1:], " ")); err != nil {
+			return "", err
+		}
+		return "activity set", nil
+	}}
+}
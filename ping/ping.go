@@ -0,0 +1,38 @@
+// Package ping implements XEP-0199 XMPP ping.
+package ping
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:ping"
+
+type query struct {
+	XMLName xml.Name `xml:"urn:xmpp:ping ping"`
+}
+
+// Ping pings to and waits for the answer.
+func Ping(d *dispatch.Dispatcher, to jid.JID) error {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &query{})
+	_, err := d.Request(iq)
+	return err
+}
+
+// Handler answers pings.
+func Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.GET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		d.Send(iq.Result())
+		return true
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+// sendPresence is a step broadcasting the bot's presence to its contacts.
+func sendPresence(show, status string) func(stream.Stream) error {
+	return func(s stream.Stream) error {
+		p := stanza.NewPresence(stanza.AVAILABLE, jid.JID{})
+		p.Show, p.Status, p.Priority = show, status, int8(priority)
+		if capsExt.XMLName.Local != "" {
+			p.Extensions = append(p.Extensions, capsExt)
+		}
+		buf, err := stanza.Buffer(p)
+		if err != nil {
+			return err
+		}
+		return s.Write(buf)
+	}
+}
+
+var shows = map[string]string{"online": "", "chat": stanza.CHAT, "away": stanza.AWAY, "xa": stanza.XA, "dnd": stanza.DND}
+
+func init() {
+	commands["presence"] = &command{admin: true, usage: "<online|chat|away|xa|dnd> [status]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		show, ok := shows[c.args[0]]
+		if !ok {
+			return "", errUsage
+		}
+		status := strings.Join(c.args[1:], " ")
+		if err := sendPresence(show, status)(disp.Stream()); err != nil {
+			return "", err
+		}
+		return "presence set to " + c.args[0], rooms.Update(show, status)(disp.Stream())
+	}}
+}
@@ -0,0 +1,154 @@
+// Package privilege implements XEP-0356 privileged entities, letting a
+// component send messages on behalf of users and access their rosters.
+package privilege
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/roster"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:privilege:2"
+
+const (
+	ROSTER  = "roster"
+	MESSAGE = "message"
+)
+
+var ErrNotPermitted = errors.New("privilege: not granted by the server")
+
+type Perm struct {
+	Access string `xml:"access,attr"`
+	Type   string `xml:"type,attr"`
+	Push   bool   `xml:"push,attr,omitempty"`
+}
+
+// advertisement nests the privilege element, with a>b the namespace would
+// be marshaled on the perms.
+type advertisement struct {
+	XMLName   xml.Name `xml:"message"`
+	From      jid.JID  `xml:"from,attr"`
+	To        jid.JID  `xml:"to,attr"`
+	Privilege struct {
+		XMLName xml.Name `xml:"urn:xmpp:privilege:2 privilege"`
+		Perms   []Perm   `xml:"perm"`
+	}
+}
+
+// Privileges holds the permissions the server advertised to the component.
+type Privileges struct {
+	sync.Mutex
+	Server    jid.JID
+	Component jid.JID
+	perms     map[string]string
+}
+
+func New() *Privileges {
+	return &Privileges{perms: make(map[string]string)}
+}
+
+// Handler records privilege advertisements sent by the server.
+func (p *Privileges) Handler() dispatch.Handler {
+	return func(name xml.Name, _ stanza.Header, raw []byte) bool {
+		if name.Local != "message" {
+			return false
+		}
+		a := &advertisement{}
+		if err := xml.Unmarshal(raw, a); err != nil || len(a.Privilege.Perms) == 0 {
+			return false
+		}
+		if a.From.Local != "" || a.From.Resource != "" {
+			// only the server itself may grant privileges
+			return true
+		}
+		p.Lock()
+		p.Server, p.Component = a.From, a.To
+		for _, perm := range a.Privilege.Perms {
+			p.perms[perm.Access] = perm.Type
+		}
+		p.Unlock()
+		return true
+	}
+}
+
+// Granted reports whether access of the given kind was granted, typ is
+// "get", "set" or "outgoing"; "both" grants get and set.
+func (p *Privileges) Granted(access, typ string) bool {
+	p.Lock()
+	defer p.Unlock()
+	switch t := p.perms[access]; t {
+	case "", "none":
+		return false
+	case "both":
+		return typ == stanza.GET || typ == stanza.SET
+	default:
+		return t == typ
+	}
+}
+
+// SendAs sends a raw message stanza whose from is a user of the server.
+func (p *Privileges) SendAs(d *dispatch.Dispatcher, msg []byte) error {
+	if !p.Granted(MESSAGE, "outgoing") {
+		return ErrNotPermitted
+	}
+	p.Lock()
+	out := &struct {
+		XMLName   xml.Name `xml:"message"`
+		From      jid.JID  `xml:"from,attr"`
+		To        jid.JID  `xml:"to,attr"`
+		Privilege struct {
+			XMLName   xml.Name `xml:"urn:xmpp:privilege:2 privilege"`
+			Forwarded stanza.Forwarded
+		}
+	}{From: p.Component, To: p.Server}
+	p.Unlock()
+	out.Privilege.Forwarded.Stanza = msg
+	return d.Send(out)
+}
+
+// Roster fetches the roster of a user of the server.
+func (p *Privileges) Roster(d *dispatch.Dispatcher, user string) ([]roster.Item, error) {
+	if !p.Granted(ROSTER, stanza.GET) {
+		return nil, ErrNotPermitted
+	}
+	to, err := jid.Parse(user)
+	if err != nil {
+		return nil, err
+	}
+	iq, _ := stanza.NewIQ(stanza.GET, to, &roster.Query{})
+	p.Lock()
+	iq.From = p.Component
+	p.Unlock()
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	q := &roster.Query{}
+	if err = res.Decode(q); err != nil {
+		return nil, fmt.Errorf("privilege: bad roster of %s: %v", user, err)
+	}
+	return q.Items, nil
+}
+
+// SetRosterItem adds, updates or (with subscription="remove") deletes an item of a user's roster.
+func (p *Privileges) SetRosterItem(d *dispatch.Dispatcher, user string, item roster.Item) error {
+	if !p.Granted(ROSTER, stanza.SET) {
+		return ErrNotPermitted
+	}
+	to, err := jid.Parse(user)
+	if err != nil {
+		return err
+	}
+	iq, _ := stanza.NewIQ(stanza.SET, to, &roster.Query{Items: []roster.Item{item}})
+	p.Lock()
+	iq.From = p.Component
+	p.Unlock()
+	_, err = d.Request(iq)
+	return err
+}
@@ -0,0 +1,131 @@
+package privilege
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/roster"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+const granted = `<message from="example.org" to="bot.example.org"><privilege xmlns="urn:xmpp:privilege:2">` +
+	`<perm access="roster" type="both" push="true"/><perm access="message" type="outgoing"/></privilege></message>`
+
+// serve feeds what the server answers to the dispatcher, as the bot loop does.
+func serve(st *streamtest.Stream, d *dispatch.Dispatcher) {
+	go st.Ring(func(b *bytes.Buffer) bool {
+		d.Feed(b.Bytes())
+		return false
+	}, 5*time.Second)
+}
+
+func TestAdvertisement(t *testing.T) {
+	for _, tc := range []struct {
+		name, raw string
+		consumed  bool
+		granted   map[string]bool
+	}{
+		{"granted", granted, true, map[string]bool{"roster get": true, "roster set": true, "message outgoing": true}},
+		{"get only", `<message from="example.org" to="bot.example.org"><privilege xmlns="urn:xmpp:privilege:2">` +
+			`<perm access="roster" type="get"/><perm access="message" type="none"/></privilege></message>`,
+			true, map[string]bool{"roster get": true, "roster set": false, "message outgoing": false}},
+		{"from a user", strings.Replace(granted, `from="example.org"`, `from="mallory@example.org"`, 1),
+			true, map[string]bool{"roster get": false, "message outgoing": false}},
+		{"from a resource", strings.Replace(granted, `from="example.org"`, `from="example.org/x"`, 1),
+			true, map[string]bool{"roster get": false}},
+		{"no privilege", `<message from="example.org" to="bot.example.org"><body>hi</body></message>`,
+			false, map[string]bool{"roster get": false}},
+	} {
+		p := New()
+		name, h, _ := stanza.Peek([]byte(tc.raw))
+		if got := p.Handler()(name, h, []byte(tc.raw)); got != tc.consumed {
+			t.Errorf("%s: consumed %v", tc.name, got)
+		}
+		for perm, want := range tc.granted {
+			f := strings.Fields(perm)
+			if got := p.Granted(f[0], f[1]); got != want {
+				t.Errorf("%s: %s granted %v", tc.name, perm, got)
+			}
+		}
+	}
+}
+
+func TestAdvertisementRoundTrip(t *testing.T) {
+	a := &advertisement{}
+	if err := xml.Unmarshal([]byte(granted), a); err != nil {
+		t.Fatal(err)
+	}
+	want := []Perm{{"roster", "both", true}, {"message", "outgoing", false}}
+	if a.From != jid.MustParse("example.org") || len(a.Privilege.Perms) != len(want) {
+		t.Fatalf("parsed %+v", a)
+	}
+	b, err := xml.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := &advertisement{}
+	if err := xml.Unmarshal(b, again); err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if a.Privilege.Perms[i] != want[i] || again.Privilege.Perms[i] != want[i] {
+			t.Errorf("perm %d is %+v, after a round trip %+v, want %+v", i, a.Privilege.Perms[i], again.Privilege.Perms[i], want[i])
+		}
+	}
+}
+
+func TestSendAs(t *testing.T) {
+	st := streamtest.New("example.org")
+	d := dispatch.New(st)
+	p := New()
+	msg := []byte(`<message from="user@example.org/res" to="friend@example.org" type="chat"><body>hi</body></message>`)
+	if err := p.SendAs(d, msg); err != ErrNotPermitted {
+		t.Fatalf("sent without the privilege: %v", err)
+	}
+	p.Handler()(xml.Name{Local: "message"}, stanza.Header{}, []byte(granted))
+	if err := p.SendAs(d, msg); err != nil {
+		t.Fatal(err)
+	}
+	out := st.Written()[0]
+	env, err := stanza.Unwrap(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.From != jid.MustParse("bot.example.org") || env.To != jid.MustParse("example.org") || env.Wrapper.Space != Ns {
+		t.Fatalf("sent %s", out)
+	}
+	if !bytes.Equal(env.Stanza, msg) {
+		t.Fatalf("forwarded %s, want %s", env.Stanza, msg)
+	}
+}
+
+func TestRoster(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains("jabber:iq:roster"), `<iq type="result" id="{id}" from="user@example.org" to="bot.example.org">`+
+		`<query xmlns="jabber:iq:roster"><item jid="friend@example.org" name="Friend" subscription="both"><group>Work</group></item></query></iq>`)
+	d := dispatch.New(st)
+	serve(st, d)
+	p := New()
+	if _, err := p.Roster(d, "user@example.org"); err != ErrNotPermitted {
+		t.Fatalf("fetched without the privilege: %v", err)
+	}
+	p.Handler()(xml.Name{Local: "message"}, stanza.Header{}, []byte(granted))
+	items, err := p.Roster(d, "user@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := roster.Item{JID: "friend@example.org", Name: "Friend", Subscription: "both", Groups: []string{"Work"}}
+	if len(items) != 1 || items[0].JID != want.JID || items[0].Name != want.Name || len(items[0].Groups) != 1 {
+		t.Fatalf("roster %+v", items)
+	}
+	req := string(st.Written()[0])
+	if !strings.Contains(req, `from="bot.example.org"`) || !strings.Contains(req, `to="user@example.org"`) {
+		t.Fatalf("asked with %s", req)
+	}
+}
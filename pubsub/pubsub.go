@@ -0,0 +1,239 @@
+// Package pubsub is a XEP-0060 publish-subscribe client, the operations go
+// to a pubsub service and the event notifications come through the
+// dispatcher. An empty service is the PEP service of the account.
+package pubsub
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns        = "http://jabber.org/protocol/pubsub"
+	NsEvent   = "http://jabber.org/protocol/pubsub#event"
+	NsOwner   = "http://jabber.org/protocol/pubsub#owner"
+	NsConfig  = "http://jabber.org/protocol/pubsub#node_config"
+	NsOptions = "http://jabber.org/protocol/pubsub#publish-options"
+)
+
+// Item is a published item, Payload is its raw XML.
+type Item struct {
+	ID      string `xml:"id,attr,omitempty"`
+	Payload []byte `xml:",innerxml"`
+}
+
+// Decode unmarshals the payload of the item.
+func (i *Item) Decode(v interface{}) error {
+	return xml.Unmarshal(i.Payload, v)
+}
+
+// newForm makes a submitted form of the given type, nil without values.
+func newForm(typ string, values map[string]string) *dataforms.Form {
+	if len(values) == 0 {
+		return nil
+	}
+	return dataforms.NewSubmit(typ, dataforms.Single(values))
+}
+
+type nodeRef struct {
+	Node string `xml:"node,attr"`
+}
+
+type subscription struct {
+	Node  string `xml:"node,attr"`
+	JID   string `xml:"jid,attr"`
+	SubID string `xml:"subid,attr,omitempty"`
+	State string `xml:"subscription,attr,omitempty"`
+}
+
+type items struct {
+	Node     string `xml:"node,attr"`
+	MaxItems int    `xml:"max_items,attr,omitempty"`
+	Items    []Item `xml:"item"`
+}
+
+type publish struct {
+	Node  string `xml:"node,attr"`
+	Items []Item `xml:"item"`
+}
+
+type retract struct {
+	Node   string `xml:"node,attr"`
+	Notify bool   `xml:"notify,attr,omitempty"`
+	Items  []Item `xml:"item"`
+}
+
+type options struct {
+	Form *dataforms.Form
+}
+
+type query struct {
+	XMLName      xml.Name      `xml:"http://jabber.org/protocol/pubsub pubsub"`
+	Create       *nodeRef      `xml:"create"`
+	Configure    *options      `xml:"configure"`
+	Subscribe    *subscription `xml:"subscribe"`
+	Unsubscribe  *subscription `xml:"unsubscribe"`
+	Subscription *subscription `xml:"subscription"`
+	Items        *items        `xml:"items"`
+	Publish      *publish      `xml:"publish"`
+	Options      *options      `xml:"publish-options"`
+	Retract      *retract      `xml:"retract"`
+}
+
+type owner struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub#owner pubsub"`
+	Delete  *nodeRef `xml:"delete"`
+}
+
+// request asks the service, the account's own PEP service when empty.
+func request(d *dispatch.Dispatcher, typ, service string, q interface{}) (*stanza.IQ, error) {
+	var to jid.JID
+	if service != "" {
+		var err error
+		if to, err = jid.Parse(service); err != nil {
+			return nil, err
+		}
+	}
+	iq, err := stanza.NewIQ(typ, to, q)
+	if err != nil {
+		return nil, err
+	}
+	return d.Request(iq)
+}
+
+// Create makes a node, config holds the pubsub#node_config fields to set.
+func Create(d *dispatch.Dispatcher, service, node string, config map[string]string) error {
+	q := &query{Create: &nodeRef{Node: node}}
+	if f := newForm(NsConfig, config); f != nil {
+		q.Configure = &options{Form: f}
+	}
+	_, err := request(d, stanza.SET, service, q)
+	return err
+}
+
+// Delete removes a node with its items.
+func Delete(d *dispatch.Dispatcher, service, node string) error {
+	_, err := request(d, stanza.SET, service, &owner{Delete: &nodeRef{Node: node}})
+	return err
+}
+
+// Publish publishes v as the item with the id, the service picks an id when
+// it is empty; opts are the publish-options preconditions. It returns the id
+// of the published item.
+func Publish(d *dispatch.Dispatcher, service, node, id string, v interface{}, opts map[string]string) (string, error) {
+	payload, err := xml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	q := &query{Publish: &publish{Node: node, Items: []Item{{ID: id, Payload: payload}}}}
+	if f := newForm(NsOptions, opts); f != nil {
+		q.Options = &options{Form: f}
+	}
+	res, err := request(d, stanza.SET, service, q)
+	if err != nil {
+		return "", err
+	}
+	ret := &query{}
+	if len(res.Payload) > 0 && res.Decode(ret) == nil && ret.Publish != nil && len(ret.Publish.Items) > 0 {
+		id = ret.Publish.Items[0].ID
+	}
+	return id, nil
+}
+
+// Retract removes an item and notifies the subscribers about it.
+func Retract(d *dispatch.Dispatcher, service, node, id string) error {
+	_, err := request(d, stanza.SET, service, &query{Retract: &retract{Node: node, Notify: true, Items: []Item{{ID: id}}}})
+	return err
+}
+
+// Items retrieves the items of a node, the last max ones unless max is 0, or
+// those with the given ids.
+func Items(d *dispatch.Dispatcher, service, node string, max int, ids ...string) ([]Item, error) {
+	q := &query{Items: &items{Node: node, MaxItems: max}}
+	for _, id := range ids {
+		q.Items.Items = append(q.Items.Items, Item{ID: id})
+	}
+	res, err := request(d, stanza.GET, service, q)
+	if err != nil {
+		return nil, err
+	}
+	ret := &query{}
+	if err = res.Decode(ret); err != nil || ret.Items == nil {
+		return nil, err
+	}
+	return ret.Items.Items, nil
+}
+
+// Subscribe subscribes jid to a node and returns the subscription state,
+// e.g. "subscribed" or "pending".
+func Subscribe(d *dispatch.Dispatcher, service, node, jid string) (string, error) {
+	res, err := request(d, stanza.SET, service, &query{Subscribe: &subscription{Node: node, JID: jid}})
+	if err != nil {
+		return "", err
+	}
+	ret := &query{}
+	if err = res.Decode(ret); err != nil || ret.Subscription == nil {
+		return "", err
+	}
+	return ret.Subscription.State, nil
+}
+
+// Unsubscribe ends the subscription of jid to a node.
+func Unsubscribe(d *dispatch.Dispatcher, service, node, jid string) error {
+	_, err := request(d, stanza.SET, service, &query{Unsubscribe: &subscription{Node: node, JID: jid}})
+	return err
+}
+
+// Event is a notification about the items of a node.
+type Event struct {
+	Node     string
+	Items    []Item
+	Retracts []string
+	// Deleted is set when the node was deleted.
+	Deleted bool
+}
+
+type event struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub#event event"`
+	Items   *struct {
+		Node     string `xml:"node,attr"`
+		Items    []Item `xml:"item"`
+		Retracts []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"retract"`
+	} `xml:"items"`
+	Delete *nodeRef `xml:"delete"`
+}
+
+// Handler passes the event notifications to fn, consuming them.
+func Handler(fn func(from string, e *Event)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR || h.Archived {
+			return false
+		}
+		m := &struct {
+			Event *event `xml:"http://jabber.org/protocol/pubsub#event event"`
+		}{}
+		if xml.Unmarshal(raw, m) != nil || m.Event == nil {
+			return false
+		}
+		e := &Event{}
+		switch {
+		case m.Event.Items != nil:
+			e.Node, e.Items = m.Event.Items.Node, m.Event.Items.Items
+			for _, r := range m.Event.Items.Retracts {
+				e.Retracts = append(e.Retracts, r.ID)
+			}
+		case m.Event.Delete != nil:
+			e.Node, e.Deleted = m.Event.Delete.Node, true
+		default:
+			return true
+		}
+		fn(h.From.String(), e)
+		return true
+	}
+}
@@ -0,0 +1,121 @@
+// Package pump reads a stream in its own goroutine and processes the stanzas
+// on a pool of workers, so a slow handler doesn't hold up the reading.
+package pump
+
+import (
+	"bytes"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 256
+
+	// warnEvery limits the warnings about a filling queue
+	warnEvery = 10 * time.Second
+)
+
+// Pump spreads the stanzas over the workers by the bare JID of their sender,
+// so the stanzas of one room or contact keep their order.
+type Pump struct {
+	queues   []chan *bytes.Buffer
+	size     int
+	depth    int64
+	lastWarn int64
+}
+
+func New(workers, size int) *Pump {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	if size < workers {
+		size = DefaultQueueSize
+	}
+	p := &Pump{size: size}
+	for i := 0; i < workers; i++ {
+		p.queues = append(p.queues, make(chan *bytes.Buffer, size/workers))
+	}
+	return p
+}
+
+// Depth returns the number of stanzas waiting for a worker.
+func (p *Pump) Depth() int {
+	return int(atomic.LoadInt64(&p.depth))
+}
+
+func (p *Pump) queue(raw []byte) chan *bytes.Buffer {
+	_, h, err := stanza.Peek(raw)
+	if err != nil || h.From.IsZero() {
+		return p.queues[0]
+	}
+	f := fnv.New32a()
+	f.Write([]byte(h.From.Bare().String()))
+	return p.queues[f.Sum32()%uint32(len(p.queues))]
+}
+
+func (p *Pump) push(in *bytes.Buffer) {
+	// the stream may reuse its buffer once the callback returns; the copy
+	// isn't pooled, the handlers may keep slices of it
+	buf := bytes.NewBuffer(append([]byte(nil), in.Bytes()...))
+	q := p.queue(buf.Bytes())
+	if d := atomic.AddInt64(&p.depth, 1); d > int64(p.size)*3/4 {
+		now := time.Now().UnixNano()
+		if last := atomic.LoadInt64(&p.lastWarn); now-last > int64(warnEvery) && atomic.CompareAndSwapInt64(&p.lastWarn, last, now) {
+			log.Println("pump: queue depth", d, "of", p.size)
+		}
+	}
+	// a full queue blocks the reading, which pushes back on the server
+	q <- buf
+}
+
+func (p *Pump) work(q chan *bytes.Buffer, fn func(*bytes.Buffer) bool) {
+	for buf := range q {
+		atomic.AddInt64(&p.depth, -1)
+		fn(buf)
+	}
+}
+
+// ender is a stream that tells once it has ended and why, like c2s.Stream.
+type ender interface {
+	Err() error
+}
+
+// Run starts the workers calling fn and reads the stream until it ends, it
+// has the shape of a step so the bot can end with it. The workers stop once
+// they are done with what was read, the error is the one the stream ended
+// with. A stream that can't tell it has ended is read forever.
+func (p *Pump) Run(st stream.Stream, fn func(*bytes.Buffer) bool) error {
+	var wg sync.WaitGroup
+	for _, q := range p.queues {
+		wg.Add(1)
+		go func(q chan *bytes.Buffer) {
+			defer wg.Done()
+			p.work(q, fn)
+		}(q)
+	}
+	defer func() {
+		for _, q := range p.queues {
+			close(q)
+		}
+		wg.Wait()
+	}()
+	end, _ := st.(ender)
+	for {
+		st.Ring(func(in *bytes.Buffer) bool {
+			p.push(in)
+			return true
+		}, 0)
+		if end != nil {
+			if err := end.Err(); err != nil {
+				return err
+			}
+		}
+	}
+}
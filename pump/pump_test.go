@@ -0,0 +1,115 @@
+package pump
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+// busyRoom are the groupchat messages of a room with many talking occupants.
+func busyRoom(n int) []*bytes.Buffer {
+	ret := make([]*bytes.Buffer, n)
+	for i := range ret {
+		ret[i] = bytes.NewBufferString(fmt.Sprintf(`<message from="golang@conference.jabber.ru/nick%d" to="goxep@xmpp.ru/go" type="groupchat" id="m%d">`+
+			`<body>message %d with some text in it, as people write</body>`+
+			`<stanza-id xmlns="urn:xmpp:sid:0" id="s%d" by="golang@conference.jabber.ru"/></message>`, i%50, i, i, i))
+	}
+	return ret
+}
+
+// ending is a stream delivering its stanzas and ending after them.
+type ending struct {
+	streamtest.Stream
+	in    chan *bytes.Buffer
+	ended bool
+}
+
+func (e *ending) Ring(fn func(*bytes.Buffer) bool, _ time.Duration) {
+	for b := range e.in {
+		if fn(b) {
+			return
+		}
+	}
+	e.ended = true
+}
+
+func (e *ending) Err() error {
+	if e.ended {
+		return io.EOF
+	}
+	return nil
+}
+
+func TestRunEnds(t *testing.T) {
+	room := busyRoom(100)
+	st := &ending{in: make(chan *bytes.Buffer, len(room))}
+	for _, b := range room {
+		st.in <- b
+	}
+	close(st.in)
+	var mu sync.Mutex
+	handled := 0
+	p := New(DefaultWorkers, DefaultQueueSize)
+	err := p.Run(st, func(*bytes.Buffer) bool {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+		return true
+	})
+	if err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+	// the workers are done with what was read before Run returns
+	if handled != len(room) || p.Depth() != 0 {
+		t.Fatalf("handled %d of %d, %d queued", handled, len(room), p.Depth())
+	}
+}
+
+// benchmarkBusyRoom pumps a busy room to workers decoding the messages like
+// the bot loop, with the copies of the stanzas pooled or not.
+func benchmarkBusyRoom(b *testing.B, pooled bool) {
+	room := busyRoom(1000)
+	p := New(DefaultWorkers, DefaultQueueSize)
+	var wg sync.WaitGroup
+	for _, q := range p.queues {
+		go p.work(q, func(buf *bytes.Buffer) bool {
+			defer wg.Done()
+			m := &stanza.Message{}
+			xml.Unmarshal(buf.Bytes(), m)
+			if pooled {
+				stanza.PutBuffer(buf)
+			}
+			return true
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		in := room[i%len(room)]
+		if !pooled {
+			p.push(in)
+			continue
+		}
+		buf := stanza.GetBuffer()
+		buf.Write(in.Bytes())
+		p.queue(buf.Bytes()) <- buf
+	}
+	wg.Wait()
+}
+
+func BenchmarkBusyRoom(b *testing.B) {
+	benchmarkBusyRoom(b, false)
+}
+
+// BenchmarkBusyRoomPooled shows what pooling the copies would save.
+func BenchmarkBusyRoomPooled(b *testing.B) {
+	benchmarkBusyRoom(b, true)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/kpmy/xep/reactions"
+	"github.com/kpmy/xep/stanza"
+)
+
+// react lets hooks react to a message in a joined room, or in a chat with a
+// contact, by its id; an empty reaction removes the bot's ones.
+func react(to, id, reaction string) error {
+	var list []string
+	if reaction != "" {
+		list = []string{reaction}
+	}
+	if _, ok := rooms.Get(to); ok {
+		return reactions.React(disp, stanza.GROUPCHAT, to, id, list...)
+	}
+	if contacts.Contains(to) {
+		return reactions.React(disp, stanza.CHAT, to, id, list...)
+	}
+	return errors.New("can't react in " + to)
+}
@@ -0,0 +1,61 @@
+// Package reactions implements XEP-0444 message reactions.
+package reactions
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:reactions:0"
+
+type Reactions struct {
+	XMLName   xml.Name `xml:"urn:xmpp:reactions:0 reactions"`
+	ID        string   `xml:"id,attr"`
+	Reactions []string `xml:"reaction"`
+}
+
+// Of returns the reactions carried by the message, if any.
+func Of(m *stanza.Message) (*Reactions, bool) {
+	e := m.Ext(Ns, "reactions")
+	if e == nil {
+		return nil, false
+	}
+	r := &Reactions{}
+	return r, e.Decode(r) == nil
+}
+
+// React sets the bot's reactions to the message with the id, the stanza-id
+// assigned by the room for groupchat. No reactions remove the previous ones.
+func React(d *dispatch.Dispatcher, typ, to, id string, reactions ...string) error {
+	addr, err := jid.Parse(to)
+	if err != nil {
+		return err
+	}
+	m := stanza.NewMessage(typ, addr, "")
+	if err := m.With(&Reactions{ID: id, Reactions: reactions}); err != nil {
+		return err
+	}
+	m.Hint(stanza.Store)
+	return d.Send(m)
+}
+
+// Handler passes the received reactions to fn, consuming them.
+func Handler(fn func(from string, r *Reactions)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR || h.Archived {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil {
+			return false
+		}
+		r, ok := Of(m)
+		if ok {
+			fn(h.From.String(), r)
+		}
+		return ok && m.Body == ""
+	}
+}
@@ -0,0 +1,57 @@
+package record
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/streamtest"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+var _ stream.Stream = (*Player)(nil)
+
+// Player is a stream.Stream serving a recording, everything written to it is
+// kept for inspection and may be answered like on a streamtest.Stream. Done
+// is closed once the recording is delivered.
+type Player struct {
+	*streamtest.Stream
+	// Speed replays the recording paced by the recorded times, sped up by the
+	// factor; zero delivers the stanzas as fast as they are consumed.
+	Speed   float64
+	entries []Entry
+	start   sync.Once
+	Done    <-chan struct{}
+}
+
+func NewPlayer(server string, entries []Entry) *Player {
+	st := streamtest.New(server)
+	return &Player{Stream: st, entries: entries, Done: st.Drained()}
+}
+
+// play pushes the recording to the stream, then closes it.
+func (p *Player) play() {
+	for i, e := range p.entries {
+		if i > 0 && p.Speed > 0 {
+			time.Sleep(time.Duration(float64(e.At.Sub(p.entries[i-1].At)) / p.Speed))
+		}
+		p.Push(e.Raw)
+	}
+	p.Close()
+}
+
+// Ring delivers recorded stanzas until fn is done with one or the recording
+// ends, after which it blocks for the timeout (forever if zero). The
+// recording starts playing on the first call.
+func (p *Player) Ring(fn func(*bytes.Buffer) bool, timeout time.Duration) {
+	p.start.Do(func() { go p.play() })
+	p.Stream.Ring(fn, timeout)
+}
+
+// Written returns the stanzas written so far.
+func (p *Player) Written() (ret []string) {
+	for _, raw := range p.Stream.Written() {
+		ret = append(ret, string(raw))
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package record
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/attention"
+	"github.com/kpmy/xep/chatstates"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/ping"
+	"github.com/kpmy/xep/pump"
+	"github.com/kpmy/xep/reactions"
+	"github.com/kpmy/xep/retract"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/version"
+)
+
+// TestReplay plays testdata/room.jsonl through the pump and the dispatcher
+// the way the bot reads a stream, and checks what each handler got and what
+// was left for the bot loop.
+func TestReplay(t *testing.T) {
+	entries, err := Load(filepath.Join("testdata", "room.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer("xmpp.ru", entries)
+	d := dispatch.New(p)
+
+	var mu sync.Mutex
+	fired := make(map[string][]string)
+	fire := func(handler, what string) {
+		mu.Lock()
+		fired[handler] = append(fired[handler], what)
+		mu.Unlock()
+	}
+	d.Handle(ping.Handler(d), ping.Ns)
+	d.Handle(version.Handler(d, &version.Query{Name: "xep", Version: "test"}), version.Ns)
+	d.Handle(attention.Handler(func(from, body string) { fire("attention", from) }), attention.Ns)
+	d.Handle(chatstates.Handler(func(from, state string) { fire("chatstates", from+" "+state) }), chatstates.Ns)
+	d.Handle(reactions.Handler(func(from string, r *reactions.Reactions) { fire("reactions", r.ID+" "+strings.Join(r.Reactions, "")) }), reactions.Ns)
+	d.Handle(retract.Handler(func(from string, r *retract.Retract) { fire("retract", r.ID) }), retract.Ns)
+
+	var wg sync.WaitGroup
+	wg.Add(len(entries))
+	go pump.New(pump.DefaultWorkers, pump.DefaultQueueSize).Run(p, func(in *bytes.Buffer) bool {
+		defer wg.Done()
+		if !d.Feed(in.Bytes()) {
+			_, h, _ := stanza.Peek(in.Bytes())
+			fire("bot", h.ID)
+		}
+		return true
+	})
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the recording wasn't played through")
+	}
+
+	for _, got := range fired {
+		sort.Strings(got)
+	}
+	want := map[string][]string{
+		"attention": {"nick@xmpp.ru/home"},
+		// the state of a message with a body is told, the message goes on
+		"chatstates": {"nick@xmpp.ru/home active", "nick@xmpp.ru/home composing"},
+		"reactions":  {"s1 👍"},
+		"retract":    {"s1"},
+		// the presences have no id
+		"bot": {"", "", "c2", "m1"},
+	}
+	if !reflect.DeepEqual(fired, want) {
+		t.Fatalf("handlers got %v, want %v", fired, want)
+	}
+	var answered []string
+	for _, raw := range p.Written() {
+		_, h, err := stanza.Peek([]byte(raw))
+		if err != nil || h.Type != stanza.RESULT {
+			t.Fatalf("wrote %s", raw)
+		}
+		answered = append(answered, h.ID+" "+h.To.String())
+	}
+	sort.Strings(answered)
+	if want := []string{"p1 xmpp.ru", "v1 nick@xmpp.ru/home"}; !reflect.DeepEqual(answered, want) {
+		t.Fatalf("answered %v, want %v", answered, want)
+	}
+}
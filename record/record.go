@@ -0,0 +1,80 @@
+// Package record writes received stanzas to files and plays them back
+// through the bot pipeline, so streams seen in production rooms can be turned
+// into deterministic regression tests.
+//
+// A recording has one json object per line: {"at": time, "raw": stanza}.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	At  time.Time `json:"at"`
+	Raw string    `json:"raw"`
+}
+
+// Recorder appends received stanzas to a file.
+type Recorder struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func New(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Wrap records every stanza before passing it to the stream callback fn.
+func (r *Recorder) Wrap(fn func(*bytes.Buffer) bool) func(*bytes.Buffer) bool {
+	return func(in *bytes.Buffer) bool {
+		r.Lock()
+		r.enc.Encode(&Entry{At: time.Now(), Raw: in.String()})
+		r.Unlock()
+		return fn(in)
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.f.Close()
+}
+
+// Load reads a recording.
+func Load(path string) (ret []Entry, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<24)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		e := Entry{}
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, sc.Err()
+}
+
+// Replay feeds the recorded stanzas to fn in order, ignoring their timing.
+func Replay(entries []Entry, fn func(*bytes.Buffer) bool) {
+	for _, e := range entries {
+		fn(bytes.NewBufferString(e.Raw))
+	}
+}
@@ -0,0 +1,172 @@
+// Package register creates the account with XEP-0077 in-band registration,
+// the step runs on the negotiated stream before authentication.
+package register
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const (
+	Ns        = "jabber:iq:register"
+	NsCaptcha = "urn:xmpp:captcha"
+
+	Timeout = 30 * time.Second
+)
+
+var ErrTimeout = errors.New("register: no reply from the server")
+
+// the legacy fields are pointers, as the server asks for them by sending
+// them empty
+type query struct {
+	XMLName      xml.Name  `xml:"jabber:iq:register query"`
+	Registered   *struct{} `xml:"registered"`
+	Instructions string    `xml:"instructions,omitempty"`
+	Username     *string   `xml:"username"`
+	Password     *string   `xml:"password"`
+	Email        *string   `xml:"email"`
+	Form         *dataforms.Form
+}
+
+// Register holds the account to create.
+type Register struct {
+	Username, Password, Email string
+	// Answer fills the form fields other than the account ones, e.g. a
+	// captcha; the simple arithmetic ones are solved when it is nil or
+	// doesn't know the field.
+	Answer func(f dataforms.Field) (string, bool)
+}
+
+var sum = regexp.MustCompile(`(-?\d+)\s*([-+*x×])\s*(-?\d+)`)
+
+// Solve answers a question like "What is 3 + 4?".
+func Solve(question string) (string, bool) {
+	m := sum.FindStringSubmatch(question)
+	if m == nil {
+		return "", false
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[3])
+	switch m[2] {
+	case "+":
+		return strconv.Itoa(a + b), true
+	case "-":
+		return strconv.Itoa(a - b), true
+	default:
+		return strconv.Itoa(a * b), true
+	}
+}
+
+func (r *Register) answer(f dataforms.Field) (string, bool) {
+	if r.Answer != nil {
+		if v, ok := r.Answer(f); ok {
+			return v, true
+		}
+	}
+	if v, ok := Solve(f.Label); ok {
+		return v, true
+	}
+	return Solve(f.Desc)
+}
+
+// fill answers the registration form.
+func (r *Register) fill(f *dataforms.Form) (*dataforms.Form, error) {
+	values := make(map[string][]string)
+	for _, fld := range f.Fields {
+		switch fld.Var {
+		case "", "FORM_TYPE":
+		case "username":
+			values[fld.Var] = []string{r.Username}
+		case "password":
+			values[fld.Var] = []string{r.Password}
+		case "email":
+			values[fld.Var] = []string{r.Email}
+		default:
+			if fld.Type == dataforms.HIDDEN || fld.Type == dataforms.FIXED {
+				continue
+			}
+			if v, ok := r.answer(fld); ok {
+				values[fld.Var] = []string{v}
+			} else if fld.Required != nil {
+				return nil, errors.New("register: can't answer " + fld.Var + " " + fld.Label)
+			}
+		}
+	}
+	ret := f.Submit(values)
+	return ret, f.Validate(ret)
+}
+
+// request writes the IQ and waits for its reply on the stream.
+func request(st stream.Stream, iq *stanza.IQ) (ret *stanza.IQ, err error) {
+	buf, err := stanza.Buffer(iq)
+	if err != nil {
+		return nil, err
+	}
+	if err = st.Write(buf); err != nil {
+		return nil, err
+	}
+	st.Ring(func(b *bytes.Buffer) bool {
+		res := &stanza.IQ{}
+		if xml.Unmarshal(b.Bytes(), res) != nil || res.ID != iq.ID {
+			return false
+		}
+		ret = res
+		return true
+	}, Timeout)
+	switch {
+	case ret == nil:
+		return nil, ErrTimeout
+	case ret.Type == stanza.ERROR:
+		return ret, &stanza.IQError{IQ: ret}
+	}
+	return ret, nil
+}
+
+// Act is the registration step, it succeeds when the account exists already.
+func (r *Register) Act() func(stream.Stream) error {
+	return func(st stream.Stream) error {
+		iq, _ := stanza.NewIQ(stanza.GET, jid.JID{}, &query{})
+		iq.ID = "reg1"
+		res, err := request(st, iq)
+		if err != nil {
+			return err
+		}
+		q := &query{}
+		if err = res.Decode(q); err != nil {
+			return err
+		}
+		if q.Registered != nil {
+			return nil
+		}
+		submit := &query{}
+		if q.Form != nil {
+			if submit.Form, err = r.fill(q.Form); err != nil {
+				return err
+			}
+		} else {
+			submit.Username, submit.Password = &r.Username, &r.Password
+			if q.Email != nil {
+				submit.Email = &r.Email
+			}
+		}
+		iq, _ = stanza.NewIQ(stanza.SET, jid.JID{}, submit)
+		iq.ID = "reg2"
+		if _, err = request(st, iq); err != nil {
+			if stanza.IsConflict(err) {
+				// the account was created before
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package register
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestRegistered(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`id="reg1"`), `<iq type="result" id="reg1"><query xmlns="jabber:iq:register"><registered/><username>bot</username></query></iq>`)
+	if err := (&Register{Username: "bot", Password: "secret"}).Act()(st); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(st.Written()); n != 1 {
+		t.Fatalf("%d requests, the registered account was registered again", n)
+	}
+}
+
+func TestLegacyFields(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`id="reg1"`), `<iq type="result" id="reg1"><query xmlns="jabber:iq:register"><username/><password/><email/></query></iq>`)
+	st.On(streamtest.Contains(`id="reg2"`), `<iq type="result" id="reg2"/>`)
+	if err := (&Register{Username: "bot", Password: "secret", Email: "bot@example.org"}).Act()(st); err != nil {
+		t.Fatal(err)
+	}
+	w := st.Written()
+	if len(w) != 2 {
+		t.Fatalf("%d requests, want 2", len(w))
+	}
+	for _, want := range []string{"<username>bot</username>", "<password>secret</password>", "<email>bot@example.org</email>"} {
+		if !bytes.Contains(w[1], []byte(want)) {
+			t.Errorf("submit %s lacks %s", w[1], want)
+		}
+	}
+}
+
+func TestCaptchaForm(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`id="reg1"`), `<iq type="result" id="reg1"><query xmlns="jabber:iq:register">`+
+		`<x xmlns="jabber:x:data" type="form">`+
+		`<field type="hidden" var="FORM_TYPE"><value>jabber:iq:register</value></field>`+
+		`<field type="text-single" var="username"><required/></field>`+
+		`<field type="text-private" var="password"><required/></field>`+
+		`<field type="text-single" var="answer" label="What is 3 + 4?"><required/></field>`+
+		`</x></query></iq>`)
+	st.On(streamtest.Contains(`id="reg2"`), `<iq type="result" id="reg2"/>`)
+	if err := (&Register{Username: "bot", Password: "secret"}).Act()(st); err != nil {
+		t.Fatal(err)
+	}
+	w := st.Written()
+	if len(w) != 2 || !strings.Contains(string(w[1]), "<value>7</value>") {
+		t.Fatalf("captcha not answered: %q", w)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`id="reg1"`), `<iq type="result" id="reg1"><query xmlns="jabber:iq:register"><username/><password/></query></iq>`)
+	st.On(streamtest.Contains(`id="reg2"`), `<iq type="error" id="reg2"><error type="cancel"><conflict xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`)
+	if err := (&Register{Username: "bot", Password: "secret"}).Act()(st); err != nil {
+		t.Fatalf("a conflict means the account exists, got %v", err)
+	}
+}
+
+func TestRefused(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`id="reg1"`), `<iq type="error" id="reg1"><error type="cancel"><service-unavailable xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`)
+	if err := (&Register{Username: "bot", Password: "secret"}).Act()(st); err == nil {
+		t.Fatal("refused registration succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+// Package retract implements XEP-0424 message retraction and XEP-0425
+// moderation of room messages.
+package retract
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns         = "urn:xmpp:message-retract:1"
+	NsModerate = "urn:xmpp:message-moderate:1"
+)
+
+// Moderated tells a retraction was done by a room moderator.
+type Moderated struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-moderate:1 moderated"`
+	By      string   `xml:"by,attr,omitempty"`
+}
+
+// Retract retracts the message with the id, the stanza-id assigned by the
+// room for groupchat.
+type Retract struct {
+	XMLName   xml.Name   `xml:"urn:xmpp:message-retract:1 retract"`
+	ID        string     `xml:"id,attr"`
+	Moderated *Moderated `xml:"moderated,omitempty"`
+	Reason    string     `xml:"reason,omitempty"`
+}
+
+type moderate struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-moderate:1 moderate"`
+	ID      string   `xml:"id,attr"`
+	Retract struct {
+		XMLName xml.Name `xml:"urn:xmpp:message-retract:1 retract"`
+	}
+	Reason string `xml:"reason,omitempty"`
+}
+
+// Of returns the retraction carried by the message, if any.
+func Of(m *stanza.Message) (*Retract, bool) {
+	e := m.Ext(Ns, "retract")
+	if e == nil {
+		return nil, false
+	}
+	r := &Retract{}
+	return r, e.Decode(r) == nil && r.ID != ""
+}
+
+// Moderate asks the room to retract the message with the stanza-id, the bot
+// must be a moderator there.
+func Moderate(d *dispatch.Dispatcher, room, id, reason string) error {
+	to, err := jid.Parse(room)
+	if err != nil {
+		return err
+	}
+	iq, err := stanza.NewIQ(stanza.SET, to, &moderate{ID: id, Reason: reason})
+	if err != nil {
+		return err
+	}
+	_, err = d.Request(iq)
+	return err
+}
+
+// Handler passes the received retractions to fn, the archived ones as well,
+// and consumes them so their fallback body isn't taken for a message.
+func Handler(fn func(from string, r *Retract)) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type == stanza.ERROR {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil {
+			return false
+		}
+		r, ok := Of(m)
+		if ok {
+			fn(h.From.String(), r)
+		}
+		return ok
+	}
+}
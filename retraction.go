@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/retract"
+	"github.com/kpmy/xep/stanza"
+)
+
+// logPosts keeps the log of the main room shown on the web page, it never
+// consumes the messages.
+func logPosts() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "message" || h.Type != stanza.GROUPCHAT || h.Archived || statsSalt != "" {
+			return false
+		}
+		room, nick := splitJID(h.From)
+		if room != ROOM || nick == "" {
+			return false
+		}
+		m := &stanza.Message{}
+		if xml.Unmarshal(raw, m) != nil || m.Delay != nil {
+			return false
+		}
+		user := nick
+		if u, ok := muc.UserMapping()[nick]; ok {
+			user, _ = u.(string)
+		}
+		posts.Lock()
+		posts.data = append(posts.data, Post{Nick: nick, User: user, Msg: m.Body, ID: m.StanzaID(room)})
+		posts.Unlock()
+		return false
+	}
+}
+
+// onRetract takes a message retracted in a room off the stats and the log, a
+// retraction from the room itself is a moderator's.
+func onRetract(from string, r *retract.Retract) {
+	room, nick := jid.Split(from)
+	if _, ok := rooms.Get(room); !ok {
+		return
+	}
+	user := ""
+	if nick != "" {
+		user = statUser(nick)
+	}
+	DecStat(room, user, r.ID)
+	if room == ROOM {
+		posts.Lock()
+		for i := range posts.data {
+			if p := &posts.data[i]; p.ID == r.ID && (nick == "" || p.Nick == nick) {
+				p.Msg, p.Retracted = "", true
+			}
+		}
+		posts.Unlock()
+	}
+	by := nick
+	if r.Moderated != nil {
+		by = r.Moderated.By
+	}
+	emit("retract", map[string]string{"room": room, "id": r.ID, "by": by, "reason": r.Reason})
+}
+
+func init() {
+	commands["retract"] = &command{admin: true, confirm: true, usage: "<id> [reason], or [reason] in reply to the message", run: func(c *cmd) (string, error) {
+		id, args := c.re, c.args
+		if id == "" {
+			if len(args) < 1 {
+				return "", errUsage
+			}
+			id, args = args[0], args[1:]
+		}
+		if o, ok := rooms.Occupant(c.room, rooms.Nick(c.room)); !ok || o.Role != muc.MODERATOR {
+			return "", errors.New("not a moderator in " + c.room)
+		}
+		return "", retract.Moderate(disp, c.room, id, strings.Join(args, " "))
+	}}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fjl/go-couchdb"
+)
+
+// CRoomConfig is the bot setup of a room, kept next to its stats.
+type CRoomConfig struct {
+	Room    string
+	Modules []string
+	Prefix  string
+	Locale  string
+	// Log opts the room in to message logging.
+	Log bool
+	// Attention allows buzzing the occupants for urgent alerts.
+	Attention bool
+	// Rich sends the formatted responses as XHTML-IM too.
+	Rich bool
+}
+
+var prefixes = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+func roomCfgDocId(room string) string {
+	return "config:" + room
+}
+
+func GetRoomConfig(room string) (ret *CRoomConfig, err error) {
+	ret = &CRoomConfig{}
+	if err = db.Get(roomCfgDocId(room), ret, nil); couchdb.NotFound(err) {
+		ret, err = &CRoomConfig{Room: room, Prefix: "!", Locale: "ru"}, nil
+	}
+	return
+}
+
+func SetRoomConfig(c *CRoomConfig) (err error) {
+	rev, err := db.Rev(roomCfgDocId(c.Room))
+	if err != nil && !couchdb.NotFound(err) {
+		return err
+	}
+	if _, err = db.Put(roomCfgDocId(c.Room), c, rev); err == nil {
+		prefixes.Lock()
+		prefixes.m[c.Room] = c.Prefix
+		prefixes.Unlock()
+	}
+	return
+}
+
+// commandPrefix returns the prefix of the commands in the room.
+func commandPrefix(room string) string {
+	prefixes.Lock()
+	p, ok := prefixes.m[room]
+	prefixes.Unlock()
+	if !ok {
+		p = "!"
+		if c, err := GetRoomConfig(room); err == nil && c.Prefix != "" {
+			p = c.Prefix
+		}
+		prefixes.Lock()
+		prefixes.m[room] = p
+		prefixes.Unlock()
+	}
+	return p
+}
+
+// isCommand reports whether a room message is a command and returns it in
+// the !name form the commands are run with.
+func isCommand(room, body string) (string, bool) {
+	p := commandPrefix(room)
+	if !strings.HasPrefix(body, p) {
+		return "", false
+	}
+	return "!" + strings.TrimPrefix(body, p), true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/kpmy/xep/bookmarks"
+	"github.com/kpmy/xep/muc"
+)
+
+// joinRoom enters a room and, when asked to, keeps it in the account bookmarks.
+func joinRoom(room, nick, password string, bookmark bool) error {
+	rooms.Add(&muc.Room{JID: room, Nick: nick, Password: password})
+	if err := rooms.Join(room)(disp.Stream()); err != nil {
+		return err
+	}
+	if bookmark && useBookmarks {
+		if err := bookmarks.Add(disp, bookmarks.Conference{JID: room, Nick: nick, Password: password, Autojoin: true}); err != nil {
+			log.Println("failed to bookmark", room, err)
+		}
+	}
+	return nil
+}
+
+// leaveRoom exits a room and drops its bookmark.
+func leaveRoom(room string) error {
+	if _, ok := rooms.Get(room); !ok {
+		return errors.New("not in " + room)
+	}
+	nick := rooms.Nick(room)
+	rooms.Remove(room)
+	if err := muc.Leave(room, nick, "")(disp.Stream()); err != nil {
+		return err
+	}
+	if useBookmarks {
+		if err := bookmarks.Remove(disp, room); err != nil {
+			log.Println("failed to remove bookmark of", room, err)
+		}
+	}
+	return nil
+}
+
+// autojoin joins the rooms bookmarked with autojoin.
+func autojoin() {
+	bm, err := bookmarks.Fetch(disp)
+	if err != nil {
+		log.Println("failed to fetch bookmarks:", err)
+		return
+	}
+	for _, c := range bm {
+		if _, joined := rooms.Get(c.JID); !c.Autojoin || joined {
+			continue
+		}
+		nick := c.Nick
+		if nick == "" {
+			nick = ME
+		}
+		log.Println("JOIN", c.JID, "from bookmarks")
+		if err := joinRoom(c.JID, nick, c.Password, false); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func init() {
+	commands["mkroom"] = &command{admin: true, usage: "<room@service> [title]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		room := c.args[0]
+		var cfg map[string][]string
+		if title := strings.Join(c.args[1:], " "); title != "" {
+			cfg = map[string][]string{"muc#roomconfig_roomname": {title}}
+		}
+		rooms.Add(&muc.Room{JID: room, Nick: ME})
+		if err := muc.Create(disp, room, ME, cfg); err != nil {
+			return "", err
+		}
+		if useBookmarks {
+			bookmarks.Add(disp, bookmarks.Conference{JID: room, Nick: ME, Autojoin: true})
+		}
+		return "created " + room, nil
+	}}
+	commands["rmroom"] = &command{admin: true, confirm: true, usage: "<room@service> [reason]", run: func(c *cmd) (string, error) {
+		if len(c.args) < 1 {
+			return "", errUsage
+		}
+		// forget the room first, so its destruction doesn't trigger a rejoin
+		rooms.Remove(c.args[0])
+		if err := muc.Destroy(disp, c.args[0], strings.Join(c.args[1:], " ")); err != nil {
+			return "", err
+		}
+		if useBookmarks {
+			bookmarks.Remove(disp, c.args[0])
+		}
+		return "destroyed " + c.args[0], nil
+	}}
+}
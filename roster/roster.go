@@ -0,0 +1,147 @@
+// Package roster implements the jabber:iq:roster protocol and keeps a local
+// copy of the roster up to date with the server's pushes.
+package roster
+
+import (
+	"encoding/xml"
+	"sort"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "jabber:iq:roster"
+
+const REMOVE = "remove"
+
+type Item struct {
+	JID          string   `xml:"jid,attr"`
+	Name         string   `xml:"name,attr,omitempty"`
+	Subscription string   `xml:"subscription,attr,omitempty"`
+	Ask          string   `xml:"ask,attr,omitempty"`
+	Groups       []string `xml:"group"`
+}
+
+type Query struct {
+	XMLName xml.Name `xml:"jabber:iq:roster query"`
+	Ver     string   `xml:"ver,attr,omitempty"`
+	Items   []Item   `xml:"item"`
+}
+
+// Roster is the cached roster of the account.
+type Roster struct {
+	sync.Mutex
+	// Owner is the bare JID of the account, pushes from anyone else are refused.
+	Owner string
+	items map[string]Item
+}
+
+func New(owner string) *Roster {
+	return &Roster{Owner: owner, items: make(map[string]Item)}
+}
+
+// Fetch replaces the cache with the roster held by the server.
+func (r *Roster) Fetch(d *dispatch.Dispatcher) error {
+	iq, _ := stanza.NewIQ(stanza.GET, jid.JID{}, &Query{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return err
+	}
+	q := &Query{}
+	if err = res.Decode(q); err != nil {
+		return err
+	}
+	r.Lock()
+	r.items = make(map[string]Item)
+	for _, i := range q.Items {
+		r.items[jid.Bare(i.JID)] = i
+	}
+	r.Unlock()
+	return nil
+}
+
+func (r *Roster) update(i Item) {
+	r.Lock()
+	if i.Subscription == REMOVE {
+		delete(r.items, jid.Bare(i.JID))
+	} else {
+		r.items[jid.Bare(i.JID)] = i
+	}
+	r.Unlock()
+}
+
+// Handler applies roster pushes to the cache.
+func (r *Roster) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.SET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		if !h.From.IsZero() && h.From.Bare().String() != jid.Bare(r.Owner) {
+			// not a push from our server, ignore it as RFC 6121 says
+			return true
+		}
+		q := &Query{}
+		if err := iq.Decode(q); err == nil {
+			for _, i := range q.Items {
+				r.update(i)
+			}
+		}
+		d.Send(iq.Result())
+		return true
+	}
+}
+
+func (r *Roster) set(d *dispatch.Dispatcher, i Item) error {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &Query{Items: []Item{i}})
+	if _, err := d.Request(iq); err != nil {
+		return err
+	}
+	// the push will tell the same, but callers want to see it right away
+	r.update(i)
+	return nil
+}
+
+// Add adds a contact or replaces its name and groups.
+func (r *Roster) Add(d *dispatch.Dispatcher, addr, name string, groups ...string) error {
+	return r.set(d, Item{JID: jid.Bare(addr), Name: name, Groups: groups})
+}
+
+func (r *Roster) Remove(d *dispatch.Dispatcher, addr string) error {
+	return r.set(d, Item{JID: jid.Bare(addr), Subscription: REMOVE})
+}
+
+// Rename changes the name of a contact keeping its groups.
+func (r *Roster) Rename(d *dispatch.Dispatcher, addr, name string) error {
+	i, _ := r.Get(addr)
+	return r.set(d, Item{JID: jid.Bare(addr), Name: name, Groups: i.Groups})
+}
+
+func (r *Roster) Get(addr string) (ret Item, ok bool) {
+	r.Lock()
+	ret, ok = r.items[jid.Bare(addr)]
+	r.Unlock()
+	return
+}
+
+// Contains reports whether the bare JID of addr is on the roster.
+func (r *Roster) Contains(addr string) bool {
+	_, ok := r.Get(addr)
+	return ok
+}
+
+// Items returns the cached roster ordered by JID.
+func (r *Roster) Items() (ret []Item) {
+	r.Lock()
+	for _, i := range r.items {
+		ret = append(ret, i)
+	}
+	r.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].JID < ret[j].JID })
+	return
+}
@@ -0,0 +1,126 @@
+package roster
+
+import (
+	"encoding/xml"
+	"sort"
+	"sync"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+// Policies for inbound subscription requests.
+const (
+	AutoAccept   = "auto-accept"
+	AcceptDomain = "accept-from-roster-domain"
+	Deny         = "deny"
+	AskAdmin     = "ask-admin"
+)
+
+type subscription struct {
+	XMLName xml.Name `xml:"presence"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+// Subscriptions answers presence subscription requests according to Policy.
+type Subscriptions struct {
+	sync.Mutex
+	Policy string
+	// Ask is called for requests waiting for an admin's decision.
+	Ask     func(jid string)
+	roster  *Roster
+	pending map[string]bool
+}
+
+func NewSubscriptions(r *Roster, policy string) *Subscriptions {
+	return &Subscriptions{Policy: policy, roster: r, pending: make(map[string]bool)}
+}
+
+func send(d *dispatch.Dispatcher, to, typ string) error {
+	return d.Send(&subscription{To: to, Type: typ})
+}
+
+// fromRosterDomain reports whether a contact of the same domain is already on the roster.
+func (s *Subscriptions) fromRosterDomain(contact string) bool {
+	domain := jid.Domain(contact)
+	for _, i := range s.roster.Items() {
+		if jid.Domain(i.JID) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler answers subscribe and unsubscribe presences.
+func (s *Subscriptions) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "presence" {
+			return false
+		}
+		addr := h.From.Bare().String()
+		switch h.Type {
+		case "subscribe":
+			s.Lock()
+			policy := s.Policy
+			s.Unlock()
+			switch {
+			case policy == AutoAccept, policy == AcceptDomain && s.fromRosterDomain(addr):
+				go s.Approve(d, addr)
+			case policy == AskAdmin:
+				s.Lock()
+				s.pending[addr] = true
+				ask := s.Ask
+				s.Unlock()
+				if ask != nil {
+					go ask(addr)
+				}
+			default:
+				go send(d, addr, "unsubscribed")
+			}
+			return true
+		case "unsubscribe":
+			s.Lock()
+			delete(s.pending, addr)
+			s.Unlock()
+			go send(d, addr, "unsubscribed")
+			return true
+		}
+		return false
+	}
+}
+
+// Approve grants a subscription and asks for one in return.
+func (s *Subscriptions) Approve(d *dispatch.Dispatcher, addr string) error {
+	addr = jid.Bare(addr)
+	s.Lock()
+	delete(s.pending, addr)
+	s.Unlock()
+	if err := send(d, addr, "subscribed"); err != nil {
+		return err
+	}
+	if i, ok := s.roster.Get(addr); ok && (i.Subscription == "to" || i.Subscription == "both") {
+		return nil
+	}
+	return send(d, addr, "subscribe")
+}
+
+func (s *Subscriptions) Reject(d *dispatch.Dispatcher, addr string) error {
+	addr = jid.Bare(addr)
+	s.Lock()
+	delete(s.pending, addr)
+	s.Unlock()
+	return send(d, addr, "unsubscribed")
+}
+
+// Pending lists the requests waiting for a decision.
+func (s *Subscriptions) Pending() (ret []string) {
+	s.Lock()
+	for addr := range s.pending {
+		ret = append(ret, addr)
+	}
+	s.Unlock()
+	sort.Strings(ret)
+	return
+}
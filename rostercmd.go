@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+)
+
+func init() {
+	commands["roster"] = &command{admin: true, usage: "list | add <jid> [name] | remove <jid> | rename <jid> <name>", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		switch {
+		case c.args[0] == "list":
+			ret := []string{}
+			for _, i := range contacts.Items() {
+				ret = append(ret, i.JID+" ("+i.Subscription+")")
+			}
+			return strings.Join(ret, ", "), nil
+		case c.args[0] == "add" && len(c.args) >= 2:
+			return "", contacts.Add(disp, c.args[1], strings.Join(c.args[2:], " "))
+		case c.args[0] == "remove" && len(c.args) == 2:
+			return "", contacts.Remove(disp, c.args[1])
+		case c.args[0] == "rename" && len(c.args) >= 3:
+			return "", contacts.Rename(disp, c.args[1], strings.Join(c.args[2:], " "))
+		}
+		return "", errUsage
+	}}
+}
+
+func init() {
+	commands["subscription"] = &command{admin: true, usage: "list | approve <jid> | deny <jid>", run: func(c *cmd) (string, error) {
+		switch {
+		case len(c.args) == 1 && c.args[0] == "list":
+			if p := subscriptions.Pending(); len(p) > 0 {
+				return "pending: " + strings.Join(p, ", "), nil
+			}
+			return "no pending requests", nil
+		case len(c.args) == 2 && c.args[0] == "approve":
+			return "approved " + c.args[1], subscriptions.Approve(disp, c.args[1])
+		case len(c.args) == 2 && c.args[0] == "deny":
+			return "denied " + c.args[1], subscriptions.Reject(disp, c.args[1])
+		}
+		return "", errUsage
+	}}
+}
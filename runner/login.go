@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kpmy/xep/register"
+	"github.com/kpmy/xippo/c2s/actors/steps"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+var ErrNoMechanism = errors.New("runner: no supported SASL mechanism offered")
+
+// mechanisms are the SASL mechanisms Login can authenticate with.
+var mechanisms = map[string]func(*units.Client, string) StateStep{
+	"PLAIN": PlainAuth,
+}
+
+// LoginError tells which phase of Login failed.
+type LoginError struct {
+	Phase string
+	Err   error
+}
+
+func (e *LoginError) Error() string {
+	return fmt.Sprintf("runner: login failed at %s: %v", e.Phase, e.Err)
+}
+
+func (e *LoginError) Unwrap() error { return e.Err }
+
+type LoginOptions struct {
+	Resource string
+	// Starter opens and restarts the stream, steps.Starter by default.
+	Starter Step
+	// Mechanisms are the SASL mechanisms to try in order, PLAIN by default.
+	Mechanisms []string
+	// Register creates the account before authenticating, a failure is only
+	// logged as the account may be there already.
+	Register bool
+	// Features reads the stream features after authentication, the plain
+	// negotiation step by default.
+	Features Step
+	// Timeout bounds each phase.
+	Timeout time.Duration
+	// BindRetries is how many times a failing bind is retried.
+	BindRetries int
+}
+
+// Login opens the stream, authenticates with the first of the mechanisms the
+// server offers, restarts the stream, binds the resource and starts the
+// session, recording the mechanism and the JID in the state. The phases give
+// up once the context of the chain is done. The streams of xippo can't be
+// upgraded, STARTTLS is not negotiated.
+func Login(c *units.Client, pwd string, opts LoginOptions) StateStep {
+	if len(opts.Mechanisms) == 0 {
+		opts.Mechanisms = []string{"PLAIN"}
+	}
+	if opts.Starter == nil {
+		opts.Starter = steps.Starter
+	}
+	return func(st stream.Stream, s *State) error {
+		ctx := s.Context()
+		do := func(phase string, step Step, retries int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			l := &link{step: step, timeout: opts.Timeout, retries: retries, backoff: time.Second}
+			if err := l.try(ctx, st); err != nil {
+				return &LoginError{Phase: phase, Err: err}
+			}
+			return nil
+		}
+		if err := do("stream start", opts.Starter, 0); err != nil {
+			return err
+		}
+		if err := do("negotiation", s.Step(Negotiate(opts.Mechanisms...)), 0); err != nil {
+			return err
+		}
+		var auth []Step
+		for _, m := range opts.Mechanisms {
+			if fn, ok := mechanisms[m]; ok && s.Offers(m) {
+				auth = append(auth, s.Step(fn(c, pwd)))
+			}
+		}
+		if len(auth) == 0 {
+			return &LoginError{Phase: "authentication", Err: ErrNoMechanism}
+		}
+		if opts.Register {
+			reg := &register.Register{Username: c.Name, Password: pwd}
+			if err := do("registration", reg.Act(), 0); err != nil {
+				log.Println(err)
+			}
+		}
+		if err := do("authentication", Either(auth...), 0); err != nil {
+			return err
+		}
+		features := opts.Features
+		if features == nil {
+			features = (&steps.Negotiation{}).Act()
+		}
+		if err := do("stream restart", opts.Starter, 0); err != nil {
+			return err
+		}
+		if err := do("stream features", features, 0); err != nil {
+			return err
+		}
+		if err := do("bind", s.Step(Bind(opts.Resource)), opts.BindRetries); err != nil {
+			return err
+		}
+		return do("session", steps.Session, 0)
+	}
+}
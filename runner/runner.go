@@ -0,0 +1,291 @@
+// Package runner chains the steps of a stream the way xippo's actors do, with
+// what they lack for a bot that has to survive a stalled server: steps can be
+// bounded in time, retried, taken on conditions and cancelled.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+// ErrNoBranch is returned by a step of If whose condition doesn't hold and by
+// Either when none of its steps was taken.
+var ErrNoBranch = errors.New("runner: no branch taken")
+
+// Step is a unit of work on the stream, like the steps of xippo.
+type Step func(stream.Stream) error
+
+// TimeoutError is passed to the error handlers of a step that took too long.
+type TimeoutError struct {
+	Step  string
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("runner: %s timed out after %s", e.Step, e.After)
+}
+
+// Errors are the errors of the steps of a parallel group that failed.
+type Errors []error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// StepError is returned by Run for the step that failed.
+type StepError struct {
+	// Index is the place of the step in the chain, from zero.
+	Index int
+	Step  string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("runner: step %d (%s): %v", e.Index, e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+type link struct {
+	step    Step
+	cond    func() bool
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	onError []func(error)
+}
+
+// Chain runs its steps in order, a failing step calls its error handlers and
+// stops the chain.
+type Chain struct {
+	links   []*link
+	timeout time.Duration
+	ctx     context.Context
+	state   *State
+}
+
+func With() *Chain {
+	return &Chain{state: &State{}}
+}
+
+// State returns the state the steps of the chain share.
+func (c *Chain) State() *State {
+	return c.state
+}
+
+// WithContext makes the chain give up on the step in flight and skip the rest
+// once ctx is done, the error handlers aren't called then.
+func (c *Chain) WithContext(ctx context.Context) *Chain {
+	c.ctx = ctx
+	c.state.Lock()
+	c.state.ctx = ctx
+	c.state.Unlock()
+	return c
+}
+
+// Timeout bounds the steps added after it, zero lets them run as long as
+// they take.
+func (c *Chain) Timeout(d time.Duration) *Chain {
+	c.timeout = d
+	return c
+}
+
+// Do adds a step bounded by the current timeout.
+func (c *Chain) Do(step Step, onError ...func(error)) *Chain {
+	return c.DoWithTimeout(step, c.timeout, onError...)
+}
+
+// DoState adds a step of the shared state.
+func (c *Chain) DoState(step StateStep, onError ...func(error)) *Chain {
+	return c.Do(c.state.Step(step), onError...)
+}
+
+// DoParallel adds a group of independent steps run at once, the group fails
+// with the Errors of its steps once they are all done. The timeout bounds the
+// group as a whole.
+func (c *Chain) DoParallel(steps ...Step) *Chain {
+	return c.Do(Parallel(steps...))
+}
+
+// Parallel returns the step running the steps at once.
+func Parallel(steps ...Step) Step {
+	return func(st stream.Stream) error {
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs Errors
+		)
+		for _, step := range steps {
+			wg.Add(1)
+			go func(step Step) {
+				defer wg.Done()
+				if err := step(st); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(step)
+		}
+		wg.Wait()
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+}
+
+// Task makes a step of work that doesn't touch the stream itself, like the
+// requests made through the dispatcher.
+func Task(fn func() error) Step {
+	return func(stream.Stream) error {
+		return fn()
+	}
+}
+
+// DoWithTimeout adds a step bounded by d rather than the current timeout.
+func (c *Chain) DoWithTimeout(step Step, d time.Duration, onError ...func(error)) *Chain {
+	c.links = append(c.links, &link{step: step, timeout: d, onError: onError})
+	return c
+}
+
+// DoIf adds a step that is run only when cond holds once the steps before it
+// are done, and skipped otherwise.
+func (c *Chain) DoIf(cond func() bool, step Step, onError ...func(error)) *Chain {
+	c.Do(step, onError...)
+	c.links[len(c.links)-1].cond = cond
+	return c
+}
+
+// If returns the step run when cond holds, it fails with ErrNoBranch otherwise
+// so that Either goes on to the next branch.
+func If(cond func() bool, step Step) Step {
+	return func(st stream.Stream) error {
+		if !cond() {
+			return ErrNoBranch
+		}
+		return step(st)
+	}
+}
+
+// Either returns the step running the steps in turn until one succeeds, the
+// error of the last branch taken is returned when none does.
+func Either(steps ...Step) Step {
+	return func(st stream.Stream) error {
+		err := ErrNoBranch
+		for _, step := range steps {
+			e := step(st)
+			if e == nil {
+				return nil
+			}
+			if e != ErrNoBranch || err == ErrNoBranch {
+				err = e
+			}
+		}
+		return err
+	}
+}
+
+// Retry makes the step added last try again up to n times before its error
+// handlers are called, waiting backoff before the first retry and twice as
+// long before each next one. A step that timed out is not retried, it may
+// still be reading the stream.
+func (c *Chain) Retry(n int, backoff time.Duration) *Chain {
+	if len(c.links) > 0 {
+		l := c.links[len(c.links)-1]
+		l.retries, l.backoff = n, backoff
+	}
+	return c
+}
+
+// Name returns the name of the function of a step for the errors.
+func Name(step Step) string {
+	name := runtime.FuncForPC(reflect.ValueOf(step).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// run runs the step, giving up on it after the timeout or once ctx is done. A
+// step given up on can't be stopped, its goroutine ends whenever the step
+// returns.
+func (l *link) run(ctx context.Context, st stream.Stream) error {
+	if l.timeout <= 0 && ctx.Done() == nil {
+		return l.step(st)
+	}
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		t := time.NewTimer(l.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.step(st) }()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return &TimeoutError{Step: Name(l.step), After: l.timeout}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// try runs the step as many times as it may be retried.
+func (l *link) try(ctx context.Context, st stream.Stream) (err error) {
+	wait := l.backoff
+	for i := 0; ; i++ {
+		err = l.run(ctx, st)
+		if _, timeout := err.(*TimeoutError); err == nil || timeout || ctx.Err() != nil || i == l.retries {
+			return
+		}
+		log.Println(Name(l.step), "failed, retrying:", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// Run runs the steps on the stream until one fails or the context is done.
+// The error handlers of the failed step are called before Run returns its
+// error, a *StepError, or the error of the context.
+func (c *Chain) Run(st stream.Stream) error {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for i, l := range c.links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if l.cond != nil && !l.cond() {
+			continue
+		}
+		if err := l.try(ctx, st); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			for _, fn := range l.onError {
+				fn(err)
+			}
+			return &StepError{Index: i, Step: Name(l.step), Err: err}
+		}
+	}
+	return nil
+}
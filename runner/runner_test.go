@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+// query is a step asking the server like the steps of a login do: it writes
+// an IQ get and waits for the reply on the stream.
+func query(ns string) Step {
+	return func(st stream.Stream) error {
+		iq, _ := stanza.NewIQ(stanza.GET, jid.JID{}, nil)
+		iq.ID = st.Id()
+		iq.Payload = []byte(`<query xmlns="` + ns + `"/>`)
+		buf, err := stanza.Buffer(iq)
+		if err != nil {
+			return err
+		}
+		if err = st.Write(buf); err != nil {
+			return err
+		}
+		var res *stanza.IQ
+		st.Ring(func(b *bytes.Buffer) bool {
+			r := &stanza.IQ{}
+			if xml.Unmarshal(b.Bytes(), r) != nil || r.ID != iq.ID {
+				return false
+			}
+			res = r
+			return true
+		}, 0)
+		if res.Type == stanza.ERROR {
+			return &stanza.IQError{IQ: res}
+		}
+		return nil
+	}
+}
+
+const (
+	result   = `<iq type="result" id="{id}"/>`
+	notFound = `<iq type="error" id="{id}"><error type="cancel"><item-not-found xmlns="urn:ietf:params:xml:ns:xmpp-stanzas"/></error></iq>`
+)
+
+func TestChainInOrder(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains("<query"), result)
+	if err := With().Do(query("a")).Do(query("b")).Run(st); err != nil {
+		t.Fatal(err)
+	}
+	w := st.Written()
+	if len(w) != 2 || !bytes.Contains(w[0], []byte(`"a"`)) || !bytes.Contains(w[1], []byte(`"b"`)) {
+		t.Fatalf("written %q", w)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	st := streamtest.New("example.org")
+	var handled error
+	err := With().Timeout(50*time.Millisecond).Do(query("silent"), func(err error) { handled = err }).Run(st)
+	var se *StepError
+	if !errors.As(err, &se) || se.Index != 0 {
+		t.Fatalf("got %v, want a StepError of the first step", err)
+	}
+	if _, ok := handled.(*TimeoutError); !ok {
+		t.Fatalf("error handler got %v, want a TimeoutError", handled)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.Once(streamtest.Contains("<query"), notFound)
+	st.On(streamtest.Contains("<query"), result)
+	if err := With().Do(query("flaky")).Retry(1, time.Millisecond).Run(st); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(st.Written()); n != 2 {
+		t.Fatalf("%d queries, want 2", n)
+	}
+}
+
+func TestFailureStops(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`"bad"`), notFound)
+	err := With().Do(query("bad")).Do(query("never")).Run(st)
+	var iqErr *stanza.IQError
+	if !errors.As(err, &iqErr) {
+		t.Fatalf("got %v, want the IQ error", err)
+	}
+	if n := len(st.Written()); n != 1 {
+		t.Fatalf("%d queries, the chain went on", n)
+	}
+}
+
+func TestEither(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`"first"`), notFound)
+	st.On(streamtest.Contains(`"second"`), result)
+	never := func() bool { return false }
+	step := Either(If(never, query("skipped")), query("first"), query("second"))
+	if err := With().Do(step).Run(st); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(st.Written()); n != 2 {
+		t.Fatalf("%d queries, want 2", n)
+	}
+	if err := Either(If(never, query("skipped")))(st); err != ErrNoBranch {
+		t.Fatalf("got %v, want ErrNoBranch", err)
+	}
+}
+
+func TestDoIf(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains("<query"), result)
+	taken := false
+	err := With().
+		Do(Task(func() error { taken = true; return nil })).
+		DoIf(func() bool { return !taken }, query("skipped")).
+		DoIf(func() bool { return taken }, query("taken")).
+		Run(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := st.Written()
+	if len(w) != 1 || !bytes.Contains(w[0], []byte(`"taken"`)) {
+		t.Fatalf("written %q", w)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains(`"ok`), result)
+	st.On(streamtest.Contains(`"bad"`), notFound)
+	err := With().DoParallel(query("ok1"), query("bad"), query("ok2")).Run(st)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("got %v, want the error of one step", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	st := streamtest.New("example.org")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	called := false
+	err := With().WithContext(ctx).Do(query("stuck"), func(error) { called = true }).Do(query("skipped")).Run(st)
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if called {
+		t.Fatal("error handler called on cancel")
+	}
+	if n := len(st.Written()); n != 1 {
+		t.Fatalf("%d queries, the chain went on", n)
+	}
+}
@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/actors/steps"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+// State is what the steps of a chain learn about the stream, for the steps
+// after them and for the caller once Run is done.
+type State struct {
+	sync.Mutex
+	// Mechanisms are the known SASL mechanisms the server offered.
+	Mechanisms []string
+	// Mechanism is the one authenticated with.
+	Mechanism string
+	// JID is the full JID bound.
+	JID    string
+	values map[string]interface{}
+	ctx    context.Context
+}
+
+// StateStep is a step reading or recording the state of its chain.
+type StateStep func(stream.Stream, *State) error
+
+// Step binds the step to the state, for If and Either.
+func (s *State) Step(step StateStep) Step {
+	return func(st stream.Stream) error {
+		return step(st, s)
+	}
+}
+
+// Context returns the context of the chain, for the steps running steps of
+// their own.
+func (s *State) Context() context.Context {
+	s.Lock()
+	defer s.Unlock()
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Set keeps a value the fields don't cover under the key.
+func (s *State) Set(key string, v interface{}) {
+	s.Lock()
+	if s.values == nil {
+		s.values = make(map[string]interface{})
+	}
+	s.values[key] = v
+	s.Unlock()
+}
+
+func (s *State) Get(key string) interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return s.values[key]
+}
+
+// Offers tells whether the server offered the mechanism.
+func (s *State) Offers(mechanism string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, m := range s.Mechanisms {
+		if m == mechanism {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate reads the stream features and records which of the known
+// mechanisms the server offers.
+func Negotiate(known ...string) StateStep {
+	return func(st stream.Stream, s *State) error {
+		neg := &steps.Negotiation{}
+		if err := neg.Act()(st); err != nil {
+			return err
+		}
+		s.Lock()
+		s.Mechanisms = s.Mechanisms[:0]
+		for _, m := range known {
+			if neg.HasMechanism(m) {
+				s.Mechanisms = append(s.Mechanisms, m)
+			}
+		}
+		s.Unlock()
+		return nil
+	}
+}
+
+// PlainAuth authenticates with PLAIN and records it.
+func PlainAuth(c *units.Client, pwd string) StateStep {
+	return func(st stream.Stream, s *State) error {
+		if err := (&steps.PlainAuth{Client: c, Pwd: pwd}).Act()(st); err != nil {
+			return err
+		}
+		s.Lock()
+		s.Mechanism = "PLAIN"
+		s.Unlock()
+		return nil
+	}
+}
+
+// bindTimeout bounds the wait for the bind result.
+const bindTimeout = 30 * time.Second
+
+var (
+	ErrNoBind = errors.New("runner: no bind result")
+	ErrNoJID  = errors.New("runner: bind result without a JID")
+)
+
+type bind struct {
+	XMLName  xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Resource string   `xml:"resource,omitempty"`
+	JID      string   `xml:"jid,omitempty"`
+}
+
+// Bind binds the resource and records the full JID the server assigned, it
+// may have a resource of the server's choosing rather than rsrc.
+func Bind(rsrc string) StateStep {
+	return func(st stream.Stream, s *State) error {
+		iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, &bind{Resource: rsrc})
+		iq.ID = st.Id()
+		buf, err := stanza.Buffer(iq)
+		if err != nil {
+			return err
+		}
+		if err = st.Write(buf); err != nil {
+			return err
+		}
+		var res *stanza.IQ
+		st.Ring(func(b *bytes.Buffer) bool {
+			r := &stanza.IQ{}
+			if xml.Unmarshal(b.Bytes(), r) != nil || r.ID != iq.ID {
+				return false
+			}
+			res = r
+			return true
+		}, bindTimeout)
+		switch {
+		case res == nil:
+			return ErrNoBind
+		case res.Type == stanza.ERROR:
+			return &stanza.IQError{IQ: res}
+		}
+		b := &bind{}
+		if res.Decode(b) != nil || b.JID == "" {
+			return ErrNoJID
+		}
+		bound, err := jid.Parse(b.JID)
+		if err != nil {
+			return err
+		}
+		s.Lock()
+		s.JID = bound.String()
+		s.Unlock()
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+func TestBind(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.On(streamtest.Contains("xmpp-bind"), `<iq type="result" id="{id}"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind">`+
+		`<jid>Bot@Example.org/home.4f2a</jid></bind></iq>`)
+	s := &State{}
+	if err := Bind("home")(st, s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(st.Written()[0], []byte("<resource>home</resource>")) {
+		t.Fatalf("bind is %s", st.Written()[0])
+	}
+	if s.JID != "bot@example.org/home.4f2a" {
+		t.Fatalf("bound %q, want the JID the server assigned", s.JID)
+	}
+}
+
+func TestBindFailed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		reply string
+		check func(error) bool
+	}{
+		"no jid":    {result, func(err error) bool { return err == ErrNoJID }},
+		"malformed": {`<iq type="result" id="{id}"><bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><jid>@example.org</jid></bind></iq>`, func(err error) bool { return err != nil }},
+		"conflict":  {notFound, func(err error) bool { _, ok := err.(*stanza.IQError); return ok }},
+	} {
+		st := streamtest.New("example.org")
+		st.On(streamtest.Contains("xmpp-bind"), tc.reply)
+		s := &State{}
+		if err := Bind("home")(st, s); !tc.check(err) || s.JID != "" {
+			t.Errorf("%s: got %v, bound %q", name, err, s.JID)
+		}
+	}
+}
+
+func TestLoginCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	starts := 0
+	start := func(stream.Stream) error {
+		// the server went quiet and the caller gave up meanwhile
+		starts++
+		cancel()
+		return nil
+	}
+	c := &units.Client{Name: "bot", Server: &units.Server{Name: "example.org"}}
+	chain := With().WithContext(ctx)
+	if chain.State().Context() != ctx {
+		t.Fatal("the state doesn't carry the context of the chain")
+	}
+	err := chain.DoState(Login(c, "pwd", LoginOptions{Starter: start})).Run(streamtest.New("example.org"))
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if starts != 1 || len(chain.State().Mechanisms) != 0 {
+		t.Fatalf("login went on after the cancel, %d starts", starts)
+	}
+}
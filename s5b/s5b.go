@@ -0,0 +1,303 @@
+// Package s5b implements XEP-0065 SOCKS5 bytestreams: receiving as the
+// target through the streamhosts offered, and sending through a proxy of the
+// server.
+package s5b
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "http://jabber.org/protocol/bytestreams"
+
+// DialTimeout bounds connecting to a streamhost.
+const DialTimeout = 5 * time.Second
+
+var (
+	ErrNoProxy   = errors.New("s5b: the server has no proxy")
+	ErrTooLarge  = errors.New("s5b: stream too large")
+	errSOCKS     = errors.New("s5b: socks5 handshake failed")
+	errNoConnect = errors.New("s5b: no streamhost could be connected")
+)
+
+// StreamHost is where the parties of a bytestream meet.
+type StreamHost struct {
+	JID  string `xml:"jid,attr"`
+	Host string `xml:"host,attr"`
+	Port int    `xml:"port,attr"`
+}
+
+type query struct {
+	XMLName     xml.Name     `xml:"http://jabber.org/protocol/bytestreams query"`
+	SID         string       `xml:"sid,attr,omitempty"`
+	Mode        string       `xml:"mode,attr,omitempty"`
+	StreamHosts []StreamHost `xml:"streamhost"`
+	Used        *struct {
+		JID string `xml:"jid,attr"`
+	} `xml:"streamhost-used"`
+	Activate string `xml:"activate,omitempty"`
+}
+
+// hash is the address the parties ask the streamhost for.
+func hash(sid, requester, target string) string {
+	h := sha1.Sum([]byte(sid + requester + target))
+	return hex.EncodeToString(h[:])
+}
+
+// connect makes the SOCKS5 handshake without authentication and asks for
+// the domain of the hash.
+func connect(host StreamHost, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.Host, strconv.Itoa(host.Port)), DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(DialTimeout))
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return fail(err)
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return fail(err)
+	}
+	if b[0] != 5 || b[1] != 0 {
+		return fail(errSOCKS)
+	}
+	req := append([]byte{5, 1, 0, 3, byte(len(addr))}, addr...)
+	if _, err := conn.Write(append(req, 0, 0)); err != nil {
+		return fail(err)
+	}
+	b = make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return fail(err)
+	}
+	if b[0] != 5 || b[1] != 0 {
+		return fail(errSOCKS)
+	}
+	// the bound address follows, its length depends on the type
+	rest := 0
+	switch b[3] {
+	case 1:
+		rest = 4 - 1 + 2
+	case 3:
+		rest = int(b[4]) + 2
+	case 4:
+		rest = 16 - 1 + 2
+	default:
+		return fail(errSOCKS)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, rest)); err != nil {
+		return fail(err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+var (
+	mu      sync.Mutex
+	proxies = make(map[string]*StreamHost)
+)
+
+// Proxy returns the streamhost of the proxy among the items of the domain,
+// it is looked up once per domain.
+func Proxy(d *dispatch.Dispatcher, domain string) (*StreamHost, error) {
+	mu.Lock()
+	host, ok := proxies[domain]
+	mu.Unlock()
+	if ok {
+		return host, nil
+	}
+	server, err := jid.Parse(domain)
+	if err != nil {
+		return nil, err
+	}
+	items, err := disco.Items(d, server)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range items.Items {
+		addr, err := jid.Parse(i.JID)
+		if err != nil {
+			continue
+		}
+		if info, err := disco.Info(d, addr); err != nil || !info.Is("proxy", "bytestreams") {
+			continue
+		}
+		iq, _ := stanza.NewIQ(stanza.GET, addr, &query{})
+		res, err := d.Request(iq)
+		if err != nil {
+			return nil, err
+		}
+		q := &query{}
+		if err := res.Decode(q); err != nil {
+			return nil, err
+		}
+		if len(q.StreamHosts) == 0 {
+			continue
+		}
+		host = &q.StreamHosts[0]
+		mu.Lock()
+		proxies[domain] = host
+		mu.Unlock()
+		return host, nil
+	}
+	return nil, ErrNoProxy
+}
+
+// Send offers the streamhosts to the target and, once it connected to one,
+// sends r over it. from is the full JID of the bot. An error before any data
+// went, the target failing to connect say, leaves the caller to try another
+// method.
+func Send(d *dispatch.Dispatcher, from, to, sid string, r io.Reader, hosts ...StreamHost) error {
+	target, err := jid.Parse(to)
+	if err != nil {
+		return err
+	}
+	iq, _ := stanza.NewIQ(stanza.SET, target, &query{SID: sid, Mode: "tcp", StreamHosts: hosts})
+	res, err := d.Request(iq)
+	if err != nil {
+		return err
+	}
+	q := &query{}
+	if err := res.Decode(q); err != nil {
+		return err
+	}
+	if q.Used == nil {
+		return errors.New("s5b: no streamhost used")
+	}
+	var host *StreamHost
+	for i := range hosts {
+		if hosts[i].JID == q.Used.JID {
+			host = &hosts[i]
+		}
+	}
+	if host == nil {
+		return fmt.Errorf("s5b: unknown streamhost %s used", q.Used.JID)
+	}
+	proxy, err := jid.Parse(host.JID)
+	if err != nil {
+		return err
+	}
+	conn, err := connect(*host, hash(sid, from, to))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	iq, _ = stanza.NewIQ(stanza.SET, proxy, &query{SID: sid, Activate: to})
+	if _, err := d.Request(iq); err != nil {
+		return err
+	}
+	_, err = io.Copy(conn, r)
+	return err
+}
+
+// Receiver takes the bytestreams offered to the bot.
+type Receiver struct {
+	// Accept returns where the data of a stream offered goes, nil declines
+	// the stream.
+	Accept func(from, sid string) io.WriteCloser
+	// Done is called once a stream ended, err is set when it failed.
+	Done func(from, sid string, n int64, err error)
+	// MaxSize bounds the bytes of a stream, unbounded when zero.
+	MaxSize int64
+}
+
+// limited fails the writes past the size.
+type limited struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limited) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, ErrTooLarge
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+func (r *Receiver) receive(d *dispatch.Dispatcher, iq *stanza.IQ, q *query, w io.WriteCloser) {
+	addr := hash(q.SID, iq.From.String(), iq.To.String())
+	var conn net.Conn
+	var used StreamHost
+	for _, host := range q.StreamHosts {
+		var err error
+		if conn, err = connect(host, addr); err == nil {
+			used = host
+			break
+		}
+		log.Println("s5b: streamhost", host.JID, err)
+	}
+	if conn == nil {
+		d.Send(iq.ErrorReply("cancel", "item-not-found"))
+		w.Close()
+		if r.Done != nil {
+			r.Done(iq.From.String(), q.SID, 0, errNoConnect)
+		}
+		return
+	}
+	defer conn.Close()
+	res := iq.Result()
+	res.Set(&query{SID: q.SID, Used: &struct {
+		JID string `xml:"jid,attr"`
+	}{used.JID}})
+	d.Send(res)
+	var dst io.Writer = w
+	if r.MaxSize > 0 {
+		dst = &limited{w: w, n: r.MaxSize}
+	}
+	n, err := io.Copy(dst, conn)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if r.Done != nil {
+		r.Done(iq.From.String(), q.SID, n, err)
+	}
+}
+
+// Handler answers the offers of streamhosts, connecting outside of the
+// dispatching.
+func (r *Receiver) Handler(d *dispatch.Dispatcher) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.SET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		q := &query{}
+		if err := iq.Decode(q); err != nil || q.SID == "" || strings.EqualFold(q.Mode, "udp") {
+			d.Send(iq.ErrorReply("modify", "bad-request"))
+			return true
+		}
+		var w io.WriteCloser
+		if r.Accept != nil {
+			w = r.Accept(iq.From.String(), q.SID)
+		}
+		if w == nil {
+			d.Send(iq.ErrorReply("cancel", "not-acceptable"))
+			return true
+		}
+		go r.receive(d, iq, q, w)
+		return true
+	}
+}
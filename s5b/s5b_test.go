@@ -0,0 +1,193 @@
+package s5b
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xep/streamtest"
+)
+
+const (
+	bot  = "bot@example.org/res"
+	peer = "peer@example.org/res"
+)
+
+type sink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *sink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want query
+	}{
+		{`<query xmlns="http://jabber.org/protocol/bytestreams" sid="s1" mode="tcp">` +
+			`<streamhost jid="proxy.example.org" host="192.0.2.1" port="7777"/><streamhost jid="bot@example.org/res" host="::1" port="1080"/></query>`,
+			query{SID: "s1", Mode: "tcp", StreamHosts: []StreamHost{{"proxy.example.org", "192.0.2.1", 7777}, {"bot@example.org/res", "::1", 1080}}}},
+		{`<query xmlns="http://jabber.org/protocol/bytestreams" sid="s1"><streamhost-used jid="proxy.example.org"/></query>`,
+			query{SID: "s1", Used: &struct {
+				JID string `xml:"jid,attr"`
+			}{"proxy.example.org"}}},
+		{`<query xmlns="http://jabber.org/protocol/bytestreams" sid="s1"><activate>peer@example.org/res</activate></query>`,
+			query{SID: "s1", Activate: peer}},
+	} {
+		got := &query{}
+		if err := xml.Unmarshal([]byte(tc.raw), got); err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		b, err := xml.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := &query{}
+		if err := xml.Unmarshal(b, again); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		tc.want.XMLName = xml.Name{Space: Ns, Local: "query"}
+		if !reflect.DeepEqual(*got, tc.want) || !reflect.DeepEqual(*again, tc.want) {
+			t.Errorf("%s parsed %+v, after a round trip %+v", tc.raw, got, again)
+		}
+	}
+}
+
+// proxy is a SOCKS5 streamhost, it pairs the connections asking for the same
+// address and copies what the second sends to the first.
+func proxy(t *testing.T) StreamHost {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn)
+	go func() {
+		waiting := make(map[string]net.Conn)
+		for c := range conns {
+			b := make([]byte, 3)
+			io.ReadFull(c, b)
+			c.Write([]byte{5, 0})
+			b = make([]byte, 5)
+			io.ReadFull(c, b)
+			addr := make([]byte, int(b[4])+2)
+			io.ReadFull(c, addr)
+			c.Write(append(append([]byte{5, 0, 0, 3, b[4]}, addr[:b[4]]...), 0, 0))
+			if first, ok := waiting[string(addr)]; ok {
+				go func(c net.Conn) {
+					io.Copy(first, c)
+					first.Close()
+				}(c)
+			} else {
+				waiting[string(addr)] = c
+			}
+		}
+	}()
+	go func() {
+		defer close(conns)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return StreamHost{JID: "proxy.example.org", Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port}
+}
+
+// receive passes an offer to the handler as sent to the bot by the peer.
+func receive(r *Receiver, d *dispatch.Dispatcher, q *query) {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.MustParse(bot), q)
+	iq.From, iq.ID = jid.MustParse(peer), "offer"
+	raw, _ := xml.Marshal(iq)
+	name, h, _ := stanza.Peek(raw)
+	r.Handler(d)(name, h, raw)
+}
+
+func TestSendReceive(t *testing.T) {
+	host := proxy(t)
+	w := &sink{}
+	done := make(chan int64, 1)
+	r := &Receiver{
+		Accept: func(from, sid string) io.WriteCloser { return w },
+		Done: func(from, sid string, n int64, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			done <- n
+		},
+	}
+	back := streamtest.New("example.org")
+	// the bot receives from the peer here, as the target
+	receive(r, dispatch.New(back), &query{SID: "s1", Mode: "tcp", StreamHosts: []StreamHost{host}})
+	for len(back.Written()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if res := string(back.Written()[0]); !strings.Contains(res, `type="result"`) || !strings.Contains(res, `<streamhost-used jid="proxy.example.org"`) {
+		t.Fatalf("answered %s", res)
+	}
+	// and the peer sends as the requester
+	st := streamtest.New("example.org")
+	st.Once(streamtest.Contains("<streamhost "), `<iq type="result" id="{id}" from="`+bot+`"><query xmlns="`+Ns+`" sid="s1">`+
+		`<streamhost-used jid="proxy.example.org"/></query></iq>`)
+	st.Once(streamtest.Contains("<activate>"), `<iq type="result" id="{id}" from="proxy.example.org"/>`)
+	d := dispatch.New(st)
+	go st.Ring(func(b *bytes.Buffer) bool {
+		d.Feed(b.Bytes())
+		return false
+	}, 5*time.Second)
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := Send(d, peer, bot, "s1", bytes.NewReader(payload), host); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-done:
+		if n != int64(len(payload)) || !bytes.Equal(w.Bytes(), payload) || !w.closed {
+			t.Fatalf("received %d bytes, closed %v", n, w.closed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end")
+	}
+}
+
+func TestReceiveRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		q         *query
+		accept    bool
+		condition string
+	}{
+		{"no sid", &query{Mode: "tcp"}, true, "bad-request"},
+		{"udp", &query{SID: "s1", Mode: "udp"}, true, "bad-request"},
+		{"declined", &query{SID: "s1"}, false, "not-acceptable"},
+		{"no streamhost", &query{SID: "s1", StreamHosts: []StreamHost{{"proxy.example.org", "127.0.0.1", 1}}}, true, "item-not-found"},
+	} {
+		st := streamtest.New("example.org")
+		r := &Receiver{Accept: func(from, sid string) io.WriteCloser {
+			if !tc.accept {
+				return nil
+			}
+			return &sink{}
+		}}
+		receive(r, dispatch.New(st), tc.q)
+		for i := 0; len(st.Written()) == 0 && i < 5000; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if w := st.Written(); len(w) != 1 || !strings.Contains(string(w[0]), "<"+tc.condition) {
+			t.Errorf("%s: answered %q", tc.name, w)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package session records what the server supports, so optional features
+// can enable themselves only where they work.
+package session
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xippo/c2s/stream"
+)
+
+const (
+	MAM     = "urn:xmpp:mam:2"
+	Carbons = "urn:xmpp:carbons:2"
+	Upload  = "urn:xmpp:http:upload:0"
+	SM      = "urn:xmpp:sm:3"
+	CSI     = "urn:xmpp:csi:0"
+)
+
+var names = map[string]string{MAM: "MAM", Carbons: "carbons", Upload: "HTTP upload", SM: "stream management", CSI: "CSI"}
+
+type Session struct {
+	sync.Mutex
+	// JID is the bare JID of the account.
+	JID string
+	// UploadService is the JID of the HTTP upload component, if any.
+	UploadService string
+	features      map[string]bool
+}
+
+func New(jid string) *Session {
+	return &Session{JID: jid, features: make(map[string]bool)}
+}
+
+func (s *Session) set(feature string) {
+	s.Lock()
+	s.features[feature] = true
+	s.Unlock()
+}
+
+func (s *Session) Supports(feature string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.features[feature]
+}
+
+// Missing returns the names of the known features the server lacks.
+func (s *Session) Missing() (ret []string) {
+	for ns, name := range names {
+		if !s.Supports(ns) {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Supported returns the names of the known features the server has.
+func (s *Session) Supported() (ret []string) {
+	for ns, name := range names {
+		if s.Supports(ns) {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Features is a step reading the stream features sent after authentication,
+// it takes the place of the last negotiation step.
+func (s *Session) Features() func(stream.Stream) error {
+	return func(st stream.Stream) (err error) {
+		st.Ring(func(b *bytes.Buffer) bool {
+			f := &struct {
+				XMLName  xml.Name
+				Features []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			}{}
+			if err = xml.Unmarshal(b.Bytes(), f); err != nil || f.XMLName.Local != "features" {
+				return err != nil
+			}
+			for _, x := range f.Features {
+				s.set(x.XMLName.Space)
+			}
+			return true
+		}, 0)
+		return
+	}
+}
+
+// Probe asks the server and the account for their features and looks for an
+// upload service among the server's items.
+func (s *Session) Probe(d *dispatch.Dispatcher) {
+	account, err := jid.Parse(s.JID)
+	if err != nil {
+		log.Println("bad account", s.JID, err)
+		return
+	}
+	domain := jid.JID{Domain: account.Domain}
+	for _, addr := range []jid.JID{domain, account.Bare()} {
+		if info, err := disco.Info(d, addr); err == nil {
+			for _, f := range info.Features {
+				s.set(f.Var)
+			}
+		} else {
+			log.Println("disco of", addr, "failed:", err)
+		}
+	}
+	if items, err := disco.Items(d, domain); err == nil {
+		for _, i := range items.Items {
+			addr, err := jid.Parse(i.JID)
+			if err != nil {
+				continue
+			}
+			if info, err := disco.Info(d, addr); err == nil && info.Has(Upload) {
+				s.Lock()
+				s.UploadService = i.JID
+				s.Unlock()
+				s.set(Upload)
+				break
+			}
+		}
+	}
+	log.Println("server supports:", s.Supported())
+	if missing := s.Missing(); len(missing) > 0 {
+		log.Println("server lacks:", missing, "- the features depending on them are disabled")
+	}
+}
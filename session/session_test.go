@@ -0,0 +1,34 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/kpmy/xep/streamtest"
+)
+
+func TestFeatures(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.Push(`<message from="early@example.org"/>`,
+		`<stream:features xmlns:stream="http://etherx.jabber.org/streams">`+
+			`<bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"/><sm xmlns="urn:xmpp:sm:3"/><csi xmlns="urn:xmpp:csi:0"/></stream:features>`)
+	s := New("bot@example.org")
+	if err := s.Features()(st); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{SM, CSI} {
+		if !s.Supports(f) {
+			t.Errorf("%s not recorded", f)
+		}
+	}
+	if s.Supports(MAM) {
+		t.Error("MAM recorded though not offered")
+	}
+}
+
+func TestFeaturesBadXML(t *testing.T) {
+	st := streamtest.New("example.org")
+	st.Push(`<stream:features><unclosed></stream:features>`)
+	if err := New("bot@example.org").Features()(st); err == nil {
+		t.Fatal("broken features accepted")
+	}
+}
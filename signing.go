@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/ox"
+	"github.com/kpmy/xep/stanza"
+)
+
+var pgp = &ox.GPG{}
+
+// pgpFpr is the fingerprint of the -pgp-key once resolved.
+var pgpFpr string
+
+func setupPGP() {
+	pgp.Home = pgpHome
+	if pgpKey == "" || pgpFpr != "" {
+		return
+	}
+	fpr, err := pgp.Fingerprint(pgpKey, true)
+	if err != nil {
+		log.Println("not signing:", err)
+		return
+	}
+	pgpFpr = fpr
+}
+
+func publishPGPKey() {
+	if pgpFpr == "" {
+		return
+	}
+	if err := pgp.PublishKey(disp, pgpFpr); err != nil {
+		log.Println("failed to publish the OpenPGP key:", err)
+	}
+}
+
+// announcement makes a chat message, signed with the -pgp-key when set.
+func announcement(to, text string) (*stanza.Message, error) {
+	m, err := newMessage(stanza.CHAT, to, text)
+	if err != nil || pgpFpr == "" {
+		return m, err
+	}
+	if e, err := pgp.Sign(pgpFpr, []string{m.To.Bare().String()}, text); err != nil {
+		log.Println("failed to sign:", err)
+	} else {
+		m.With(e)
+	}
+	return m, nil
+}
+
+// runSignedCommand runs a command with a verified OpenPGP signature.
+func runSignedCommand(from, body, fpr string) {
+	if !contacts.Contains(from) {
+		log.Println("ignoring signed command from", from, "not on the roster")
+		return
+	}
+	log.Println("command from", from, "signed by", fpr)
+	execCommand(&cmd{room: ROOM, sender: from, user: jid.Bare(from), direct: true, signed: true}, body)
+}
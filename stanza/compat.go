@@ -0,0 +1,63 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xippo/entity"
+)
+
+// The compatibility layer for code still written against the entity package
+// of xippo: Consume takes the place of entity.ConsumeStatic, and messages
+// convert to and from entity.Message.
+
+var ErrUnknownElement = errors.New("stanza: unknown top-level element")
+
+// Consume decodes a raw stanza as received into a *Message, a *Presence or
+// an *IQ. Other elements, like those of the stream level, are
+// ErrUnknownElement.
+func Consume(raw []byte) (interface{}, error) {
+	name, _, err := Peek(raw)
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	switch name.Local {
+	case "message":
+		ret = &Message{}
+	case "presence":
+		ret = &Presence{}
+	case "iq":
+		ret = &IQ{}
+	default:
+		return nil, ErrUnknownElement
+	}
+	if err := xml.Unmarshal(raw, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// FromEntity converts a message built with the entity package, its
+// addresses are checked on the way.
+func FromEntity(m *entity.Message) (*Message, error) {
+	to, err := jid.Parse(m.To)
+	if err != nil {
+		return nil, err
+	}
+	ret := NewMessage(string(m.Type), to, m.Body)
+	if m.From != "" {
+		if ret.From, err = jid.Parse(m.From); err != nil {
+			return nil, err
+		}
+	}
+	ret.ID = m.Id
+	return ret, nil
+}
+
+// Entity converts the message for the entity package, which has no place
+// for the extensions.
+func (m *Message) Entity() *entity.Message {
+	return &entity.Message{Type: entity.MessageType(m.Type), From: m.From.String(), To: m.To.String(), Id: m.ID, Body: m.Body}
+}
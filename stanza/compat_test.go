@@ -0,0 +1,102 @@
+package stanza
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kpmy/xippo/entity"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares what was produced for a test case with its golden file.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, append(got, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bytes.TrimSpace(want)) {
+		t.Fatalf("%s:\n got %s\nwant %s", name, got, want)
+	}
+}
+
+func produce(t *testing.T, raw []byte) []byte {
+	v, err := Consume(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := Produce(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestGolden reads the received stanzas of testdata/golden and writes them
+// back, which has to give the golden wire form and be stable from there.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*.xml"))
+	if err != nil || len(files) == 0 {
+		t.Fatal("no golden stanzas", err)
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".xml")
+		t.Run(name, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := produce(t, bytes.TrimSpace(raw))
+			golden(t, name, got)
+			if again := produce(t, got); !bytes.Equal(again, got) {
+				t.Fatalf("not stable:\n got %s\nthen %s", got, again)
+			}
+		})
+	}
+}
+
+func TestEntity(t *testing.T) {
+	old := &entity.Message{Type: entity.GROUPCHAT, To: "golang@conference.jabber.ru", Id: "e1", Body: "пщ"}
+	m, err := FromEntity(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := Produce(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "entity", buf.Bytes())
+	if back := m.Entity(); *back != *old {
+		t.Fatalf("got %+v back, want %+v", back, old)
+	}
+}
+
+func TestEntityMalformed(t *testing.T) {
+	if _, err := FromEntity(&entity.Message{Type: entity.CHAT, To: "a@b@c"}); err == nil {
+		t.Fatal("converted a message to a malformed address")
+	}
+}
+
+func TestConsumeUnknown(t *testing.T) {
+	for _, raw := range []string{
+		`<stream:features xmlns:stream="http://etherx.jabber.org/streams"/>`,
+		`<handshake/>`,
+	} {
+		if v, err := Consume([]byte(raw)); err != ErrUnknownElement {
+			t.Errorf("%s consumed as %v, %v", raw, v, err)
+		}
+	}
+	if _, err := Consume([]byte(`<message><body>torn`)); err == nil {
+		t.Error("torn message consumed")
+	}
+}
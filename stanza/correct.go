@@ -0,0 +1,28 @@
+package stanza
+
+import "encoding/xml"
+
+const NsCorrect = "urn:xmpp:message-correct:0"
+
+// Replace marks a XEP-0308 correction of the message with the id.
+type Replace struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-correct:0 replace"`
+	ID      string   `xml:"id,attr"`
+}
+
+// Replaces returns the id of the message this one corrects, if it is a correction.
+func (m *Message) Replaces() string {
+	if e := m.Ext(NsCorrect, "replace"); e != nil {
+		r := &Replace{}
+		if e.Decode(r) == nil {
+			return r.ID
+		}
+	}
+	return ""
+}
+
+// CorrectLast makes the message replace the last one sent with the id, it has
+// to go to the same recipient with the same type.
+func (m *Message) CorrectLast(id string) error {
+	return m.With(&Replace{ID: id})
+}
@@ -0,0 +1,16 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const NsDelay = "urn:xmpp:delay"
+
+// Delay is a XEP-0203 delayed delivery marker.
+type Delay struct {
+	XMLName xml.Name  `xml:"urn:xmpp:delay delay"`
+	From    string    `xml:"from,attr,omitempty"`
+	Stamp   time.Time `xml:"stamp,attr"`
+	Reason  string    `xml:",chardata"`
+}
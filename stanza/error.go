@@ -0,0 +1,154 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+const NsStanzas = "urn:ietf:params:xml:ns:xmpp-stanzas"
+
+// error types
+const (
+	AUTH     = "auth"
+	CANCEL   = "cancel"
+	CONTINUE = "continue"
+	MODIFY   = "modify"
+	WAIT     = "wait"
+)
+
+// stanza error conditions, conflict, not-authorized, policy-violation and
+// resource-constraint are shared with the stream errors
+const (
+	BadRequest            = "bad-request"
+	FeatureNotImplemented = "feature-not-implemented"
+	Forbidden             = "forbidden"
+	Gone                  = "gone"
+	InternalServerError   = "internal-server-error"
+	ItemNotFound          = "item-not-found"
+	JIDMalformed          = "jid-malformed"
+	NotAcceptable         = "not-acceptable"
+	NotAllowed            = "not-allowed"
+	PaymentRequired       = "payment-required"
+	RecipientUnavailable  = "recipient-unavailable"
+	Redirect              = "redirect"
+	RegistrationRequired  = "registration-required"
+	RemoteServerNotFound  = "remote-server-not-found"
+	RemoteServerTimeout   = "remote-server-timeout"
+	ServiceUnavailable    = "service-unavailable"
+	SubscriptionRequired  = "subscription-required"
+	UndefinedCondition    = "undefined-condition"
+	UnexpectedRequest     = "unexpected-request"
+)
+
+// StanzaError is the error child of a stanza of type='error'.
+type StanzaError struct {
+	Type      string
+	By        string
+	Condition string
+	Text      string
+	// App is the application-specific condition, if any.
+	App *Extension
+}
+
+type errorElement struct {
+	XMLName  xml.Name    `xml:"error"`
+	Type     string      `xml:"type,attr,omitempty"`
+	By       string      `xml:"by,attr,omitempty"`
+	Children []Extension `xml:",any"`
+}
+
+func (e *StanzaError) Error() string {
+	if e.Text != "" {
+		return e.Condition + ": " + e.Text
+	}
+	return e.Condition
+}
+
+func (e *StanzaError) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	x := &errorElement{Type: e.Type, By: e.By}
+	cond := e.Condition
+	if cond == "" {
+		cond = UndefinedCondition
+	}
+	x.Children = append(x.Children, Extension{XMLName: xml.Name{Space: NsStanzas, Local: cond}})
+	if e.Text != "" {
+		buf := new(bytes.Buffer)
+		xml.EscapeText(buf, []byte(e.Text))
+		x.Children = append(x.Children, Extension{XMLName: xml.Name{Space: NsStanzas, Local: "text"}, Inner: buf.Bytes()})
+	}
+	if e.App != nil {
+		x.Children = append(x.Children, *e.App)
+	}
+	return enc.Encode(x)
+}
+
+func (e *StanzaError) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	x := &errorElement{}
+	if err := dec.DecodeElement(x, &start); err != nil {
+		return err
+	}
+	*e = StanzaError{Type: x.Type, By: x.By}
+	for i, c := range x.Children {
+		switch {
+		case c.XMLName.Space != NsStanzas:
+			e.App = &x.Children[i]
+		case c.XMLName.Local == "text":
+			t := &struct {
+				Text string `xml:",chardata"`
+			}{}
+			if c.Decode(t) == nil {
+				e.Text = t.Text
+			}
+		default:
+			e.Condition = c.XMLName.Local
+		}
+	}
+	return nil
+}
+
+// ParseStanzaError reads the error child of a raw stanza, if any.
+func ParseStanzaError(raw []byte) (*StanzaError, bool) {
+	x := &struct {
+		Error *StanzaError `xml:"error"`
+	}{}
+	if xml.Unmarshal(raw, x) != nil || x.Error == nil {
+		return nil, false
+	}
+	return x.Error, true
+}
+
+// ErrorCondition returns the defined condition of the error a remote entity
+// answered with, it is empty for any other error.
+func ErrorCondition(err error) string {
+	switch e := err.(type) {
+	case *IQError:
+		return e.Condition()
+	case *StanzaError:
+		return e.Condition
+	}
+	return ""
+}
+
+func IsItemNotFound(err error) bool {
+	return ErrorCondition(err) == ItemNotFound
+}
+
+func IsFeatureNotImplemented(err error) bool {
+	return ErrorCondition(err) == FeatureNotImplemented
+}
+
+func IsServiceUnavailable(err error) bool {
+	return ErrorCondition(err) == ServiceUnavailable
+}
+
+func IsForbidden(err error) bool {
+	return ErrorCondition(err) == Forbidden
+}
+
+func IsNotAllowed(err error) bool {
+	return ErrorCondition(err) == NotAllowed
+}
+
+func IsConflict(err error) bool {
+	return ErrorCondition(err) == Conflict
+}
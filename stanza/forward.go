@@ -0,0 +1,110 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+const NsForward = "urn:xmpp:forward:0"
+
+var ErrNotForwarded = errors.New("stanza: no forwarded payload")
+
+// Forwarded is a XEP-0297 forwarded stanza. Stanza keeps the inner stanza
+// verbatim, so it can be fed back to entity.Decode like any received one.
+type Forwarded struct {
+	Delay  *Delay
+	Stanza []byte
+}
+
+// Buffer returns the inner stanza in the form the xippo entity package consumes.
+func (f *Forwarded) Buffer() *bytes.Buffer {
+	return bytes.NewBuffer(f.Stanza)
+}
+
+func (f *Forwarded) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return e.Encode(struct {
+		XMLName xml.Name `xml:"urn:xmpp:forward:0 forwarded"`
+		Delay   *Delay
+		Inner   []byte `xml:",innerxml"`
+	}{Delay: f.Delay, Inner: f.Stanza})
+}
+
+// Envelope is a stanza carrying a forwarded one, like a carbon copy, an
+// archived message or a privileged entity request.
+type Envelope struct {
+	Name xml.Name
+	Header
+	// Wrapper is the element holding <forwarded/>, e.g. carbons' <received/>;
+	// it is zero when <forwarded/> is a direct child of the stanza.
+	Wrapper xml.Name
+	Forwarded
+}
+
+// Wrap forwards a raw stanza, optionally stamped with its original delivery time.
+func Wrap(inner []byte, delay *Delay) ([]byte, error) {
+	return xml.Marshal(&Forwarded{Delay: delay, Stanza: inner})
+}
+
+// Unwrap returns the envelope of the first forwarded stanza found in raw, it
+// keeps no reference to raw.
+func Unwrap(raw []byte) (ret *Envelope, err error) {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	var path []xml.Name
+	for {
+		var t xml.Token
+		if t, err = d.Token(); err != nil {
+			if err == io.EOF {
+				err = ErrNotForwarded
+			}
+			return nil, err
+		}
+		switch tt := t.(type) {
+		case xml.StartElement:
+			if len(path) == 0 {
+				ret = &Envelope{Name: tt.Name}
+				ret.Header.read(tt.Attr)
+			} else if tt.Name.Space == NsForward && tt.Name.Local == "forwarded" {
+				if len(path) > 1 {
+					ret.Wrapper = path[len(path)-1]
+				}
+				err = readForwarded(d, raw, &ret.Forwarded)
+				return
+			}
+			path = append(path, tt.Name)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+}
+
+func readForwarded(d *xml.Decoder, raw []byte, f *Forwarded) error {
+	for {
+		off := d.InputOffset()
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tt := t.(type) {
+		case xml.StartElement:
+			if tt.Name.Space == NsDelay && tt.Name.Local == "delay" {
+				f.Delay = new(Delay)
+				if err = d.DecodeElement(f.Delay, &tt); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = d.Skip(); err != nil {
+				return err
+			}
+			// raw may be a pooled buffer, the envelope outlives it
+			f.Stanza = append([]byte(nil), raw[off:d.InputOffset()]...)
+		case xml.EndElement:
+			if f.Stanza == nil {
+				return ErrNotForwarded
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package stanza
+
+import "testing"
+
+func TestUnwrapCopies(t *testing.T) {
+	raw := []byte(`<message from="goxep@xmpp.ru" to="goxep@xmpp.ru/go"><result xmlns="urn:xmpp:mam:2" queryid="q" id="1">` +
+		`<forwarded xmlns="urn:xmpp:forward:0"><message from="a@b/c" type="chat"><body>archived</body></message></forwarded></result></message>`)
+	env, err := Unwrap(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as a pooled buffer is reused for the next stanza
+	for i := range raw {
+		raw[i] = 'x'
+	}
+	if got, want := string(env.Stanza), `<message from="a@b/c" type="chat"><body>archived</body></message>`; got != want {
+		t.Fatalf("forwarded stanza is %q, want %q", got, want)
+	}
+}
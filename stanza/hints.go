@@ -0,0 +1,25 @@
+package stanza
+
+import "encoding/xml"
+
+const NsHints = "urn:xmpp:hints"
+
+// XEP-0334 message processing hints.
+const (
+	NoPermanentStore = "no-permanent-store"
+	NoStore          = "no-store"
+	NoCopy           = "no-copy"
+	Store            = "store"
+)
+
+// Hint adds processing hints to the message.
+func (m *Message) Hint(hints ...string) {
+	for _, h := range hints {
+		m.Extensions = append(m.Extensions, Extension{XMLName: xml.Name{Space: NsHints, Local: h}})
+	}
+}
+
+// Hinted tells whether the message carries the hint.
+func (m *Message) Hinted(hint string) bool {
+	return m.Ext(NsHints, hint) != nil
+}
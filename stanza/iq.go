@@ -0,0 +1,102 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/kpmy/xep/jid"
+)
+
+const (
+	GET    = "get"
+	SET    = "set"
+	RESULT = "result"
+	ERROR  = "error"
+)
+
+// IQ is an info/query stanza, Payload keeps its child element verbatim.
+type IQ struct {
+	XMLName xml.Name `xml:"iq"`
+	Header
+	Payload []byte `xml:",innerxml"`
+}
+
+// NewIQ builds an IQ of the given type carrying the marshaled payload, payload may be nil.
+func NewIQ(typ string, to jid.JID, payload interface{}) (ret *IQ, err error) {
+	ret = &IQ{Header: Header{To: to, Type: typ}}
+	err = ret.Set(payload)
+	return
+}
+
+// ConsumeIQ reads a raw IQ stanza as received from the stream.
+func ConsumeIQ(raw []byte) (*IQ, error) {
+	iq := &IQ{}
+	if err := xml.Unmarshal(raw, iq); err != nil {
+		return nil, err
+	}
+	return iq, nil
+}
+
+// Produce validates and marshals the IQ into a buffer ready for stream.Write.
+func (iq *IQ) Produce() (*bytes.Buffer, error) {
+	return Produce(iq)
+}
+
+// Set replaces the payload with the marshaled typed one, nil leaves the IQ empty.
+func (iq *IQ) Set(payload interface{}) (err error) {
+	iq.Payload = nil
+	if payload != nil {
+		iq.Payload, err = xml.Marshal(payload)
+	}
+	return
+}
+
+// Decode unmarshals the payload into v.
+func (iq *IQ) Decode(v interface{}) error {
+	return xml.Unmarshal(iq.Payload, v)
+}
+
+// Result builds an empty result addressed back to the sender of the request.
+func (iq *IQ) Result() *IQ {
+	return &IQ{Header: Header{To: iq.From, ID: iq.ID, Type: RESULT}}
+}
+
+// ErrorReply builds an error addressed back to the sender of the request, typ
+// is the error type like "cancel" and condition a stanza error condition.
+func (iq *IQ) ErrorReply(typ, condition string) *IQ {
+	ret := &IQ{Header: Header{To: iq.From, ID: iq.ID, Type: ERROR}}
+	ret.Set(&StanzaError{Type: typ, Condition: condition})
+	return ret
+}
+
+// StanzaError returns the error child of the IQ, if any.
+func (iq *IQ) StanzaError() (*StanzaError, bool) {
+	return ParseStanzaError(append(append([]byte("<iq>"), iq.Payload...), "</iq>"...))
+}
+
+// IQError is returned for requests answered with type='error'.
+type IQError struct {
+	IQ *IQ
+}
+
+func (e *IQError) Error() string {
+	if se, ok := e.IQ.StanzaError(); ok {
+		return fmt.Sprintf("iq error from %s: %s", e.IQ.From, se)
+	}
+	return fmt.Sprintf("iq error from %s: %s", e.IQ.From, e.IQ.Payload)
+}
+
+// PayloadName returns the name of the payload element, it is zero for an empty IQ.
+func (iq *IQ) PayloadName() (ret xml.Name) {
+	ret, _, _ = Peek(iq.Payload)
+	return
+}
+
+// Condition returns the defined condition of the error, e.g. "item-not-found".
+func (e *IQError) Condition() string {
+	if se, ok := e.IQ.StanzaError(); ok {
+		return se.Condition
+	}
+	return ""
+}
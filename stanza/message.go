@@ -0,0 +1,55 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/kpmy/xep/jid"
+)
+
+const (
+	CHAT      = "chat"
+	GROUPCHAT = "groupchat"
+	NORMAL    = "normal"
+	HEADLINE  = "headline"
+)
+
+type Message struct {
+	XMLName xml.Name `xml:"message"`
+	Header
+	Body       string      `xml:"body,omitempty"`
+	Delay      *Delay      `xml:"urn:xmpp:delay delay,omitempty"`
+	Extensions []Extension `xml:",any"`
+}
+
+func NewMessage(typ string, to jid.JID, body string) *Message {
+	return &Message{Header: Header{To: to, Type: typ}, Body: body}
+}
+
+// Sent is the time the message was originally sent, its delay stamp or the
+// current time.
+func (m *Message) Sent() time.Time {
+	if m.Delay != nil && !m.Delay.Stamp.IsZero() {
+		return m.Delay.Stamp
+	}
+	return time.Now()
+}
+
+// Ext returns the first extension with the given name or nil.
+func (m *Message) Ext(space, local string) *Extension {
+	for i, e := range m.Extensions {
+		if e.XMLName.Space == space && e.XMLName.Local == local {
+			return &m.Extensions[i]
+		}
+	}
+	return nil
+}
+
+// With adds an extension struct to the message.
+func (m *Message) With(v interface{}) error {
+	e, err := NewExtension(v)
+	if err == nil {
+		m.Extensions = append(m.Extensions, e)
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package stanza
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooled is the largest buffer kept for reuse, so a single huge stanza
+// doesn't pin its memory.
+const maxPooled = 64 * 1024
+
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// GetBuffer returns an empty buffer, from the pool when there is one.
+func GetBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// PutBuffer gives the buffer back to the pool, it must not be used after.
+func PutBuffer(b *bytes.Buffer) {
+	if b == nil || b.Cap() > maxPooled {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
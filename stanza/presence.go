@@ -0,0 +1,104 @@
+package stanza
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/jid"
+)
+
+const (
+	AVAILABLE    = ""
+	UNAVAILABLE  = "unavailable"
+	SUBSCRIBE    = "subscribe"
+	SUBSCRIBED   = "subscribed"
+	UNSUBSCRIBE  = "unsubscribe"
+	UNSUBSCRIBED = "unsubscribed"
+	PROBE        = "probe"
+)
+
+// show values, an empty show means plain online and CHAT doubles as "free for chat"
+const (
+	AWAY = "away"
+	XA   = "xa"
+	DND  = "dnd"
+)
+
+// Extension is a presence child Presence doesn't model itself, like the MUC
+// x element or entity capabilities. It is kept raw so it is written back as
+// received, Value holds it decoded when its name is registered.
+type Extension struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr  `xml:",any,attr"`
+	Inner   []byte      `xml:",innerxml"`
+	Value   interface{} `xml:"-"`
+}
+
+// NewExtension turns an extension struct into an Extension.
+func NewExtension(v interface{}) (ret Extension, err error) {
+	var data []byte
+	if data, err = xml.Marshal(v); err == nil {
+		err = xml.Unmarshal(data, &ret)
+	}
+	return
+}
+
+// UnmarshalXML keeps the attributes of the extension but the namespace
+// declarations, which marshaling derives from XMLName again.
+func (e *Extension) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	e.XMLName, e.Attrs = start.Name, nil
+	for _, a := range start.Attr {
+		if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+			e.Attrs = append(e.Attrs, a)
+		}
+	}
+	inner := &struct {
+		Inner []byte `xml:",innerxml"`
+	}{}
+	if err := d.DecodeElement(inner, &start); err != nil {
+		return err
+	}
+	e.Inner = inner.Inner
+	e.Value = decode(e)
+	return nil
+}
+
+// Decode reads the extension into v.
+func (e *Extension) Decode(v interface{}) error {
+	data, err := xml.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+type Presence struct {
+	XMLName xml.Name `xml:"presence"`
+	Header
+	Show       string      `xml:"show,omitempty"`
+	Status     string      `xml:"status,omitempty"`
+	Priority   int8        `xml:"priority,omitempty"`
+	Extensions []Extension `xml:",any"`
+}
+
+func NewPresence(typ string, to jid.JID) *Presence {
+	return &Presence{Header: Header{To: to, Type: typ}}
+}
+
+// Ext returns the first extension with the given name or nil.
+func (p *Presence) Ext(space, local string) *Extension {
+	for i, e := range p.Extensions {
+		if e.XMLName.Space == space && e.XMLName.Local == local {
+			return &p.Extensions[i]
+		}
+	}
+	return nil
+}
+
+// With adds an extension struct to the presence.
+func (p *Presence) With(v interface{}) error {
+	e, err := NewExtension(v)
+	if err == nil {
+		p.Extensions = append(p.Extensions, e)
+	}
+	return err
+}
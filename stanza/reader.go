@@ -0,0 +1,198 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errNoStream = errors.New("stanza: stream closed before it was opened")
+
+// ErrRestart is returned by Next when the stream is opened anew, as servers
+// do after authentication; Header returns the new stream header.
+var ErrRestart = errors.New("stanza: stream restarted")
+
+// recorder keeps what the decoder reads, so the raw stanzas can be cut out.
+type recorder struct {
+	buf  []byte
+	base int64
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	return len(p), nil
+}
+
+// cut returns a copy of the bytes read between the offsets and forgets them
+// along with anything before, the buffer is reused for what follows.
+func (r *recorder) cut(from, to int64) []byte {
+	ret := append([]byte(nil), bytes.TrimSpace(r.buf[from-r.base:to-r.base])...)
+	r.buf = r.buf[:copy(r.buf, r.buf[to-r.base:])]
+	r.base = to
+	return ret
+}
+
+// drop forgets the bytes read up to the offset.
+func (r *recorder) drop(to int64) {
+	r.buf = r.buf[:copy(r.buf, r.buf[to-r.base:])]
+	r.base = to
+}
+
+// ParseError is a malformed stanza a recovering Reader skipped.
+type ParseError struct {
+	Err error
+	// Raw is what was skipped.
+	Raw []byte
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("stanza: skipped %d malformed bytes: %v", len(e.Raw), e.Err)
+}
+
+// Reader reads the stanzas off a stream as they arrive, a stanza split over
+// several reads is put together by the decoder rather than the caller.
+type Reader struct {
+	// Recover makes Next skip malformed XML up to the next stanza and return
+	// a *ParseError rather than fail for good.
+	Recover bool
+	src     io.Reader
+	dec     *xml.Decoder
+	rec     *recorder
+	header  xml.StartElement
+}
+
+func NewReader(r io.Reader) *Reader {
+	ret := &Reader{src: r}
+	ret.reset(nil)
+	return ret
+}
+
+// reset starts decoding afresh from the pending bytes on.
+func (r *Reader) reset(pending []byte) {
+	r.rec = &recorder{}
+	src := r.src
+	if len(pending) > 0 {
+		src = io.MultiReader(bytes.NewReader(pending), r.src)
+	}
+	r.dec = xml.NewDecoder(io.TeeReader(src, r.rec))
+}
+
+// boundaries start the top-level elements a stream carries.
+var boundaries = [][]byte{[]byte("<message"), []byte("<presence"), []byte("<iq")}
+
+// boundary returns where the next stanza starts in b, or -1.
+func boundary(b []byte) int {
+	ret := -1
+	for _, s := range boundaries {
+		for i := 0; ; {
+			j := bytes.Index(b[i:], s)
+			if j < 0 {
+				break
+			}
+			end := i + j + len(s)
+			if end == len(b) || bytes.IndexByte([]byte(" \t\r\n/>"), b[end]) >= 0 {
+				if ret < 0 || i+j < ret {
+					ret = i + j
+				}
+				break
+			}
+			i = end
+		}
+	}
+	return ret
+}
+
+// partial tells whether b may be the start of a boundary cut short.
+func partial(b []byte) bool {
+	for _, s := range boundaries {
+		if len(b) < len(s) && bytes.HasPrefix(s, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip drops the malformed bytes from the offset on up to the next stanza
+// already read, or all of them.
+func (r *Reader) skip(from int64, err error) *ParseError {
+	bad := r.rec.buf[from-r.rec.base:]
+	var pending []byte
+	// the bad stanza itself may start at a boundary, look past it
+	if len(bad) > 0 {
+		if i := boundary(bad[1:]); i >= 0 {
+			bad, pending = bad[:i+1], bad[i+1:]
+		} else if i := bytes.LastIndexByte(bad, '<'); i > 0 && partial(bad[i:]) {
+			// the next stanza starts in the bytes not read yet
+			bad, pending = bad[:i], bad[i:]
+		}
+	}
+	ret := &ParseError{Err: err, Raw: append([]byte(nil), bad...)}
+	r.reset(append([]byte(nil), pending...))
+	return ret
+}
+
+// Open reads up to the stream header and returns it.
+func (r *Reader) Open() (xml.StartElement, error) {
+	for {
+		t, err := r.dec.RawToken()
+		if err == io.EOF {
+			err = errNoStream
+		}
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == "stream" {
+			r.header = se.Copy()
+			return r.header, nil
+		}
+	}
+}
+
+// Header returns the header of the stream last opened.
+func (r *Reader) Header() xml.StartElement {
+	return r.header
+}
+
+func isHeader(se xml.StartElement) bool {
+	return se.Name.Local == "stream" && (se.Name.Space == "stream" || se.Name.Space == NsStream)
+}
+
+// Next returns the next top-level element of the opened stream, io.EOF once
+// the stream is closed. A stream header read is returned as ErrRestart, so
+// Next may read a stream from the start as well.
+func (r *Reader) Next() ([]byte, error) {
+	depth := 0
+	start := r.dec.InputOffset()
+	for {
+		offset := r.dec.InputOffset()
+		t, err := r.dec.RawToken()
+		if _, ok := err.(*xml.SyntaxError); ok && r.Recover {
+			return nil, r.skip(start, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tt := t.(type) {
+		case xml.StartElement:
+			if depth == 0 && isHeader(tt) {
+				r.header = tt.Copy()
+				r.rec.drop(r.dec.InputOffset())
+				return nil, ErrRestart
+			}
+			if depth == 0 {
+				start = offset
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return r.rec.cut(start, r.dec.InputOffset()), nil
+			}
+			if depth < 0 {
+				return nil, io.EOF
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package stanza
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const (
+	header   = `<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='s1' from='xmpp.ru' version='1.0'>`
+	features = `<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>`
+	chat     = `<message from="a@xmpp.ru/x" to="goxep@xmpp.ru/go" type="chat" id="m1"><body>hi &amp; bye</body></message>`
+	room     = `<presence from="golang@conference.jabber.ru/nick"><x xmlns="http://jabber.org/protocol/muc#user"><item affiliation="none" role="participant"/></x></presence>`
+)
+
+func readAll(t *testing.T, r *Reader) (ret []string) {
+	for {
+		raw, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, string(raw))
+	}
+}
+
+func TestReaderSplitReads(t *testing.T) {
+	in := header + chat + "\n" + room + "</stream:stream>"
+	for name, src := range map[string]io.Reader{
+		"whole":        strings.NewReader(in),
+		"byte by byte": iotest.OneByteReader(strings.NewReader(in)),
+		"half reads":   iotest.HalfReader(strings.NewReader(in)),
+	} {
+		r := NewReader(src)
+		if _, err := r.Open(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := readAll(t, r)
+		if len(got) != 2 || got[0] != chat || got[1] != room {
+			t.Fatalf("%s: read %q", name, got)
+		}
+	}
+}
+
+func TestReaderRestart(t *testing.T) {
+	restarted := strings.Replace(header, "s1", "s2", 1)
+	r := NewReader(iotest.HalfReader(strings.NewReader(header + features + "<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>" + restarted + features + chat)))
+	want := []interface{}{ErrRestart, features, "<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>", ErrRestart, features, chat}
+	ids := []string{"s1", "s2"}
+	for i, w := range want {
+		raw, err := r.Next()
+		if w == ErrRestart {
+			if err != ErrRestart {
+				t.Fatalf("%d: got %q, %v; want a restart", i, raw, err)
+			}
+			var id string
+			for _, a := range r.Header().Attr {
+				if a.Name.Local == "id" {
+					id = a.Value
+				}
+			}
+			if id != ids[0] {
+				t.Fatalf("%d: stream id %q, want %q", i, id, ids[0])
+			}
+			ids = ids[1:]
+			continue
+		}
+		if err != nil || string(raw) != w {
+			t.Fatalf("%d: got %q, %v; want %q", i, raw, err, w)
+		}
+	}
+}
+
+func TestReaderRecover(t *testing.T) {
+	r := NewReader(strings.NewReader(header + chat + `<message><body>torn < here</body></message>` + room))
+	r.Recover = true
+	r.Open()
+	if raw, err := r.Next(); err != nil || string(raw) != chat {
+		t.Fatalf("got %q, %v", raw, err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Fatal("malformed stanza read")
+	} else if pe, ok := err.(*ParseError); !ok || !bytes.Contains(pe.Raw, []byte("torn")) {
+		t.Fatalf("got %v, want a parse error", err)
+	}
+	if raw, err := r.Next(); err != nil || string(raw) != room {
+		t.Fatalf("got %q, %v", raw, err)
+	}
+}
+
+// TestReaderRecoverAtReadEdge has a read end right after a malformed stanza,
+// in the middle of the name of the next one.
+func TestReaderRecoverAtReadEdge(t *testing.T) {
+	bad := `<message><body>torn < here</body></message>`
+	for _, cut := range []string{"<", "<mess", "<message", "<p", "<pres"} {
+		rest := strings.TrimPrefix(chat, cut)
+		if !strings.HasPrefix(chat, cut) {
+			rest = strings.TrimPrefix(room, cut)
+		}
+		r := NewReader(io.MultiReader(strings.NewReader(header+bad+cut), strings.NewReader(rest+room+"</stream:stream>")))
+		r.Recover = true
+		r.Open()
+		if _, err := r.Next(); err == nil {
+			t.Fatalf("%s: malformed stanza read", cut)
+		}
+		var got []string
+		for {
+			raw, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", cut, err)
+			}
+			got = append(got, string(raw))
+		}
+		if len(got) != 2 || got[0] != cut+rest || got[1] != room {
+			t.Fatalf("%s: read %q", cut, got)
+		}
+	}
+}
+
+// stream lays out a stream of n stanzas, every tenth of them malformed when
+// bad is set.
+func stream(n int, bad bool) []byte {
+	b := bytes.NewBufferString(header)
+	for i := 0; i < n; i++ {
+		switch {
+		case bad && i%10 == 9:
+			b.WriteString(`<message><body>torn < here</body></message>`)
+		case i%2 == 0:
+			b.WriteString(chat)
+		default:
+			b.WriteString(room)
+		}
+	}
+	b.WriteString("</stream:stream>")
+	return b.Bytes()
+}
+
+func benchmarkReader(b *testing.B, wrap func(io.Reader) io.Reader, bad bool) {
+	const n = 1000
+	in := stream(n, bad)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(in)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := NewReader(wrap(bytes.NewReader(in)))
+		r.Recover = bad
+		if _, err := r.Open(); err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if _, ok := err.(*ParseError); err != nil && !ok {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	benchmarkReader(b, func(r io.Reader) io.Reader { return r }, false)
+}
+
+// BenchmarkReaderSplitReads reads the stream the way a slow link delivers
+// it, a few bytes at a time.
+func BenchmarkReaderSplitReads(b *testing.B) {
+	benchmarkReader(b, iotest.HalfReader, false)
+}
+
+// BenchmarkReaderRecover skips a malformed stanza in every ten.
+func BenchmarkReaderRecover(b *testing.B) {
+	benchmarkReader(b, func(r io.Reader) io.Reader { return r }, true)
+}
+
+// FuzzReader reads streams of arbitrary stanzas; the seeds in
+// testdata/fuzz/FuzzReader are truncated and malformed ones.
+func FuzzReader(f *testing.F) {
+	f.Add([]byte(chat + room))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := append([]byte(header), data...)
+		// a recovering reader moves on with every call and only hands out
+		// bytes it was given
+		r := NewReader(bytes.NewReader(in))
+		r.Recover = true
+		if _, err := r.Open(); err != nil {
+			t.Fatal(err)
+		}
+		for calls := 0; ; calls++ {
+			if calls > len(in) {
+				t.Fatal("no progress")
+			}
+			raw, err := r.Next()
+			if pe, ok := err.(*ParseError); ok {
+				if !bytes.Contains(in, pe.Raw) {
+					t.Fatalf("skipped %q, not in the stream", pe.Raw)
+				}
+				continue
+			}
+			if err != nil {
+				break
+			}
+			if !bytes.Contains(in, raw) {
+				t.Fatalf("read %q, not in the stream", raw)
+			}
+		}
+		// without recovering, how the stream is split doesn't matter
+		whole, split := NewReader(bytes.NewReader(in)), NewReader(iotest.OneByteReader(bytes.NewReader(in)))
+		whole.Open()
+		split.Open()
+		for {
+			a, errA := whole.Next()
+			b, errB := split.Next()
+			if !bytes.Equal(a, b) || (errA == nil) != (errB == nil) {
+				t.Fatalf("read %q, %v whole but %q, %v split", a, errA, b, errB)
+			}
+			if errA != nil && errA != ErrRestart {
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"sync"
+)
+
+// registry holds the decoders of the known extensions by element name.
+var registry = struct {
+	sync.RWMutex
+	m map[xml.Name]func() interface{}
+}{m: make(map[xml.Name]func() interface{})}
+
+// Register makes the extensions with the name decode into the values fn
+// returns, pointers to the structs modelling them. The packages of the XEPs
+// register their elements in init.
+func Register(space, local string, fn func() interface{}) {
+	registry.Lock()
+	registry.m[xml.Name{Space: space, Local: local}] = fn
+	registry.Unlock()
+}
+
+// decode returns the extension decoded by its registered decoder, or nil for
+// the unknown ones.
+func decode(e *Extension) interface{} {
+	registry.RLock()
+	fn, ok := registry.m[e.XMLName]
+	registry.RUnlock()
+	if !ok {
+		return nil
+	}
+	v := fn()
+	if e.Decode(v) != nil {
+		return nil
+	}
+	return v
+}
+
+// Value returns the payload decoded by its registered decoder, if any.
+func (iq *IQ) Value() (interface{}, bool) {
+	e := &Extension{}
+	if len(iq.Payload) == 0 || xml.Unmarshal(iq.Payload, e) != nil || e.Value == nil {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+func init() {
+	Register(NsDelay, "delay", func() interface{} { return &Delay{} })
+	Register(NsSID, "stanza-id", func() interface{} { return &StanzaID{} })
+	Register(NsSID, "origin-id", func() interface{} { return &OriginID{} })
+	Register(NsReply, "reply", func() interface{} { return &Reply{} })
+}
@@ -0,0 +1,92 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+const (
+	NsReply    = "urn:xmpp:reply:0"
+	NsFallback = "urn:xmpp:fallback:0"
+)
+
+// Reply marks a XEP-0461 reply to the message with the id, the stanza-id in
+// rooms, sent by To.
+type Reply struct {
+	XMLName xml.Name `xml:"urn:xmpp:reply:0 reply"`
+	To      string   `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+}
+
+// fallbackBody is a range of the body in code points, no range means the whole body.
+type fallbackBody struct {
+	Start *int `xml:"start,attr"`
+	End   *int `xml:"end,attr"`
+}
+
+type fallback struct {
+	XMLName xml.Name       `xml:"urn:xmpp:fallback:0 fallback"`
+	For     string         `xml:"for,attr"`
+	Bodies  []fallbackBody `xml:"body"`
+}
+
+// Reply returns what the message replies to, or nil.
+func (m *Message) Reply() *Reply {
+	if e := m.Ext(NsReply, "reply"); e != nil {
+		r := &Reply{}
+		if e.Decode(r) == nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Text returns the body without the quote a reply carries for the clients
+// not supporting replies.
+func (m *Message) Text() string {
+	body := []rune(m.Body)
+	cut := make([]bool, len(body))
+	for _, e := range m.Extensions {
+		f := &fallback{}
+		if e.XMLName.Space != NsFallback || e.Decode(f) != nil || f.For != NsReply {
+			continue
+		}
+		for _, b := range f.Bodies {
+			start, end := 0, len(body)
+			if b.Start != nil && b.End != nil {
+				start, end = *b.Start, *b.End
+			}
+			for i := start; i < end && i < len(body); i++ {
+				if i >= 0 {
+					cut[i] = true
+				}
+			}
+		}
+	}
+	ret := make([]rune, 0, len(body))
+	for i, r := range body {
+		if !cut[i] {
+			ret = append(ret, r)
+		}
+	}
+	return string(ret)
+}
+
+// InReplyTo makes the message a reply to the message with the id sent by to,
+// quoting it in the body for the clients not supporting replies.
+func (m *Message) InReplyTo(to, id, quote string) error {
+	if err := m.With(&Reply{To: to, ID: id}); err != nil {
+		return err
+	}
+	if quote == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(quote, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = "> " + l
+	}
+	prefix := strings.Join(lines, "\n") + "\n"
+	start, end := 0, len([]rune(prefix))
+	m.Body = prefix + m.Body
+	return m.With(&fallback{For: NsReply, Bodies: []fallbackBody{{Start: &start, End: &end}}})
+}
@@ -0,0 +1,64 @@
+package stanza
+
+import "encoding/xml"
+
+const NsSID = "urn:xmpp:sid:0"
+
+// StanzaID is a XEP-0359 id assigned by an entity like a room or an archive.
+type StanzaID struct {
+	XMLName xml.Name `xml:"urn:xmpp:sid:0 stanza-id"`
+	ID      string   `xml:"id,attr"`
+	By      string   `xml:"by,attr"`
+}
+
+// StanzaIDBy returns the id the entity assigned to the raw stanza, if any.
+func StanzaIDBy(raw []byte, by string) string {
+	s := &struct {
+		IDs []StanzaID `xml:"urn:xmpp:sid:0 stanza-id"`
+	}{}
+	if xml.Unmarshal(raw, s) == nil {
+		for _, id := range s.IDs {
+			if id.By == by {
+				return id.ID
+			}
+		}
+	}
+	return ""
+}
+
+// OriginID is the XEP-0359 id the sender gave the stanza, unlike the id
+// attribute it is kept by rooms and archives.
+type OriginID struct {
+	XMLName xml.Name `xml:"urn:xmpp:sid:0 origin-id"`
+	ID      string   `xml:"id,attr"`
+}
+
+// StanzaID returns the id the entity assigned to the message, if any.
+func (m *Message) StanzaID(by string) string {
+	for _, e := range m.Extensions {
+		if e.XMLName.Space != NsSID || e.XMLName.Local != "stanza-id" {
+			continue
+		}
+		id := StanzaID{}
+		if e.Decode(&id) == nil && id.By == by {
+			return id.ID
+		}
+	}
+	return ""
+}
+
+// OriginID returns the origin-id of the message, if any.
+func (m *Message) OriginID() string {
+	if e := m.Ext(NsSID, "origin-id"); e != nil {
+		id := OriginID{}
+		if e.Decode(&id) == nil {
+			return id.ID
+		}
+	}
+	return ""
+}
+
+// SetOriginID marks the message with its id as origin-id, it must have one.
+func (m *Message) SetOriginID() error {
+	return m.With(&OriginID{ID: m.ID})
+}
@@ -0,0 +1,69 @@
+// Package stanza models stanza extensions with encoding/xml, so they can be
+// read from and written to the raw buffers the xippo stream works with.
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"github.com/kpmy/xep/jid"
+)
+
+// Header holds the routing attributes shared by all stanzas.
+type Header struct {
+	From jid.JID `xml:"from,attr,omitempty"`
+	To   jid.JID `xml:"to,attr,omitempty"`
+	ID   string  `xml:"id,attr,omitempty"`
+	Type string  `xml:"type,attr,omitempty"`
+	// Archived is set on stanzas replayed from an archive rather than
+	// received live, it isn't part of the stanza.
+	Archived bool `xml:"-"`
+}
+
+// read takes the header of the attributes, a malformed address is left zero
+// as Peek only routes.
+func (h *Header) read(attrs []xml.Attr) {
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "from":
+			h.From, _ = jid.Parse(a.Value)
+		case "to":
+			h.To, _ = jid.Parse(a.Value)
+		case "id":
+			h.ID = a.Value
+		case "type":
+			h.Type = a.Value
+		}
+	}
+}
+
+// Peek returns the top-level element name and routing header of a raw stanza.
+func Peek(raw []byte) (name xml.Name, h Header, err error) {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	for {
+		var t xml.Token
+		if t, err = d.Token(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			name = se.Name
+			h.read(se.Attr)
+			return
+		}
+	}
+}
+
+// Buffer marshals v into a buffer ready for stream.Write, the buffer comes
+// from the pool and may be put back once written.
+func Buffer(v interface{}) (*bytes.Buffer, error) {
+	buf := GetBuffer()
+	if err := xml.NewEncoder(buf).Encode(v); err != nil {
+		PutBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
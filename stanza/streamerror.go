@@ -0,0 +1,66 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	NsStream       = "http://etherx.jabber.org/streams"
+	NsStreamErrors = "urn:ietf:params:xml:ns:xmpp-streams"
+)
+
+// stream error conditions the bot acts upon
+const (
+	SystemShutdown     = "system-shutdown"
+	Conflict           = "conflict"
+	PolicyViolation    = "policy-violation"
+	NotAuthorized      = "not-authorized"
+	HostUnknown        = "host-unknown"
+	ResourceConstraint = "resource-constraint"
+)
+
+// StreamError is a <stream:error/> sent by the server before closing the stream.
+type StreamError struct {
+	Condition string
+	Text      string
+	At        time.Time
+}
+
+func (e *StreamError) Error() string {
+	if e.Text != "" {
+		return "stream error " + e.Condition + ": " + e.Text
+	}
+	return "stream error " + e.Condition
+}
+
+// IsStreamError tells whether the element name is that of a stream error, the
+// stream prefix may come undeclared as the buffer lacks the stream header.
+func IsStreamError(name xml.Name) bool {
+	return name.Local == "error" && (name.Space == NsStream || name.Space == "stream")
+}
+
+// ParseStreamError reads a raw stream error.
+func ParseStreamError(raw []byte) (*StreamError, error) {
+	x := &struct {
+		Children []struct {
+			XMLName xml.Name
+			Text    string `xml:",chardata"`
+		} `xml:",any"`
+	}{}
+	if err := xml.Unmarshal(raw, x); err != nil {
+		return nil, err
+	}
+	ret := &StreamError{At: time.Now()}
+	for _, c := range x.Children {
+		if c.XMLName.Space != NsStreamErrors {
+			continue
+		}
+		if c.XMLName.Local == "text" {
+			ret.Text = c.Text
+		} else {
+			ret.Condition = c.XMLName.Local
+		}
+	}
+	return ret, nil
+}
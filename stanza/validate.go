@@ -0,0 +1,111 @@
+package stanza
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNoTo       = errors.New("stanza: message without to")
+	ErrNoID       = errors.New("stanza: iq without id")
+	ErrNotOneRoot = errors.New("stanza: not a single element")
+)
+
+// TypeError is returned for a stanza of a type its element doesn't have.
+type TypeError struct {
+	Element string
+	Type    string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("stanza: %s of type %q", e.Element, e.Type)
+}
+
+func oneOf(s string, values ...string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the required fields of a stanza about to be sent, the
+// values of other types pass.
+func Validate(v interface{}) error {
+	switch s := v.(type) {
+	case *Message:
+		if s.To.IsZero() {
+			return ErrNoTo
+		}
+		if !oneOf(s.Type, "", CHAT, GROUPCHAT, NORMAL, HEADLINE, ERROR) {
+			return &TypeError{"message", s.Type}
+		}
+	case *Presence:
+		if !oneOf(s.Type, AVAILABLE, UNAVAILABLE, SUBSCRIBE, SUBSCRIBED, UNSUBSCRIBE, UNSUBSCRIBED, PROBE, ERROR) {
+			return &TypeError{"presence", s.Type}
+		}
+	case *IQ:
+		if s.ID == "" {
+			return ErrNoID
+		}
+		if !oneOf(s.Type, GET, SET, RESULT, ERROR) {
+			return &TypeError{"iq", s.Type}
+		}
+	}
+	return nil
+}
+
+// WellFormed checks that raw is a single well-formed element, so writing it
+// can't break the stream.
+func WellFormed(raw []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	depth, roots := 0, 0
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(t)) > 0 {
+				return ErrNotOneRoot
+			}
+		}
+	}
+	if roots != 1 || depth != 0 {
+		return ErrNotOneRoot
+	}
+	return nil
+}
+
+// Produce validates the stanza and marshals it into a buffer ready for
+// stream.Write, bodies and attributes come out escaped and the raw
+// extensions are checked to be well-formed.
+func Produce(v interface{}) (*bytes.Buffer, error) {
+	if err := Validate(v); err != nil {
+		return nil, err
+	}
+	buf, err := Buffer(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := WellFormed(buf.Bytes()); err != nil {
+		PutBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
@@ -1,22 +1,60 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/fjl/go-couchdb"
 	"github.com/kpmy/ypk/halt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const dbUrl = "http://127.0.0.1:5984"
 const dbName = "stats"
 const docId = "total"
 
+const dayLayout = "2006-01-02"
+
+// seenTTL is how long the ids of counted messages are kept, backfills can't
+// reach further back.
+const seenTTL = 7 * 24 * time.Hour
+
 type CStatDoc struct {
 	Total int
 	Data  map[string]int
 }
 
+// CRoomStatDoc keeps the stats of a single room.
+type CRoomStatDoc struct {
+	Room  string
+	Total int
+	Days  map[string]int
+	Users map[string]*CUserStat
+	// Seen holds the stanza ids of the counted messages.
+	Seen map[string]time.Time
+	// Authors holds who sent the counted messages by stanza id, so a
+	// retraction can be taken off their count.
+	Authors map[string]string
+}
+
+type CUserStat struct {
+	Count int
+	Today int
+	Day   string
+	First time.Time
+	Last  time.Time
+}
+
 var db *couchdb.DB
 
+// statMu serializes the read-modify-write of the stat documents, which the
+// pump workers would otherwise race on.
+var statMu sync.Mutex
+
 func GetStat() (ret *CStatDoc, err error) {
 	ret = &CStatDoc{}
 	if err = db.Get(docId, ret, nil); err == nil {
@@ -39,7 +77,111 @@ func SetStat(old *CStatDoc) {
 	}
 }
 
-func IncStat(user string) {
+func roomDocId(room string) string {
+	return "room:" + room
+}
+
+func GetRoomStat(room string) (ret *CRoomStatDoc, err error) {
+	ret = &CRoomStatDoc{}
+	if err = db.Get(roomDocId(room), ret, nil); err == nil {
+		if ret.Days == nil {
+			ret.Days = make(map[string]int)
+		}
+		if ret.Users == nil {
+			ret.Users = make(map[string]*CUserStat)
+		}
+		if ret.Seen == nil {
+			ret.Seen = make(map[string]time.Time)
+		}
+		if ret.Authors == nil {
+			ret.Authors = make(map[string]string)
+		}
+	} else if couchdb.NotFound(err) {
+		if _, err = db.Put(roomDocId(room), &CRoomStatDoc{Room: room}, ""); err == nil {
+			ret, err = GetRoomStat(room)
+		}
+	}
+	return
+}
+
+func SetRoomStat(doc *CRoomStatDoc) {
+	if rev, err := db.Rev(roomDocId(doc.Room)); err == nil {
+		if _, err = db.Put(roomDocId(doc.Room), doc, rev); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Rank returns the 1-based position of the user by message count.
+func (d *CRoomStatDoc) Rank(user string) int {
+	users := make([]string, 0, len(d.Users))
+	for u := range d.Users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return d.Users[users[i]].Count > d.Users[users[j]].Count })
+	for i, u := range users {
+		if u == user {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func incRoomStat(room, user, id string, at time.Time) bool {
+	s, err := GetRoomStat(room)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if id != "" {
+		if _, ok := s.Seen[id]; ok {
+			return false
+		}
+		for k, t := range s.Seen {
+			if time.Since(t) > seenTTL {
+				delete(s.Seen, k)
+				delete(s.Authors, k)
+			}
+		}
+		s.Seen[id] = at
+		s.Authors[id] = user
+	}
+	day := at.Format(dayLayout)
+	u, ok := s.Users[user]
+	if !ok {
+		u = &CUserStat{First: at}
+		s.Users[user] = u
+	}
+	// backfilled messages may be older than the ones counted already
+	if day > u.Day {
+		u.Day, u.Today = day, 0
+	}
+	if day == u.Day {
+		u.Today++
+	}
+	if at.Before(u.First) {
+		u.First = at
+	}
+	if at.After(u.Last) {
+		u.Last = at
+	}
+	u.Count++
+	s.Days[day]++
+	s.Total++
+	SetRoomStat(s)
+	return true
+}
+
+// IncStat counts a message sent at the given time, it returns false when the
+// message with that stanza id was counted before.
+func IncStat(room, user, id string, at time.Time) bool {
+	defer statTimer.Since(time.Now())
+	statMu.Lock()
+	defer statMu.Unlock()
+	user = statKey(user)
+	if !incRoomStat(room, user, id, at) {
+		return false
+	}
 	if s, err := GetStat(); err == nil {
 		if _, ok := s.Data[user]; ok {
 			s.Data[user] = s.Data[user] + 1
@@ -49,6 +191,71 @@ func IncStat(user string) {
 		s.Total++
 		SetStat(s)
 	}
+	return true
+}
+
+func decRoomStat(room, user, id string) (author string, ok bool) {
+	s, err := GetRoomStat(room)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	// the id stays seen, so a backfill doesn't count the message again
+	if author, ok = s.Authors[id]; !ok || (user != "" && user != author) {
+		return "", false
+	}
+	delete(s.Authors, id)
+	day := s.Seen[id].Format(dayLayout)
+	if u, found := s.Users[author]; found && u.Count > 0 {
+		u.Count--
+		if day == u.Day && u.Today > 0 {
+			u.Today--
+		}
+	}
+	if s.Days[day] > 0 {
+		s.Days[day]--
+	}
+	if s.Total > 0 {
+		s.Total--
+	}
+	SetRoomStat(s)
+	return
+}
+
+// DecStat takes the retracted message with the stanza id off the counts, the
+// user must be its sender unless empty. It returns false when the message
+// wasn't counted or was taken off before.
+func DecStat(room, user, id string) bool {
+	statMu.Lock()
+	defer statMu.Unlock()
+	if user != "" {
+		user = statKey(user)
+	}
+	user, ok := decRoomStat(room, user, id)
+	if !ok {
+		return false
+	}
+	if s, err := GetStat(); err == nil {
+		if s.Data[user] > 0 {
+			s.Data[user]--
+		}
+		if s.Total > 0 {
+			s.Total--
+		}
+		SetStat(s)
+	}
+	return true
+}
+
+// statKey is the name stats are stored under, in privacy mode a salted hash
+// so leaderboards can be kept without identifiable data.
+func statKey(user string) string {
+	if statsSalt == "" {
+		return user
+	}
+	mac := hmac.New(sha256.New, []byte(statsSalt))
+	mac.Write([]byte(strings.ToLower(user)))
+	return "#" + hex.EncodeToString(mac.Sum(nil))[:16]
 }
 
 func init() {
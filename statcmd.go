@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kpmy/xep/muc"
+	"github.com/kpmy/xep/xhtmlim"
+)
+
+// statUser maps a nick to the user name stats are kept under.
+func statUser(nick string) string {
+	if u, ok := muc.UserMapping()[nick]; ok {
+		if s, ok := u.(string); ok {
+			return s
+		}
+	}
+	return nick
+}
+
+func init() {
+	commands["stats"] = &command{usage: "[me|nick]", run: func(c *cmd) (string, error) {
+		s, err := GetRoomStat(c.room)
+		if err != nil {
+			return "", err
+		}
+		if len(c.args) == 0 {
+			today := s.Days[time.Now().Format(dayLayout)]
+			return fmt.Sprintf("%s: %d messages today, %d total from %d users", c.room, today, s.Total, len(s.Users)), nil
+		}
+		who, user := c.args[0], statUser(c.args[0])
+		if who == "me" {
+			who, user = c.sender, c.user
+		}
+		user = statKey(user)
+		u, ok := s.Users[user]
+		if !ok {
+			return fmt.Sprintf("no stats for %s", who), nil
+		}
+		today := 0
+		if u.Day == time.Now().Format(dayLayout) {
+			today = u.Today
+		}
+		c.replyRich(xhtmlim.Doc{
+			xhtmlim.Bold(who),
+			xhtmlim.Text(fmt.Sprintf(": %d messages (%d today), rank %d of %d, first seen %s",
+				u.Count, today, s.Rank(user), len(s.Users), u.First.Format(dayLayout))),
+		})
+		return "", nil
+	}}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"github.com/kpmy/xep/botstatus"
+	"github.com/kpmy/xep/pump"
+)
+
+// inbound is the pump of the current connection.
+var inbound *pump.Pump
+
+// botStatus makes the status document published with -status-every.
+func botStatus() *botstatus.Doc {
+	doc := &botstatus.Doc{Version: swVersion, Started: started.UTC(), Uptime: int64(time.Since(started) / time.Second)}
+	for _, r := range rooms.List() {
+		doc.Rooms = append(doc.Rooms, r.JID)
+	}
+	if p := inbound; p != nil {
+		doc.Queues = append(doc.Queues, botstatus.Queue{Name: "stanzas", Depth: p.Depth()})
+	}
+	if hookExec != nil {
+		doc.Queues = append(doc.Queues, botstatus.Queue{Name: "hooks", Depth: hookExec.Queued()})
+	}
+	return doc
+}
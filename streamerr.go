@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/stanza"
+)
+
+var streamErr struct {
+	sync.Mutex
+	last *stanza.StreamError
+}
+
+// onStreamError keeps the condition the server closes the stream with, so the
+// reconnect can act upon it.
+func onStreamError() dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if !stanza.IsStreamError(name) {
+			return false
+		}
+		e, err := stanza.ParseStreamError(raw)
+		if err != nil {
+			log.Println(err)
+			return true
+		}
+		log.Println(e)
+		streamErr.Lock()
+		streamErr.last = e
+		streamErr.Unlock()
+		emit("stream-error", map[string]string{"condition": e.Condition, "text": e.Text})
+		return true
+	}
+}
+
+// reconnectDelay tells how long to wait before reconnecting after the last
+// stream error, or that reconnecting is pointless.
+func reconnectDelay() (delay time.Duration, stop bool) {
+	streamErr.Lock()
+	e := streamErr.last
+	streamErr.Unlock()
+	if e == nil {
+		return time.Second, false
+	}
+	switch e.Condition {
+	case stanza.Conflict, stanza.NotAuthorized, stanza.HostUnknown:
+		// another instance took over or the account is gone, retrying just fights it
+		return 0, true
+	case stanza.SystemShutdown:
+		delay = time.Minute
+	case stanza.PolicyViolation, stanza.ResourceConstraint:
+		delay = 5 * time.Minute
+	default:
+		delay = 5 * time.Second
+	}
+	// repeated errors keep the last one, back off while it stays fresh
+	if since := time.Since(e.At); since < delay {
+		return delay - since, false
+	}
+	return time.Second, false
+}
+
+// reportStreamError tells the room why the bot was away once it is back.
+func reportStreamError() {
+	streamErr.Lock()
+	e := streamErr.last
+	streamErr.last = nil
+	streamErr.Unlock()
+	if e != nil {
+		reply(ROOM, "reconnected after "+e.Error())
+	}
+}
+
+func giveUp() {
+	streamErr.Lock()
+	e := streamErr.last
+	streamErr.Unlock()
+	log.Println("not reconnecting after", e)
+	emit("stopped", map[string]string{"condition": e.Condition, "text": e.Text})
+	stopHooks()
+	shipper.Close()
+	os.Exit(1)
+}
@@ -0,0 +1,196 @@
+// Package streamtest is an in-memory stream.Stream to try steps and handlers
+// on without a server, the server side is scripted with canned stanzas:
+//
+//	st := streamtest.New("example.org")
+//	st.On(streamtest.Contains("jabber:iq:version"), `<iq type="result" id="{id}"/>`)
+//	err := step(st)
+package streamtest
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpmy/xep/stanza"
+	"github.com/kpmy/xippo/c2s/stream"
+	"github.com/kpmy/xippo/units"
+)
+
+var _ stream.Stream = (*Stream)(nil)
+
+type rule struct {
+	match     func([]byte) bool
+	responses []string
+	once      bool
+}
+
+// Stream keeps what is written to it and answers it by its rules. The lock
+// is never held while a stanza is delivered, so the callbacks of Ring may
+// write and push.
+type Stream struct {
+	server *units.Server
+	mu     sync.Mutex
+	queue  []*bytes.Buffer
+	closed bool
+	// wake tells Ring there is more to deliver, drained is closed once the
+	// stream is closed and its queue delivered
+	wake    chan struct{}
+	drained chan struct{}
+	drain   sync.Once
+	rules   []*rule
+	written [][]byte
+	ids     int64
+}
+
+func New(domain string) *Stream {
+	return &Stream{server: &units.Server{Name: domain}, wake: make(chan struct{}, 1), drained: make(chan struct{})}
+}
+
+func (s *Stream) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Element matches the stanzas of the top-level element.
+func Element(local string) func([]byte) bool {
+	return func(raw []byte) bool {
+		name, _, err := stanza.Peek(raw)
+		return err == nil && name.Local == local
+	}
+}
+
+// Contains matches the stanzas containing s, a namespace usually.
+func Contains(s string) func([]byte) bool {
+	return func(raw []byte) bool {
+		return bytes.Contains(raw, []byte(s))
+	}
+}
+
+// On answers every stanza written that matches with the responses, {id} in
+// them is replaced with the id of the stanza answered.
+func (s *Stream) On(match func([]byte) bool, responses ...string) *Stream {
+	s.mu.Lock()
+	s.rules = append(s.rules, &rule{match: match, responses: responses})
+	s.mu.Unlock()
+	return s
+}
+
+// Once is On for the first stanza that matches only.
+func (s *Stream) Once(match func([]byte) bool, responses ...string) *Stream {
+	s.mu.Lock()
+	s.rules = append(s.rules, &rule{match: match, responses: responses, once: true})
+	s.mu.Unlock()
+	return s
+}
+
+// Push queues stanzas as if the server sent them, a closed stream drops them.
+func (s *Stream) Push(raw ...string) {
+	s.mu.Lock()
+	if !s.closed {
+		for _, r := range raw {
+			s.queue = append(s.queue, bytes.NewBufferString(r))
+		}
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Close ends the server side, Ring delivers nothing after the stanzas queued.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Drained is closed once the stream is closed and the stanzas queued before
+// are delivered.
+func (s *Stream) Drained() <-chan struct{} {
+	return s.drained
+}
+
+func (s *Stream) Server() *units.Server { return s.server }
+
+// Write keeps the stanza and queues the responses of the rules it matches.
+func (s *Stream) Write(b *bytes.Buffer) error {
+	raw := append([]byte(nil), b.Bytes()...)
+	var id string
+	if _, h, err := stanza.Peek(raw); err == nil {
+		id = h.ID
+	}
+	s.mu.Lock()
+	s.written = append(s.written, raw)
+	var responses []string
+	kept := s.rules[:0]
+	for _, r := range s.rules {
+		if r.match(raw) {
+			for _, resp := range r.responses {
+				responses = append(responses, strings.Replace(resp, "{id}", id, -1))
+			}
+			if r.once {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	s.rules = kept
+	s.mu.Unlock()
+	s.Push(responses...)
+	return nil
+}
+
+// Written returns the stanzas written so far.
+func (s *Stream) Written() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.written...)
+}
+
+// Ring delivers the queued stanzas until fn is done with one or for the
+// timeout, forever if zero; a closed stream delivers nothing once its queue
+// is delivered.
+func (s *Stream) Ring(fn func(*bytes.Buffer) bool, timeout time.Duration) {
+	var after <-chan time.Time
+	if timeout > 0 {
+		after = time.After(timeout)
+	}
+	wake := s.wake
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			b := s.queue[0]
+			s.queue = s.queue[1:]
+			more := len(s.queue) > 0
+			s.mu.Unlock()
+			if more {
+				// for another Ring waiting
+				s.signal()
+			}
+			if fn(b) {
+				return
+			}
+			continue
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			s.drain.Do(func() { close(s.drained) })
+			wake = nil
+		}
+		select {
+		case <-wake:
+		case <-after:
+			return
+		}
+	}
+}
+
+// Id returns a fresh id.
+func (s *Stream) Id(...string) string {
+	return "test-" + strconv.FormatInt(atomic.AddInt64(&s.ids, 1), 10)
+}
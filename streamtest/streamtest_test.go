@@ -0,0 +1,78 @@
+package streamtest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	st := New("example.org")
+	st.On(Contains("jabber:iq:version"), `<iq type="result" id="{id}"/>`)
+	st.Once(Element("presence"), `<presence from="room@conference.example.org/bot"/>`)
+	st.Write(bytes.NewBufferString(`<iq type="get" id="v1"><query xmlns="jabber:iq:version"/></iq>`))
+	st.Write(bytes.NewBufferString(`<presence to="room@conference.example.org/bot"/>`))
+	st.Write(bytes.NewBufferString(`<presence to="room@conference.example.org/bot"/>`))
+	st.Close()
+	var got []string
+	st.Ring(func(b *bytes.Buffer) bool {
+		got = append(got, b.String())
+		return false
+	}, 100*time.Millisecond)
+	want := []string{`<iq type="result" id="v1"/>`, `<presence from="room@conference.example.org/bot"/>`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+	if n := len(st.Written()); n != 3 {
+		t.Fatalf("%d stanzas written, want 3", n)
+	}
+	select {
+	case <-st.Drained():
+	default:
+		t.Fatal("closed stream delivered everything but isn't drained")
+	}
+}
+
+// A callback may write while the server side pushes, answered by the rules.
+func TestPushWhileRinging(t *testing.T) {
+	st := New("example.org")
+	st.On(Element("message"), `<iq type="result" id="{id}"/>`)
+	go func() {
+		for i := 0; i < 128; i++ {
+			st.Push(fmt.Sprintf(`<message id="m%d"/>`, i))
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		n := 0
+		st.Ring(func(b *bytes.Buffer) bool {
+			if bytes.HasPrefix(b.Bytes(), []byte("<message")) {
+				st.Write(b)
+				n++
+			}
+			return n == 128
+		}, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ringing and pushing deadlocked")
+	}
+}
+
+func TestClosedDropsPush(t *testing.T) {
+	st := New("example.org")
+	st.Push(`<message id="1"/>`)
+	st.Close()
+	st.Push(`<message id="2"/>`)
+	var got []string
+	st.Ring(func(b *bytes.Buffer) bool {
+		got = append(got, b.String())
+		return false
+	}, 50*time.Millisecond)
+	if len(got) != 1 || got[0] != `<message id="1"/>` {
+		t.Fatalf("delivered %q", got)
+	}
+}
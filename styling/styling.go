@@ -0,0 +1,193 @@
+// Package styling parses XEP-0393 message styling, the *strong*, _emphasis_,
+// ~strike~ and `pre` spans, the > quotes and the ``` blocks of plain bodies.
+package styling
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"unicode"
+)
+
+type Kind int
+
+const (
+	// Line is a line of text, its children are the spans.
+	Line Kind = iota
+	// Block is a preformatted block, its text is kept verbatim.
+	Block
+	// Quote is a block quote, its children are the quoted blocks.
+	Quote
+
+	Text
+	Strong
+	Emphasis
+	Strike
+	Pre
+)
+
+type Node struct {
+	Kind     Kind
+	Text     string
+	Children []*Node
+}
+
+var spans = map[rune]Kind{'*': Strong, '_': Emphasis, '~': Strike, '`': Pre}
+
+// Parse parses a message body into blocks.
+func Parse(body string) []*Node {
+	return parseBlocks(strings.Split(body, "\n"))
+}
+
+func parseBlocks(lines []string) (ret []*Node) {
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "```"):
+			// the block runs to the closing line or to the end of the quote or message
+			j := i + 1
+			for j < len(lines) && lines[j] != "```" {
+				j++
+			}
+			ret = append(ret, &Node{Kind: Block, Text: strings.Join(lines[i+1:min(j, len(lines))], "\n")})
+			i = j
+		case strings.HasPrefix(l, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				q := strings.TrimPrefix(lines[i], ">")
+				if !strings.HasPrefix(q, ">") {
+					q = strings.TrimPrefix(q, " ")
+				}
+				quoted = append(quoted, q)
+			}
+			i--
+			ret = append(ret, &Node{Kind: Quote, Children: parseBlocks(quoted)})
+		default:
+			ret = append(ret, &Node{Kind: Line, Children: parseSpans([]rune(l))})
+		}
+	}
+	return
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// closing finds the directive closing the span opened at i, it must follow
+// some text that doesn't end in a space.
+func closing(s []rune, i int) int {
+	for j := i + 2; j < len(s); j++ {
+		if s[j] == s[i] && !unicode.IsSpace(s[j-1]) {
+			return j
+		}
+	}
+	return -1
+}
+
+func parseSpans(s []rune) (ret []*Node) {
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			ret = append(ret, &Node{Kind: Text, Text: string(s[start:end])})
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		k, ok := spans[s[i]]
+		if !ok || i+1 >= len(s) || unicode.IsSpace(s[i+1]) {
+			continue
+		}
+		// an opening directive starts the line or follows a space or another directive
+		if i > 0 && !unicode.IsSpace(s[i-1]) {
+			if _, after := spans[s[i-1]]; !after {
+				continue
+			}
+		}
+		j := closing(s, i)
+		if j < 0 {
+			continue
+		}
+		flush(i)
+		n := &Node{Kind: k}
+		if k == Pre {
+			n.Text = string(s[i+1 : j])
+		} else {
+			n.Children = parseSpans(s[i+1 : j])
+		}
+		ret = append(ret, n)
+		i, start = j, j+1
+	}
+	flush(len(s))
+	return
+}
+
+// Plain returns the text of the nodes without the styling directives.
+func Plain(nodes []*Node) string {
+	buf := new(bytes.Buffer)
+	var walk func(nodes []*Node, prefix string)
+	walk = func(nodes []*Node, prefix string) {
+		for _, n := range nodes {
+			switch n.Kind {
+			case Line:
+				buf.WriteString(prefix)
+				walk(n.Children, "")
+				buf.WriteByte('\n')
+			case Block:
+				for _, l := range strings.Split(n.Text, "\n") {
+					buf.WriteString(prefix + l + "\n")
+				}
+			case Quote:
+				walk(n.Children, prefix+"> ")
+			case Text, Pre:
+				buf.WriteString(n.Text)
+			default:
+				walk(n.Children, "")
+			}
+		}
+	}
+	walk(nodes, "")
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+var tags = map[Kind]string{Strong: "strong", Emphasis: "em", Strike: "del", Pre: "code"}
+
+// HTML renders the nodes as escaped HTML, the directives are kept as the
+// XEP recommends so copied text stays the same.
+func HTML(nodes []*Node) string {
+	buf := new(bytes.Buffer)
+	var walk func(nodes []*Node)
+	marks := map[Kind]string{Strong: "*", Emphasis: "_", Strike: "~", Pre: "`"}
+	walk = func(nodes []*Node) {
+		for _, n := range nodes {
+			if n.Kind == Text {
+				xml.EscapeText(buf, []byte(n.Text))
+				continue
+			}
+			buf.WriteString("<" + tags[n.Kind] + ">" + marks[n.Kind])
+			if n.Kind == Pre {
+				xml.EscapeText(buf, []byte(n.Text))
+			} else {
+				walk(n.Children)
+			}
+			buf.WriteString(marks[n.Kind] + "</" + tags[n.Kind] + ">")
+		}
+	}
+	for i, n := range nodes {
+		if i > 0 && nodes[i-1].Kind == Line && n.Kind == Line {
+			buf.WriteString("<br>")
+		}
+		switch n.Kind {
+		case Line:
+			walk(n.Children)
+		case Block:
+			buf.WriteString("<pre>")
+			xml.EscapeText(buf, []byte(n.Text))
+			buf.WriteString("</pre>")
+		case Quote:
+			buf.WriteString("<blockquote>" + HTML(n.Children) + "</blockquote>")
+		}
+	}
+	return buf.String()
+}
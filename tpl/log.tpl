@@ -16,6 +16,6 @@
 	<body>
 		<a href="/stat">стата</a>
 		<h1>лог</h1>
-		{{range .Posts}}<p class="message"><span class="user"><em>{{.Nick}}</em></span>: {{.Msg}}</p>{{else}}ничего ._.{{end}}
+		{{range .Posts}}<p class="message"><span class="user"><em>{{.Nick}}</em></span>: {{if .Retracted}}<span class="user">сообщение удалено</span>{{else}}{{.Msg}}{{end}}</p>{{else}}ничего ._.{{end}}
 	</body>
 </html>
@@ -0,0 +1,142 @@
+// Package upload implements XEP-0363 HTTP file upload, the files are put on
+// the upload service of the server and shared by their URL.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/kpmy/xep/disco"
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "urn:xmpp:http:upload:0"
+
+var ErrNoService = errors.New("upload: the server has no upload service")
+
+type request struct {
+	XMLName     xml.Name `xml:"urn:xmpp:http:upload:0 request"`
+	Filename    string   `xml:"filename,attr"`
+	Size        int64    `xml:"size,attr"`
+	ContentType string   `xml:"content-type,attr,omitempty"`
+}
+
+type header struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Slot is where a file is put and then got from.
+type Slot struct {
+	XMLName xml.Name `xml:"urn:xmpp:http:upload:0 slot"`
+	Put     struct {
+		URL     string   `xml:"url,attr"`
+		Headers []header `xml:"header"`
+	} `xml:"put"`
+	Get struct {
+		URL string `xml:"url,attr"`
+	} `xml:"get"`
+}
+
+var (
+	mu       sync.Mutex
+	services = make(map[string]string)
+)
+
+// Service returns the upload service among the items of the domain, it is
+// looked up once per domain.
+func Service(d *dispatch.Dispatcher, domain string) (string, error) {
+	mu.Lock()
+	service, ok := services[domain]
+	mu.Unlock()
+	if ok {
+		return service, nil
+	}
+	server, err := jid.Parse(domain)
+	if err != nil {
+		return "", err
+	}
+	items, err := disco.Items(d, server)
+	if err != nil {
+		return "", err
+	}
+	for _, i := range items.Items {
+		addr, err := jid.Parse(i.JID)
+		if err != nil {
+			continue
+		}
+		if info, err := disco.Info(d, addr); err == nil && info.Has(Ns) {
+			mu.Lock()
+			services[domain] = i.JID
+			mu.Unlock()
+			return i.JID, nil
+		}
+	}
+	return "", ErrNoService
+}
+
+// RequestSlot asks the service for a slot for the file.
+func RequestSlot(d *dispatch.Dispatcher, service, name string, size int64, mime string) (*Slot, error) {
+	to, err := jid.Parse(service)
+	if err != nil {
+		return nil, err
+	}
+	iq, _ := stanza.NewIQ(stanza.GET, to, &request{Filename: name, Size: size, ContentType: mime})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Slot{}
+	return ret, res.Decode(ret)
+}
+
+// File uploads size bytes read from r to the upload service of the domain
+// and returns the URL to get the file from.
+func File(ctx context.Context, d *dispatch.Dispatcher, domain string, r io.Reader, name string, size int64, mime string) (string, error) {
+	service, err := Service(d, domain)
+	if err != nil {
+		return "", err
+	}
+	slot, err := RequestSlot(d, service, name, size, mime)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, slot.Put.URL, io.LimitReader(r, size))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	if mime != "" {
+		req.Header.Set("Content-Type", mime)
+	}
+	for _, h := range slot.Put.Headers {
+		// the XEP allows the service to set these only
+		switch h.Name {
+		case "Authorization", "Cookie", "Expires":
+			req.Header.Set(h.Name, h.Value)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upload: put of %s failed: %s", name, resp.Status)
+	}
+	return slot.Get.URL, nil
+}
+
+// Bytes uploads data, a shorthand for File.
+func Bytes(ctx context.Context, d *dispatch.Dispatcher, domain, name string, data []byte, mime string) (string, error) {
+	return File(ctx, d, domain, bytes.NewReader(data), name, int64(len(data)), mime)
+}
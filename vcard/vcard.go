@@ -0,0 +1,66 @@
+// Package vcard gets and sets XEP-0054 vCard-temp cards.
+package vcard
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "vcard-temp"
+
+type Photo struct {
+	Type   string `xml:"TYPE,omitempty"`
+	BinVal string `xml:"BINVAL,omitempty"`
+	Ext    string `xml:"EXTVAL,omitempty"`
+}
+
+// Data returns the decoded image of the photo.
+func (p *Photo) Data() ([]byte, error) {
+	// clients wrap the base64 at 76 characters
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(p.BinVal), ""))
+}
+
+// VCard holds the fields of a card the bot uses, the others are dropped.
+type VCard struct {
+	XMLName  xml.Name `xml:"vcard-temp vCard"`
+	FN       string   `xml:"FN,omitempty"`
+	Nickname string   `xml:"NICKNAME,omitempty"`
+	URL      string   `xml:"URL,omitempty"`
+	Desc     string   `xml:"DESC,omitempty"`
+	Email    *Email   `xml:"EMAIL"`
+	Photo    *Photo   `xml:"PHOTO"`
+}
+
+type Email struct {
+	UserID string `xml:"USERID"`
+}
+
+// Get fetches the card of to, the account's when zero. A missing card is
+// returned empty.
+func Get(d *dispatch.Dispatcher, to jid.JID) (*VCard, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &VCard{})
+	res, err := d.Request(iq)
+	if err != nil {
+		if stanza.IsItemNotFound(err) {
+			return &VCard{}, nil
+		}
+		return nil, err
+	}
+	ret := &VCard{}
+	if len(res.Payload) == 0 {
+		return ret, nil
+	}
+	return ret, res.Decode(ret)
+}
+
+// Set replaces the card of the account.
+func Set(d *dispatch.Dispatcher, v *VCard) error {
+	iq, _ := stanza.NewIQ(stanza.SET, jid.JID{}, v)
+	_, err := d.Request(iq)
+	return err
+}
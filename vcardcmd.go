@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/vcard"
+)
+
+// publishVCard fills the card of the bot from the -vcard-* flags, keeping
+// the photo set along with the avatar.
+func publishVCard() {
+	if vcardDesc == "" && vcardEmail == "" {
+		return
+	}
+	v, err := vcard.Get(disp, jid.JID{})
+	if err == nil {
+		v.FN, v.Nickname, v.Desc = swName, ME, vcardDesc
+		if vcardEmail != "" {
+			v.Email = &vcard.Email{UserID: vcardEmail}
+		}
+		err = vcard.Set(disp, v)
+	}
+	if err != nil {
+		log.Println("failed to publish the vCard:", err)
+	}
+}
+
+func init() {
+	commands["whois"] = &command{usage: "<nick>", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		nick := strings.Join(c.args, " ")
+		o, ok := rooms.Occupant(c.room, nick)
+		if !ok {
+			return "", errors.New(nick + " isn't here")
+		}
+		if o.JID == "" {
+			return "", errors.New("the real JID of " + nick + " isn't visible")
+		}
+		addr, err := jid.Parse(o.JID)
+		if err != nil {
+			return "", err
+		}
+		v, err := vcard.Get(disp, addr.Bare())
+		if err != nil {
+			return "", err
+		}
+		ret := []string{nick + " is " + addr.Bare().String()}
+		for _, f := range []struct{ name, value string }{
+			{"name", v.FN}, {"nickname", v.Nickname}, {"url", v.URL}, {"about", v.Desc},
+		} {
+			if f.value != "" {
+				ret = append(ret, f.name+": "+f.value)
+			}
+		}
+		if v.Email != nil && v.Email.UserID != "" {
+			ret = append(ret, "email: "+v.Email.UserID)
+		}
+		return strings.Join(ret, "\n"), nil
+	}}
+}
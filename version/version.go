@@ -0,0 +1,50 @@
+// Package version implements XEP-0092 software version.
+package version
+
+import (
+	"encoding/xml"
+
+	"github.com/kpmy/xep/dispatch"
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const Ns = "jabber:iq:version"
+
+type Query struct {
+	XMLName xml.Name `xml:"jabber:iq:version query"`
+	Name    string   `xml:"name,omitempty"`
+	Version string   `xml:"version,omitempty"`
+	OS      string   `xml:"os,omitempty"`
+}
+
+// Get asks to for its software version.
+func Get(d *dispatch.Dispatcher, to jid.JID) (*Query, error) {
+	iq, _ := stanza.NewIQ(stanza.GET, to, &Query{})
+	res, err := d.Request(iq)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Query{}
+	return ret, res.Decode(ret)
+}
+
+// Handler answers version queries with the given software, an empty OS is
+// left out as the XEP allows to keep it private.
+func Handler(d *dispatch.Dispatcher, sw *Query) dispatch.Handler {
+	return func(name xml.Name, h stanza.Header, raw []byte) bool {
+		if name.Local != "iq" || h.Type != stanza.GET {
+			return false
+		}
+		iq := &stanza.IQ{}
+		if err := xml.Unmarshal(raw, iq); err != nil || iq.PayloadName().Space != Ns {
+			return false
+		}
+		res := iq.Result()
+		if res.Set(sw) != nil {
+			return false
+		}
+		d.Send(res)
+		return true
+	}
+}
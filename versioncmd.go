@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/version"
+	"github.com/kpmy/xippo/units"
+)
+
+func init() {
+	commands["version"] = &command{usage: "<nick>", run: func(c *cmd) (string, error) {
+		if len(c.args) == 0 {
+			return "", errUsage
+		}
+		nick := strings.Join(c.args, " ")
+		to, err := jid.Parse(units.Bare2Full(c.room, nick))
+		if err != nil {
+			return "", err
+		}
+		v, err := version.Get(disp, to)
+		if err != nil {
+			return "", err
+		}
+		ret := nick + " uses " + v.Name + " " + v.Version
+		if v.OS != "" {
+			ret += " on " + v.OS
+		}
+		return ret, nil
+	}}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kpmy/xep/adhoc"
+	"github.com/kpmy/xep/dataforms"
+	"github.com/kpmy/xep/jid"
+)
+
+var locales = []string{"ru", "en"}
+
+func boolValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func roomForm() *dataforms.Form {
+	return &dataforms.Form{Type: dataforms.FORM, Title: "Room onboarding", Instructions: "Which room should the bot serve?", Fields: []dataforms.Field{
+		{Var: "room", Type: dataforms.JID_SINGLE, Label: "Room", Required: dataforms.Required},
+	}}
+}
+
+func settingsForm(c *CRoomConfig) *dataforms.Form {
+	mf := dataforms.Field{Var: "modules", Type: dataforms.LIST_MULTI, Label: "Modules to enable", Values: c.Modules}
+	for _, n := range mods.Names() {
+		mf.Options = append(mf.Options, dataforms.Option{Value: n})
+	}
+	lf := dataforms.Field{Var: "locale", Type: dataforms.LIST_SINGLE, Label: "Locale", Values: []string{c.Locale}}
+	for _, l := range locales {
+		lf.Options = append(lf.Options, dataforms.Option{Value: l})
+	}
+	return &dataforms.Form{Type: dataforms.FORM, Title: "Room onboarding", Instructions: "Settings of " + c.Room, Fields: []dataforms.Field{
+		mf,
+		{Var: "prefix", Type: dataforms.TEXT_SINGLE, Label: "Command prefix", Values: []string{c.Prefix}},
+		lf,
+		{Var: "log", Type: dataforms.BOOLEAN, Label: "Log messages", Values: []string{boolValue(c.Log)}},
+		{Var: "attention", Type: dataforms.BOOLEAN, Label: "Allow buzzing occupants", Values: []string{boolValue(c.Attention)}},
+		{Var: "rich", Type: dataforms.BOOLEAN, Label: "Formatted responses", Values: []string{boolValue(c.Rich)}},
+	}}
+}
+
+// onboard is the two step room setup: the room first, then its settings,
+// which are saved and the room joined on completion.
+func onboard(s *adhoc.Session, action string, form *dataforms.Form) (*adhoc.Response, error) {
+	switch {
+	case action == adhoc.EXECUTE && s.Step == 0, action == adhoc.PREV:
+		s.Step = 1
+		return &adhoc.Response{Form: roomForm(), Actions: []string{adhoc.NEXT}}, nil
+	case s.Step == 1 && form != nil:
+		if err := roomForm().Validate(form); err != nil {
+			return nil, err
+		}
+		room := strings.TrimSpace(form.Value("room"))
+		if !strings.Contains(room, "@") || strings.Contains(room, "/") {
+			return nil, errors.New("bad room " + room)
+		}
+		c, err := GetRoomConfig(room)
+		if err != nil {
+			return nil, err
+		}
+		s.Step, s.Data["room"] = 2, []string{room}
+		return &adhoc.Response{Form: settingsForm(c), Actions: []string{adhoc.COMPLETE, adhoc.PREV}}, nil
+	case s.Step == 2 && form != nil:
+		c, err := GetRoomConfig(s.Data["room"][0])
+		if err != nil {
+			return nil, err
+		}
+		if err = settingsForm(c).Validate(form); err != nil {
+			return nil, err
+		}
+		c.Modules = form.Get("modules")
+		if p := strings.TrimSpace(form.Value("prefix")); p != "" {
+			c.Prefix = p
+		}
+		for _, l := range locales {
+			if form.Value("locale") == l {
+				c.Locale = l
+			}
+		}
+		c.Log = form.Bool("log")
+		c.Attention = form.Bool("attention")
+		c.Rich = form.Bool("rich")
+		if err = SetRoomConfig(c); err != nil {
+			return nil, err
+		}
+		note := c.Room + " is set up"
+		if _, joined := rooms.Get(c.Room); !joined {
+			if err = joinRoom(c.Room, ME, "", true); err != nil {
+				return nil, err
+			}
+			note += " and joined"
+		}
+		return &adhoc.Response{Notes: []adhoc.Note{{Type: "info", Text: note}}}, nil
+	}
+	return nil, errors.New("unexpected " + action)
+}
+
+func adminJID(addr string) bool {
+	return isAdmin("", "", jid.Bare(addr))
+}
+
+func init() {
+	adhocCmds.Add(&adhoc.Command{Node: "onboard", Name: "Set up a room", Allowed: adminJID, Run: onboard})
+}
@@ -0,0 +1,148 @@
+// Package xhtmlim builds XEP-0071 XHTML-IM messages from simple rich text,
+// a plain text body is generated for the clients without XHTML-IM.
+package xhtmlim
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"github.com/kpmy/xep/jid"
+	"github.com/kpmy/xep/stanza"
+)
+
+const (
+	Ns      = "http://jabber.org/protocol/xhtml-im"
+	NsXHTML = "http://www.w3.org/1999/xhtml"
+)
+
+type kind int
+
+const (
+	text kind = iota
+	bold
+	italic
+	code
+	pre
+	link
+)
+
+// Span is a run of text with a single style.
+type Span struct {
+	kind kind
+	Text string
+	URL  string
+}
+
+// Doc is a rich text made of spans.
+type Doc []Span
+
+func Text(s string) Span   { return Span{kind: text, Text: s} }
+func Bold(s string) Span   { return Span{kind: bold, Text: s} }
+func Italic(s string) Span { return Span{kind: italic, Text: s} }
+func Code(s string) Span   { return Span{kind: code, Text: s} }
+
+// Pre is a code block, it always stands on lines of its own.
+func Pre(s string) Span { return Span{kind: pre, Text: s} }
+
+// Link is a link to url, the url itself is shown when s is empty.
+func Link(url, s string) Span { return Span{kind: link, Text: s, URL: url} }
+
+// Plain renders the doc as plain text, marked up the way XEP-0393 styling
+// does so it reads well in any client.
+func (d Doc) Plain() string {
+	buf := new(bytes.Buffer)
+	for _, s := range d {
+		switch s.kind {
+		case bold:
+			buf.WriteString("*" + s.Text + "*")
+		case italic:
+			buf.WriteString("_" + s.Text + "_")
+		case code:
+			buf.WriteString("`" + s.Text + "`")
+		case pre:
+			if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString("```\n" + strings.TrimSuffix(s.Text, "\n") + "\n```\n")
+		case link:
+			if s.Text == "" || s.Text == s.URL {
+				buf.WriteString(s.URL)
+			} else {
+				buf.WriteString(s.Text + " <" + s.URL + ">")
+			}
+		default:
+			buf.WriteString(s.Text)
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// writeText escapes s and turns its line breaks into <br/>.
+func writeText(buf *bytes.Buffer, s string) {
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			buf.WriteString("<br/>")
+		}
+		xml.EscapeText(buf, []byte(line))
+	}
+}
+
+// XHTML renders the doc as the inner XML of an XHTML body.
+func (d Doc) XHTML() string {
+	buf := new(bytes.Buffer)
+	wrap := func(tag, s string) {
+		buf.WriteString("<" + tag + ">")
+		writeText(buf, s)
+		buf.WriteString("</" + tag + ">")
+	}
+	for _, s := range d {
+		switch s.kind {
+		case bold:
+			wrap("strong", s.Text)
+		case italic:
+			wrap("em", s.Text)
+		case code:
+			wrap("code", s.Text)
+		case pre:
+			// line breaks are kept as they are inside <pre>
+			buf.WriteString("<pre>")
+			xml.EscapeText(buf, []byte(strings.TrimSuffix(s.Text, "\n")))
+			buf.WriteString("</pre>")
+		case link:
+			t := s.Text
+			if t == "" {
+				t = s.URL
+			}
+			buf.WriteString(`<a href="`)
+			xml.EscapeText(buf, []byte(s.URL))
+			buf.WriteString(`">`)
+			writeText(buf, t)
+			buf.WriteString("</a>")
+		default:
+			writeText(buf, s.Text)
+		}
+	}
+	return buf.String()
+}
+
+type html struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/xhtml-im html"`
+	Body    struct {
+		XMLName xml.Name `xml:"http://www.w3.org/1999/xhtml body"`
+		Inner   string   `xml:",innerxml"`
+	}
+}
+
+// Attach adds the doc to the message as XHTML-IM.
+func Attach(m *stanza.Message, d Doc) error {
+	h := &html{}
+	h.Body.Inner = d.XHTML()
+	return m.With(h)
+}
+
+// NewMessage makes a message with the doc as XHTML-IM and as plain body.
+func NewMessage(typ string, to jid.JID, d Doc) (*stanza.Message, error) {
+	m := stanza.NewMessage(typ, to, d.Plain())
+	return m, Attach(m, d)
+}